@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/transform"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// deadcode lists what the command in dir, and the packages it imports,
+// declare but can never use, package by package, as in
+//
+//	main
+//		func helper
+//		method Point.Scale
+//		type unused
+//		var cache
+func deadcode(dir string) string {
+	xs := extensionsFor(dir)
+	packages, _, xkeep := parsePackages(dir, xs)
+	// We keep every declaration, so that TrackImports drops nothing
+	// by name, and remember where each mangled name came from.
+	var keep, roots []string
+	origin := make(map[string][2]string)
+	for path, files := range packages {
+		for _, f := range files {
+			for _, name := range declaredNames(f) {
+				keep = append(keep, path+"."+name)
+				origin[transform.ManglePackageAndName(path, name)] = [2]string{path, name}
+			}
+		}
+	}
+	for _, k := range xkeep {
+		i := strings.LastIndex(k, ".")
+		roots = append(roots, transform.ManglePackageAndName(k[:i], k[i+1:]))
+	}
+	f := transform.TrackImports(nil, packages, append(keep, xkeep...)...)
+
+	dead := make(map[string][]string)
+	for _, o := range transform.Unreachable(f, types.TypeCheck(f), roots...) {
+		var kind string
+		where := origin[o.Name]
+		switch o.Kind {
+		case types.Func:
+			kind = "func"
+			if o.Recv != nil {
+				kind = "method"
+				t := o.Recv
+				if p, ok := t.(*types.Pointer); ok {
+					t = p.Elem
+				}
+				where = origin[t.(*types.Named).Name]
+				where[1] += "." + o.Name
+			}
+		case types.TypeName:
+			kind = "type"
+		default:
+			kind = "var"
+		}
+		dead[where[0]] = append(dead[where[0]], kind+" "+where[1])
+	}
+	var paths []string
+	for path := range dead {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var out strings.Builder
+	for _, path := range paths {
+		fmt.Fprintln(&out, path)
+		sort.Strings(dead[path])
+		for _, d := range dead[path] {
+			fmt.Fprintf(&out, "\t%s\n", d)
+		}
+	}
+	return out.String()
+}
+
+// declaredNames gives the names that the file f declares at package
+// level, other than those of methods and init functions.
+func declaredNames(f *ast.File) []string {
+	var names []string
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil && d.Name.Name != "init" {
+				names = append(names, d.Name.Name)
+			}
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					names = append(names, s.Name.Name)
+				case *ast.ValueSpec:
+					if d.Tok != token.VAR {
+						continue
+					}
+					for _, n := range s.Names {
+						if n.Name != "_" {
+							names = append(names, n.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,10 @@
+package main
+
+// The extensions that ogo -x may ask for, each of which registers
+// itself.  An extension kept out of tree needs only a blank import
+// here.
+import (
+	_ "github.com/droundy/ogo/x/sum"
+	_ "github.com/droundy/ogo/x/trace"
+	_ "github.com/droundy/ogo/x/unless"
+)
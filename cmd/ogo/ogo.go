@@ -3,6 +3,7 @@ package main
 // A handy program for compiling go code...
 
 import (
+	"flag"
 	"fmt"
 	"github.com/droundy/ogo/cprinter"
 	"github.com/droundy/ogo/transform"
@@ -16,10 +17,91 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
-func parseFile(fset *token.FileSet, srcdir, f string) (parsedf *ast.File, err error) {
-	parsedf, err = parser.ParseFile(fset, filepath.Join(srcdir, f), nil, parser.ParseComments)
+var dump = flag.Bool("dump", false, "write the program out after each g2g pass, in the passes directory of each test")
+
+var noinline = flag.Bool("l", false, "don't inline calls")
+
+var decisions = flag.Bool("m", false, "print the decisions of the g2g passes, such as which calls they inline")
+
+var bce = flag.Bool("bce", false, "print the index expressions whose bounds are still checked in C")
+
+var gc = flag.String("gc", "precise", "the garbage collector of the C programs (none, boehm or precise)")
+
+// extensionList is the list of extensions that -x asks for, which
+// may be given more than once, or as a list separated by commas.
+type extensionList []string
+
+func (l *extensionList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *extensionList) Set(names string) error {
+	for _, name := range strings.Split(names, ",") {
+		if transform.LookupExtension(name) == nil {
+			return fmt.Errorf("there is no extension called %s (there are %s)",
+				name, strings.Join(transform.Extensions(), ", "))
+		}
+		*l = append(*l, name)
+	}
+	return nil
+}
+
+var xflag extensionList
+
+func init() {
+	flag.Var(&xflag, "x", "the language extensions to build with")
+}
+
+// extensionsFor gives the extensions to build the program in dir
+// with, which are those asked for with -x along with any that the
+// program names in a file called extensions.
+func extensionsFor(dir string) []*transform.Extension {
+	names := append([]string{}, xflag...)
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "extensions")); err == nil {
+		names = append(names, strings.Fields(string(b))...)
+	}
+	var xs []*transform.Extension
+	seen := make(map[string]bool)
+	for _, name := range names {
+		x := transform.LookupExtension(name)
+		if x == nil {
+			panic("There is no extension called " + name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			xs = append(xs, x)
+		}
+	}
+	return xs
+}
+
+// collecting is true if the C programs have a garbage collector, which
+// they have if we asked for the precise one, or for boehm and libgc is
+// there to link.
+var collecting bool
+
+func haveBoehm() bool {
+	check := exec.Command("gcc", "-x", "c", "-o", os.DevNull, "-", "-lgc")
+	check.Stdin = strings.NewReader("#include <gc.h>\nint main(void) { GC_INIT(); return 0; }\n")
+	return check.Run() == nil
+}
+
+func parseFile(fset *token.FileSet, srcdir, f string, xs []*transform.Extension) (parsedf *ast.File, err error) {
+	filename := filepath.Join(srcdir, f)
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, x := range xs {
+		if x.Preprocess != nil {
+			src = x.Preprocess(filename, src)
+		}
+	}
+	parsedf, err = parser.ParseFile(fset, filename, src, parser.ParseComments)
 	if err != nil {
 		return
 	}
@@ -47,7 +129,7 @@ func parseFile(fset *token.FileSet, srcdir, f string) (parsedf *ast.File, err er
 	return
 }
 
-func importPath(packages map[string](map[string]*ast.File), fset *token.FileSet, path, dir string) (fmap map[string]*ast.File, err error) {
+func importPath(packages map[string](map[string]*ast.File), fset *token.FileSet, path, dir string, xs []*transform.Extension) (fmap map[string]*ast.File, err error) {
 	if _, ok := packages[path]; !ok {
 		x, err := build.Import(path, dir, 0)
 		if err != nil {
@@ -56,7 +138,7 @@ func importPath(packages map[string](map[string]*ast.File), fset *token.FileSet,
 		fmap = make(map[string]*ast.File)
 		for _, f := range x.GoFiles {
 			// fmt.Println("Looking up", f, "for import", path, "in directory", x.Dir)
-			parsedf, err := parseFile(fset, x.Dir, f)
+			parsedf, err := parseFile(fset, x.Dir, f, xs)
 			if err != nil {
 				fmt.Println("error on file", f, err)
 			} else {
@@ -70,7 +152,7 @@ func importPath(packages map[string](map[string]*ast.File), fset *token.FileSet,
 	for _, f := range packages[path] {
 		for _, i := range f.Imports {
 			subpath := i.Path.Value[1 : len(i.Path.Value)-1]
-			packages[subpath], err = importPath(packages, fset, subpath, dir)
+			packages[subpath], err = importPath(packages, fset, subpath, dir, xs)
 			if err != nil {
 				panic(err)
 			}
@@ -79,7 +161,15 @@ func importPath(packages map[string](map[string]*ast.File), fset *token.FileSet,
 	return
 }
 
-func parseCommand(dir string) (*ast.File, *token.FileSet) {
+func parseCommand(dir string, xs []*transform.Extension) (*ast.File, *token.FileSet) {
+	packages, fset, keep := parsePackages(dir, xs)
+	return transform.TrackImports(os.Stdout, packages, keep...), fset
+}
+
+// parsePackages parses the command in dir and the packages it imports,
+// giving them by path, along with the functions that the extensions
+// xs keep.
+func parsePackages(dir string, xs []*transform.Extension) (map[string](map[string]*ast.File), *token.FileSet, []string) {
 	x, err := build.ImportDir(dir, 0)
 	if err != nil {
 		panic(err)
@@ -93,18 +183,31 @@ func parseCommand(dir string) (*ast.File, *token.FileSet) {
 	packages := make(map[string](map[string]*ast.File))
 	packages["main"] = make(map[string]*ast.File)
 	for _, f := range x.GoFiles {
-		parsedf, err := parseFile(&fset, dir, f)
+		parsedf, err := parseFile(&fset, dir, f, xs)
 		if err != nil {
 			fmt.Println("error on file", f, err)
 		} else {
 			packages["main"][f] = parsedf
 		}
 	}
-	_, err = importPath(packages, &fset, "main", dir)
+	_, err = importPath(packages, &fset, "main", dir, xs)
 	if err != nil {
 		fmt.Println("Error importing stuff:", err)
 	}
-	return transform.TrackImports(packages), &fset
+	// The functions that an extension keeps may be in packages that
+	// the program doesn't import.
+	var keep []string
+	for _, x := range xs {
+		for _, k := range x.Keep {
+			path := k[:strings.LastIndex(k, ".")]
+			_, err = importPath(packages, &fset, path, dir, xs)
+			if err != nil {
+				panic(err)
+			}
+			keep = append(keep, k)
+		}
+	}
+	return packages, &fset, keep
 }
 
 func runGoBuildIn(dir string) (err error) {
@@ -113,8 +216,122 @@ func runGoBuildIn(dir string) (err error) {
 	return buildit.Run()
 }
 
+// runtimeDir is the directory holding ogo.h, which every C file
+// includes.
+func runtimeDir() string {
+	x, err := build.Import("github.com/droundy/ogo/runtime", "", build.FindOnly)
+	if err != nil {
+		panic(err)
+	}
+	return x.Dir
+}
+
 func buildC(f string) (err error) {
-	return exec.Command("gcc", "-o", f[0:len(f)-2], f).Run()
+	args := []string{"-std=gnu11", "-I", runtimeDir(), "-o", f[0 : len(f)-2], f, "-lm"}
+	switch {
+	case *gc == "precise":
+		args = append(args, "-DOGO_PRECISE")
+	case collecting:
+		args = append(args, "-DOGO_BOEHM", "-lgc")
+	}
+	out, err := exec.Command("gcc", args...).CombinedOutput()
+	if err != nil {
+		fmt.Print(string(out))
+	}
+	return err
+}
+
+// buildGo writes out the go file in the directory dir, and builds
+// it unless it is an ogo program.
+func buildGo(dir string, fset *token.FileSet, file *ast.File, build bool) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		panic(err)
+	}
+	goname := filepath.Join(dir, filepath.Base(filepath.Dir(dir))+".go")
+	f, err := os.Create(goname)
+	if err != nil {
+		panic(err)
+	}
+	printer.Fprint(f, fset, file)
+	f.Close()
+	if !build {
+		return
+	}
+	err = runGoBuildIn(dir)
+	if err != nil {
+		panic(fmt.Sprintln("Trouble building go file: ", goname, err))
+	}
+}
+
+// size counts the statements and expressions of a program.
+type size struct {
+	stmts, exprs int
+}
+
+func measure(file *ast.File) size {
+	var s size
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n.(type) {
+		case ast.Stmt:
+			s.stmts++
+		case ast.Expr:
+			s.exprs++
+		}
+		return true
+	})
+	return s
+}
+
+// dumpPass writes out the program as it is after the pass with the
+// given name, which is the nth, headed by how much the pass changed
+// its size.
+func dumpPass(dir string, n int, name string, fset *token.FileSet, file *ast.File, before *size, log []string) {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		panic(err)
+	}
+	f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%02d-%s.go", n, name)))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	after := measure(file)
+	if before == nil {
+		fmt.Fprintf(f, "// The %s: %d statements, %d expressions\n\n", name, after.stmts, after.exprs)
+	} else {
+		fmt.Fprintf(f, "// After %s: %d statements (%+d), %d expressions (%+d)\n\n",
+			name, after.stmts, after.stmts-before.stmts, after.exprs, after.exprs-before.exprs)
+	}
+	for _, l := range log {
+		fmt.Fprintf(f, "// %s\n", l)
+	}
+	if len(log) > 0 {
+		fmt.Fprintln(f)
+	}
+	printer.Fprint(f, fset, file)
+}
+
+// goroutineHeader begins the stack trace of the main goroutine, which
+// a crash prints.
+var goroutineHeader = regexp.MustCompile(`goroutine 1 \[[^\]]*\]:\n`)
+
+// run runs a program, returning its output and exit status.  The
+// output of a panic (or a deadlock) is cut off after the goroutine
+// header, since the stack trace that follows depends on how the
+// program was compiled.
+func run(program string) (string, int) {
+	out, err := exec.Command(program).CombinedOutput()
+	status := 0
+	if e, ok := err.(*exec.ExitError); ok {
+		status = e.ExitCode()
+	} else if err != nil {
+		panic("Error running " + program + ": " + err.Error())
+	}
+	if loc := goroutineHeader.FindIndex(out); loc != nil {
+		out = out[:loc[1]]
+	}
+	return string(out), status
 }
 
 func checkFor(f string) bool {
@@ -127,33 +344,69 @@ func buildCommand(dir string) {
 	fmt.Println(" building", dir)
 	fmt.Println("*****************************")
 
-	err := runGoBuildIn(dir)
-	if err != nil {
-		panic(fmt.Sprintln("Trouble building original file: ", dir, err))
+	// An ogo program, which gc can't build, comes with the output it
+	// should give.
+	expected, err := ioutil.ReadFile(filepath.Join(dir, "expected"))
+	isogo := err == nil
+	if !isogo {
+		err = runGoBuildIn(dir)
+		if err != nil {
+			panic(fmt.Sprintln("Trouble building original file: ", dir, err))
+		}
+	}
+
+	// A test may hold what ogo deadcode should say of it.
+	if want, err := ioutil.ReadFile(filepath.Join(dir, "deadcode")); err == nil {
+		if got := deadcode(dir); got != string(want) {
+			panic(fmt.Sprint("ogo deadcode says:\n", got, "\nrather than:\n", string(want)))
+		}
 	}
 
 	// First parse the input file and concatenate all its necessary
 	// imports.
-	mymain, fset := parseCommand(dir)
+	xs := extensionsFor(dir)
+	mymain, fset := parseCommand(dir, xs)
+	defer func() {
+		// Say where a type error is.
+		r := recover()
+		if e, ok := r.(*types.Error); ok {
+			panic(fset.Position(e.Pos).String() + ": " + e.Msg)
+		} else if r != nil {
+			panic(r)
+		}
+	}()
 	catdir := filepath.Join(dir, "concatenated")
-	err = os.MkdirAll(catdir, 0777)
-	if err != nil {
-		panic(err)
+	buildGo(catdir, fset, mymain, !isogo)
+
+	// Now we typecheck the thing, and simplify it with go-to-go
+	// transformations.
+	passdir := filepath.Join(dir, "passes")
+	if *dump {
+		dumpPass(passdir, 0, "input", fset, mymain, nil, nil)
 	}
-	goname := filepath.Join(catdir, filepath.Base(dir)+".go")
-	f, err := os.Create(goname)
-	if err != nil {
-		panic(err)
+	var log []string
+	transform.Log = func(pos token.Pos, msg string) {
+		l := fset.Position(pos).String() + ": " + msg
+		if *decisions {
+			fmt.Println(l)
+		}
+		log = append(log, l)
 	}
-	printer.Fprint(f, fset, mymain)
-	f.Close()
-	err = runGoBuildIn(catdir)
-	if err != nil {
-		panic(fmt.Sprintln("Trouble building cat file: ", catdir, err))
+	n := 0
+	for _, p := range transform.Passes(xs) {
+		if *noinline && p.Name == "Inline" {
+			continue
+		}
+		n++
+		before := measure(mymain)
+		log = nil
+		p.Run(mymain, types.TypeCheck(mymain))
+		if *dump {
+			dumpPass(passdir, n, p.Name, fset, mymain, &before, log)
+		}
 	}
-
-	// Now we typecheck the thing
-	types.TypeCheck(mymain)
+	g2gdir := filepath.Join(dir, "g2g")
+	buildGo(g2gdir, fset, mymain, !isogo)
 
 	// Finally, generate the C file
 	cdir := filepath.Join(dir, "c")
@@ -162,43 +415,96 @@ func buildCommand(dir string) {
 		panic(err)
 	}
 	cname := filepath.Join(cdir, filepath.Base(dir)+".c")
-	f, err = os.Create(cname)
+	f, err := os.Create(cname)
 	fmt.Println("created", cname)
 	if err != nil {
 		panic(err)
 	}
-	cprinter.Fprint(f, fset, mymain)
+	cfails := checkFor(dir + "/CFAILS")
+	// A program that allocates more than we could without a garbage
+	// collector can't be run without one.
+	runC := !cfails && (collecting || !checkFor(dir+"/NEEDSGC"))
+	func() {
+		defer func() {
+			if r := recover(); r != nil && !cfails {
+				panic(r)
+			} else if r != nil {
+				fmt.Println("Lowering to C failed as expected:", r)
+			}
+		}()
+		transform.Log = func(pos token.Pos, msg string) {
+			if *bce {
+				fmt.Println(fset.Position(pos).String() + ": " + msg)
+			}
+		}
+		transform.LowerToC(mymain, types.TypeCheck(mymain))
+		for _, x := range xs {
+			for _, h := range x.Runtime {
+				fmt.Fprintf(f, "#include %q\n", h)
+			}
+		}
+		cprinter.Fprint(f, fset, mymain)
+	}()
 	f.Close()
 	err = buildC(cname)
-	if err != nil && !checkFor(dir+"/CFAILS") {
+	if err != nil && !cfails {
 		panic(err)
 	}
 
 	fmt.Println("Testing", dir, "...")
-	outc, err := exec.Command(filepath.Join(catdir, filepath.Base(catdir))).CombinedOutput()
-	if err != nil {
-		panic("Error running concatenated file: " + err.Error())
-	}
-	outg, err := exec.Command(filepath.Join(dir, filepath.Base(dir))).CombinedOutput()
-	if err != nil {
-		panic("Error running raw file: " + err.Error())
+	if isogo {
+		outC, statusC := run(cname[0 : len(cname)-2])
+		if outC != string(expected) || statusC != 0 {
+			panic(fmt.Sprint("C output differs:\n", outC, "\nversus expected:\n", string(expected)))
+		}
+		fmt.Println("Tests pass!")
+		return
 	}
-	if string(outc) != string(outg) {
+	outg, statusg := run(filepath.Join(dir, filepath.Base(dir)))
+	outc, statusc := run(filepath.Join(catdir, filepath.Base(catdir)))
+	if outc != outg || statusc != statusg {
 		panic("outputs differ")
 	}
-	if !checkFor(dir + "/CFAILS") {
-		outC, err := exec.Command(cname[0 : len(cname)-2]).CombinedOutput()
-		if err != nil {
-			fmt.Println("Error running C file: " + err.Error() + " command was " + cname[0:len(cname)-2])
+	outg2g, statusg2g := run(filepath.Join(g2gdir, filepath.Base(g2gdir)))
+	if outg2g != outg || statusg2g != statusg {
+		panic("Transformed output differs:\n" + outg2g + "\nversus:\n" + outg)
+	}
+	if runC {
+		outC, statusC := run(cname[0 : len(cname)-2])
+		if outC != outg {
+			panic("C output differs:\n" + outC + "\nversus:\n" + outg)
 		}
-		if string(outC) != string(outg) {
-			panic("C output differs:\n" + string(outC) + "\nversus:\n" + string(outg))
+		if statusC != statusg {
+			panic(fmt.Sprint("C program exits with ", statusC, " rather than ", statusg))
 		}
 	}
 	fmt.Println("Tests pass!")
 }
 
 func main() {
+	// The files of an ogo program, which gc can't build, are
+	// marked with the ogo build tag.
+	build.Default.BuildTags = append(build.Default.BuildTags, "ogo")
+	flag.Parse()
+	if flag.Arg(0) == "deadcode" {
+		for _, dir := range flag.Args()[1:] {
+			fmt.Print(deadcode(dir))
+		}
+		return
+	}
+	switch *gc {
+	case "none":
+	case "precise":
+		collecting = true
+	case "boehm":
+		collecting = haveBoehm()
+		if !collecting {
+			fmt.Println("libgc is not available, so memory will never be freed")
+		}
+	default:
+		fmt.Println("There is no garbage collector called", *gc)
+		os.Exit(1)
+	}
 	//buildCommand(".")
 	tests, err := ioutil.ReadDir("tests")
 	if err != nil {
@@ -14,6 +14,7 @@ package cprinter
 
 import (
 	"bytes"
+	"fmt"
 	"go/ast"
 	"go/token"
 	"unicode/utf8"
@@ -274,19 +275,18 @@ func (p *printer) parameters(fields *ast.FieldList) {
 			} else if i > 0 {
 				p.print(blank)
 			}
-			// parameter names
-			if len(par.Names) > 0 {
-				// Very subtle: If we indented before (ws == ignore), identList
-				// won't indent again. If we didn't (ws == indent), identList will
-				// indent if the identList spans multiple lines, and it will outdent
-				// again at the end (and still ws == indent). Thus, a subsequent indent
-				// by a linebreak call after a type, or in the next multi-line identList
-				// will do the right thing.
-				p.identList(par.Names, ws == indent)
-				p.print(blank)
+			// parameter type, followed by its name, since C
+			// wants a type for each name
+			for j, n := range par.Names {
+				if j > 0 {
+					p.print(token.COMMA, blank)
+				}
+				p.expr(par.Type)
+				p.print(blank, n)
+			}
+			if len(par.Names) == 0 {
+				p.expr(par.Type)
 			}
-			// parameter type
-			p.expr(par.Type)
 			prevLine = parLineEnd
 		}
 		// if the closing ")" is on a separate line from the last parameter,
@@ -299,6 +299,8 @@ func (p *printer) parameters(fields *ast.FieldList) {
 		if ws == ignore {
 			p.print(unindent)
 		}
+	} else {
+		p.print("void")
 	}
 	p.print(fields.Closing, token.RPAREN)
 }
@@ -309,17 +311,16 @@ func (p *printer) signature(params *ast.FieldList) {
 
 func (p *printer) funcreturn(result *ast.FieldList) {
 	n := result.NumFields()
-	if n > 0 {
+	if n == 1 && result.List[0].Names == nil {
+		// C allows only a single anonymous result
+		p.expr(result.List[0].Type)
 		p.print(blank)
-		if n == 1 && result.List[0].Names == nil {
-			// single anonymous result; no ()'s
-			p.expr(result.List[0].Type)
-			return
-		}
-		p.parameters(result)
-	} else {
-		p.print("void ")
+		return
 	}
+	if n > 0 {
+		panic("C functions return a single anonymous result")
+	}
+	p.print("void ")
 }
 
 func identListSize(list []*ast.Ident, maxSize int) (size int) {
@@ -374,25 +375,9 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 			// no blank between keyword and {} in this case
 			p.print(lbrace, token.LBRACE, rbrace, token.RBRACE)
 			return
-		} else if isStruct && p.isOneLineFieldList(list) { // for now ignore interfaces
-			// small enough - print on one line
-			// (don't use identList and ignore source line breaks)
-			p.print(lbrace, token.LBRACE, blank)
-			f := list[0]
-			for i, x := range f.Names {
-				if i > 0 {
-					// no comments so no need for comma position
-					p.print(token.COMMA, blank)
-				}
-				p.expr(x)
-			}
-			if len(f.Names) > 0 {
-				p.print(blank)
-			}
-			p.expr(f.Type)
-			p.print(blank, rbrace, token.RBRACE)
-			return
 		}
+		// A one-line struct would be possible, but C wants a
+		// semicolon after each field, which makes it rather ugly.
 	}
 	// hasComments || !srcIsOneLine
 
@@ -414,15 +399,34 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 			}
 			extraTabs := 0
 			p.setComment(f.Doc)
-			if len(f.Names) > 0 {
-				// named fields
-				p.identList(f.Names, false)
+			if at, ok := f.Type.(*ast.ArrayType); ok && len(f.Names) > 0 {
+				// array fields, with the length after each name in C
+				p.expr(at.Elt)
 				p.print(sep)
+				for i, n := range f.Names {
+					if i > 0 {
+						p.print(token.COMMA, blank)
+					}
+					p.expr(n)
+					p.print(token.LBRACK)
+					if at.Len != nil {
+						p.expr(at.Len)
+					}
+					p.print(token.RBRACK)
+				}
+				p.print(token.SEMICOLON)
+				extraTabs = 1
+			} else if len(f.Names) > 0 {
+				// named fields, with the type first in C
 				p.expr(f.Type)
+				p.print(sep)
+				p.identList(f.Names, false)
+				p.print(token.SEMICOLON)
 				extraTabs = 1
 			} else {
 				// anonymous field
 				p.expr(f.Type)
+				p.print(token.SEMICOLON)
 				extraTabs = 2
 			}
 			if f.Tag != nil {
@@ -620,10 +624,13 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 		return
 	}
 
-	printBlank := prec < cutoff
+	// C's precedences differ from go's (& binds more loosely than ==,
+	// for instance) so we parenthesize every operand that is not
+	// primary.
+	printBlank := true
 
 	ws := indent
-	p.expr1(x.X, prec, depth+diffPrec(x.X, prec))
+	p.expr1(x.X, token.HighestPrec, depth+diffPrec(x.X, prec))
 	if printBlank {
 		p.print(blank)
 	}
@@ -641,7 +648,7 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 	if printBlank {
 		p.print(blank)
 	}
-	p.expr1(x.Y, prec+1, depth+1)
+	p.expr1(x.Y, token.HighestPrec, depth+1)
 	if ws == ignore {
 		p.print(unindent)
 	}
@@ -670,8 +677,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.binaryExpr(x, prec1, cutoff(x, depth), depth)
 
 	case *ast.KeyValueExpr:
+		// The key is a designator, like .X or [3]
 		p.expr(x.Key)
-		p.print(x.Colon, token.COLON, blank)
+		p.print(blank, token.ASSIGN, blank)
 		p.expr(x.Value)
 
 	case *ast.StarExpr:
@@ -709,8 +717,10 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.print(x)
 
 	case *ast.FuncLit:
-		p.expr(x.Type)
-		p.funcBody(x.Body, p.distance(x.Type.Pos(), p.pos), true)
+		// A function literal stands for a statement expression.
+		p.print(token.LPAREN)
+		p.block(x.Body, 1)
+		p.print(token.RPAREN)
 
 	case *ast.ParenExpr:
 		if _, hasParens := x.X.(*ast.ParenExpr); hasParens {
@@ -768,6 +778,13 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.print(x.Rbrack, token.RBRACK)
 
 	case *ast.CallExpr:
+		if id, ok := x.Fun.(*ast.Ident); ok && isBasicType(id.Name) {
+			// A type conversion is a cast in C.
+			p.print(token.LPAREN, id, token.RPAREN, x.Lparen, token.LPAREN)
+			p.expr0(x.Args[0], depth+1)
+			p.print(x.Rparen, token.RPAREN)
+			break
+		}
 		if len(x.Args) > 1 {
 			depth++
 		}
@@ -803,12 +820,13 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		}
 
 	case *ast.ArrayType:
+		// a C type name, with the length after the element type
+		p.expr(x.Elt)
 		p.print(token.LBRACK)
 		if x.Len != nil {
 			p.expr(x.Len)
 		}
 		p.print(token.RBRACK)
-		p.expr(x.Elt)
 
 	case *ast.StructType:
 		p.print(token.STRUCT)
@@ -820,8 +838,10 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.signature(x.Params)
 
 	case *ast.InterfaceType:
-		p.print(token.INTERFACE)
-		p.fieldList(x.Methods, false, x.Incomplete)
+		// C has no interfaces, so this stands for a union, whose
+		// members are the methods.
+		p.print("union")
+		p.fieldList(x.Methods, true, x.Incomplete)
 
 	case *ast.MapType:
 		p.print(token.MAP, token.LBRACK)
@@ -848,6 +868,17 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 	return
 }
 
+func isBasicType(name string) bool {
+	switch name {
+	case "bool", "int", "int8", "int16", "int32", "int64",
+		"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+		"float32", "float64", "complex64", "complex128",
+		"string", "byte", "rune":
+		return true
+	}
+	return false
+}
+
 func (p *printer) expr0(x ast.Expr, depth int) {
 	p.expr1(x, token.LowestPrec, depth)
 }
@@ -926,38 +957,47 @@ func stripParens(x ast.Expr) ast.Expr {
 }
 
 func (p *printer) controlClause(isForStmt bool, init ast.Stmt, expr ast.Expr, post ast.Stmt) {
-	p.print(blank)
-	needsBlank := false
-	if init == nil && post == nil {
-		// no semicolons required
-		if expr != nil {
-			p.print(token.LPAREN)
-			p.expr(stripParens(expr))
-			needsBlank = true
-			p.print(token.RPAREN)
-		}
-	} else {
-		// all semicolons required
-		// (they are not separators, print them explicitly)
-		if init != nil {
-			p.stmt(init, false)
-		}
-		p.print(token.SEMICOLON, blank)
-		if expr != nil {
-			p.expr(stripParens(expr))
-			needsBlank = true
-		}
-		if isForStmt {
-			p.print(token.SEMICOLON, blank)
-			needsBlank = false
-			if post != nil {
-				p.stmt(post, false)
-				needsBlank = true
-			}
-		}
+	p.print(blank, token.LPAREN)
+	if !isForStmt {
+		p.expr(stripParens(expr))
+		p.print(token.RPAREN, blank)
+		return
+	}
+	if init != nil {
+		p.simpleStmt(init)
+	}
+	p.print(token.SEMICOLON)
+	if expr != nil {
+		p.print(blank)
+		p.expr(stripParens(expr))
 	}
-	if needsBlank {
+	p.print(token.SEMICOLON)
+	if post != nil {
 		p.print(blank)
+		p.simpleStmt(post)
+	}
+	p.print(token.RPAREN, blank)
+}
+
+// simpleStmt prints a statement that may appear in the clauses of a
+// for statement, which has no semicolon.
+func (p *printer) simpleStmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.IncDecStmt:
+		p.expr0(s.X, 2)
+		p.print(s.TokPos, s.Tok)
+	case *ast.AssignStmt:
+		var depth = 1
+		if len(s.Lhs) > 1 && len(s.Rhs) > 1 {
+			depth++
+		}
+		p.exprList(s.Pos(), s.Lhs, depth, 0, s.TokPos)
+		p.print(blank, s.TokPos, s.Tok, blank)
+		p.exprList(s.TokPos, s.Rhs, depth, 0, token.NoPos)
+	case *ast.ExprStmt:
+		p.expr0(s.X, 1)
+	default:
+		panic(fmt.Sprintf("A %T can't go in the clause of a for statement", s))
 	}
 }
 
@@ -1017,10 +1057,10 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.expr(s.Label)
 		p.print(s.Colon, token.COLON, indent)
 		if e, isEmpty := s.Stmt.(*ast.EmptyStmt); isEmpty {
-			if !nextIsRBrace {
-				p.print(newline, e.Pos(), token.SEMICOLON)
-				break
-			}
+			// C wants a statement after a label, even at the end of
+			// a block.
+			p.print(newline, e.Pos(), token.SEMICOLON)
+			break
 		} else {
 			p.linebreak(p.lineFor(s.Stmt.Pos()), 1, ignore, true)
 		}
@@ -1038,18 +1078,12 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.expr0(s.Value, depth)
 
 	case *ast.IncDecStmt:
-		const depth = 1
-		p.expr0(s.X, depth+1)
-		p.print(s.TokPos, s.Tok)
+		p.simpleStmt(s)
+		p.print(token.SEMICOLON)
 
 	case *ast.AssignStmt:
-		var depth = 1
-		if len(s.Lhs) > 1 && len(s.Rhs) > 1 {
-			depth++
-		}
-		p.exprList(s.Pos(), s.Lhs, depth, 0, s.TokPos)
-		p.print(blank, s.TokPos, s.Tok, blank)
-		p.exprList(s.TokPos, s.Rhs, depth, 0, token.NoPos)
+		p.simpleStmt(s)
+		p.print(token.SEMICOLON)
 
 	case *ast.GoStmt:
 		p.print(token.GO, blank)
@@ -1084,6 +1118,7 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 			p.print(blank)
 			p.expr(s.Label)
 		}
+		p.print(token.SEMICOLON)
 
 	case *ast.BlockStmt:
 		p.block(s, 1)
@@ -1278,9 +1313,23 @@ func (p *printer) spec(spec ast.Spec) {
 		panic("C doesn't have import statements (include?)")
 	case *ast.ValueSpec:
 		p.setComment(s.Doc)
-		p.expr(s.Type)
-		p.print(blank)
-		p.identList(s.Names, true) // always present
+		if at, ok := s.Type.(*ast.ArrayType); ok {
+			// an array, with its length after its name in C
+			p.expr(at.Elt)
+			p.print(blank)
+			p.identList(s.Names, true)
+			p.print(token.LBRACK)
+			if at.Len != nil {
+				p.expr(at.Len)
+			}
+			p.print(token.RBRACK)
+		} else {
+			if s.Type != nil {
+				p.expr(s.Type)
+				p.print(blank)
+			}
+			p.identList(s.Names, true) // always present
+		}
 		if s.Values != nil {
 			p.print(blank, token.ASSIGN, blank)
 			p.exprList(token.NoPos, s.Values, 1, 0, token.NoPos)
@@ -1290,9 +1339,17 @@ func (p *printer) spec(spec ast.Spec) {
 
 	case *ast.TypeSpec:
 		p.setComment(s.Doc)
-		p.expr(s.Name)
-		p.print(blank)
-		p.expr(s.Type)
+		if st, ok := s.Type.(*ast.StructType); ok {
+			p.print(token.STRUCT, blank)
+			p.expr(s.Name)
+			p.fieldList(st.Fields, true, st.Incomplete)
+		} else {
+			p.print("typedef", blank)
+			p.expr(s.Type)
+			p.print(blank)
+			p.expr(s.Name)
+		}
+		p.print(token.SEMICOLON)
 		p.setComment(s.Comment)
 
 	default:
@@ -1374,27 +1431,8 @@ func (p *printer) funcBody(b *ast.BlockStmt, headerSize int, isLit bool) {
 	if b == nil {
 		return
 	}
-
-	if p.isOneLineFunc(b, headerSize) {
-		sep := vtab
-		if isLit {
-			sep = blank
-		}
-		p.print(sep, b.Lbrace, token.LBRACE)
-		if len(b.List) > 0 {
-			p.print(blank)
-			for i, s := range b.List {
-				if i > 0 {
-					p.print(token.SEMICOLON, blank)
-				}
-				p.stmt(s, i == len(b.List)-1)
-			}
-			p.print(blank)
-		}
-		p.print(b.Rbrace, token.RBRACE)
-		return
-	}
-
+	// C statements end with semicolons, which makes one-line
+	// functions awkward, so we never print them.
 	p.print(blank)
 	p.block(b, 1)
 }
@@ -1427,6 +1465,11 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 		zero := &ast.BasicLit{Kind: token.INT, Value: "0"}
 		d.Body.List = append(d.Body.List, &ast.ReturnStmt{Results: []ast.Expr{zero}})
 	}
+	if d.Body == nil {
+		// just a prototype
+		p.print(token.SEMICOLON)
+		return
+	}
 	p.funcBody(d.Body, p.distance(d.Pos(), p.pos), false)
 }
 
@@ -1460,7 +1503,7 @@ func declToken(decl ast.Decl) (tok token.Token) {
 func (p *printer) file(src *ast.File) {
 	p.setComment(src.Doc)
 
-	p.print("#include <stdio.h>\n#include <stdlib.h>\n\nvoid println(const char *x) {\n  puts(x);\n}\n")
+	p.print("#include \"ogo.h\"\n")
 
 	if len(src.Decls) > 0 {
 		tok := token.ILLEGAL
@@ -0,0 +1,2450 @@
+/* The ogo runtime.
+ *
+ * This header is included by every C file that ogo generates, and
+ * holds the C side of everything the go code may do that C can't do
+ * directly.  It is all static, so there is no library to link.
+ */
+
+#ifndef OGO_H
+#define OGO_H
+
+#include <complex.h>
+#include <setjmp.h>
+#include <signal.h>
+#include <math.h>
+#include <stdarg.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/mman.h>
+#include <sys/random.h>
+#include <time.h>
+#include <ucontext.h>
+#include <unistd.h>
+
+typedef int64_t ogo_int;
+typedef int8_t ogo_int8;
+typedef int16_t ogo_int16;
+typedef int32_t ogo_int32;
+typedef int64_t ogo_int64;
+typedef uint64_t ogo_uint;
+typedef uint8_t ogo_uint8;
+typedef uint16_t ogo_uint16;
+typedef uint32_t ogo_uint32;
+typedef uint64_t ogo_uint64;
+typedef uintptr_t ogo_uintptr;
+typedef float ogo_float32;
+typedef double ogo_float64;
+typedef float _Complex ogo_complex64;
+typedef double _Complex ogo_complex128;
+typedef _Bool ogo_bool;
+
+typedef struct {
+	const uint8_t *ptr;
+	ogo_int len;
+} ogo_string;
+
+typedef struct {
+	void *ptr;
+	ogo_int len, cap;
+} ogo_slice;
+
+/* An interface value points to an itable, which holds the dynamic
+ * type of the value along with its methods (in the order of the
+ * methods of the interface), and to the value itself.  A pointer is
+ * stored directly in the data field, while any other value is copied
+ * to the heap.  A nil interface has a NULL itable. */
+
+typedef struct ogo_type ogo_type;
+
+typedef struct {
+	/* the name and signature of a method, e.g. "Error() string" */
+	ogo_string name;
+	void (*fn)(void);
+} ogo_method;
+
+typedef struct {
+	ogo_string name;
+	const ogo_type *type;
+	ogo_int offset;
+} ogo_field;
+
+/* A pointer map tells the collector where a value holds pointers into
+ * the heap.  Each of its entries stands for count pointers from offset
+ * on, one word apart, or, if the entry has a map of its own, for count
+ * values laid out one after another that the map describes.  An object
+ * holding several values of the same type, like the array behind a
+ * slice, has the map of one of them.  A value of a sealed interface
+ * type, a tag followed by a union of its variants, instead has a map
+ * for each variant, chosen by its tag, which is 0 for nil and i + 1
+ * for variants[i]. */
+typedef struct ogo_gcmap ogo_gcmap;
+
+typedef struct {
+	ogo_int offset, count;
+	const ogo_gcmap *map;
+} ogo_gcptr;
+
+struct ogo_gcmap {
+	ogo_int size, n;
+	const ogo_gcptr *ptrs;
+	const ogo_gcmap *const *variants;
+};
+
+/* The kinds of types, numbered as in the reflect package, with one
+ * more for the builtin Type. */
+enum {
+	OGO_KIND_INVALID, OGO_KIND_BOOL, OGO_KIND_INT, OGO_KIND_INT8, OGO_KIND_INT16,
+	OGO_KIND_INT32, OGO_KIND_INT64, OGO_KIND_UINT, OGO_KIND_UINT8, OGO_KIND_UINT16,
+	OGO_KIND_UINT32, OGO_KIND_UINT64, OGO_KIND_UINTPTR, OGO_KIND_FLOAT32,
+	OGO_KIND_FLOAT64, OGO_KIND_COMPLEX64, OGO_KIND_COMPLEX128, OGO_KIND_ARRAY,
+	OGO_KIND_CHAN, OGO_KIND_FUNC, OGO_KIND_INTERFACE, OGO_KIND_MAP, OGO_KIND_POINTER,
+	OGO_KIND_SLICE, OGO_KIND_STRING, OGO_KIND_STRUCT, OGO_KIND_UNSAFE_POINTER,
+	OGO_KIND_TYPE,
+};
+
+/* A type descriptor, of which the compiler generates one for each type
+ * that the program needs at run time. */
+struct ogo_type {
+	ogo_string name;
+	ogo_int kind;
+	ogo_int size, align;
+	/* equal compares the data of two interfaces holding this type, and
+	 * hash hashes it, or they are NULL if the type isn't comparable. */
+	ogo_bool (*equal)(const void *, const void *);
+	ogo_uint (*hash)(const void *, ogo_uint);
+	/* the key type of a map type, the element type of a pointer,
+	 * slice, array, channel or map type, and the length of an array
+	 * type */
+	const ogo_type *key;
+	const ogo_type *elem;
+	ogo_int len;
+	ogo_int nfields;
+	const ogo_field *fields;
+	/* The method set of the type, sorted by name.  The methods of an
+	 * interface type have no fn. */
+	ogo_int nmethods;
+	const ogo_method *methods;
+	/* the type of pointers to this type, if we have needed it */
+	const ogo_type *ptrto;
+	/* where a value of the type holds pointers, or NULL if it has none */
+	const ogo_gcmap *gcmap;
+};
+
+/* A value of the builtin Type is a pointer to a descriptor. */
+typedef const ogo_type *ogo_Type;
+
+typedef struct {
+	const ogo_type *type;
+	void (*fns[])(void);
+} ogo_itab;
+
+typedef struct {
+	const ogo_itab *itab;
+	void *data;
+} ogo_iface;
+
+/* A func value points to a closure, whose code takes the func value
+ * itself as its first argument.  A closure made from a function
+ * literal holds the variables it captures after the ogo_closure, while
+ * a top-level function has a static closure holding nothing else.
+ * A nil func is NULL. */
+typedef struct {
+	void (*fn)(void);
+} ogo_closure;
+
+typedef const ogo_closure *ogo_func;
+
+/* A channel points to the runtime's channel (see "Goroutines and
+ * channels" below), and a nil channel is NULL. */
+typedef struct ogo_hchan *ogo_chan;
+
+/* A map points to the runtime's hash table (see "Maps" below), and a
+ * nil map is NULL. */
+typedef struct ogo_hmap *ogo_map;
+
+/* The pointer maps of the values that hold a single pointer, in their
+ * first word, and of interfaces, whose itables aren't in the heap. */
+static const ogo_gcptr ogo_gcptrs_word[] = {{0, 1, NULL}};
+static const ogo_gcmap ogo_gcmap_pointer = {sizeof(void *), 1, ogo_gcptrs_word};
+static const ogo_gcmap ogo_gcmap_string = {sizeof(ogo_string), 1, ogo_gcptrs_word};
+static const ogo_gcmap ogo_gcmap_slice = {sizeof(ogo_slice), 1, ogo_gcptrs_word};
+static const ogo_gcptr ogo_gcptrs_iface[] = {{offsetof(ogo_iface, data), 1, NULL}};
+static const ogo_gcmap ogo_gcmap_iface = {sizeof(ogo_iface), 1, ogo_gcptrs_iface};
+
+/* OGO_STR turns a C string literal (which may hold NUL bytes) into a
+ * go string. */
+#define OGO_STR(s) ((ogo_string){(const uint8_t *)(s), sizeof(s) - 1})
+
+/* OGO_NOINDEX stands in for an index that was left out of a slice
+ * expression, as in s[lo:], or a capacity left out of a make. */
+#define OGO_NOINDEX INT64_MIN
+
+/* Panics.  A panic that the runtime raises holds a runtime error,
+ * whose message ogo_raise is given; see "Defer, panic and recover"
+ * below. */
+
+/* ogo_goid is the number of the running goroutine. */
+static ogo_int ogo_goid = 1;
+
+static void ogo_die(void) {
+	fprintf(stderr, "\ngoroutine %lld [running]:\n", (long long)ogo_goid);
+	exit(2);
+}
+
+static void ogo_raise(const char *msg, ogo_bool signal) __attribute__((noreturn));
+
+static void ogo_panic_nil(void) __attribute__((noreturn));
+static void ogo_panic_nil(void) {
+	ogo_raise("runtime error: invalid memory address or nil pointer dereference", 1);
+}
+
+static void ogo_vpanic(const char *kind, const char *fmt, va_list ap) __attribute__((noreturn));
+static void ogo_vpanic(const char *kind, const char *fmt, va_list ap) {
+	va_list ap2;
+	va_copy(ap2, ap);
+	int n = strlen(kind) + 2, len = n + vsnprintf(NULL, 0, fmt, ap);
+	char *msg = malloc(len + 1);
+	sprintf(msg, "%s: ", kind);
+	vsnprintf(msg + n, len + 1 - n, fmt, ap2);
+	va_end(ap2);
+	ogo_raise(msg, 0);
+}
+
+static void ogo_panic_error(const char *fmt, ...) __attribute__((noreturn, format(printf, 1, 2)));
+static void ogo_panic_error(const char *fmt, ...) {
+	va_list ap;
+	va_start(ap, fmt);
+	ogo_vpanic("runtime error", fmt, ap);
+}
+
+/* ogo_panic_conversion reports a failed type assertion. */
+static void ogo_panic_conversion(const char *fmt, ...) __attribute__((noreturn, format(printf, 1, 2)));
+static void ogo_panic_conversion(const char *fmt, ...) {
+	va_list ap;
+	va_start(ap, fmt);
+	ogo_vpanic("interface conversion", fmt, ap);
+}
+
+/* OGO_DIVISOR checks the divisor of an integer division. */
+#define OGO_DIVISOR(y) ({ __typeof__(y) ogo_y = (y); \
+	if (ogo_y == 0) ogo_panic_error("integer divide by zero"); ogo_y; })
+
+/* Integers
+ *
+ * Go's integers wrap around when they overflow, which C's signed
+ * integers needn't, so the generated code adds, subtracts and
+ * multiplies them as uint64_t and converts the result back, which gcc
+ * does modulo 2^n.  Division and shifts need more care. */
+
+/* OGO_QUO and OGO_REM divide signed integers of type T, where the most
+   negative T divided by -1 overflows. */
+#define OGO_QUO(T, x, y) ({ T ogo_x = (x); T ogo_y = OGO_DIVISOR(y); \
+	ogo_y == -1 ? (T)(0 - (uint64_t)ogo_x) : (T)(ogo_x / ogo_y); })
+#define OGO_REM(T, x, y) ({ T ogo_x = (x); T ogo_y = OGO_DIVISOR(y); \
+	ogo_y == -1 ? (T)0 : (T)(ogo_x % ogo_y); })
+
+/* OGO_SHL and OGO_SHR shift x, of type T, by s bits.  Shifting by the
+   width of T or more gives 0, or -1 when a negative x shifts right,
+   and shifting by a negative count panics. */
+#define OGO_SHIFT(s) ({ __typeof__(s) ogo_s = (s); \
+	if (ogo_s < 0) ogo_panic_error("negative shift amount"); (uint64_t)ogo_s; })
+#define OGO_SHL(T, x, s) ({ T ogo_x = (x); uint64_t ogo_n = OGO_SHIFT(s); \
+	ogo_n >= 8 * sizeof(T) ? (T)0 : (T)((uint64_t)ogo_x << ogo_n); })
+#define OGO_SHR(T, x, s) ({ T ogo_x = (x); uint64_t ogo_n = OGO_SHIFT(s); \
+	ogo_n >= 8 * sizeof(T) ? (T)(ogo_x < 0 ? -1 : 0) : (T)(ogo_x >> ogo_n); })
+
+/* OGO_AT stores e in the place p points to, which e refers to as
+   *ogo_at, so that x op= y works out where x is just once. */
+#define OGO_AT(p, e) ({ __typeof__(p) ogo_at = (p); *ogo_at = (e); })
+
+/* Memory
+ *
+ * Everything the program allocates comes from ogo_alloc, which is given
+ * the pointer map of what it allocates, or NULL if that holds no
+ * pointers.  When OGO_PRECISE is defined, it allocates from our own
+ * collector (see "The precise collector" below), which uses the maps to
+ * find every pointer; when OGO_BOEHM is defined, from the Boehm
+ * collector; and otherwise from calloc, so that memory is never
+ * collected.  What the runtime keeps for as long as the program runs,
+ * like itables, comes from ogo_persistent_alloc, which is never freed.
+ *
+ * The precise collector finds the pointers on the stack with a shadow
+ * stack: each function begins with OGO_GCFRAME(n), giving it room for n
+ * roots, each the address of a variable holding pointers, along with
+ * its map, which OGO_ROOT registers once the variable is declared.  It
+ * only collects at the safe points that OGO_GCSAFE marks before each
+ * statement, telling it how many roots are still in scope.  A value
+ * part way through an expression, such as the result of a call that is
+ * the argument of another, is in no variable, so while something else
+ * in the expression might collect, OGO_KEEP keeps it until the next
+ * safe point of its function.  Without OGO_PRECISE, these do nothing. */
+
+static void ogo_out_of_memory(void) {
+	fputs("fatal error: runtime: out of memory\n", stderr);
+	exit(2);
+}
+
+typedef struct {
+	void *p;
+	const ogo_gcmap *map;
+} ogo_gcroot;
+
+typedef struct ogo_gcframe {
+	struct ogo_gcframe *prev;
+	/* the number of roots in scope, and how many values were kept
+	 * when the function began */
+	ogo_int n, keep;
+	ogo_gcroot *roots;
+} ogo_gcframe;
+
+/* The pointers of the values that are kept, which each goroutine has
+ * its own of. */
+typedef struct {
+	void **ptrs;
+	ogo_int len, cap;
+} ogo_keepstack;
+
+static ogo_gcframe *ogo_gctop;
+static ogo_keepstack ogo_keep;
+
+/* ogo_globals holds the global variables that hold pointers, which
+ * main registers with ogo_gc_globals. */
+static const ogo_gcroot *ogo_globals;
+static ogo_int ogo_nglobals;
+
+static void ogo_gc_globals(const ogo_gcroot *globals, ogo_int n) {
+	ogo_globals = globals;
+	ogo_nglobals = n;
+}
+
+/* ogo_scan calls visit with each pointer in the value at p, which the
+ * map describes. */
+static void ogo_scan(const char *p, const ogo_gcmap *map, void (*visit)(void *, void *), void *arg) {
+	if (map->variants != NULL) {
+		ogo_int tag = *(const ogo_int *)p;
+		if (tag > 0 && map->variants[tag - 1] != NULL) {
+			ogo_scan(p, map->variants[tag - 1], visit, arg);
+		}
+		return;
+	}
+	for (ogo_int i = 0; i < map->n; i++) {
+		const ogo_gcptr *e = &map->ptrs[i];
+		for (ogo_int j = 0; j < e->count; j++) {
+			if (e->map == NULL) {
+				visit(arg, ((void *const *)(p + e->offset))[j]);
+			} else {
+				ogo_scan(p + e->offset + j * e->map->size, e->map, visit, arg);
+			}
+		}
+	}
+}
+
+static void ogo_keep_pointer(void *arg, void *p) {
+	ogo_keepstack *k = arg;
+	if (p == NULL) {
+		return;
+	}
+	if (k->len == k->cap) {
+		k->cap = k->cap > 0 ? 2 * k->cap : 64;
+		k->ptrs = realloc(k->ptrs, k->cap * sizeof(void *));
+		if (k->ptrs == NULL) {
+			ogo_out_of_memory();
+		}
+	}
+	k->ptrs[k->len++] = p;
+}
+
+/* ogo_keep_value keeps the pointers in the value at p, described by
+ * map, on the keep stack k. */
+static void ogo_keep_value(ogo_keepstack *k, const void *p, const ogo_gcmap *map) {
+	if (map != NULL) {
+		ogo_scan(p, map, ogo_keep_pointer, k);
+	}
+}
+
+#ifdef OGO_PRECISE
+
+#define OGO_GCFRAME(n) \
+	ogo_gcroot ogo_gcroots[(n) > 0 ? (n) : 1]; \
+	__attribute__((cleanup(ogo_gc_leave))) ogo_gcframe ogo_gcf = {ogo_gctop, 0, ogo_keep.len, ogo_gcroots}; \
+	ogo_gctop = &ogo_gcf
+#define OGO_ROOT(i, x, map) (ogo_gcroots[i] = (ogo_gcroot){&(x), (map)}, ogo_gcf.n = (i) + 1)
+#define OGO_GCSAFE(nroots) (ogo_gcf.n = (nroots), ogo_keep.len = ogo_gcf.keep, \
+	ogo_gc_due ? ogo_collect() : (void)0)
+#define OGO_KEEP(map, ...) ({ __typeof__(__VA_ARGS__) ogo_kept = (__VA_ARGS__); \
+	ogo_keep_value(&ogo_keep, &ogo_kept, (map)); ogo_kept; })
+
+static void ogo_collect(void);
+
+static void ogo_gc_leave(ogo_gcframe *f) {
+	ogo_gctop = f->prev;
+	ogo_keep.len = f->keep;
+}
+
+/* Every object begins with a header giving its map and its size, and
+ * ogo_heap holds them all, sorted by address as of the last collection,
+ * so that we can find the object that a pointer points into. */
+typedef struct {
+	const ogo_gcmap *map;
+	ogo_uint size : 63, marked : 1;
+} ogo_object;
+
+static ogo_object **ogo_heap;
+static ogo_int ogo_heap_len, ogo_heap_cap;
+
+/* A collection is due once the objects take ogo_heap_next bytes, which
+ * is ogo_gc_percent more than were left by the last one, as with GOGC,
+ * or at least 4MB.  The OGOGC environment variable sets the percentage,
+ * or turns the collector off. */
+static ogo_int ogo_heap_bytes, ogo_heap_next = 4 << 20;
+static ogo_int ogo_gc_percent = 100;
+static ogo_bool ogo_gc_due;
+
+__attribute__((constructor)) static void ogo_gc_init(void) {
+	const char *percent = getenv("OGOGC");
+	if (percent == NULL) {
+		return;
+	}
+	if (strcmp(percent, "off") == 0) {
+		ogo_gc_percent = -1;
+		ogo_heap_next = INT64_MAX;
+	} else {
+		ogo_gc_percent = atoi(percent);
+		if (ogo_gc_percent == 0) {
+			ogo_heap_next = 0;
+		}
+	}
+}
+
+static void *ogo_alloc(ogo_int size, const ogo_gcmap *map) {
+	ogo_object *o = calloc(1, sizeof(ogo_object) + (size > 0 ? size : 1));
+	if (o == NULL) {
+		ogo_out_of_memory();
+	}
+	o->map = map;
+	o->size = size;
+	if (ogo_heap_len == ogo_heap_cap) {
+		ogo_heap_cap = ogo_heap_cap > 0 ? 2 * ogo_heap_cap : 1024;
+		ogo_heap = realloc(ogo_heap, ogo_heap_cap * sizeof(ogo_object *));
+		if (ogo_heap == NULL) {
+			ogo_out_of_memory();
+		}
+	}
+	ogo_heap[ogo_heap_len++] = o;
+	ogo_heap_bytes += size;
+	if (ogo_heap_bytes >= ogo_heap_next) {
+		ogo_gc_due = 1;
+	}
+	return o + 1;
+}
+
+static void *ogo_persistent_alloc(ogo_int size) {
+	void *p = calloc(1, size > 0 ? size : 1);
+	if (p == NULL) {
+		ogo_out_of_memory();
+	}
+	return p;
+}
+
+#else
+
+#define OGO_GCFRAME(n) ((void)0)
+#define OGO_ROOT(i, x, map) ((void)0)
+#define OGO_GCSAFE(n) ((void)0)
+#define OGO_KEEP(map, ...) (__VA_ARGS__)
+
+#ifdef OGO_BOEHM
+
+#include <gc.h>
+#include <gc_mark.h>
+
+/* ogo_push_stacks shows the collector the stacks of the goroutines
+ * that aren't running, before doing what it otherwise would. */
+static void ogo_push_stacks(void);
+static GC_push_other_roots_proc ogo_push_other_roots;
+
+/* ogo_stack_base is the bottom of the stack of the main goroutine,
+ * which the collector needs back when we switch to it. */
+static struct GC_stack_base ogo_stack_base;
+
+__attribute__((constructor)) static void ogo_gc_init(void) {
+	GC_INIT();
+	GC_get_stack_base(&ogo_stack_base);
+	ogo_push_other_roots = GC_get_push_other_roots();
+	GC_set_push_other_roots(ogo_push_stacks);
+}
+
+/* Memory from GC_MALLOC_ATOMIC isn't cleared. */
+static void *ogo_alloc(ogo_int size, const ogo_gcmap *map) {
+	void *p = map != NULL ? GC_MALLOC(size > 0 ? size : 1) : GC_MALLOC_ATOMIC(size > 0 ? size : 1);
+	if (p == NULL) {
+		ogo_out_of_memory();
+	}
+	if (map == NULL) {
+		memset(p, 0, size);
+	}
+	return p;
+}
+
+/* The collector still scans what it never frees. */
+static void *ogo_persistent_alloc(ogo_int size) {
+	void *p = GC_MALLOC_UNCOLLECTABLE(size > 0 ? size : 1);
+	if (p == NULL) {
+		ogo_out_of_memory();
+	}
+	return p;
+}
+
+#else
+
+static void *ogo_alloc(ogo_int size, const ogo_gcmap *map) {
+	(void)map;
+	void *p = calloc(1, size > 0 ? size : 1);
+	if (p == NULL) {
+		ogo_out_of_memory();
+	}
+	return p;
+}
+
+static void *ogo_persistent_alloc(ogo_int size) {
+	return ogo_alloc(size, NULL);
+}
+
+#endif
+#endif
+
+/* OGO_CONVERT converts a value to another type with the same
+ * representation, which C won't do for structs. */
+#define OGO_CONVERT(T, ...) ({ __typeof__(__VA_ARGS__) ogo_x = (__VA_ARGS__); *(T *)&ogo_x; })
+
+/* OGO_NEW allocates a T holding a value, which it evaluates first,
+ * since that may collect. */
+#define OGO_NEW(T, map, ...) ({ T ogo_v = (__VA_ARGS__); \
+	T *ogo_p = ogo_alloc(sizeof(T), (map)); *ogo_p = ogo_v; ogo_p; })
+
+/* Funcs */
+
+/* ogo_func_code gives the code of the func value f, which the caller
+ * casts to the right type. */
+static void (*ogo_func_code(ogo_func f))(void) {
+	if (f == NULL) {
+		ogo_panic_nil();
+	}
+	return f->fn;
+}
+
+/* Slices */
+
+static inline ogo_int ogo_check_index(ogo_int i, ogo_int len) {
+	if (i < 0) {
+		ogo_panic_error("index out of range [%lld]", (long long)i);
+	}
+	if (i >= len) {
+		ogo_panic_error("index out of range [%lld] with length %lld",
+		                (long long)i, (long long)len);
+	}
+	return i;
+}
+
+static inline void *ogo_slice_index(ogo_slice s, ogo_int i, ogo_int size) {
+	return (char *)s.ptr + ogo_check_index(i, s.len) * size;
+}
+
+/* ogo_slice_at indexes a slice where the compiler knows that i is in
+   range. */
+static inline void *ogo_slice_at(ogo_slice s, ogo_int i, ogo_int size) {
+	return (char *)s.ptr + i * size;
+}
+
+static ogo_slice ogo_slice_slice(ogo_slice s, ogo_int lo, ogo_int hi, ogo_int size) {
+	if (hi == OGO_NOINDEX) {
+		hi = s.len;
+	} else if ((uint64_t)hi > (uint64_t)s.cap) {
+		ogo_panic_error("slice bounds out of range [:%lld] with capacity %lld",
+		                (long long)hi, (long long)s.cap);
+	}
+	if ((uint64_t)lo > (uint64_t)hi) {
+		ogo_panic_error("slice bounds out of range [%lld:%lld]", (long long)lo, (long long)hi);
+	}
+	ogo_slice out = {s.ptr, hi - lo, s.cap - lo};
+	if (out.cap > 0) {
+		out.ptr = (char *)s.ptr + lo * size;
+	}
+	return out;
+}
+
+static ogo_slice ogo_slice_slice3(ogo_slice s, ogo_int lo, ogo_int hi, ogo_int max, ogo_int size) {
+	if ((uint64_t)max > (uint64_t)s.cap) {
+		ogo_panic_error("slice bounds out of range [::%lld] with capacity %lld",
+		                (long long)max, (long long)s.cap);
+	}
+	if ((uint64_t)hi > (uint64_t)max) {
+		ogo_panic_error("slice bounds out of range [:%lld:%lld]", (long long)hi, (long long)max);
+	}
+	if ((uint64_t)lo > (uint64_t)hi) {
+		ogo_panic_error("slice bounds out of range [%lld:%lld:]", (long long)lo, (long long)hi);
+	}
+	ogo_slice out = {s.ptr, hi - lo, max - lo};
+	if (out.cap > 0) {
+		out.ptr = (char *)s.ptr + lo * size;
+	}
+	return out;
+}
+
+static ogo_slice ogo_make_slice(ogo_int size, ogo_int len, ogo_int cap, const ogo_gcmap *map) {
+	if (cap == OGO_NOINDEX) {
+		cap = len;
+	}
+	if (len < 0 || (size > 0 && len > INT64_MAX / size)) {
+		ogo_panic_error("makeslice: len out of range");
+	}
+	if (cap < len || (size > 0 && cap > INT64_MAX / size)) {
+		ogo_panic_error("makeslice: cap out of range");
+	}
+	ogo_slice s = {ogo_alloc(cap * size, map), len, cap};
+	return s;
+}
+
+static ogo_slice ogo_slice_lit(const void *elems, ogo_int n, ogo_int size, const ogo_gcmap *map) {
+	ogo_slice s = ogo_make_slice(size, n, n, map);
+	memcpy(s.ptr, elems, n * size);
+	return s;
+}
+
+/* These are the sizes of the size classes of the gc allocator, which
+ * determine how much room append leaves for growth. */
+static const uint16_t ogo_size_classes[] = {
+	0, 8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208,
+	224, 240, 256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704,
+	768, 896, 1024, 1152, 1280, 1408, 1536, 1792, 2048, 2304, 2688, 3072,
+	3200, 3456, 4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728,
+	10240, 10880, 12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760,
+	24576, 27264, 28672, 32768,
+};
+
+static ogo_int ogo_roundupsize(ogo_int size, ogo_bool noscan) {
+	ogo_int req = size;
+	if (req <= 32768 - 8) {
+		if (!noscan && req > 512) {
+			req += 8; /* gc needs room for a malloc header */
+		}
+		size_t i;
+		for (i = 1; i < sizeof(ogo_size_classes) / sizeof(ogo_size_classes[0]); i++) {
+			if (ogo_size_classes[i] >= req) {
+				return ogo_size_classes[i] - (req - size);
+			}
+		}
+	}
+	return (req + 8191) & ~(ogo_int)8191;
+}
+
+/* ogo_grow_slice makes room in s for n more elements, growing the
+ * capacity just as gc's append does. */
+static ogo_slice ogo_grow_slice(ogo_slice s, ogo_int n, ogo_int size, const ogo_gcmap *map) {
+	ogo_int newlen = s.len + n;
+	if (newlen <= s.cap) {
+		s.len = newlen;
+		return s;
+	}
+	if (size == 0) {
+		ogo_slice out = {s.ptr, newlen, newlen};
+		return out;
+	}
+	ogo_int newcap = s.cap;
+	if (newlen > 2 * newcap) {
+		newcap = newlen;
+	} else if (newcap < 256) {
+		newcap = 2 * newcap;
+	} else {
+		while (newcap < newlen) {
+			newcap += (newcap + 3 * 256) >> 2;
+		}
+	}
+	newcap = ogo_roundupsize(newcap * size, map == NULL) / size;
+	ogo_slice out = {ogo_alloc(newcap * size, map), newlen, newcap};
+	if (s.len > 0) {
+		memcpy(out.ptr, s.ptr, s.len * size);
+	}
+	return out;
+}
+
+static ogo_slice ogo_append(ogo_slice s, const void *elems, ogo_int n, ogo_int size, const ogo_gcmap *map) {
+	ogo_int oldlen = s.len;
+	s = ogo_grow_slice(s, n, size, map);
+	if (n > 0) {
+		memmove((char *)s.ptr + oldlen * size, elems, n * size);
+	}
+	return s;
+}
+
+static ogo_slice ogo_append_slice(ogo_slice s, ogo_slice t, ogo_int size, const ogo_gcmap *map) {
+	return ogo_append(s, t.ptr, t.len, size, map);
+}
+
+static ogo_slice ogo_append_string(ogo_slice s, ogo_string t) {
+	return ogo_append(s, t.ptr, t.len, 1, NULL);
+}
+
+static ogo_int ogo_copy(ogo_slice dst, const void *src, ogo_int n, ogo_int size) {
+	if (dst.len < n) {
+		n = dst.len;
+	}
+	if (n > 0) {
+		memmove(dst.ptr, src, n * size);
+	}
+	return n;
+}
+
+static ogo_int ogo_copy_slice(ogo_slice dst, ogo_slice src, ogo_int size) {
+	return ogo_copy(dst, src.ptr, src.len, size);
+}
+
+static ogo_int ogo_copy_string(ogo_slice dst, ogo_string src) {
+	return ogo_copy(dst, src.ptr, src.len, 1);
+}
+
+/* Strings */
+
+static inline uint8_t ogo_string_index(ogo_string s, ogo_int i) {
+	return s.ptr[ogo_check_index(i, s.len)];
+}
+
+static inline uint8_t ogo_string_at(ogo_string s, ogo_int i) {
+	return s.ptr[i];
+}
+
+static ogo_string ogo_string_slice(ogo_string s, ogo_int lo, ogo_int hi) {
+	if (hi == OGO_NOINDEX) {
+		hi = s.len;
+	} else if ((uint64_t)hi > (uint64_t)s.len) {
+		ogo_panic_error("slice bounds out of range [:%lld] with length %lld",
+		                (long long)hi, (long long)s.len);
+	}
+	if ((uint64_t)lo > (uint64_t)hi) {
+		ogo_panic_error("slice bounds out of range [%lld:%lld]", (long long)lo, (long long)hi);
+	}
+	ogo_string out = {s.ptr + lo, hi - lo};
+	return out;
+}
+
+static ogo_string ogo_string_concat(ogo_string a, ogo_string b) {
+	if (a.len == 0) {
+		return b;
+	}
+	if (b.len == 0) {
+		return a;
+	}
+	uint8_t *p = ogo_alloc(a.len + b.len, NULL);
+	memcpy(p, a.ptr, a.len);
+	memcpy(p + a.len, b.ptr, b.len);
+	ogo_string out = {p, a.len + b.len};
+	return out;
+}
+
+static ogo_int ogo_string_cmp(ogo_string a, ogo_string b) {
+	ogo_int n = a.len < b.len ? a.len : b.len;
+	int c = n > 0 ? memcmp(a.ptr, b.ptr, n) : 0;
+	if (c != 0) {
+		return c;
+	}
+	return a.len < b.len ? -1 : a.len > b.len;
+}
+
+static ogo_bool ogo_string_eq(ogo_string a, ogo_string b) {
+	return a.len == b.len && (a.len == 0 || memcmp(a.ptr, b.ptr, a.len) == 0);
+}
+
+static ogo_string ogo_string_from_bytes(ogo_slice b) {
+	uint8_t *p = ogo_alloc(b.len, NULL);
+	if (b.len > 0) {
+		memcpy(p, b.ptr, b.len);
+	}
+	ogo_string out = {p, b.len};
+	return out;
+}
+
+static ogo_slice ogo_bytes_from_string(ogo_string s) {
+	ogo_slice out = ogo_make_slice(1, s.len, ogo_roundupsize(s.len, 1), NULL);
+	if (s.len > 0) {
+		memcpy(out.ptr, s.ptr, s.len);
+	}
+	return out;
+}
+
+/* ogo_encode_rune writes the UTF-8 encoding of r into p, returning
+ * its length. */
+static int ogo_encode_rune(uint8_t *p, int32_t r) {
+	if (r < 0 || r > 0x10FFFF || (r >= 0xD800 && r <= 0xDFFF)) {
+		r = 0xFFFD;
+	}
+	if (r < 0x80) {
+		p[0] = r;
+		return 1;
+	}
+	if (r < 0x800) {
+		p[0] = 0xC0 | (r >> 6);
+		p[1] = 0x80 | (r & 0x3F);
+		return 2;
+	}
+	if (r < 0x10000) {
+		p[0] = 0xE0 | (r >> 12);
+		p[1] = 0x80 | ((r >> 6) & 0x3F);
+		p[2] = 0x80 | (r & 0x3F);
+		return 3;
+	}
+	p[0] = 0xF0 | (r >> 18);
+	p[1] = 0x80 | ((r >> 12) & 0x3F);
+	p[2] = 0x80 | ((r >> 6) & 0x3F);
+	p[3] = 0x80 | (r & 0x3F);
+	return 4;
+}
+
+/* ogo_decode_rune decodes the rune starting at s[i], storing it in *r
+ * and returning the index of the following rune. */
+static ogo_int ogo_decode_rune(ogo_string s, ogo_int i, int32_t *r) {
+	const uint8_t *p = s.ptr + i;
+	ogo_int n = s.len - i;
+	uint8_t c = p[0];
+	*r = 0xFFFD;
+	if (c < 0x80) {
+		*r = c;
+		return i + 1;
+	}
+	if (c >= 0xC2 && c <= 0xDF && n >= 2 && (p[1] & 0xC0) == 0x80) {
+		*r = ((c & 0x1F) << 6) | (p[1] & 0x3F);
+		return i + 2;
+	}
+	if (c >= 0xE0 && c <= 0xEF && n >= 3 && (p[1] & 0xC0) == 0x80 && (p[2] & 0xC0) == 0x80) {
+		int32_t x = ((c & 0x0F) << 12) | ((p[1] & 0x3F) << 6) | (p[2] & 0x3F);
+		if (x >= 0x800 && (x < 0xD800 || x > 0xDFFF)) {
+			*r = x;
+			return i + 3;
+		}
+	}
+	if (c >= 0xF0 && c <= 0xF4 && n >= 4 && (p[1] & 0xC0) == 0x80 &&
+	    (p[2] & 0xC0) == 0x80 && (p[3] & 0xC0) == 0x80) {
+		int32_t x = ((c & 0x07) << 18) | ((p[1] & 0x3F) << 12) |
+		            ((p[2] & 0x3F) << 6) | (p[3] & 0x3F);
+		if (x >= 0x10000 && x <= 0x10FFFF) {
+			*r = x;
+			return i + 4;
+		}
+	}
+	return i + 1;
+}
+
+static ogo_string ogo_string_from_rune(ogo_int r) {
+	uint8_t *p = ogo_alloc(4, NULL);
+	if (r < INT32_MIN || r > INT32_MAX) {
+		r = 0xFFFD;
+	}
+	ogo_string out = {p, ogo_encode_rune(p, r)};
+	return out;
+}
+
+static ogo_slice ogo_runes_from_string(ogo_string s) {
+	ogo_int n = 0, i = 0;
+	int32_t r;
+	while (i < s.len) {
+		i = ogo_decode_rune(s, i, &r);
+		n++;
+	}
+	ogo_slice out = ogo_make_slice(4, n, ogo_roundupsize(4 * n, 1) / 4, NULL);
+	for (i = 0, n = 0; i < s.len; n++) {
+		i = ogo_decode_rune(s, i, &((int32_t *)out.ptr)[n]);
+	}
+	return out;
+}
+
+static ogo_string ogo_string_from_runes(ogo_slice rs) {
+	uint8_t *p = ogo_alloc(4 * rs.len, NULL);
+	ogo_int i, n = 0;
+	for (i = 0; i < rs.len; i++) {
+		n += ogo_encode_rune(p + n, ((int32_t *)rs.ptr)[i]);
+	}
+	ogo_string out = {p, n};
+	return out;
+}
+
+/* Interfaces */
+
+/* OGO_NAME gives the arguments to print the name of a type with %.*s. */
+#define OGO_NAME(t) (int)(t)->name.len, (const char *)(t)->name.ptr
+
+static inline void (*ogo_method_fn(ogo_iface x, ogo_int i))(void) {
+	if (x.itab == NULL) {
+		ogo_panic_nil();
+	}
+	return x.itab->fns[i];
+}
+
+/* ogo_equal_direct compares pointers that are stored directly in
+ * interfaces. */
+static ogo_bool ogo_equal_direct(const void *a, const void *b) {
+	return a == b;
+}
+
+static ogo_bool ogo_iface_eq(ogo_iface a, ogo_iface b) {
+	if (a.itab == NULL || b.itab == NULL) {
+		return a.itab == b.itab;
+	}
+	const ogo_type *t = a.itab->type;
+	if (t != b.itab->type) {
+		return 0;
+	}
+	if (t->equal == NULL) {
+		ogo_panic_error("comparing uncomparable type %.*s", OGO_NAME(t));
+	}
+	return t->equal(a.data, b.data);
+}
+
+/* The hash of a value depends on the seed it is given, which lets us
+ * hash the parts of a value in turn. */
+static ogo_uint ogo_memhash(const void *p, ogo_int n, ogo_uint h) {
+	const uint8_t *b = p;
+	h ^= 14695981039346656037ULL;
+	for (ogo_int i = 0; i < n; i++) {
+		h = (h ^ b[i]) * 1099511628211ULL;
+	}
+	return h;
+}
+
+static ogo_uint ogo_hash_string(const void *p, ogo_uint h) {
+	const ogo_string *s = p;
+	return ogo_memhash(s->ptr, s->len, h);
+}
+
+/* A floating point zero equals its negative, and a NaN equals nothing,
+ * not even itself, so it may as well hash at random. */
+static ogo_uint ogo_hash_float64(const void *p, ogo_uint h) {
+	double f = *(const double *)p;
+	if (f == 0) {
+		f = 0;
+	} else if (f != f) {
+		return ogo_memhash(&f, sizeof f, h ^ rand());
+	}
+	return ogo_memhash(&f, sizeof f, h);
+}
+
+static ogo_uint ogo_hash_float32(const void *p, ogo_uint h) {
+	double f = *(const float *)p;
+	return ogo_hash_float64(&f, h);
+}
+
+static ogo_uint ogo_hash_complex64(const void *p, ogo_uint h) {
+	const float *f = p;
+	return ogo_hash_float32(&f[1], ogo_hash_float32(&f[0], h));
+}
+
+static ogo_uint ogo_hash_complex128(const void *p, ogo_uint h) {
+	const double *f = p;
+	return ogo_hash_float64(&f[1], ogo_hash_float64(&f[0], h));
+}
+
+/* ogo_hash_direct hashes a pointer that is stored directly in an
+ * interface. */
+static ogo_uint ogo_hash_direct(const void *p, ogo_uint h) {
+	return ogo_memhash(&p, sizeof p, h);
+}
+
+static ogo_uint ogo_hash_iface(const void *p, ogo_uint h) {
+	const ogo_iface *x = p;
+	if (x->itab == NULL) {
+		return ogo_memhash(NULL, 0, h);
+	}
+	const ogo_type *t = x->itab->type;
+	if (t->hash == NULL) {
+		ogo_panic_error("hash of unhashable type %.*s", OGO_NAME(t));
+	}
+	return t->hash(x->data, ogo_memhash(&t, sizeof t, h));
+}
+
+/* ogo_find_method looks for a method of t by name and signature,
+ * returning NULL if t lacks it. */
+static void (*ogo_find_method(const ogo_type *t, ogo_string name))(void) {
+	for (ogo_int i = 0; i < t->nmethods; i++) {
+		if (ogo_string_eq(t->methods[i].name, name)) {
+			return t->methods[i].fn;
+		}
+	}
+	return NULL;
+}
+
+/* ogo_itab_for finds the itable for values of type t in interfaces of
+ * type iface, creating it the first time it is needed.  It returns
+ * NULL if t doesn't implement iface, storing the name of a missing
+ * method in *missing. */
+static const ogo_itab *ogo_itab_for(const ogo_type *t, const ogo_type *iface, ogo_string *missing) {
+	static struct ogo_itab_cache {
+		const ogo_type *t, *iface;
+		const ogo_itab *itab;
+		struct ogo_itab_cache *next;
+	} *cache = NULL;
+	for (struct ogo_itab_cache *c = cache; c != NULL; c = c->next) {
+		if (c->t == t && c->iface == iface) {
+			return c->itab;
+		}
+	}
+	for (ogo_int i = 0; i < iface->nmethods; i++) {
+		if (ogo_find_method(t, iface->methods[i].name) == NULL) {
+			*missing = iface->methods[i].name;
+			return NULL;
+		}
+	}
+	ogo_itab *itab = ogo_persistent_alloc(sizeof(ogo_itab) + iface->nmethods * sizeof(void (*)(void)));
+	itab->type = t;
+	for (ogo_int i = 0; i < iface->nmethods; i++) {
+		itab->fns[i] = ogo_find_method(t, iface->methods[i].name);
+	}
+	struct ogo_itab_cache *c = ogo_persistent_alloc(sizeof(struct ogo_itab_cache));
+	*c = (struct ogo_itab_cache){t, iface, itab, cache};
+	cache = c;
+	return itab;
+}
+
+/* ogo_iface_convert converts an interface value to another interface
+ * type that its static type implements. */
+static ogo_iface ogo_iface_convert(ogo_iface x, const ogo_type *iface) {
+	if (x.itab != NULL) {
+		ogo_string missing;
+		x.itab = ogo_itab_for(x.itab->type, iface, &missing);
+	}
+	return x;
+}
+
+/* ogo_is_type tells whether x holds a value of the type t. */
+static inline ogo_bool ogo_is_type(ogo_iface x, const ogo_type *t) {
+	return x.itab != NULL && x.itab->type == t;
+}
+
+/* ogo_implements tells whether x holds a value whose type implements
+ * the interface type iface. */
+static ogo_bool ogo_implements(ogo_iface x, const ogo_type *iface) {
+	ogo_string missing;
+	return x.itab != NULL && ogo_itab_for(x.itab->type, iface, &missing) != NULL;
+}
+
+/* ogo_assert_type asserts that x, of the interface type from, holds a
+ * value of type t, and returns its data pointer. */
+static void *ogo_assert_type(ogo_iface x, const ogo_type *from, const ogo_type *t) {
+	if (!ogo_is_type(x, t)) {
+		if (x.itab == NULL) {
+			ogo_panic_conversion("%.*s is nil, not %.*s", OGO_NAME(from), OGO_NAME(t));
+		}
+		ogo_panic_conversion("%.*s is %.*s, not %.*s", OGO_NAME(from),
+		                     OGO_NAME(x.itab->type), OGO_NAME(t));
+	}
+	return x.data;
+}
+
+/* ogo_assert_iface asserts that x holds a value whose type implements
+ * the interface type iface, and converts x to that type. */
+static ogo_iface ogo_assert_iface(ogo_iface x, const ogo_type *iface) {
+	if (x.itab == NULL) {
+		ogo_panic_conversion("interface is nil, not %.*s", OGO_NAME(iface));
+	}
+	ogo_string missing;
+	const ogo_itab *itab = ogo_itab_for(x.itab->type, iface, &missing);
+	if (itab == NULL) {
+		int n = 0;
+		while (n < missing.len && missing.ptr[n] != '(') {
+			n++;
+		}
+		ogo_panic_conversion("%.*s is not %.*s: missing method %.*s", OGO_NAME(x.itab->type),
+		                     OGO_NAME(iface), n, (const char *)missing.ptr);
+	}
+	x.itab = itab;
+	return x;
+}
+
+/* ogo_panic_variant panics as a type assertion does when a value of the
+ * sealed interface type from, whose variants are variants, holds the
+ * variant with the tag tag rather than a t. */
+static void ogo_panic_variant(const ogo_type *from, const ogo_type *const *variants, ogo_int tag,
+		const ogo_type *t) __attribute__((noreturn));
+static void ogo_panic_variant(const ogo_type *from, const ogo_type *const *variants, ogo_int tag,
+		const ogo_type *t) {
+	if (tag == 0) {
+		ogo_panic_conversion("%.*s is nil, not %.*s", OGO_NAME(from), OGO_NAME(t));
+	}
+	ogo_panic_conversion("%.*s is %.*s, not %.*s", OGO_NAME(from),
+	                     OGO_NAME(variants[tag - 1]), OGO_NAME(t));
+}
+
+/* Types */
+
+static const ogo_string ogo_kind_names[] = {
+	OGO_STR("invalid"), OGO_STR("bool"), OGO_STR("int"), OGO_STR("int8"),
+	OGO_STR("int16"), OGO_STR("int32"), OGO_STR("int64"), OGO_STR("uint"),
+	OGO_STR("uint8"), OGO_STR("uint16"), OGO_STR("uint32"), OGO_STR("uint64"),
+	OGO_STR("uintptr"), OGO_STR("float32"), OGO_STR("float64"), OGO_STR("complex64"),
+	OGO_STR("complex128"), OGO_STR("array"), OGO_STR("chan"), OGO_STR("func"),
+	OGO_STR("interface"), OGO_STR("map"), OGO_STR("ptr"), OGO_STR("slice"),
+	OGO_STR("string"), OGO_STR("struct"), OGO_STR("unsafe.Pointer"), OGO_STR("type"),
+};
+
+static inline ogo_Type ogo_type_check(ogo_Type t) {
+	if (t == NULL) {
+		ogo_panic_nil();
+	}
+	return t;
+}
+
+/* ogo_ptr_to finds the type of pointers to t, creating it if the
+ * program has no descriptor for it. */
+static ogo_Type ogo_ptr_to(ogo_Type t) {
+	if (t->ptrto == NULL) {
+		ogo_type *p = ogo_persistent_alloc(sizeof(ogo_type));
+		uint8_t *name = ogo_persistent_alloc(t->name.len + 1);
+		name[0] = '*';
+		memcpy(name + 1, t->name.ptr, t->name.len);
+		*p = (ogo_type){.name = {name, t->name.len + 1},
+		                .kind = OGO_KIND_POINTER, .size = sizeof(void *),
+		                .align = _Alignof(void *), .equal = ogo_equal_direct,
+		                .hash = ogo_hash_direct, .elem = t, .gcmap = &ogo_gcmap_pointer};
+		((ogo_type *)t)->ptrto = p;
+	}
+	return t->ptrto;
+}
+
+/* ogo_new allocates a zero value of type t, returning a pointer to it
+ * in an interface of the type any. */
+static ogo_iface ogo_new(ogo_Type t, const ogo_type *any) {
+	ogo_string missing;
+	ogo_type_check(t);
+	return (ogo_iface){ogo_itab_for(ogo_ptr_to(t), any, &missing), ogo_alloc(t->size, t->gcmap)};
+}
+
+/* ogo_typeof gives the dynamic type of x. */
+static inline ogo_Type ogo_typeof(ogo_iface x) {
+	return x.itab == NULL ? NULL : x.itab->type;
+}
+
+/* The methods of Type */
+
+static ogo_string ogo_Type__Name(ogo_Type t) {
+	return ogo_type_check(t)->name;
+}
+
+static ogo_string ogo_Type__Kind(ogo_Type t) {
+	return ogo_kind_names[ogo_type_check(t)->kind];
+}
+
+static ogo_int ogo_Type__Size(ogo_Type t) {
+	return ogo_type_check(t)->size;
+}
+
+static ogo_int ogo_Type__Align(ogo_Type t) {
+	return ogo_type_check(t)->align;
+}
+
+static ogo_Type ogo_Type__Elem(ogo_Type t) {
+	return ogo_type_check(t)->elem;
+}
+
+static ogo_int ogo_Type__Len(ogo_Type t) {
+	return ogo_type_check(t)->len;
+}
+
+static ogo_int ogo_Type__NumField(ogo_Type t) {
+	return ogo_type_check(t)->nfields;
+}
+
+static ogo_string ogo_Type__FieldName(ogo_Type t, ogo_int i) {
+	return ogo_type_check(t)->fields[ogo_check_index(i, t->nfields)].name;
+}
+
+static ogo_Type ogo_Type__FieldType(ogo_Type t, ogo_int i) {
+	return ogo_type_check(t)->fields[ogo_check_index(i, t->nfields)].type;
+}
+
+static ogo_int ogo_Type__NumMethod(ogo_Type t) {
+	return ogo_type_check(t)->nmethods;
+}
+
+/* ogo_Type__Method gives the name of a method, without its
+ * signature. */
+static ogo_string ogo_Type__Method(ogo_Type t, ogo_int i) {
+	ogo_string name = ogo_type_check(t)->methods[ogo_check_index(i, t->nmethods)].name;
+	ogo_int n = 0;
+	while (n < name.len && name.ptr[n] != '(') {
+		n++;
+	}
+	return ogo_string_slice(name, 0, n);
+}
+
+/* ogo_Type__Implements tells whether t implements the interface type
+ * u, which is false if u is not an interface type. */
+static ogo_bool ogo_Type__Implements(ogo_Type t, ogo_Type u) {
+	ogo_type_check(t);
+	if (ogo_type_check(u)->kind != OGO_KIND_INTERFACE) {
+		return 0;
+	}
+	for (ogo_int i = 0; i < u->nmethods; i++) {
+		ogo_int j = 0;
+		while (j < t->nmethods && !ogo_string_eq(t->methods[j].name, u->methods[i].name)) {
+			j++;
+		}
+		if (j == t->nmethods) {
+			return 0;
+		}
+	}
+	return 1;
+}
+
+/* Maps */
+
+/* ogo_seed_rand seeds rand, which gives the seeds of the hashes of
+ * maps and the orders that ranges over them take, so that each run of
+ * the program orders its maps differently, as gc's do. */
+__attribute__((constructor)) static void ogo_seed_rand(void) {
+	unsigned seed;
+	if (getrandom(&seed, sizeof seed, GRND_NONBLOCK) != sizeof seed) {
+		seed = (unsigned)time(NULL) ^ ((unsigned)getpid() << 16);
+	}
+	srand(seed);
+}
+
+/* The compiler describes each type of map with an ogo_maptype, giving
+ * the sizes of its keys and elements, the functions that hash and
+ * compare its keys given pointers to them, and their pointer maps.
+ * The runtime fills in the pointer map of an entry. */
+typedef struct {
+	ogo_int keysize, keyalign, elemsize, elemalign;
+	ogo_uint (*hash)(const void *, ogo_uint);
+	ogo_bool (*equal)(const void *, const void *);
+	const ogo_gcmap *keymap, *elemmap;
+	ogo_gcmap entrymap;
+	ogo_gcptr entryptrs[2];
+} ogo_maptype;
+
+/* A map keeps its entries in an array in the order they were added,
+ * each holding the hash of its key, whether it is still in the map,
+ * the key and the element.  Its index is a hash table with linear
+ * probing, holding one more than the position of each entry, or 0 in
+ * a free slot, or -1 where an entry was deleted.  When the array
+ * fills up we move the live entries to a new one, leaving the old one
+ * to any iterators still going through it. */
+typedef struct {
+	ogo_uint hash;
+	ogo_bool live;
+} ogo_entry;
+
+struct ogo_hmap {
+	const ogo_maptype *type;
+	ogo_int count;
+	ogo_uint seed;
+	ogo_int keyoff, elemoff, entrysize;
+	char *entries;
+	ogo_int used, cap;
+	ogo_int *index;
+	ogo_int mask;
+};
+
+static const ogo_gcptr ogo_gcptrs_hmap[] = {
+	{offsetof(struct ogo_hmap, entries), 1, NULL},
+	{offsetof(struct ogo_hmap, index), 1, NULL},
+};
+static const ogo_gcmap ogo_gcmap_hmap = {sizeof(struct ogo_hmap), 2, ogo_gcptrs_hmap};
+
+#define OGO_ROUND(n, a) (((n) + (a) - 1) / (a) * (a))
+
+static inline ogo_entry *ogo_map_entry(char *entries, ogo_map m, ogo_int i) {
+	return (ogo_entry *)(entries + i * m->entrysize);
+}
+
+/* ogo_map_slot finds the slot of the index where the key with hash h
+ * is, or else the free slot where it would go. */
+static ogo_int ogo_map_slot(ogo_map m, const void *key, ogo_uint h) {
+	ogo_uint mix = (h ^ (h >> 29)) * 0xbf58476d1ce4e5b9ULL;
+	ogo_int i = (mix ^ (mix >> 32)) & m->mask;
+	for (;; i = (i + 1) & m->mask) {
+		ogo_int pos = m->index[i];
+		if (pos == 0) {
+			return i;
+		}
+		if (pos > 0) {
+			ogo_entry *e = ogo_map_entry(m->entries, m, pos - 1);
+			if (e->hash == h && m->type->equal((char *)e + m->keyoff, key)) {
+				return i;
+			}
+		}
+	}
+}
+
+/* ogo_map_resize moves the live entries of m to a new array with room
+ * for cap entries, and indexes them afresh. */
+static void ogo_map_resize(ogo_map m, ogo_int cap) {
+	char *old = m->entries;
+	ogo_int used = m->used, size = 2;
+	while (size < 2 * cap) {
+		size *= 2;
+	}
+	m->entries = ogo_alloc(cap * m->entrysize, &m->type->entrymap);
+	m->cap = cap;
+	m->used = 0;
+	m->index = ogo_alloc(size * sizeof(ogo_int), NULL);
+	m->mask = size - 1;
+	for (ogo_int i = 0; i < used; i++) {
+		ogo_entry *e = ogo_map_entry(old, m, i);
+		if (e->live) {
+			memcpy(ogo_map_entry(m->entries, m, m->used), e, m->entrysize);
+			m->index[ogo_map_slot(m, (char *)e + m->keyoff, e->hash)] = ++m->used;
+		}
+	}
+}
+
+static ogo_map ogo_make_map(ogo_maptype *t, ogo_int hint) {
+	if (hint < 0) {
+		ogo_panic_error("makemap: size out of range");
+	}
+	ogo_map m = ogo_alloc(sizeof(struct ogo_hmap), &ogo_gcmap_hmap);
+	ogo_int align = sizeof(ogo_uint);
+	align = t->keyalign > align ? t->keyalign : align;
+	align = t->elemalign > align ? t->elemalign : align;
+	m->type = t;
+	m->seed = ((ogo_uint)rand() << 32) ^ rand();
+	m->keyoff = OGO_ROUND((ogo_int)sizeof(ogo_entry), t->keyalign);
+	m->elemoff = OGO_ROUND(m->keyoff + t->keysize, t->elemalign);
+	m->entrysize = OGO_ROUND(m->elemoff + t->elemsize, align);
+	if (t->entrymap.size == 0) {
+		ogo_int n = 0;
+		if (t->keymap != NULL) {
+			t->entryptrs[n++] = (ogo_gcptr){m->keyoff, 1, t->keymap};
+		}
+		if (t->elemmap != NULL) {
+			t->entryptrs[n++] = (ogo_gcptr){m->elemoff, 1, t->elemmap};
+		}
+		t->entrymap = (ogo_gcmap){m->entrysize, n, t->entryptrs};
+	}
+	ogo_map_resize(m, hint > 8 ? hint : 8);
+	return m;
+}
+
+static inline ogo_int ogo_map_len(ogo_map m) {
+	return m == NULL ? 0 : m->count;
+}
+
+/* ogo_map_access gives a pointer to the element of m with the given
+ * key, or NULL if there is none. */
+static void *ogo_map_access(ogo_map m, const void *key) {
+	if (m == NULL || m->count == 0) {
+		return NULL;
+	}
+	ogo_uint h = m->type->hash(key, m->seed);
+	ogo_int pos = m->index[ogo_map_slot(m, key, h)];
+	return pos > 0 ? (char *)ogo_map_entry(m->entries, m, pos - 1) + m->elemoff : NULL;
+}
+
+/* ogo_map_assign gives a pointer to the element of m with the given
+ * key, adding it with a zero element if need be. */
+static void *ogo_map_assign(ogo_map m, const void *key) {
+	if (m == NULL) {
+		ogo_raise("assignment to entry in nil map", 0);
+	}
+	ogo_uint h = m->type->hash(key, m->seed);
+	ogo_int i = ogo_map_slot(m, key, h);
+	if (m->index[i] > 0) {
+		return (char *)ogo_map_entry(m->entries, m, m->index[i] - 1) + m->elemoff;
+	}
+	if (m->used == m->cap) {
+		ogo_map_resize(m, 2 * m->count > 8 ? 2 * m->count : 8);
+		i = ogo_map_slot(m, key, h);
+	}
+	ogo_entry *e = ogo_map_entry(m->entries, m, m->used);
+	e->hash = h;
+	e->live = 1;
+	memcpy((char *)e + m->keyoff, key, m->type->keysize);
+	m->index[i] = ++m->used;
+	m->count++;
+	return (char *)e + m->elemoff;
+}
+
+static void ogo_map_delete(ogo_map m, const void *key) {
+	if (m == NULL || m->count == 0) {
+		return;
+	}
+	ogo_uint h = m->type->hash(key, m->seed);
+	ogo_int i = ogo_map_slot(m, key, h);
+	if (m->index[i] > 0) {
+		ogo_entry *e = ogo_map_entry(m->entries, m, m->index[i] - 1);
+		memset(e, 0, m->entrysize);
+		m->index[i] = -1;
+		m->count--;
+	}
+}
+
+/* An iterator goes through the entries that m had when it started,
+ * in a random order, and then through any added since.  The order
+ * maps each i below the power of two size to ((mul*i + add) mod size)
+ * xor mask, with a random odd mul and random add and mask, which
+ * shuffles the positions, skipping those past the entries.  If m has
+ * moved its entries since, it looks each one up to see whether it is
+ * still there. */
+typedef struct {
+	ogo_map m;
+	char *entries;
+	ogo_int n, i;
+	ogo_uint size, mul, add, mask;
+	void *key, *elem;
+} ogo_map_iter;
+
+static const ogo_gcptr ogo_gcptrs_map_iter[] = {
+	{offsetof(ogo_map_iter, m), 1, NULL},
+	{offsetof(ogo_map_iter, entries), 1, NULL},
+	{offsetof(ogo_map_iter, key), 1, NULL},
+	{offsetof(ogo_map_iter, elem), 1, NULL},
+};
+static const ogo_gcmap ogo_gcmap_map_iter = {sizeof(ogo_map_iter), 4, ogo_gcptrs_map_iter};
+
+static void ogo_map_iterinit(ogo_map_iter *it, ogo_map m) {
+	it->m = m;
+	it->i = 0;
+	it->n = m == NULL || m->count == 0 ? 0 : m->used;
+	if (it->n > 0) {
+		it->entries = m->entries;
+		for (it->size = 1; it->size < (ogo_uint)it->n; it->size *= 2) {
+		}
+		it->mul = ((ogo_uint)rand() << 1) | 1;
+		it->add = rand() & (it->size - 1);
+		it->mask = rand() & (it->size - 1);
+	}
+}
+
+static ogo_bool ogo_map_next(ogo_map_iter *it) {
+	ogo_map m = it->m;
+	while (it->n > 0) {
+		ogo_bool moved = it->entries != m->entries;
+		ogo_int pos;
+		if ((ogo_uint)it->i < it->size) {
+			pos = ((it->mul * it->i + it->add) & (it->size - 1)) ^ it->mask;
+			it->i++;
+			if (pos >= it->n) {
+				continue;
+			}
+		} else {
+			pos = it->n + (it->i - it->size);
+			if (pos >= (moved ? it->n : m->used)) {
+				return 0;
+			}
+			it->i++;
+		}
+		ogo_entry *e = ogo_map_entry(it->entries, m, pos);
+		if (!e->live) {
+			continue;
+		}
+		it->key = (char *)e + m->keyoff;
+		it->elem = (char *)e + m->elemoff;
+		if (moved) {
+			void *elem = ogo_map_access(m, it->key);
+			if (elem == NULL && m->type->equal(it->key, it->key)) {
+				/* It has been deleted since. */
+				continue;
+			}
+			if (elem != NULL) {
+				it->elem = elem;
+			}
+		}
+		return 1;
+	}
+	return 0;
+}
+
+/* Printing, which goes to stderr just as it does with gc. */
+
+static void ogo_print_string(ogo_string s) {
+	fwrite(s.ptr, 1, s.len, stderr);
+}
+
+static void ogo_print_space(void) {
+	fputc(' ', stderr);
+}
+
+static void ogo_print_nl(void) {
+	fputc('\n', stderr);
+}
+
+static void ogo_print_bool(ogo_bool b) {
+	fputs(b ? "true" : "false", stderr);
+}
+
+static void ogo_print_int(int64_t i) {
+	fprintf(stderr, "%lld", (long long)i);
+}
+
+static void ogo_print_uint(uint64_t i) {
+	fprintf(stderr, "%llu", (unsigned long long)i);
+}
+
+static void ogo_print_pointer(const void *p) {
+	fprintf(stderr, "0x%llx", (unsigned long long)(uintptr_t)p);
+}
+
+static void ogo_print_slice(ogo_slice s) {
+	fprintf(stderr, "[%lld/%lld]", (long long)s.len, (long long)s.cap);
+	ogo_print_pointer(s.ptr);
+}
+
+static void ogo_print_iface(ogo_iface x) {
+	fputc('(', stderr);
+	ogo_print_pointer(x.itab == NULL ? NULL : x.itab->type);
+	fputc(',', stderr);
+	ogo_print_pointer(x.data);
+	fputc(')', stderr);
+}
+
+/* ogo_format_float formats f just as strconv.FormatFloat(f, 'g', -1,
+ * bits) does, which is how gc prints floats. */
+static void ogo_format_float(char *out, double f, int bits) {
+	char buf[40];
+	int prec, nd = 0, exp, i;
+	char digits[20];
+	if (isnan(f)) {
+		strcpy(out, "NaN");
+		return;
+	}
+	if (isinf(f)) {
+		strcpy(out, f > 0 ? "+Inf" : "-Inf");
+		return;
+	}
+	if (f == 0) {
+		strcpy(out, signbit(f) ? "-0" : "0");
+		return;
+	}
+	/* Find the shortest decimal that reads back as the same number. */
+	for (prec = 1; prec <= 17; prec++) {
+		snprintf(buf, sizeof(buf), "%.*e", prec - 1, f);
+		if (bits == 32 ? strtof(buf, NULL) == (float)f : strtod(buf, NULL) == f) {
+			break;
+		}
+	}
+	char *p = buf;
+	if (*p == '-') {
+		*out++ = *p++;
+	}
+	for (; *p != 'e'; p++) {
+		if (*p != '.') {
+			digits[nd++] = *p;
+		}
+	}
+	exp = atoi(p + 1);
+	while (nd > 1 && digits[nd - 1] == '0') {
+		nd--;
+	}
+	if (exp < -4 || exp >= 6) {
+		*out++ = digits[0];
+		if (nd > 1) {
+			*out++ = '.';
+			for (i = 1; i < nd; i++) {
+				*out++ = digits[i];
+			}
+		}
+		sprintf(out, "e%c%02d", exp < 0 ? '-' : '+', exp < 0 ? -exp : exp);
+		return;
+	}
+	if (exp < 0) {
+		*out++ = '0';
+		*out++ = '.';
+		for (i = -1; i > exp; i--) {
+			*out++ = '0';
+		}
+		for (i = 0; i < nd; i++) {
+			*out++ = digits[i];
+		}
+	} else {
+		for (i = 0; i <= exp; i++) {
+			*out++ = i < nd ? digits[i] : '0';
+		}
+		if (nd > exp + 1) {
+			*out++ = '.';
+			for (; i < nd; i++) {
+				*out++ = digits[i];
+			}
+		}
+	}
+	*out = 0;
+}
+
+static void ogo_print_float(double f, int bits) {
+	char buf[40];
+	ogo_format_float(buf, f, bits);
+	fputs(buf, stderr);
+}
+
+static void ogo_print_complex(double _Complex c, int bits) {
+	char buf[40];
+	fputc('(', stderr);
+	ogo_print_float(creal(c), bits);
+	ogo_format_float(buf, cimag(c), bits);
+	if (buf[0] != '-' && buf[0] != '+') {
+		fputc('+', stderr);
+	}
+	fputs(buf, stderr);
+	fputs("i)", stderr);
+}
+
+/* Defer, panic and recover */
+
+/* Each call of a function with defer statements has an ogo_frame,
+ * holding the calls it has deferred, most recent first.  A panic jumps
+ * to the innermost frame, whose function then runs its deferred calls
+ * with ogo_run_defers, just as it does when it returns, after which the
+ * panic moves on to the next frame out, unless a deferred call has
+ * recovered it. */
+typedef struct ogo_defer {
+	struct ogo_defer *next;
+	/* call is given the ogo_defer, which begins a record of the call */
+	void (*call)(void *);
+} ogo_defer;
+
+/* A frame also remembers the shadow stack of its function, to which a
+ * panic goes back along with the jump. */
+typedef struct ogo_frame {
+	struct ogo_frame *prev;
+	ogo_defer *defers;
+	ogo_gcframe *gctop;
+	ogo_int keep;
+	jmp_buf jmp;
+} ogo_frame;
+
+/* A panic links to the one that was running deferred calls when it
+ * began, which it aborts if it reaches the frame running them. */
+typedef struct ogo_panicking {
+	struct ogo_panicking *link;
+	ogo_iface value;
+	/* the frame whose deferred calls the panic is running */
+	ogo_frame *frame;
+	ogo_bool recovered, aborted, repanicked, signal;
+} ogo_panicking;
+
+static const ogo_gcptr ogo_gcptrs_panicking[] = {
+	{offsetof(ogo_panicking, link), 1, NULL},
+	{offsetof(ogo_panicking, value), 1, &ogo_gcmap_iface},
+};
+static const ogo_gcmap ogo_gcmap_panicking = {sizeof(ogo_panicking), 2, ogo_gcptrs_panicking};
+
+static ogo_frame *ogo_frames;
+static ogo_panicking *ogo_panics;
+
+/* ogo_deferred is the code of the deferred call that is starting.  A
+ * function that calls recover checks on entry whether it is that code,
+ * since only a deferred call can recover. */
+static void (*ogo_deferred)(void);
+
+static inline ogo_bool ogo_take_deferred(void (*code)(void)) {
+	ogo_bool direct = ogo_deferred == code;
+	ogo_deferred = NULL;
+	return direct;
+}
+
+/* ogo_forward_deferred passes on the right to recover when the
+ * deferred code is a wrapper that calls the real function. */
+static inline void ogo_forward_deferred(void (*wrapper)(void), void (*code)(void)) {
+	if (ogo_deferred == wrapper) {
+		ogo_deferred = code;
+	}
+}
+
+static inline void ogo_enter(ogo_frame *f) {
+	f->prev = ogo_frames;
+	f->defers = NULL;
+	f->gctop = ogo_gctop;
+	f->keep = ogo_keep.len;
+	ogo_frames = f;
+}
+
+static inline void ogo_push_defer(ogo_frame *f, ogo_defer *d) {
+	d->next = f->defers;
+	f->defers = d;
+}
+
+/* A runtime error is an error holding its message. */
+static ogo_string ogo_runtime_error_Error(void *data) {
+	return *(ogo_string *)data;
+}
+
+static ogo_bool ogo_runtime_error_equal(const void *a, const void *b) {
+	return ogo_string_eq(*(const ogo_string *)a, *(const ogo_string *)b);
+}
+
+static const ogo_method ogo_runtime_error_methods[] = {
+	{OGO_STR("Error() string"), (void (*)(void))ogo_runtime_error_Error},
+};
+
+static const ogo_type ogo_type_runtime_error = {
+	.name = OGO_STR("runtime.Error"),
+	.kind = OGO_KIND_STRING,
+	.size = sizeof(ogo_string),
+	.align = _Alignof(ogo_string),
+	.equal = ogo_runtime_error_equal,
+	.hash = ogo_hash_string,
+	.nmethods = 1,
+	.methods = ogo_runtime_error_methods,
+};
+
+static const ogo_itab ogo_itab_runtime_error = {
+	&ogo_type_runtime_error, {(void (*)(void))ogo_runtime_error_Error},
+};
+
+static void ogo_print_indented(ogo_string s) {
+	for (ogo_int i = 0; i < s.len; i++) {
+		fputc(s.ptr[i], stderr);
+		if (s.ptr[i] == '\n') {
+			fputc('\t', stderr);
+		}
+	}
+}
+
+/* ogo_print_panic_value prints the value of a panic as gc does. */
+static void ogo_print_panic_value(ogo_iface v) {
+	if (v.itab == NULL) {
+		fputs("nil", stderr);
+		return;
+	}
+	const ogo_type *t = v.itab->type;
+	void (*m)(void) = ogo_find_method(t, OGO_STR("Error() string"));
+	if (m == NULL) {
+		m = ogo_find_method(t, OGO_STR("String() string"));
+	}
+	if (m != NULL) {
+		ogo_print_indented(((ogo_string (*)(void *))m)(v.data));
+		return;
+	}
+	/* A value of a named type is shown with its type. */
+	ogo_bool named = memchr(t->name.ptr, '.', t->name.len) != NULL;
+	const void *p = v.data;
+	if (named && t->kind != OGO_KIND_COMPLEX64 && t->kind != OGO_KIND_COMPLEX128) {
+		ogo_print_string(t->name);
+		fputs(t->kind == OGO_KIND_STRING ? "(\"" : "(", stderr);
+	}
+	switch (t->kind) {
+	case OGO_KIND_BOOL: ogo_print_bool(*(const ogo_bool *)p); break;
+	case OGO_KIND_INT: ogo_print_int(*(const ogo_int *)p); break;
+	case OGO_KIND_INT8: ogo_print_int(*(const ogo_int8 *)p); break;
+	case OGO_KIND_INT16: ogo_print_int(*(const ogo_int16 *)p); break;
+	case OGO_KIND_INT32: ogo_print_int(*(const ogo_int32 *)p); break;
+	case OGO_KIND_INT64: ogo_print_int(*(const ogo_int64 *)p); break;
+	case OGO_KIND_UINT: ogo_print_uint(*(const ogo_uint *)p); break;
+	case OGO_KIND_UINT8: ogo_print_uint(*(const ogo_uint8 *)p); break;
+	case OGO_KIND_UINT16: ogo_print_uint(*(const ogo_uint16 *)p); break;
+	case OGO_KIND_UINT32: ogo_print_uint(*(const ogo_uint32 *)p); break;
+	case OGO_KIND_UINT64: ogo_print_uint(*(const ogo_uint64 *)p); break;
+	case OGO_KIND_UINTPTR: ogo_print_uint(*(const ogo_uintptr *)p); break;
+	case OGO_KIND_FLOAT32: ogo_print_float(*(const ogo_float32 *)p, 32); break;
+	case OGO_KIND_FLOAT64: ogo_print_float(*(const ogo_float64 *)p, 64); break;
+	case OGO_KIND_COMPLEX64:
+		if (named) {
+			ogo_print_string(t->name);
+		}
+		ogo_print_complex(*(const ogo_complex64 *)p, 32);
+		return;
+	case OGO_KIND_COMPLEX128:
+		if (named) {
+			ogo_print_string(t->name);
+		}
+		ogo_print_complex(*(const ogo_complex128 *)p, 64);
+		return;
+	case OGO_KIND_STRING: ogo_print_indented(*(const ogo_string *)p); break;
+	default:
+		fprintf(stderr, "(%.*s) ", OGO_NAME(t));
+		ogo_print_pointer(p);
+		return;
+	}
+	if (named) {
+		fputs(t->kind == OGO_KIND_STRING ? "\")" : ")", stderr);
+	}
+}
+
+/* ogo_print_panics prints the chain of panics ending with p, oldest
+ * first, leaving out a value that was recovered and then panicked
+ * with again. */
+static void ogo_print_panics(ogo_panicking *p) {
+	ogo_bool repanicked = 0;
+	if (p->link != NULL) {
+		ogo_iface a = p->link->value, b = p->value;
+		repanicked = a.data == b.data &&
+			(a.itab == NULL ? b.itab == NULL : b.itab != NULL && a.itab->type == b.itab->type);
+		p->link->repanicked = repanicked;
+		ogo_print_panics(p->link);
+		if (repanicked) {
+			return;
+		}
+		fputc('\t', stderr);
+	}
+	fputs("panic: ", stderr);
+	ogo_print_panic_value(p->value);
+	if (p->recovered && p->repanicked) {
+		fputs(" [recovered, repanicked]", stderr);
+	} else if (p->recovered) {
+		fputs(" [recovered]", stderr);
+	}
+	fputc('\n', stderr);
+}
+
+/* ogo_unwind moves the current panic on to the innermost frame, or
+ * crashes if there is none left. */
+static void ogo_unwind(void) __attribute__((noreturn));
+static void ogo_unwind(void) {
+	ogo_panicking *p = ogo_panics;
+	ogo_frame *f = ogo_frames;
+	if (f == NULL) {
+		fflush(stdout);
+		ogo_print_panics(p);
+		if (p->signal) {
+			fputs("[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x0]\n", stderr);
+		}
+		ogo_die();
+	}
+	for (ogo_panicking *q = p->link; q != NULL; q = q->link) {
+		if (q->frame == f) {
+			q->aborted = 1;
+		}
+	}
+	p->frame = f;
+	ogo_gctop = f->gctop;
+	ogo_keep.len = f->keep;
+	longjmp(f->jmp, 1);
+}
+
+static void ogo_gopanic(ogo_iface v, ogo_bool signal) __attribute__((noreturn));
+static void ogo_gopanic(ogo_iface v, ogo_bool signal) {
+	ogo_panicking *p = ogo_alloc(sizeof(ogo_panicking), &ogo_gcmap_panicking);
+	p->link = ogo_panics;
+	p->value = v;
+	p->signal = signal;
+	ogo_panics = p;
+	ogo_unwind();
+}
+
+static void ogo_panic(ogo_iface v) __attribute__((noreturn));
+static void ogo_panic(ogo_iface v) {
+	if (v.itab == NULL) {
+		ogo_raise("runtime error: panic called with nil argument", 0);
+	}
+	ogo_gopanic(v, 0);
+}
+
+static void ogo_raise(const char *msg, ogo_bool signal) {
+	ogo_string *s = ogo_alloc(sizeof(ogo_string), &ogo_gcmap_string);
+	*s = (ogo_string){(const uint8_t *)msg, strlen(msg)};
+	ogo_gopanic((ogo_iface){&ogo_itab_runtime_error, s}, signal);
+}
+
+/* ogo_recover stops the current panic, if direct is true, meaning that
+ * a deferred call is calling it. */
+static ogo_iface ogo_recover(ogo_bool direct) {
+	ogo_panicking *p = ogo_panics;
+	if (!direct || p == NULL || p->recovered || p->aborted) {
+		return (ogo_iface){NULL, NULL};
+	}
+	p->recovered = 1;
+	return p->value;
+}
+
+/* ogo_run_defers runs the deferred calls of the frame f, when its
+ * function returns or a panic reaches it, and then leaves the frame,
+ * carrying on with the panic unless it is over. */
+static void ogo_run_defers(ogo_frame *f) {
+	for (ogo_defer *d; (d = f->defers) != NULL;) {
+		f->defers = d->next;
+		d->call(d);
+		ogo_panicking *p = ogo_panics;
+		if (p != NULL && p->frame == f && p->recovered) {
+			/* The panic is over, along with those it aborted. */
+			do {
+				p = p->link;
+			} while (p != NULL && p->aborted);
+			ogo_panics = p;
+		}
+	}
+	ogo_frames = f->prev;
+	if (ogo_panics != NULL && ogo_panics->frame == f) {
+		ogo_unwind();
+	}
+}
+
+/* Goroutines and channels */
+
+/* Each goroutine runs on its own stack, switching to another only when
+ * it must wait for a channel, or when it finishes.  The running
+ * goroutine holds the defer and panic state above, which the others
+ * keep in their ogo_g. */
+typedef struct ogo_g {
+	ucontext_t ctx;
+	ogo_int id;
+	/* the next goroutine in the run queue or the free list, and the
+	 * next of all the goroutines there have been */
+	struct ogo_g *next, *all;
+	void (*fn)(void *);
+	void *arg;
+	void *stack;
+	/* where the stack was when the goroutine last stopped */
+	void *sp;
+	ogo_bool dead;
+	ogo_frame *frames;
+	ogo_panicking *panics;
+	void (*deferred)(void);
+	ogo_gcframe *gctop;
+	ogo_keepstack keep;
+	/* why the goroutine is waiting, for the deadlock message */
+	const char *reason;
+	/* ticket changes each time the goroutine stops waiting, so that
+	 * the other channels a select waited for can tell. */
+	ogo_int ticket;
+	/* the case of a select that let it go on, and whether the
+	 * channel was open */
+	ogo_int selected;
+	ogo_bool ok;
+} ogo_g;
+
+/* Each goroutine but the first runs on a stack of OGO_STACK_SIZE
+ * bytes, which are mapped as the goroutine uses them, above a guard
+ * page that stops it running off the end. */
+#define OGO_STACK_SIZE (8 * 1024 * 1024)
+
+static ogo_g ogo_g0 = {.id = 1};
+static ogo_g *ogo_current = &ogo_g0;
+static ogo_g *ogo_runq, *ogo_runq_tail, *ogo_gfree, *ogo_allg = &ogo_g0;
+
+#ifdef OGO_BOEHM
+
+/* The collector finds pointers on the stack of the running goroutine
+ * by itself, once ogo_switch has told it where the stack is, but the
+ * others are up to us. */
+static void ogo_push_stacks(void) {
+	for (ogo_g *g = ogo_allg; g != NULL; g = g->all) {
+		if (g == ogo_current || g->dead) {
+			continue;
+		}
+		void *top = g == &ogo_g0 ? ogo_stack_base.mem_base : (char *)g->stack + OGO_STACK_SIZE;
+		GC_push_all(g->sp, top);
+	}
+	if (ogo_push_other_roots != NULL) {
+		ogo_push_other_roots();
+	}
+}
+
+static void ogo_set_stack(ogo_g *g) {
+	struct GC_stack_base sb = ogo_stack_base;
+	if (g != &ogo_g0) {
+		sb.mem_base = (char *)g->stack + OGO_STACK_SIZE;
+	}
+	GC_set_stackbottom(NULL, &sb);
+}
+
+#else
+
+static void ogo_set_stack(ogo_g *g) {
+	(void)g;
+}
+
+#endif
+
+static void ogo_ready(ogo_g *g) {
+	g->next = NULL;
+	if (ogo_runq == NULL) {
+		ogo_runq = g;
+	} else {
+		ogo_runq_tail->next = g;
+	}
+	ogo_runq_tail = g;
+}
+
+/* ogo_switch runs the goroutine to, leaving the current one, which
+ * carries on when something switches back to it. */
+static void ogo_switch(ogo_g *to) {
+	ogo_g *from = ogo_current;
+	from->frames = ogo_frames;
+	from->panics = ogo_panics;
+	from->deferred = ogo_deferred;
+	from->gctop = ogo_gctop;
+	from->keep = ogo_keep;
+	ogo_frames = to->frames;
+	ogo_panics = to->panics;
+	ogo_deferred = to->deferred;
+	ogo_gctop = to->gctop;
+	ogo_keep = to->keep;
+	ogo_current = to;
+	ogo_goid = to->id;
+	from->sp = __builtin_frame_address(0);
+	ogo_set_stack(to);
+	swapcontext(&from->ctx, &to->ctx);
+}
+
+/* ogo_schedule switches to the next goroutine that may run, which
+ * there must be unless every goroutine is waiting. */
+static void ogo_schedule(void) {
+	ogo_g *g = ogo_runq;
+	if (g == NULL) {
+		fflush(stdout);
+		fprintf(stderr, "fatal error: all goroutines are asleep - deadlock!\n\n"
+			"goroutine 1 [%s]:\n", ogo_g0.reason);
+		exit(2);
+	}
+	ogo_runq = g->next;
+	ogo_switch(g);
+}
+
+/* ogo_park makes the current goroutine wait until another readies it. */
+static void ogo_park(const char *reason) {
+	ogo_current->reason = reason;
+	ogo_schedule();
+}
+
+static void ogo_goexit(void) {
+	ogo_g *g = ogo_current;
+	/* The stack is only reused once we have switched away from it. */
+	g->next = ogo_gfree;
+	g->dead = 1;
+	g->arg = NULL;
+	ogo_keep.len = 0;
+	ogo_gfree = g;
+	ogo_park("dead");
+}
+
+/* ogo_stack_overflow catches a goroutine that reaches the guard page
+ * below its stack, on a stack of its own, since the goroutine has no
+ * more. */
+static void ogo_stack_overflow(int sig, siginfo_t *info, void *ctx) {
+	char *addr = info->si_addr;
+	long page = sysconf(_SC_PAGESIZE);
+	for (ogo_g *g = ogo_allg; g != NULL; g = g->all) {
+		if (g != &ogo_g0 && addr >= (char *)g->stack - page && addr < (char *)g->stack) {
+			char msg[128];
+			int n = snprintf(msg, sizeof msg, "runtime: goroutine stack exceeds %d-byte limit\n"
+			                 "fatal error: stack overflow\n", OGO_STACK_SIZE);
+			write(2, msg, n);
+			_exit(2);
+		}
+	}
+	/* Any other fault is none of ours, and crashes as it would have. */
+	signal(sig, SIG_DFL);
+}
+
+/* ogo_new_stack maps a stack and its guard page, the first time it is
+ * called setting up ogo_stack_overflow to catch a goroutine that runs
+ * into one. */
+static void *ogo_new_stack(void) {
+	static char altstack[64 * 1024];
+	static ogo_bool caught;
+	if (!caught) {
+		caught = 1;
+		stack_t ss = {.ss_sp = altstack, .ss_size = sizeof altstack};
+		sigaltstack(&ss, NULL);
+		struct sigaction sa = {.sa_sigaction = ogo_stack_overflow, .sa_flags = SA_SIGINFO | SA_ONSTACK};
+		sigemptyset(&sa.sa_mask);
+		sigaction(SIGSEGV, &sa, NULL);
+		sigaction(SIGBUS, &sa, NULL);
+	}
+	long page = sysconf(_SC_PAGESIZE);
+	char *p = mmap(NULL, page + OGO_STACK_SIZE, PROT_READ | PROT_WRITE,
+	               MAP_PRIVATE | MAP_ANONYMOUS | MAP_NORESERVE, -1, 0);
+	if (p == MAP_FAILED) {
+		ogo_out_of_memory();
+	}
+	mprotect(p, page, PROT_NONE);
+	return p + page;
+}
+
+static void ogo_gostart(void) {
+	ogo_current->fn(ogo_current->arg);
+	ogo_goexit();
+}
+
+/* ogo_go starts a goroutine calling fn with arg, which is the record
+ * of a go statement.  Goroutines are never freed, but reused, and the
+ * collector only looks at their stacks through their shadow stacks, or
+ * through ogo_push_stacks. */
+static void ogo_go(void (*fn)(void *), void *arg) {
+	static ogo_int ids = 1;
+	ogo_g *g = ogo_gfree;
+	if (g != NULL) {
+		ogo_gfree = g->next;
+	} else {
+		g = ogo_persistent_alloc(sizeof(ogo_g));
+		g->stack = ogo_new_stack();
+		g->all = ogo_allg;
+		ogo_allg = g;
+	}
+	g->dead = 0;
+	g->id = ++ids;
+	g->fn = fn;
+	g->arg = arg;
+	g->frames = NULL;
+	g->panics = NULL;
+	g->deferred = NULL;
+	g->gctop = NULL;
+	g->keep.len = 0;
+	getcontext(&g->ctx);
+	g->ctx.uc_stack.ss_sp = g->stack;
+	g->ctx.uc_stack.ss_size = OGO_STACK_SIZE;
+	g->ctx.uc_link = NULL;
+	makecontext(&g->ctx, ogo_gostart, 0);
+	ogo_ready(g);
+}
+
+/* A goroutine waiting for a channel is on its queue of senders or of
+ * receivers.  It may be waiting for several, if it is in a select,
+ * so a waiter whose ticket is out of date is no longer waiting. */
+typedef struct ogo_waiter {
+	struct ogo_waiter *next;
+	ogo_g *g;
+	ogo_int ticket;
+	/* where the value is sent from or received into */
+	void *elem;
+	/* the case of the select, if it is one */
+	ogo_int index;
+} ogo_waiter;
+
+static const ogo_gcptr ogo_gcptrs_waiter[] = {{offsetof(ogo_waiter, next), 1, NULL}};
+static const ogo_gcmap ogo_gcmap_waiter = {sizeof(ogo_waiter), 1, ogo_gcptrs_waiter};
+
+typedef struct {
+	ogo_waiter *first, *last;
+} ogo_waitq;
+
+struct ogo_hchan {
+	ogo_int elemsize;
+	const ogo_gcmap *elemmap;
+	/* the buffer, holding len values from head on */
+	ogo_int cap, len, head;
+	char *buf;
+	ogo_bool closed;
+	ogo_waitq recvq, sendq;
+};
+
+static const ogo_gcptr ogo_gcptrs_hchan[] = {
+	{offsetof(struct ogo_hchan, buf), 1, NULL},
+	{offsetof(struct ogo_hchan, recvq), 2, NULL},
+	{offsetof(struct ogo_hchan, sendq), 2, NULL},
+};
+static const ogo_gcmap ogo_gcmap_hchan = {sizeof(struct ogo_hchan), 3, ogo_gcptrs_hchan};
+
+static void ogo_enqueue(ogo_waitq *q, void *elem, ogo_int index) {
+	ogo_waiter *w = ogo_alloc(sizeof(ogo_waiter), &ogo_gcmap_waiter);
+	w->g = ogo_current;
+	w->ticket = ogo_current->ticket;
+	w->elem = elem;
+	w->index = index;
+	if (q->first == NULL) {
+		q->first = w;
+	} else {
+		q->last->next = w;
+	}
+	q->last = w;
+}
+
+/* ogo_dequeue takes the first goroutine that is still waiting. */
+static ogo_waiter *ogo_dequeue(ogo_waitq *q) {
+	ogo_waiter *w;
+	while ((w = q->first) != NULL) {
+		q->first = w->next;
+		if (w->ticket == w->g->ticket) {
+			return w;
+		}
+	}
+	return NULL;
+}
+
+/* ogo_wake readies the goroutine of the waiter w, whose channel was
+ * open if ok is true. */
+static void ogo_wake(ogo_waiter *w, ogo_bool ok) {
+	w->g->ticket++;
+	w->g->selected = w->index;
+	w->g->ok = ok;
+	ogo_ready(w->g);
+}
+
+static ogo_chan ogo_make_chan(ogo_int elemsize, ogo_int size, const ogo_gcmap *map) {
+	if (size == OGO_NOINDEX) {
+		size = 0;
+	}
+	if (size < 0) {
+		ogo_panic_error("makechan: size out of range");
+	}
+	ogo_chan c = ogo_alloc(sizeof(struct ogo_hchan), &ogo_gcmap_hchan);
+	c->elemsize = elemsize;
+	c->elemmap = map;
+	c->cap = size;
+	c->buf = ogo_alloc(size * elemsize, map);
+	return c;
+}
+
+static ogo_int ogo_chan_len(ogo_chan c) {
+	return c == NULL ? 0 : c->len;
+}
+
+static ogo_int ogo_chan_cap(ogo_chan c) {
+	return c == NULL ? 0 : c->cap;
+}
+
+static void *ogo_chan_slot(ogo_chan c, ogo_int i) {
+	return c->buf + (c->head + i) % c->cap * c->elemsize;
+}
+
+/* ogo_chan_trysend sends the value at elem on c if it can do so at
+ * once, telling whether it did. */
+static ogo_bool ogo_chan_trysend(ogo_chan c, const void *elem) {
+	if (c->closed) {
+		ogo_raise("send on closed channel", 0);
+	}
+	ogo_waiter *w = ogo_dequeue(&c->recvq);
+	if (w != NULL) {
+		/* The receiver has nowhere to keep the value until it runs. */
+		memcpy(w->elem, elem, c->elemsize);
+#ifdef OGO_PRECISE
+		ogo_keep_value(&w->g->keep, w->elem, c->elemmap);
+#endif
+		ogo_wake(w, 1);
+		return 1;
+	}
+	if (c->len < c->cap) {
+		memcpy(ogo_chan_slot(c, c->len), elem, c->elemsize);
+		c->len++;
+		return 1;
+	}
+	return 0;
+}
+
+/* ogo_chan_tryrecv receives from c into elem if it can do so at once,
+ * telling whether it did, and setting *ok to whether c was open. */
+static ogo_bool ogo_chan_tryrecv(ogo_chan c, void *elem, ogo_bool *ok) {
+	if (c->len > 0) {
+		memcpy(elem, ogo_chan_slot(c, 0), c->elemsize);
+		c->head = (c->head + 1) % c->cap;
+		c->len--;
+		/* A waiting sender may now fill the space. */
+		ogo_waiter *w = ogo_dequeue(&c->sendq);
+		if (w != NULL) {
+			memcpy(ogo_chan_slot(c, c->len), w->elem, c->elemsize);
+			c->len++;
+			ogo_wake(w, 1);
+		}
+		*ok = 1;
+		return 1;
+	}
+	ogo_waiter *w = ogo_dequeue(&c->sendq);
+	if (w != NULL) {
+		memcpy(elem, w->elem, c->elemsize);
+		ogo_wake(w, 1);
+		*ok = 1;
+		return 1;
+	}
+	if (c->closed) {
+		memset(elem, 0, c->elemsize);
+		*ok = 0;
+		return 1;
+	}
+	return 0;
+}
+
+static void ogo_chan_send(ogo_chan c, const void *elem) {
+	if (c == NULL) {
+		ogo_park("chan send (nil chan)");
+	}
+	if (ogo_chan_trysend(c, elem)) {
+		return;
+	}
+	ogo_enqueue(&c->sendq, (void *)elem, 0);
+	ogo_park("chan send");
+	if (!ogo_current->ok) {
+		ogo_raise("send on closed channel", 0);
+	}
+}
+
+static ogo_bool ogo_chan_recv(ogo_chan c, void *elem) {
+	ogo_bool ok;
+	if (c == NULL) {
+		ogo_park("chan receive (nil chan)");
+	}
+	if (ogo_chan_tryrecv(c, elem, &ok)) {
+		return ok;
+	}
+	ogo_enqueue(&c->recvq, elem, 0);
+	ogo_park("chan receive");
+	return ogo_current->ok;
+}
+
+static void ogo_chan_close(ogo_chan c) {
+	if (c == NULL) {
+		ogo_raise("close of nil channel", 0);
+	}
+	if (c->closed) {
+		ogo_raise("close of closed channel", 0);
+	}
+	c->closed = 1;
+	for (ogo_waiter *w; (w = ogo_dequeue(&c->recvq)) != NULL;) {
+		memset(w->elem, 0, c->elemsize);
+		ogo_wake(w, 0);
+	}
+	/* The senders panic once they run. */
+	for (ogo_waiter *w; (w = ogo_dequeue(&c->sendq)) != NULL;) {
+		ogo_wake(w, 0);
+	}
+}
+
+typedef struct {
+	ogo_chan c;
+	void *elem;
+	ogo_bool send;
+} ogo_select_case;
+
+/* ogo_select chooses one of the n cases that may go ahead, at
+ * random, and carries it out, giving its index and setting *ok to
+ * whether its channel was open.  Without any case that may go ahead,
+ * it waits for one, unless block is false, when it gives -1. */
+static ogo_int ogo_select(ogo_select_case *cases, ogo_int n, ogo_bool block, ogo_bool *ok) {
+	ogo_int start = n > 0 ? rand() % n : 0;
+	for (ogo_int j = 0; j < n; j++) {
+		ogo_int i = (start + j) % n;
+		ogo_select_case *sc = &cases[i];
+		if (sc->c == NULL) {
+			continue;
+		}
+		if (sc->send ? ogo_chan_trysend(sc->c, sc->elem) : ogo_chan_tryrecv(sc->c, sc->elem, ok)) {
+			return i;
+		}
+	}
+	if (!block) {
+		return -1;
+	}
+	for (ogo_int i = 0; i < n; i++) {
+		ogo_select_case *sc = &cases[i];
+		if (sc->c != NULL) {
+			ogo_enqueue(sc->send ? &sc->c->sendq : &sc->c->recvq, sc->elem, i);
+		}
+	}
+	ogo_park(n == 0 ? "select (no cases)" : "select");
+	ogo_int i = ogo_current->selected;
+	if (cases[i].send && !ogo_current->ok) {
+		ogo_raise("send on closed channel", 0);
+	}
+	*ok = ogo_current->ok;
+	return i;
+}
+
+#ifdef OGO_PRECISE
+
+/* The precise collector
+ *
+ * ogo_collect marks every object it can reach from the roots, which
+ * are the global variables, and for each goroutine its shadow stack,
+ * the values it keeps, its deferred calls, its panics and the record of
+ * its go statement; it then frees the rest. */
+
+static ogo_object **ogo_gray;
+static ogo_int ogo_gray_len, ogo_gray_cap;
+
+static int ogo_object_cmp(const void *a, const void *b) {
+	const ogo_object *x = *(ogo_object *const *)a, *y = *(ogo_object *const *)b;
+	return x < y ? -1 : x > y;
+}
+
+/* ogo_find gives the object that p points into, or NULL if it points
+ * to no object. */
+static ogo_object *ogo_find(const char *p) {
+	ogo_int lo = 0, hi = ogo_heap_len;
+	while (lo < hi) {
+		ogo_int mid = lo + (hi - lo) / 2;
+		ogo_object *o = ogo_heap[mid];
+		const char *start = (const char *)(o + 1);
+		if (p < start) {
+			hi = mid;
+		} else if (p >= start + (o->size > 0 ? o->size : 1)) {
+			lo = mid + 1;
+		} else {
+			return o;
+		}
+	}
+	return NULL;
+}
+
+static void ogo_mark(void *arg, void *p) {
+	(void)arg;
+	ogo_object *o = p == NULL ? NULL : ogo_find(p);
+	if (o == NULL || o->marked) {
+		return;
+	}
+	o->marked = 1;
+	if (o->map == NULL) {
+		return;
+	}
+	if (ogo_gray_len == ogo_gray_cap) {
+		ogo_gray_cap = ogo_gray_cap > 0 ? 2 * ogo_gray_cap : 1024;
+		ogo_gray = realloc(ogo_gray, ogo_gray_cap * sizeof(ogo_object *));
+		if (ogo_gray == NULL) {
+			ogo_out_of_memory();
+		}
+	}
+	ogo_gray[ogo_gray_len++] = o;
+}
+
+static void ogo_mark_goroutine(ogo_gcframe *top, const ogo_keepstack *keep,
+		ogo_frame *frames, ogo_panicking *panics, void *arg) {
+	for (ogo_gcframe *f = top; f != NULL; f = f->prev) {
+		for (ogo_int i = 0; i < f->n; i++) {
+			ogo_scan(f->roots[i].p, f->roots[i].map, ogo_mark, NULL);
+		}
+	}
+	for (ogo_int i = 0; i < keep->len; i++) {
+		ogo_mark(NULL, keep->ptrs[i]);
+	}
+	for (ogo_frame *f = frames; f != NULL; f = f->prev) {
+		ogo_mark(NULL, f->defers);
+	}
+	ogo_mark(NULL, panics);
+	ogo_mark(NULL, arg);
+}
+
+static void ogo_collect(void) {
+	qsort(ogo_heap, ogo_heap_len, sizeof(ogo_object *), ogo_object_cmp);
+	for (ogo_int i = 0; i < ogo_nglobals; i++) {
+		ogo_scan(ogo_globals[i].p, ogo_globals[i].map, ogo_mark, NULL);
+	}
+	ogo_mark_goroutine(ogo_gctop, &ogo_keep, ogo_frames, ogo_panics, ogo_current->arg);
+	for (ogo_g *g = ogo_allg; g != NULL; g = g->all) {
+		if (g != ogo_current && !g->dead) {
+			ogo_mark_goroutine(g->gctop, &g->keep, g->frames, g->panics, g->arg);
+		}
+	}
+	while (ogo_gray_len > 0) {
+		ogo_object *o = ogo_gray[--ogo_gray_len];
+		if (o->map->size == 0) {
+			continue;
+		}
+		for (ogo_uint off = 0; off + o->map->size <= o->size; off += o->map->size) {
+			ogo_scan((const char *)(o + 1) + off, o->map, ogo_mark, NULL);
+		}
+	}
+	ogo_int n = 0;
+	ogo_heap_bytes = 0;
+	for (ogo_int i = 0; i < ogo_heap_len; i++) {
+		ogo_object *o = ogo_heap[i];
+		if (o->marked) {
+			o->marked = 0;
+			ogo_heap_bytes += o->size;
+			ogo_heap[n++] = o;
+		} else {
+			free(o);
+		}
+	}
+	ogo_heap_len = n;
+	if (ogo_gc_percent < 0) {
+		ogo_heap_next = INT64_MAX;
+	} else if (ogo_gc_percent == 0) {
+		ogo_heap_next = 0;
+	} else {
+		ogo_heap_next = ogo_heap_bytes + ogo_heap_bytes / 100 * ogo_gc_percent;
+		if (ogo_heap_next < 4 << 20) {
+			ogo_heap_next = 4 << 20;
+		}
+	}
+	ogo_gc_due = 0;
+}
+
+#endif
+
+#endif
@@ -0,0 +1,20 @@
+package main
+
+func get(xs [3]int, i int) int {
+	return xs[i]
+}
+
+func try(xs [3]int, i int) {
+	defer func() {
+		println(recover().(error).Error())
+	}()
+	println(get(xs, i))
+}
+
+func main() {
+	xs := [3]int{1, 2, 3}
+	println(get(xs, 2))
+	try(xs, -1)
+	try(xs, 5)
+	println(get(xs, 3))
+}
@@ -0,0 +1,92 @@
+package main
+
+const N = 2 * 3
+
+type Grid [N / 2][N / 2]int
+
+type Pair struct {
+	name  string
+	coord [2]float64
+}
+
+func sum(xs [N]int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func zeroFirst(xs [N]int) [N]int {
+	xs[0] = 0
+	return xs
+}
+
+func double(xs *[N]int) {
+	for i := range xs {
+		xs[i] *= 2
+	}
+}
+
+func main() {
+	var zero [N]int
+	println(len(zero), cap(zero), zero[0], zero[N-1], sum(zero))
+
+	xs := [N]int{1, 2, 3, 4, 5, 6}
+	ys := xs // arrays are copied
+	ys[0] = 100
+	println(xs[0], ys[0], sum(xs), sum(ys))
+
+	zs := zeroFirst(xs)
+	println(xs[0], zs[0], xs == zs, xs != zs)
+	zs[0] = 1
+	println(xs == zs, xs != zs)
+
+	double(&xs)
+	println(xs[0], xs[5], sum(xs), len(zeroFirst(xs)))
+
+	// Keyed elements, and a length counted from the literal
+	sparse := [...]string{2: "two", 5: "five", "six"}
+	println(len(sparse), sparse[0] == "", sparse[2], sparse[5], sparse[6])
+
+	var g Grid
+	for i := 0; i < len(g); i++ {
+		for j := range g[i] {
+			g[i][j] = i*10 + j
+		}
+	}
+	println(g[0][1], g[2][2], len(g[1]))
+	h := g
+	println(g == h)
+	h[1][1] = -1
+	println(g == h, g[1][1], h[1][1])
+
+	// A slice of an array shares its elements.
+	s := xs[1:4]
+	s[0] = 42
+	println(len(s), cap(s), xs[1], s[2])
+	s = append(s, 7)
+	println(xs[4], len(s), cap(s))
+	p := &xs
+	t := p[:2]
+	println(len(t), cap(t), t[1], p[1], len(p))
+
+	a := Pair{"a", [2]float64{1.5, 2.5}}
+	b := Pair{name: "a"}
+	b.coord[0], b.coord[1] = 1.5, 2.5
+	println(a == b, a.coord == b.coord, a.coord[1])
+	b.coord[1] = 3
+	println(a == b, a.coord != b.coord)
+
+	// Non-constant indices are checked
+	total := 0
+	for i := 0; i < N; i++ {
+		total += xs[i] * zs[N-1-i]
+	}
+	println(total)
+	var bs [4]byte
+	for i, c := range "hey" {
+		bs[i] = byte(c)
+	}
+	println(string(bs[:3]), bs[3])
+}
@@ -0,0 +1,121 @@
+package main
+
+func sum(xs []int) int {
+	total := 0
+	for i := 0; i < len(xs); i++ {
+		total += xs[i]
+	}
+	return total
+}
+
+func count(s string, c byte) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			n++
+		}
+	}
+	return n
+}
+
+func reverse(xs []int) {
+	j := len(xs) - 1
+	for i := 0; i < j; i++ {
+		xs[i], xs[j] = xs[j], xs[i]
+		j--
+	}
+}
+
+func squares() [8]int {
+	var a [8]int
+	for i := 0; i < len(a); i++ {
+		a[i] = i * i
+	}
+	for i := 0; i < 4; i++ {
+		a[i]++
+	}
+	a[7] += a[0]
+	return a
+}
+
+func swap(xs []int, i int) {
+	t := xs[i]
+	xs[i] = xs[i+1]
+	xs[i+1] = t
+	xs[i] += 0
+}
+
+func guarded(xs []int, i int) int {
+	if i >= 0 && i < len(xs) {
+		return xs[i]
+	}
+	return -1
+}
+
+func shrink(xs []int) int {
+	n := 0
+	for i := 0; i < len(xs); i++ {
+		n += xs[i]
+		xs = xs[:len(xs)/2]
+	}
+	return n
+}
+
+func skip(xs []int) int {
+	n := 0
+	for i := 0; i < len(xs); i++ {
+		i++
+		if i < len(xs) {
+			n += xs[i]
+		}
+	}
+	return n
+}
+
+func index(xs []int, i int) (n int) {
+	defer func() {
+		if recover() != nil {
+			n = -1
+		}
+	}()
+	return xs[i]
+}
+
+// overflow counts an int8 past 127, so that i goes negative, and the
+// check of a[i] must stay.
+func overflow(steps int) (msg string) {
+	defer func() {
+		if e, ok := recover().(error); ok {
+			msg = e.Error()
+		}
+	}()
+	var a [100]int
+	var i int8
+	for j := 0; j < steps; j++ {
+		i++
+	}
+	if i < 100 {
+		a[i] = 1
+	}
+	return "wrote"
+}
+
+func main() {
+	xs := []int{3, 1, 4, 1, 5, 9, 2, 6}
+	println(sum(xs), count("banana", 'a'))
+	reverse(xs)
+	println(xs[0], xs[1], xs[7])
+	a := squares()
+	println(a[0], a[3], a[7])
+	swap(xs, 2)
+	println(xs[2], xs[3])
+	println(guarded(xs, 3), guarded(xs, 8), guarded(xs, -1))
+	println(shrink(xs), skip(xs))
+	println(index(xs, 7), index(xs, 8), index(xs, -1))
+	for i := range xs {
+		xs[i] *= 2
+	}
+	println(sum(xs))
+	println(overflow(50), overflow(200))
+	swap(xs, 7)
+}
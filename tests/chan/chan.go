@@ -0,0 +1,183 @@
+package main
+
+type Result struct {
+	id, square int
+}
+
+func worker(id int, jobs <-chan int, results chan<- Result) {
+	for j := range jobs {
+		results <- Result{id, j * j}
+	}
+}
+
+func producer(n int, out chan int) {
+	for i := 0; i < n; i++ {
+		out <- i
+	}
+	close(out)
+}
+
+func pingPong(ping, pong chan string, n int) {
+	for i := 0; i < n; i++ {
+		msg := <-ping
+		pong <- msg + "-pong"
+	}
+}
+
+// sieve prints the primes below n with a chain of filtering goroutines.
+func sieve(n int) {
+	ch := make(chan int)
+	go func(gen chan<- int) {
+		for i := 2; i < n; i++ {
+			gen <- i
+		}
+		close(gen)
+	}(ch)
+	for {
+		p, ok := <-ch
+		if !ok {
+			break
+		}
+		print(p, " ")
+		in := ch
+		out := make(chan int)
+		go func() {
+			for v := range in {
+				if v%p != 0 {
+					out <- v
+				}
+			}
+			close(out)
+		}()
+		ch = out
+	}
+	println()
+}
+
+// A chan is held in an interface directly, so a method on a chan type
+// gets the channel itself through an itable.
+type Queue chan int
+
+func (q Queue) Pending() int { return len(q) }
+
+type Pender interface {
+	Pending() int
+}
+
+func depth(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return depth(n-1) + 1
+}
+
+func main() {
+	// Unbuffered channels hand values over directly.
+	ping, pong := make(chan string), make(chan string)
+	go pingPong(ping, pong, 3)
+	for _, s := range []string{"a", "b", "c"} {
+		ping <- s
+		println(<-pong)
+	}
+
+	// A buffered channel holds values until they are received.
+	buf := make(chan int, 3)
+	buf <- 1
+	buf <- 2
+	println(len(buf), cap(buf))
+	println(<-buf, <-buf, len(buf))
+
+	// Receiving from a closed channel gives the zero value.
+	done := make(chan bool, 1)
+	done <- true
+	close(done)
+	v, ok := <-done
+	println(v, ok)
+	v, ok = <-done
+	println(v, ok)
+
+	nums := make(chan int)
+	go producer(5, nums)
+	total := 0
+	for n := range nums {
+		total += n
+	}
+	println("total", total)
+
+	jobs := make(chan int, 10)
+	results := make(chan Result, 10)
+	for w := 1; w <= 3; w++ {
+		go worker(w, jobs, results)
+	}
+	for j := 1; j <= 6; j++ {
+		jobs <- j
+	}
+	close(jobs)
+	sum := 0
+	for i := 0; i < 6; i++ {
+		r := <-results
+		sum += r.square
+	}
+	println("sum of squares", sum)
+
+	// A select with a default case never waits.
+	var nilch chan int
+	select {
+	case x := <-nilch:
+		println("impossible", x)
+	default:
+		println("nothing ready")
+	}
+	ready := make(chan int, 1)
+	ready <- 42
+	select {
+	case x, ok := <-ready:
+		println("received", x, ok)
+	case nilch <- 1:
+		println("impossible")
+	}
+	select {
+	case ready <- 7:
+		println("sent")
+	default:
+		println("full")
+	}
+	quit := make(chan bool)
+	data := make(chan int)
+	go func() {
+		for i := 0; i < 3; i++ {
+			data <- i
+		}
+		quit <- true
+	}()
+	for running := true; running; {
+		select {
+		case d := <-data:
+			println("data", d)
+		case <-quit:
+			println("quit")
+			running = false
+		}
+	}
+	sieve(30)
+
+	// A goroutine may recurse deeply.
+	deep := make(chan int)
+	go func() {
+		deep <- depth(20000)
+	}()
+	println("depth", <-deep)
+
+	var e interface{} = nums
+	_, isChan := e.(chan int)
+	println(isChan, nilch == nil)
+	q := make(Queue, 4)
+	q <- 1
+	q <- 2
+	var p Pender = q
+	println(p.Pending())
+	defer func() {
+		println("recovered:", recover().(error).Error())
+	}()
+	close(nums)
+}
@@ -0,0 +1,128 @@
+package main
+
+type Op func(int, int) int
+
+func add(a, b int) int {
+	return a + b
+}
+
+func counter() func() int {
+	n := 0
+	return func() int {
+		n++
+		return n
+	}
+}
+
+func apply(f Op, a, b int) int {
+	return f(a, b)
+}
+
+func adder(base int) func(int) int {
+	return func(x int) int {
+		base += x
+		return base
+	}
+}
+
+// A method on a func type is called through an interface just as it
+// is directly, as with http.HandlerFunc.
+type Fn func() int
+
+func (f Fn) Other() int { return f() + 1 }
+
+type Other interface {
+	Other() int
+}
+
+func nine() int { return 9 }
+
+func twice(f func()) {
+	f()
+	f()
+}
+
+func sum(xs []int) (total int) {
+	each := func(x int) {
+		total += x
+	}
+	for _, x := range xs {
+		each(x)
+	}
+	return
+}
+
+func main() {
+	c1, c2 := counter(), counter()
+	println(c1(), c1(), c1(), c2())
+
+	// Top-level functions are values too.
+	println(apply(add, 3, 4), apply(func(a, b int) int { return a * b }, 3, 4))
+	var op Op
+	println(op == nil)
+	op = add
+	println(op != nil, op(1, 2))
+
+	a := adder(10)
+	println(a(1), a(5))
+
+	// A captured variable is shared with the function declaring it.
+	x := 1
+	inc := func() { x++ }
+	twice(inc)
+	println(x)
+	x = 40
+	inc()
+	println(x)
+
+	// Each iteration of a loop has its own variables.
+	var fs []func() int
+	for i := 0; i < 3; i++ {
+		fs = append(fs, func() int { return i * i })
+	}
+	for _, v := range []int{7, 8} {
+		fs = append(fs, func() int { return v })
+	}
+	for _, f := range fs {
+		print(f(), " ")
+	}
+	println()
+
+	// Recursion through a variable.
+	var fib func(int) int
+	fib = func(n int) int {
+		if n < 2 {
+			return n
+		}
+		return fib(n-1) + fib(n-2)
+	}
+	println(fib(15))
+
+	// Nested literals capture through the enclosing ones.
+	y := 100
+	outer := func(d int) func() int {
+		return func() int {
+			y += d
+			return y
+		}
+	}
+	o := outer(5)
+	println(o(), o(), y)
+
+	println(sum([]int{1, 2, 3, 4}))
+
+	// Values that never change are simply copied.
+	s := "hello"
+	greet := func(who string) string { return s + ", " + who }
+	println(greet("world"))
+
+	var e interface{} = inc
+	_, ok := e.(func())
+	println(ok)
+
+	seven := 7
+	others := []Other{Fn(nine), Fn(func() int { return seven })}
+	for _, o := range others {
+		println(o.Other())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+type Celsius float64
+
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+)
+
+const big = 1 << 40
+
+const ratio = big / 1024
+
+type Point struct {
+	X, Y int
+	Next *Point
+}
+
+type Callback func(int) int
+
+func double(x int) int {
+	return 2 * x
+}
+
+func apply(f Callback, x int) int {
+	return f(x)
+}
+
+func isNil(p *Point) bool {
+	return p == nil
+}
+
+func main() {
+	var f float64 = 1
+	var t Celsius = 100
+	var b byte = 'A'
+	var day = Tuesday
+	x := 7
+	println(f, t, b, day, x)
+	println(big, ratio, big>>30, Monday+1)
+	println(float32(ratio)/3, 7.0/2, 7/2)
+	x += 3
+	x <<= 2
+	println(x, x>>1, x%3)
+
+	p := &Point{1, 2, nil}
+	p.Next = &Point{Y: 5}
+	println(p.X, p.Y, p.Next.Y, isNil(p.Next.Next), isNil(nil))
+
+	var e interface{} = x
+	var e2 any = 40
+	println(e == 40, e2 == 40, e == e2, e != nil)
+
+	println(apply(double, 21), apply(func(i int) int { return i - 1 }, 1))
+	var cb Callback = double
+	println(cb(4) == double(4))
+
+	var u uint8 = 255
+	u++
+	println(u, ^uint8(0), -5/2, -5%2, 'x', "str"+"ing")
+}
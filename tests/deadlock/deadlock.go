@@ -0,0 +1,14 @@
+package main
+
+func main() {
+	ch := make(chan int)
+	go func() {
+		println(<-ch)
+		ch <- 2
+	}()
+	ch <- 1
+	println("sent")
+	<-ch
+	println("received")
+	<-ch
+}
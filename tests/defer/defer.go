@@ -0,0 +1,148 @@
+package main
+
+type T struct {
+	name string
+}
+
+func (t T) show(i int) {
+	println(t.name, i)
+}
+
+func (t *T) rename(name string) {
+	t.name = name
+}
+
+type Shower interface {
+	show(int)
+}
+
+func order() {
+	for i := 0; i < 3; i++ {
+		defer println("deferred", i)
+	}
+	println("order returns")
+}
+
+// args are evaluated when the defer statement runs.
+func args() int {
+	x := 1
+	defer println("x was", x)
+	x = 2
+	t := T{"first"}
+	defer t.show(x)
+	var s Shower = t
+	defer s.show(3)
+	t = T{"second"}
+	p := &t
+	defer p.rename("third")
+	f := func(s string) { println("closure", s) }
+	defer f("called")
+	f = nil
+	return x
+}
+
+// named results may be changed by deferred closures.
+func double(x int) (r int) {
+	defer func() {
+		r *= 2
+	}()
+	return x + 1
+}
+
+func early(b bool) string {
+	s := "start"
+	defer func() {
+		println("leaving early", b, s)
+	}()
+	if b {
+		s = "early"
+		return s
+	}
+	s = "late"
+	return s
+}
+
+func divide(a, b int) (q int) {
+	defer func() {
+		if r := recover(); r != nil {
+			println("recovered:", r.(error).Error())
+			q = -1
+		}
+	}()
+	return a / b
+}
+
+func index(xs []int, i int) (v int) {
+	defer func() {
+		if e, ok := recover().(error); ok {
+			println(e.Error())
+		}
+	}()
+	return xs[i]
+}
+
+func helper() interface{} {
+	return recover()
+}
+
+func indirect() (caught bool) {
+	defer func() {
+		caught = helper() != nil
+		println("helper recovered:", caught)
+		println("recovered:", recover().(string))
+	}()
+	panic("indirect")
+}
+
+func recoverer() {
+	if r := recover(); r != nil {
+		println("recoverer got", r.(string))
+	}
+}
+
+func byName() {
+	defer recoverer()
+	panic("by name")
+}
+
+func byValue() {
+	f := recoverer
+	defer f()
+	panic("by value")
+}
+
+func nested() {
+	defer func() {
+		println("outer recovers", recover().(string))
+	}()
+	func() {
+		defer println("inner deferred")
+		panic("from inner")
+	}()
+	println("not reached")
+}
+
+func repanic() {
+	defer func() {
+		println("second recovers", recover().(string))
+	}()
+	defer func() {
+		r := recover()
+		panic(r.(string) + " again")
+	}()
+	panic("once")
+}
+
+func main() {
+	order()
+	println("args returns", args())
+	println(double(4), early(true), early(false))
+	println(divide(7, 2), divide(1, 0))
+	println(index([]int{1, 2}, 5))
+	println("indirect", indirect())
+	byName()
+	byValue()
+	nested()
+	repanic()
+	println(recover() == nil)
+}
@@ -0,0 +1,159 @@
+package main
+
+type Namer interface {
+	Name() string
+}
+
+type Counter interface {
+	Bump()
+	Count() int
+}
+
+type Base struct {
+	id    int
+	count int
+}
+
+func (b Base) Name() string {
+	return "base"
+}
+
+func (b Base) ID() int {
+	return b.id
+}
+
+func (b *Base) Bump() {
+	b.count++
+}
+
+func (b *Base) Count() int {
+	return b.count
+}
+
+type Labeled struct {
+	label string
+}
+
+func (l Labeled) Name() string {
+	return "labeled " + l.label
+}
+
+func (l Labeled) Describe(prefix string, extra ...int) string {
+	s := prefix + l.label
+	for _, e := range extra {
+		s += "!"
+		_ = e
+	}
+	return s
+}
+
+// Middle gets Base's fields and methods.
+type Middle struct {
+	Base
+	weight int
+}
+
+// Top gets Base's through Middle, and Labeled's, where Name is
+// ambiguous at depth one, so Top declares its own.
+type Top struct {
+	Middle
+	*Labeled
+	Base
+	extra int
+}
+
+func (t Top) Name() string {
+	return "top " + t.Labeled.Name()
+}
+
+// Deep holds a pointer to a Middle, so even the methods of *Base are
+// promoted to a Deep value.
+type Deep struct {
+	*Middle
+}
+
+// Shadow has a field that hides Base's id.
+type Shadow struct {
+	Base
+	id string
+}
+
+// Twins embeds Base twice at depth two, so none of Base's fields or
+// methods are promoted to it, while Shadow's id and Middle's weight
+// are.
+type Twins struct {
+	Middle
+	Shadow
+}
+
+type Wrapped struct {
+	Namer
+	n int
+}
+
+func name(n Namer) string {
+	return n.Name()
+}
+
+func bumpTwice(c Counter) int {
+	c.Bump()
+	c.Bump()
+	return c.Count()
+}
+
+func main() {
+	m := Middle{Base: Base{id: 7}, weight: 3}
+	println(m.id, m.count, m.weight, m.Name(), m.ID())
+	m.Bump()
+	m.id++
+	m.count += 10
+	println(m.id, m.count, m.Count(), m.Base.count)
+	p := &m.id
+	*p = 42
+	println(m.ID(), name(m), name(&m))
+	println(bumpTwice(&m), m.count)
+
+	t := Top{Middle: m, Labeled: &Labeled{"x"}, extra: 1}
+	t.Base.id = 5
+	println(t.Middle.id, t.Base.id, t.weight, t.label, t.Name(), name(t))
+	println(t.Describe("d:"), t.Describe("e:", 1, 2, 3))
+	t.label = "y"
+	println(t.Labeled.label, t.Name())
+	t.Middle.Bump()
+	println(t.Middle.Count(), t.Base.Count())
+
+	d := Deep{&m}
+	d.Bump()
+	d.weight = 9
+	println(d.Count(), m.count, m.weight, d.id, name(d))
+	println(bumpTwice(d), m.count)
+
+	s := Shadow{Base{id: 3}, "shadow"}
+	println(s.id, s.Base.id, s.ID(), name(s))
+
+	w := Wrapped{Labeled{"inner"}, 2}
+	println(w.Name(), name(w), name(Wrapped{Namer: t}))
+
+	var n Namer = Deep{&Middle{}}
+	switch v := n.(type) {
+	case Counter:
+		v.Bump()
+		println("counter", v.Count())
+	default:
+		println("not a counter")
+	}
+	if c, ok := n.(Counter); ok {
+		println("still", c.Count())
+	}
+	tw := Twins{Middle: m, Shadow: Shadow{id: "twin"}}
+	var twins interface{} = &tw
+	_, isNamer := twins.(Namer)
+	println(tw.id, tw.weight, tw.Middle.ID(), isNamer)
+	if _, ok := n.(interface{ Describe(string, ...int) string }); !ok {
+		println("no describe")
+	}
+	var l Namer = t
+	if dd, ok := l.(interface{ Describe(string, ...int) string }); ok {
+		println(dd.Describe("via interface "))
+	}
+}
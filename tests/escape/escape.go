@@ -0,0 +1,81 @@
+package main
+
+func mk(n int) *int {
+	v := n * 2
+	return &v
+}
+
+func Ptr[T any](x T) *T {
+	return &x
+}
+
+type Node struct {
+	val  int
+	next *Node
+}
+
+// self stores a pointer to its receiver, which is a local variable of
+// its caller.
+func (n *Node) self() *Node {
+	n.next = n
+	return n
+}
+
+func node(v int) *Node {
+	var n Node
+	n.val = v
+	return n.self()
+}
+
+func digits() []int {
+	var a [4]int
+	for i := range a {
+		a[i] = i * i
+	}
+	return a[1:]
+}
+
+func field() *int {
+	type pair struct{ a, b int }
+	p := pair{1, 2}
+	return &p.b
+}
+
+// churn uses the stack that the functions above used, so that
+// anything left pointing there would show.
+func churn(n int) int {
+	var a [64]int
+	for i := range a {
+		a[i] = n + i
+	}
+	if n > 0 {
+		return churn(n-1) + a[63]
+	}
+	return a[0]
+}
+
+func main() {
+	p, q := mk(1), mk(2)
+	var ps []*int
+	for i := 0; i < 3; i++ {
+		j := i
+		ps = append(ps, &j)
+	}
+	var is []*int
+	for i := 0; i < 3; i++ {
+		is = append(is, &i)
+	}
+	s := Ptr("hello")
+	f := Ptr(1.5)
+	n := node(7)
+	d := digits()
+	b := field()
+	churn(10)
+	println(*p, *q)
+	println(*ps[0], *ps[1], *ps[2])
+	println(*is[0], *is[1], *is[2])
+	println(*s, *f)
+	println(n.val, n.next.val, n.next == n)
+	println(len(d), d[0], d[1], d[2])
+	println(*b)
+}
@@ -0,0 +1,130 @@
+package main
+
+const (
+	kilo = 1000
+	mega = kilo * kilo
+	name = "ogo"
+)
+
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+)
+
+var (
+	debug        = false
+	verbose      = true
+	level        = 3
+	small   int8 = 100
+	counter      = 0
+	greet        = "hello"
+)
+
+func area(w, h float64) float64 {
+	const half = 0.5
+	return half * w * h
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	}
+	if true {
+		return 1
+	}
+	println("never")
+	return 0
+}
+
+func describe(n int) string {
+	unseen := n * 2
+	if debug {
+		println("describing", unseen)
+	}
+	if level > 2 {
+		return "detailed"
+	} else if level > 1 {
+		return "brief"
+	}
+	return "none"
+}
+
+func loop() int {
+	total := 0
+outer:
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if j == 2 {
+				continue outer
+			}
+			total += i * j
+		}
+	}
+	for k := 0; false; k++ {
+		total += k
+	}
+	return total
+}
+
+func gone() int {
+	x := 4
+	if false {
+		goto done
+	}
+	x++
+done:
+	if level > 0 {
+		return x
+	}
+	x = 7
+	return x
+}
+
+func initialized() int {
+	if y := level * 2; verbose {
+		return y
+	} else {
+		return -y
+	}
+}
+
+func skipped() {
+	for i, v := range []int{1, 2} {
+		if debug {
+			println(i, v)
+		}
+	}
+	switch v := interface{}(level).(type) {
+	case int:
+		if debug {
+			println(v)
+		}
+	}
+	if verbose {
+		panic("skipped")
+	}
+	println("not reached")
+}
+
+func main() {
+	println(mega, name, len(name), len(greet), greet+" "+name)
+	println(Monday, Tuesday*2, Sunday == 0)
+	println(area(3, 4), 1.0/3, float32(1)/3, -2.5*kilo)
+	println(uint8(200)+50, 7/2, -7/2, -7%3, 1<<10, ^uint16(0))
+	println(small*2, level*level, level<<60, -level/2, level%2 == 1)
+	var arr [5]int
+	println(len(arr), cap(arr))
+	println(sign(-3), sign(level), describe(level), loop(), gone(), initialized())
+	if !debug && verbose {
+		println("verbose")
+	}
+	counter++
+	println(counter)
+	defer func() {
+		println(recover().(string))
+	}()
+	skipped()
+}
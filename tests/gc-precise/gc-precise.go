@@ -0,0 +1,150 @@
+package main
+
+// Each of these has a value that only the expression it is part of
+// refers to, while something else in the expression allocates enough
+// garbage for a collection, and then checks that the value survived.
+
+type node struct {
+	value int
+	next  *node
+}
+
+type pair struct {
+	name string
+	n    *node
+}
+
+type shape interface {
+	area() int
+}
+
+type rect struct {
+	w, h int
+	n    *node
+}
+
+func (r rect) area() int {
+	return r.w*r.h + sum(r.n)
+}
+
+func (n *node) plus(k int) int {
+	return n.value + k
+}
+
+// churn allocates more than the collector lets the heap grow by.
+func churn() int {
+	var l *node
+	for i := 0; i < 300000; i++ {
+		l = &node{i, l}
+	}
+	return 1
+}
+
+func fresh(v int) *node {
+	return &node{v, &node{v + 1, nil}}
+}
+
+func sum(l *node) int {
+	s := 0
+	for ; l != nil; l = l.next {
+		s += l.value
+	}
+	return s
+}
+
+func two(a, b *node) int {
+	return sum(a) + sum(b)
+}
+
+func total(ns ...*node) int {
+	t := 0
+	for _, n := range ns {
+		t += sum(n)
+	}
+	return t
+}
+
+var global = fresh(100)
+
+func deferred() (r int) {
+	n := fresh(10)
+	defer func(m *node) {
+		r = sum(m) + churn()
+	}(fresh(20))
+	n = nil
+	churn()
+	return sum(n)
+}
+
+func panicky() (r int) {
+	defer func() {
+		e := recover().(*node)
+		churn()
+		r = sum(e)
+	}()
+	panic(fresh(30))
+}
+
+func main() {
+	println(two(fresh(1), fresh(churn())))
+	println(sum(fresh(2)) + sum(fresh(churn())))
+	println(two(global, fresh(churn())))
+	println(total(fresh(3), fresh(churn()), fresh(4)))
+	println(fresh(5).plus(churn()))
+
+	s := "a"
+	println(s + string(rune('a'+churn())) + s)
+	p := &pair{s + "x", fresh(churn())}
+	ps := []pair{{s + "1", fresh(6)}, {s + "2", fresh(churn())}}
+	churn()
+	println(p.name, sum(p.n), ps[0].name, sum(ps[0].n), ps[1].name, sum(ps[1].n))
+
+	var sh shape = rect{2, 3, fresh(7)}
+	println(sh.area() + churn())
+
+	f := func(k int) int {
+		return sum(p.n) + k
+	}
+	println(f(churn()))
+
+	m := map[string]*node{}
+	for i := 0; i < 100; i++ {
+		m[string(rune('a'+i%26))+string(rune('A'+i/26))] = fresh(i)
+	}
+	t := 0
+	for k, v := range m {
+		if v.value%25 == 0 {
+			churn()
+		}
+		t += sum(v) + len(k)
+	}
+	println(len(m), t)
+
+	c := make(chan *node, 10)
+	go func() {
+		for i := 0; i < 10; i++ {
+			c <- fresh(i)
+		}
+		close(c)
+	}()
+	t = 0
+	for n := range c {
+		churn()
+		t += sum(n)
+	}
+	println(t)
+
+	d := make(chan pair)
+	go func() {
+		for i := 0; i < 3; i++ {
+			d <- pair{s + "!", fresh(i + churn())}
+			churn()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		q := <-d
+		println(q.name, sum(q.n))
+	}
+
+	println(deferred(), panicky())
+}
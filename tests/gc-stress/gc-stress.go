@@ -0,0 +1,93 @@
+package main
+
+// This allocates some 16GB in all, which it can only do in a
+// reasonable amount of memory if the garbage is collected.  Along
+// the way it keeps some data alive, and checks that it survives.
+
+type node struct {
+	value int
+	next  *node
+}
+
+func list(n int) *node {
+	var l *node
+	for i := 0; i < n; i++ {
+		l = &node{i, l}
+	}
+	return l
+}
+
+func sum(l *node) int {
+	s := 0
+	for ; l != nil; l = l.next {
+		s += l.value
+	}
+	return s
+}
+
+func adder(n int) func() int {
+	total := 0
+	return func() int {
+		total += n
+		return total
+	}
+}
+
+// worker allocates on its own stack while main waits, so that what
+// each stack holds must be kept.
+func worker(in <-chan int, out chan<- int) {
+	keep := list(100)
+	for n := range in {
+		l := list(n)
+		buf := make([]byte, 1<<20)
+		buf[n] = 1
+		out <- sum(l) + sum(keep) + int(buf[n])
+	}
+	close(out)
+}
+
+func main() {
+	const megabyte = 1 << 20
+	live := list(1000)
+	add := adder(3)
+	m := map[int]string{}
+	s := "start"
+	var blocks [][]byte
+	for i := 0; i < 8192; i++ {
+		b := make([]byte, megabyte)
+		b[i%megabyte] = byte(i)
+		blocks = append(blocks, b)
+		if len(blocks) > 16 {
+			blocks = blocks[1:]
+		}
+		var ptrs []*node
+		for j := 0; j < 100; j++ {
+			ptrs = append(ptrs, &node{j, nil})
+		}
+		m[i%100] = s + "!"
+		if i%1000 == 0 {
+			s = s + "."
+		}
+		add()
+	}
+	println(sum(live), add(), len(m), m[5], s, len(blocks))
+	for i, b := range blocks {
+		n := 8192 - len(blocks) + i
+		if b[n%megabyte] != byte(n) {
+			println("block", n, "is wrong")
+		}
+	}
+
+	in, out := make(chan int), make(chan int)
+	go worker(in, out)
+	total := 0
+	for i := 0; i < 8192; i++ {
+		in <- i % 50
+		total += <-out
+		garbage := make([]*node, megabyte/8)
+		garbage[0] = live
+	}
+	close(in)
+	_, ok := <-out
+	println(total, ok, sum(live))
+}
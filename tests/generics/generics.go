@@ -0,0 +1,154 @@
+package main
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+type Ordered interface {
+	~int | ~float64 | ~string
+}
+
+type Stringer interface {
+	String() string
+}
+
+type Celsius int
+
+func (c Celsius) String() string {
+	return "C"
+}
+
+func Sum[T Number](xs []T) T {
+	var total T
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func Max[T Ordered](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	out := make([]U, 0, len(xs))
+	for _, x := range xs {
+		out = append(out, f(x))
+	}
+	return out
+}
+
+func Filter[T any](xs []T, keep func(T) bool) []T {
+	var out []T
+	for _, x := range xs {
+		if keep(x) {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+func Index[T comparable](xs []T, x T) int {
+	for i, y := range xs {
+		if y == x {
+			return i
+		}
+	}
+	return -1
+}
+
+func Join[T Stringer](xs []T) string {
+	s := ""
+	for _, x := range xs {
+		s += x.String()
+	}
+	return s
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func NewStack[T any]() *Stack[T] {
+	return &Stack[T]{}
+}
+
+func (s *Stack[T]) Push(x T) {
+	s.items = append(s.items, x)
+}
+
+func (s *Stack[T]) Pop() T {
+	x := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return x
+}
+
+func (s *Stack[E]) Len() int {
+	return len(s.items)
+}
+
+type Pair[K comparable, V any] struct {
+	Key K
+	Val V
+}
+
+func (p Pair[K, V]) Clone() Pair[K, V] {
+	return Pair[K, V]{p.Key, p.Val}
+}
+
+type List[T any] struct {
+	head T
+	tail *List[T]
+}
+
+func (l *List[T]) Len() int {
+	if l == nil {
+		return 0
+	}
+	return 1 + l.tail.Len()
+}
+
+func Cons[T any](x T, l *List[T]) *List[T] {
+	return &List[T]{x, l}
+}
+
+func main() {
+	println("sum", Sum[int]([]int{1, 2, 3}), Sum[float64]([]float64{0.5, 0.25}))
+	println("celsius", Sum[Celsius]([]Celsius{10, 20}))
+	println("max", Max[int](3, 7), Max[string]("apple", "pear"))
+
+	words := Map[int, string]([]int{1, 2, 3}, func(i int) string {
+		return string(rune('a' + i))
+	})
+	println("map", len(words), words[0], words[2])
+	evens := Filter[int]([]int{1, 2, 3, 4, 5, 6}, func(i int) bool { return i%2 == 0 })
+	println("filter", len(evens), evens[0], evens[2])
+	println("index", Index[string]([]string{"x", "y", "z"}, "z"), Index[int](evens, 5))
+	println("join", Join[Celsius]([]Celsius{1, 2, 3}))
+
+	s := NewStack[int]()
+	s.Push(1)
+	s.Push(2)
+	s.Push(3)
+	println("stack", s.Len(), s.Pop(), s.Pop(), s.Len())
+
+	var ps Stack[Pair[string, int]]
+	ps.Push(Pair[string, int]{"one", 1})
+	ps.Push(Pair[string, int]{"two", 2})
+	p := ps.Pop()
+	println("pair", p.Key, p.Val, p.Clone().Key)
+
+	l := Cons[string]("a", Cons[string]("b", Cons[string]("c", nil)))
+	println("list", l.Len(), l.head, l.tail.head)
+	var nums *List[int]
+	for i := 0; i < 5; i++ {
+		nums = Cons[int](i, nums)
+	}
+	println("nums", nums.Len(), nums.head)
+
+	f := Max[float64]
+	println("value", f(1.5, 2.5) == 2.5)
+}
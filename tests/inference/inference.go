@@ -0,0 +1,97 @@
+package main
+
+type Number interface {
+	~int | ~int64 | ~float64
+}
+
+func Max[T Number](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Sum[T Number](xs ...T) T {
+	var total T
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func Map[T, U any](xs []T, f func(T) U) []U {
+	var out []U
+	for _, x := range xs {
+		out = append(out, f(x))
+	}
+	return out
+}
+
+func Convert[To, From Number](x From) To {
+	return To(x)
+}
+
+func First[S ~[]E, E any](s S) E {
+	return s[0]
+}
+
+func Keys[M ~map[K]V, K comparable, V any](m M) []K {
+	var out []K
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+type List[T any] struct {
+	head T
+	next *List[T]
+}
+
+func Push[T any](l *List[T], x T) *List[T] {
+	return &List[T]{x, l}
+}
+
+func Len[T any](l *List[T]) int {
+	n := 0
+	for ; l != nil; l = l.next {
+		n++
+	}
+	return n
+}
+
+func Pick[A, B any](first bool, a A, b B) interface{} {
+	if first {
+		return a
+	}
+	return b
+}
+
+type Celsius float64
+
+type Names []string
+
+func main() {
+	println(Max(3, 7))
+	println(Max(1, 2.5))
+	println(Max(Celsius(20), 30))
+	var i int64 = 5
+	println(Max(i, 9))
+	println(Sum(1, 2, 3))
+	println(Sum[float64]())
+	println(Sum([]int{4, 5}...))
+	lens := Map([]string{"a", "bb", "ccc"}, func(s string) int { return len(s) })
+	println(lens[0], lens[1], lens[2])
+	println(Convert[float64](7) / 2)
+	println(Convert[int](Celsius(3.7)))
+	println(First(Names{"x", "y"}))
+	println(First([]int{8, 9}))
+	println(len(Keys(map[string]bool{"k": true})))
+	var l *List[string]
+	l = Push(l, "a")
+	l = Push(l, "b")
+	println(Len(l), l.head)
+	println(Pick(false, 1, "one").(string))
+	g := Max[int]
+	println(g(4, 2))
+}
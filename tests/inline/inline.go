@@ -0,0 +1,136 @@
+package main
+
+type point struct {
+	x, y int
+}
+
+func (p point) sum() int { return p.x + p.y }
+
+func (p *point) scale(k int) {
+	p.x *= k
+	p.y *= k
+}
+
+func (p *point) norm1() int {
+	if p.x < 0 {
+		return -p.x + abs(p.y)
+	}
+	return p.x + abs(p.y)
+}
+
+func sq(x int) int { return x * x }
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func clamp(x, max int) (r int) {
+	if x > max {
+		r = max
+		return
+	}
+	r = x
+	return
+}
+
+func first(s []int, unused bool) int {
+	for i, v := range s {
+		if v > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+func kind(x interface{}) string {
+	switch v := x.(type) {
+	case int:
+		if v < 0 {
+			return "negative"
+		}
+		return "int"
+	case string:
+		return "string " + v
+	}
+	return "other"
+}
+
+func cleanup(n *int) {
+	defer func() { *n = 0 }()
+	*n = 5
+}
+
+func size(s []int) int { return len(s) }
+
+var calls = 0
+
+var g = 2
+
+func setg() int {
+	g = 10
+	return 0
+}
+
+func bump(p *int) int {
+	*p = 10
+	return 0
+}
+
+type cell struct{ x int }
+
+func (c *cell) get() int { return c.x }
+
+func (c *cell) set() int {
+	c.x = 10
+	return 0
+}
+
+func count() int {
+	calls++
+	return calls
+}
+
+func main() {
+	a, b := 3, -4
+	println(sq(a)+1, sq(b), abs(b), abs(a))
+	c := clamp(17, 5)
+	println(c)
+	c = clamp(1, 5)
+	println(c)
+	var x int = abs(a - 10)
+	println(x)
+	p := point{1, -2}
+	p.scale(3)
+	println(p.sum(), p.norm1(), (&p).norm1())
+	pp := &p
+	println(pp.sum())
+	println(first([]int{-1, 0, 2}, true), first(nil, false))
+	println(kind(1), kind(-1), kind("s"), kind(1.5))
+	n := 1
+	cleanup(&n)
+	println(n)
+	// A local that hides a builtin that size uses
+	len := 7
+	println(size([]int{1, 2}), len)
+	// Each argument is evaluated once, in order.
+	println(sq(count()), sq(count()), calls)
+	abs(b)
+
+	// A call runs before the calls after it, which may change what it
+	// reads.
+	d := 2
+	println(sq(d) + bump(&d))
+	println(sq(g) + setg())
+	t := &cell{2}
+	println(t.get() + t.set())
+	e := 3
+	var y int = sq(e) + sq(d) + bump(&e)
+	println(y, e)
+	d = 2
+	if sq(d) > bump(&d) {
+		println("yes")
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import "math"
+
+type Celsius int8
+
+type cell struct {
+	n int16
+}
+
+var calls = 0
+
+func next(xs []int8) []int8 {
+	calls++
+	return xs
+}
+
+func wrap() {
+	var a int8 = 127
+	var b int16 = -32768
+	var c int32 = math.MaxInt32
+	var d int64 = math.MinInt64
+	var u uint8 = 200
+	var v uint16 = 1
+	var w uint32 = 0
+	var t Celsius = 100
+	println(a+1, b-1, c+1, d-1, u+100, v-2, w-1, t*2)
+	println(a*a, b*b, c*c, d*d, u*u, v*65535)
+	println(-b, -d, -u, -v, ^u, ^v, -t)
+	a++
+	b--
+	u++
+	v--
+	println(a, b, u, v)
+	a += 100
+	b -= 1000
+	c *= 3
+	d -= 1
+	u *= 3
+	v += 7
+	t += 100
+	println(a, b, c, d, u, v, t)
+}
+
+func places() {
+	xs := []int8{120, -120, 0}
+	for i := 0; i < len(xs); i++ {
+		xs[i] += 10
+	}
+	println(xs[0], xs[1], xs[2])
+	next(xs)[0] *= 3
+	next(xs)[1]--
+	println(xs[0], xs[1], calls)
+	p := &cell{32767}
+	p.n++
+	println(p.n)
+	m := map[string]uint8{"a": 255}
+	m["a"]++
+	m["a"] += 10
+	println(m["a"])
+	for i := int8(120); i > 0; i += 5 {
+		println("loop", i)
+	}
+}
+
+func shifts() {
+	var one int8 = 1
+	var neg int32 = -8
+	var big uint64 = 1<<63 + 5
+	var u uint16 = 0xff00
+	for _, s := range []uint{0, 1, 7, 8, 15, 16, 31, 32, 63, 64, 100} {
+		println(s, one<<s, neg>>s, neg<<s, big>>s, big<<s, u<<s, u>>s)
+	}
+	var n int = 70
+	three, tiny := int64(3), uint8(3)
+	println(1<<n, -1>>n, three<<62, tiny<<7)
+	x := 5
+	x <<= 62
+	println(x)
+	x >>= n
+	println(x)
+	var y int16 = -300
+	y >>= 3
+	println(y)
+	y <<= 10
+	println(y)
+}
+
+func quo(x, y int) int {
+	return x / y
+}
+
+func rem(x, y int) int {
+	return x % y
+}
+
+func division() {
+	println(quo(math.MinInt64, -1), rem(math.MinInt64, -1))
+	var a int8 = -128
+	var m int8 = -1
+	println(a/m, a%m, a/3, a%3, -7/2, -7%2)
+	var b int32 = math.MinInt32
+	b /= int32(m)
+	println(b)
+	var u uint8 = 250
+	var k uint8 = 7
+	println(u/k, u%k, u/7, u%7)
+	d := -1
+	e := math.MinInt64
+	e /= d
+	println(e)
+}
+
+func trap(what string, f func() int) {
+	defer func() {
+		if e, ok := recover().(error); ok {
+			println(what, e.Error())
+		}
+	}()
+	println(what, f())
+}
+
+func main() {
+	wrap()
+	places()
+	shifts()
+	division()
+	zero := 0
+	s := -1
+	trap("quo", func() int { return 7 / zero })
+	trap("rem", func() int { return 7 % zero })
+	trap("assign", func() int {
+		x := 3
+		x /= zero
+		return x
+	})
+	trap("unsigned", func() int { return int(uint8(9) / uint8(zero)) })
+	trap("shl", func() int { return 1 << s })
+	trap("shr", func() int { return 1 >> s })
+	var i8 int8 = -128
+	println(i8 / int8(zero))
+}
@@ -0,0 +1,134 @@
+package main
+
+type Shape interface {
+	Area() float64
+	Name() string
+}
+
+type Named interface {
+	Name() string
+}
+
+type Rect struct {
+	w, h float64
+}
+
+func (r Rect) Area() float64 { return r.w * r.h }
+func (r Rect) Name() string  { return "rect" }
+
+type Square struct {
+	side float64
+}
+
+func (s *Square) Area() float64 { return s.side * s.side }
+func (s *Square) Name() string  { return "square" }
+func (s *Square) Grow(by float64) {
+	s.side += by
+}
+
+type Celsius float64
+
+func (c Celsius) Name() string { return "celsius" }
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Incr() int {
+	c.n++
+	return c.n
+}
+
+type NotFound struct {
+	what string
+}
+
+func (e *NotFound) Error() string { return e.what + " not found" }
+
+type Lener interface {
+	Len() int
+}
+
+// A map is held in an interface directly, as a pointer is.
+type Set map[string]bool
+
+func (s Set) Len() int { return len(s) }
+
+func find(what string) error {
+	if what == "treasure" {
+		return &NotFound{what}
+	}
+	return nil
+}
+
+func describe(s Shape) {
+	println(s.Name(), s.Area())
+}
+
+func nameOf(n Named) string {
+	if n == nil {
+		return "nobody"
+	}
+	return n.Name()
+}
+
+func main() {
+	r := Rect{2, 3}
+	sq := &Square{2}
+	describe(r)
+	describe(sq)
+	sq.Grow(1)
+	describe(sq)
+
+	shapes := []Shape{Rect{1, 1}, &Square{3}, r}
+	total := 0.0
+	for _, s := range shapes {
+		total += s.Area()
+	}
+	println(len(shapes), total)
+
+	// Interface to interface conversion
+	var n Named = shapes[1]
+	println(nameOf(n), nameOf(Celsius(3)), nameOf(nil))
+	n = nil
+	println(n == nil, nameOf(n))
+
+	// Method calls on addressable values take their address.
+	var c Counter
+	c.Incr()
+	c.Incr()
+	println(c.n)
+	println(c.Incr())
+
+	// Comparing interfaces compares their dynamic types and values.
+	var a, b any
+	println(a == nil, a == b)
+	a, b = 3, 3
+	println(a == nil, a == b)
+	b = int64(3)
+	println(a == b)
+	b = "three"
+	a = "thr" + "ee"
+	println(a == b, a != b)
+	var s1, s2 Shape = Rect{1, 2}, Rect{1, 2}
+	println(s1 == s2, s1 == Shape(sq), Shape(sq) == Shape(sq))
+	s2 = Rect{2, 1}
+	println(s1 == s2)
+
+	// An interface holding a nil pointer is not nil.
+	var nf *NotFound
+	var err error = nf
+	println(err == nil, nf == nil)
+
+	if err := find("treasure"); err != nil {
+		println("error:", err.Error())
+	}
+	if err := find("nothing"); err == nil {
+		println("no error")
+	}
+
+	set := Set{"a": true, "b": true}
+	var l Lener = set
+	set["c"] = true
+	println(l.Len())
+}
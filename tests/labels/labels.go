@@ -0,0 +1,211 @@
+package main
+
+func pairs(n int) int {
+	count := 0
+Outer:
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if j > i {
+				continue Outer
+			}
+			if i*j > 20 {
+				break Outer
+			}
+			count++
+		}
+	}
+	return count
+}
+
+func firstNegative(rows [][]int) int {
+	found := -1
+Rows:
+	for i, row := range rows {
+		for _, v := range row {
+			switch {
+			case v < 0:
+				found = i
+				break Rows
+			case v == 0:
+				continue Rows
+			}
+		}
+	}
+	return found
+}
+
+func closures() int {
+	var fs []func() int
+Loop:
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 3; j++ {
+			if i%2 == 1 {
+				continue Loop
+			}
+			if i > 6 {
+				break Loop
+			}
+		}
+		fs = append(fs, func() int { return i })
+	}
+	sum := 0
+	for _, f := range fs {
+		sum = sum*10 + f()
+	}
+	return sum
+}
+
+func collatz(n int) int {
+	steps := 0
+again:
+	if n == 1 {
+		return steps
+	}
+	steps++
+	if n%2 == 0 {
+		n /= 2
+	} else {
+		n = 3*n + 1
+	}
+	goto again
+}
+
+func skip(x int) string {
+	s := "start"
+	if x > 0 {
+		goto done
+	}
+	s += " middle"
+done:
+	s += " end"
+	return s
+}
+
+var inits int
+
+func start() int {
+	inits++
+	return 0
+}
+
+func restart() int {
+	total := 0
+	rounds := 0
+loop:
+	for i := start(); i < 3; i++ {
+		total += i
+	}
+	rounds++
+	if rounds < 3 {
+		goto loop
+	}
+	return total*100 + inits
+}
+
+func rangeRestart(xs []int) int {
+	n := 0
+again:
+	for _, x := range xs {
+		n += x
+		if n > 100 {
+			break again
+		}
+	}
+	if n < 20 {
+		goto again
+	}
+	return n
+}
+
+func strings(words []string) int {
+	vowels := 0
+Words:
+	for _, w := range words {
+		for _, r := range w {
+			switch r {
+			case 'a', 'e', 'i', 'o', 'u':
+				vowels++
+			case 'x':
+				continue Words
+			case 'z':
+				break Words
+			}
+		}
+	}
+	return vowels
+}
+
+func maps(m map[string]int) int {
+	n := 0
+Keys:
+	for _, v := range m {
+		for i := 0; i < v; i++ {
+			if i == 3 {
+				continue Keys
+			}
+			n++
+		}
+	}
+	return n
+}
+
+func selecting() int {
+	ch := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		ch <- i
+	}
+	sum := 0
+Recv:
+	for {
+		select {
+		case v := <-ch:
+			if v == 6 {
+				break Recv
+			}
+			sum += v
+		default:
+			break Recv
+		}
+	}
+	return sum
+}
+
+func labeledDecl(n int) int {
+	i := 0
+top:
+	x := i * i
+	i++
+	if i < n {
+		goto top
+	}
+	return x
+}
+
+func nested() string {
+	out := ""
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if i == 1 && j == 1 {
+				goto out
+			}
+			out += "."
+		}
+	}
+out:
+	return out
+}
+
+func main() {
+	println(pairs(5), pairs(10))
+	println(firstNegative([][]int{{1, 2}, {0, -1}, {3, -4}}), firstNegative([][]int{{1}}))
+	println(closures())
+	println(collatz(27))
+	println(skip(1), "/", skip(0))
+	println(restart())
+	println(rangeRestart([]int{3, 4}), rangeRestart([]int{50, 60, 70}))
+	println(strings([]string{"hello", "axe", "fizz", "again"}))
+	println(maps(map[string]int{"a": 2, "b": 5, "c": 9}))
+	println(selecting())
+	println(labeledDecl(5))
+	println(nested())
+}
@@ -0,0 +1,185 @@
+package main
+
+type Point struct {
+	x, y int
+}
+
+type Grid [2][2]int
+
+type Counts map[string]int
+
+func (c Counts) add(words ...string) {
+	for _, w := range words {
+		c[w]++
+	}
+}
+
+func (c Counts) total() int {
+	n := 0
+	for _, v := range c {
+		n += v
+	}
+	return n
+}
+
+// sorted gives the keys of m in order, so that we can print them
+// whatever order the map iterates in.
+func sorted(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	return keys
+}
+
+func show(m map[string]int) {
+	for _, k := range sorted(m) {
+		print(k, "=", m[k], " ")
+	}
+	println(len(m))
+}
+
+func hashUncomparable() {
+	defer func() {
+		println("recovered:", recover().(error).Error())
+	}()
+	m := map[any]int{}
+	m[[]int{1}] = 1
+}
+
+func main() {
+	m := make(map[string]int)
+	m["one"] = 1
+	m["two"] = 2
+	m["three"] = 3
+	show(m)
+	v, ok := m["two"]
+	println(v, ok)
+	v, ok = m["four"]
+	println(v, ok)
+	_, ok = m["one"]
+	println(ok, m["missing"])
+	delete(m, "two")
+	delete(m, "two")
+	show(m)
+	m["one"] += 10
+	m["new"] -= 5
+	m["three"]++
+	m["zero"]--
+	show(m)
+
+	lit := map[string]int{"a": 1, "b": 2, "c": 3}
+	show(lit)
+	key := "b"
+	lit2 := map[string]int{key: 20, key + key: 40}
+	show(lit2)
+
+	// Nil maps may be read, but not written.
+	var nilmap map[string]int
+	println(nilmap == nil, len(nilmap), nilmap["x"])
+	delete(nilmap, "x")
+	for range nilmap {
+		println("never")
+	}
+	_, ok = nilmap["x"]
+	println(ok)
+
+	// Keys of all sorts
+	points := map[Point]string{{1, 2}: "a", {3, 4}: "b"}
+	points[Point{1, 2}] += "!"
+	println(points[Point{1, 2}], points[Point{3, 4}], points[Point{5, 6}] == "")
+	grids := map[Grid]int{}
+	grids[Grid{{1, 2}, {3, 4}}] = 7
+	println(grids[Grid{{1, 2}, {3, 4}}], grids[Grid{}])
+	floats := map[float64]int{}
+	floats[0.0] = 1
+	zero := 0.0
+	floats[-zero] += 1
+	nan := zero / zero
+	floats[nan] = 5
+	floats[nan] = 6
+	println(len(floats), floats[0], floats[nan])
+	ifaces := map[any]string{1: "int", "1": "string", int8(1): "int8", Point{1, 1}: "point"}
+	println(ifaces[1], ifaces["1"], ifaces[int8(1)], ifaces[Point{1, 1}], ifaces[nil] == "")
+	ifaces[nil] = "nil"
+	println(len(ifaces), ifaces[nil])
+	p, q := &Point{}, &Point{}
+	ptrs := map[*Point]int{p: 1, q: 2}
+	println(ptrs[p], ptrs[q], ptrs[nil])
+	bools := map[bool]int{true: 1}
+	bools[false] = 2
+	println(bools[true], bools[false])
+	hashUncomparable()
+
+	// Elements that are slices, maps and structs
+	lists := map[int][]int{}
+	for i := 0; i < 10; i++ {
+		lists[i%3] = append(lists[i%3], i)
+	}
+	println(len(lists[0]), len(lists[1]), len(lists[2]), lists[2][2])
+	nested := map[string]map[string]int{}
+	nested["x"] = map[string]int{}
+	nested["x"]["y"] = 42
+	println(nested["x"]["y"], len(nested["z"]))
+	structs := map[string]Point{"p": {1, 2}}
+	pt := structs["p"]
+	pt.x = 10
+	println(structs["p"].x, pt.x)
+
+	// Maps are references.
+	c := Counts{}
+	c.add("a", "b", "a", "c", "a")
+	println(c["a"], c.total(), len(c))
+	inc := func(k string) { c[k] += 100 }
+	inc("b")
+	println(c["b"])
+
+	// Growing and shrinking
+	big := make(map[int]int, 10)
+	for i := 0; i < 10000; i++ {
+		big[i] = i * i
+	}
+	for i := 0; i < 10000; i += 2 {
+		delete(big, i)
+	}
+	sum := 0
+	for k, v := range big {
+		if v != k*k {
+			println("wrong value for", k)
+		}
+		sum += k
+	}
+	println(len(big), sum, big[9999], big[9998])
+
+	// Deleting during a range never produces the entries deleted.
+	del := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		del[i] = true
+	}
+	seen := 0
+	for k := range del {
+		seen++
+		delete(del, k^1)
+	}
+	println(seen, len(del))
+
+	// Assigning with range and tuples
+	var k string
+	var n int
+	for k, n = range map[string]int{"only": 9} {
+	}
+	println(k, n)
+	m2 := map[string]int{}
+	m2["x"], m2["y"] = 1, 2
+	m2["x"], m2["y"] = m2["y"], m2["x"]
+	show(m2)
+	var i any = m2
+	if mm, ok := i.(map[string]int); ok {
+		println("map in an interface", mm["x"])
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Add(x int) {
+	c.n += x
+}
+
+func (c Counter) Get() int {
+	return c.n
+}
+
+func (c Counter) Sum(xs ...int) int {
+	total := c.n
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+type Named struct {
+	Counter
+	name string
+}
+
+type Getter interface {
+	Get() int
+}
+
+var evaluated int
+
+func pick(c *Counter) *Counter {
+	evaluated++
+	return c
+}
+
+func main() {
+	var c Counter
+	// The method value takes the address of c, so it sees later
+	// changes.
+	add := c.Add
+	add(3)
+	add(4)
+	println(c.n)
+
+	// A value receiver is copied when the method value is made.
+	get := c.Get
+	c.Add(10)
+	println(get(), c.Get())
+
+	// So is the value a pointer receiver points to.
+	p := &c
+	getp := p.Get
+	p.Add(1)
+	println(getp(), p.Get())
+
+	// The receiver is evaluated just once.
+	addp := pick(&c).Add
+	addp(1)
+	addp(1)
+	println(evaluated, c.n)
+
+	sum := c.Sum
+	println(sum(), sum(1, 2, 3))
+
+	// Promoted methods and interface methods have values too.
+	named := Named{name: "x"}
+	nadd := named.Add
+	nadd(5)
+	var g Getter = named
+	gget := g.Get
+	named.Add(1)
+	println(named.n, gget())
+
+	// Method expressions take the receiver as their first parameter.
+	padd := (*Counter).Add
+	vget := Counter.Get
+	pget := (*Counter).Get
+	var d Counter
+	padd(&d, 2)
+	println(vget(d), pget(&d), Counter.Sum(d, 1, 1))
+
+	fs := []func() int{c.Get, d.Get}
+	for _, f := range fs {
+		println(f())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+var counter = 0
+
+// next counts the calls made, so that the order they are made in shows.
+func next() int {
+	counter++
+	return counter
+}
+
+func word() string {
+	return string(rune('a' + next()))
+}
+
+func box() *int {
+	p := new(int)
+	*p = next()
+	return p
+}
+
+func sub(a, b int) int {
+	return a - b
+}
+
+func three(a, b, c int) [3]int {
+	return [3]int{a, b, c}
+}
+
+func all(xs ...int) []int {
+	return xs
+}
+
+type Pair struct {
+	x, y int
+}
+
+type Counter struct {
+	n int
+}
+
+func (c Counter) Add(a, b int) int {
+	return c.n*100 + a*10 + b
+}
+
+func (c *Counter) Bump(by int) int {
+	c.n += by
+	return c.n
+}
+
+type Adder interface {
+	Add(a, b int) int
+}
+
+func counterAt() Counter {
+	return Counter{next()}
+}
+
+func adder() Adder {
+	return Counter{next()}
+}
+
+func pick() func(int, int) int {
+	next()
+	return sub
+}
+
+func main() {
+	println(sub(next(), next()))
+	println(next() - next())
+	println(three(next(), 0, next())[2])
+	a := three(next(), next(), next())
+	println(a[0], a[1], a[2])
+	p := Pair{next(), next()}
+	println(p.x, p.y)
+	q := Pair{y: next(), x: next()}
+	println(q.x, q.y)
+	ps := &Pair{next(), next()}
+	println(ps.x, ps.y)
+	xs := []int{next(), next(), next()}
+	println(xs[0], xs[1], xs[2])
+	xs = append(xs, next(), next())
+	println(xs[3], xs[4])
+	ys := all(next(), next(), next())
+	println(ys[0], ys[1], ys[2])
+	println(word() + word())
+	println(*box() - *box())
+	println(counterAt().Add(next(), next()))
+	println(adder().Add(next(), next()))
+	println(pick()(next(), next()))
+	var c Counter
+	println(c.Bump(next()), c.Bump(next()))
+	ms := map[string]int{word(): next()}
+	for k, v := range ms {
+		println(k, v)
+	}
+	println(sub(sub(next(), next()), sub(next(), next())))
+	println(next() < next(), next()*10+next())
+}
@@ -0,0 +1,32 @@
+package main
+
+type Problem struct {
+	what string
+}
+
+func (p *Problem) Error() string {
+	return "problem: " + p.what
+}
+
+func cleanup() {
+	println("cleaning up")
+	panic(&Problem{"cleanup failed"})
+}
+
+func work(xs []int) int {
+	defer cleanup()
+	defer func() {
+		r := recover()
+		println("recovered", r.(int))
+		panic(r)
+	}()
+	defer println("leaving work")
+	panic(len(xs))
+}
+
+func main() {
+	defer func() {
+		println("main deferred")
+	}()
+	println(work([]int{1, 2, 3}))
+}
@@ -0,0 +1,108 @@
+package main
+
+import "github.com/droundy/ogo/tests/reach/shapes"
+
+type Circle struct {
+	Radius int
+}
+
+// Area is never needed, since a Circle is never held in a Shape.
+func (c Circle) Area() int {
+	return 3 * c.Radius * c.Radius
+}
+
+func (c Circle) Diameter() int {
+	return 2 * c.Radius
+}
+
+type Celsius int
+
+// String is needed, since a panic prints a Celsius with it.
+func (c Celsius) String() string {
+	return "hot"
+}
+
+type unused struct {
+	next *unused
+}
+
+func (u *unused) Len() int {
+	return 1 + u.next.Len()
+}
+
+// The methods of a generic type are needed if those of any of its
+// instances are.
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(x T) {
+	s.items = append(s.items, x)
+}
+
+func (s *Stack[T]) Pop() T {
+	x := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return x
+}
+
+func (s *Stack[T]) Peek() T {
+	return s.items[len(s.items)-1]
+}
+
+type Box[T any] struct {
+	v T
+}
+
+// Show is needed, since a Box[int] is held in a Shower.
+func (b Box[T]) Show() string {
+	return "box"
+}
+
+func (b Box[T]) Unwrap() T {
+	return b.v
+}
+
+type Shower interface {
+	Show() string
+}
+
+var table = []int{1, 2, 3}
+
+var cache = map[string]int{}
+
+var registered = register("deadcode")
+
+func register(name string) int {
+	println("registering", name)
+	return len(name)
+}
+
+func helper() int {
+	return len(table)
+}
+
+func unreachable() int {
+	return helper() + len(cache)
+}
+
+func main() {
+	sq := shapes.Square{Side: 3}
+	sq.Grow(1)
+	all := []shapes.Shape{sq}
+	println(shapes.Total(all), registered)
+	c := Circle{Radius: 2}
+	println(c.Diameter())
+	var st Stack[int]
+	st.Push(1)
+	st.Push(2)
+	println(st.Pop())
+	var sh Shower = Box[int]{1}
+	println(sh.Show())
+	defer func() {
+		println(recover() != nil)
+		panic(Celsius(40))
+	}()
+	var xs []int
+	println(xs[0])
+}
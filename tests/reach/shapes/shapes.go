@@ -0,0 +1,47 @@
+package shapes
+
+type Shape interface {
+	Area() int
+}
+
+type Square struct {
+	Side int
+}
+
+func (s Square) Area() int {
+	return s.Side * s.Side
+}
+
+func (s Square) Perimeter() int {
+	return 4 * s.Side
+}
+
+func (s *Square) Grow(n int) {
+	s.Side += n
+}
+
+type Triangle struct {
+	Base, Height int
+}
+
+func (t Triangle) Area() int {
+	return t.Base * t.Height / 2
+}
+
+func Total(shapes []Shape) int {
+	total := 0
+	for _, s := range shapes {
+		total += s.Area()
+	}
+	return total
+}
+
+func Largest(shapes []Shape) Shape {
+	var best Shape
+	for _, s := range shapes {
+		if best == nil || s.Area() > best.Area() {
+			best = s
+		}
+	}
+	return best
+}
@@ -0,0 +1,13 @@
+package main
+
+func get(xs []int, i int) int {
+	return xs[i]
+}
+
+func main() {
+	xs := []int{1, 2, 3}
+	println(get(xs, 2))
+	println(len(xs[3:]), len(xs[:0]))
+	println(get(xs, 5))
+	println("not reached")
+}
@@ -0,0 +1,103 @@
+package main
+
+type IntList []int
+
+// grow is global so that gc cannot give its backing array a stack buffer.
+var grow []int
+
+func sum(xs []int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func count(xs ...int) int {
+	return len(xs)
+}
+
+func reverse(xs IntList) {
+	for i := 0; i < len(xs)/2; i++ {
+		j := len(xs) - 1 - i
+		xs[i], xs[j] = xs[j], xs[i]
+	}
+}
+
+func main() {
+	var empty []int
+	println(len(empty), cap(empty), empty == nil)
+
+	xs := []int{1, 2, 3, 4, 5}
+	println(len(xs), cap(xs), xs[0], xs[4], sum(xs))
+	xs[2] = 30
+	println(xs[2], sum(xs[1:3]), sum(xs[:2]), sum(xs[3:]))
+
+	// Appending grows the capacity just as gc does.
+	for i := 0; i < 600; i++ {
+		before := cap(grow)
+		grow = append(grow, i)
+		if cap(grow) != before {
+			println("len", len(grow), "cap", cap(grow))
+		}
+	}
+	bs := append([]byte{}, "hello"...)
+	println(len(bs), cap(bs), string(bs))
+	bs = append(bs, ' ', 'w')
+	println(len(bs), cap(bs), string(bs))
+
+	// Reslicing shares the underlying array.
+	ys := xs[1:3]
+	ys[0] = 20
+	println(xs[1], len(ys), cap(ys))
+	ys = append(ys, 40)
+	println(xs[3], len(ys), cap(ys))
+	zs := xs[1:2:3]
+	println(len(zs), cap(zs))
+	zs = append(zs, 7, 8)
+	println(xs[3], len(zs), cap(zs))
+
+	m := make([]string, 2, 10)
+	m[1] = "world"
+	println(len(m), cap(m), m[0] == "", m[1])
+	m2 := make([]float64, 3)
+	println(len(m2), cap(m2), m2[2])
+
+	dst := make([]int, 3)
+	n := copy(dst, xs)
+	println(n, dst[0], dst[1], dst[2])
+	n = copy(dst, []int{9})
+	println(n, dst[0], dst[1])
+	n = copy(bs, "HE")
+	println(n, string(bs))
+
+	keyed := []string{2: "two", 5: "five", "six"}
+	println(len(keyed), keyed[2], keyed[5], keyed[6], keyed[0] == "")
+
+	grid := [][]int{{1, 2}, {3, 4, 5}, nil}
+	for i, row := range grid {
+		println(i, len(row), sum(row))
+	}
+	grid[2] = append(grid[2], 6)
+	println(grid[2][0], grid[1][2])
+
+	l := IntList{1, 2, 3}
+	reverse(l)
+	println(l[0], l[1], l[2])
+	println(count(), count(1, 2), count(xs...))
+
+	runes := []rune("héllo")
+	println(len(runes), runes[1], string(runes[1:3]))
+
+	total := 0
+	for i := range 10 {
+		total += i
+	}
+	for range 3 {
+		total++
+	}
+	println(total)
+	for i, c := range "añb" {
+		println(i, c)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+type Name struct {
+	first, last string
+}
+
+type pair struct {
+	a, b int
+}
+
+var origin = struct{ x, y int }{0, 0}
+
+func midpoint(p, q struct{ x, y int }) struct{ x, y int } {
+	return struct{ x, y int }{(p.x + q.x) / 2, (p.y + q.y) / 2}
+}
+
+func people() []struct {
+	name Name
+	age  int
+} {
+	return []struct {
+		name Name
+		age  int
+	}{
+		{Name{"Ada", "Lovelace"}, 36},
+		{Name{"Alan", "Turing"}, 41},
+	}
+}
+
+func first() int {
+	type T struct{ n int }
+	return T{1}.n
+}
+
+func second() string {
+	type T struct{ s string }
+	var t T
+	t.s = "two"
+	return t.s
+}
+
+// lookalikes uses two struct types whose C names must differ.
+func lookalikes() int {
+	a := struct {
+		x int
+		y int
+	}{1, 2}
+	b := struct{ x_int__y int }{3}
+	return a.x + a.y + b.x_int__y
+}
+
+type Counter struct {
+	count int
+}
+
+func (c *Counter) Bump() {
+	c.count++
+}
+
+func main() {
+	println(lookalikes())
+	p := midpoint(origin, struct{ x, y int }{4, 6})
+	println("midpoint", p.x, p.y)
+	var q struct{ x, y int } = p
+	println("equal", p == q, p == origin)
+
+	for _, who := range people() {
+		println(who.name.first, who.name.last, who.age)
+	}
+
+	// A pointer to an anonymous struct, holding a pointer.
+	node := &struct {
+		value int
+		next  *pair
+	}{1, &pair{2, 3}}
+	node.next.b += node.value
+	println("node", node.value, node.next.a, node.next.b)
+
+	// An anonymous struct nested within another.
+	var nested struct {
+		inner struct{ a, b int }
+		label string
+	}
+	nested.inner.b = 5
+	nested.label = "nested"
+	println(nested.label, nested.inner.a, nested.inner.b)
+
+	// Anonymous structs convert to named ones with the same fields.
+	pr := pair(struct{ a, b int }{7, 8})
+	println("pair", pr.a, pr.b)
+
+	// Local types, of which two have the same name.
+	println(first(), second())
+	type list struct {
+		head int
+		tail *list
+	}
+	l := &list{1, &list{2, nil}}
+	n := 0
+	for ; l != nil; l = l.tail {
+		n += l.head
+	}
+	println("list", n)
+	type bumper struct {
+		Counter
+		name string
+	}
+	b := bumper{name: "bumper"}
+	b.Bump()
+	b.Bump()
+	println(b.name, b.count)
+
+	var things []interface{}
+	things = append(things, struct{ a, b int }{1, 2}, pair{1, 2})
+	for _, t := range things {
+		_, anon := t.(struct{ a, b int })
+		_, named := t.(pair)
+		println("anonymous", anon, "named", named)
+	}
+
+	m := make(map[struct{ x, y int }]string)
+	m[struct{ x, y int }{1, 2}] = "one two"
+	println(m[p], m[struct{ x, y int }{1, 2}])
+}
@@ -0,0 +1,123 @@
+//go:build ogo
+
+// This is an ogo program, built with the sum extension, which holds
+// each sealed interface as a tagged union.
+package main
+
+type Circle struct {
+	r float64
+}
+
+type Square struct {
+	side float64
+}
+
+func (c Circle) Area() float64 { return 3 * c.r * c.r }
+
+func (s Square) Area() float64 { return s.side * s.side }
+
+func (s Square) Corners() int { return 4 }
+
+type Shape sealed interface {
+	Circle | Square
+	Area() float64
+}
+
+type Cornered interface {
+	Corners() int
+}
+
+func describe(s Shape) string {
+	switch s := s.(type) {
+	case nil:
+		return "nothing"
+	case Circle:
+		if s.r > 1 {
+			return "a big circle"
+		}
+		return "a circle"
+	case Square:
+		return "a square"
+	}
+	return "impossible"
+}
+
+func corners(s Shape) int {
+	switch s := s.(type) {
+	case Cornered:
+		return s.Corners()
+	default:
+		return 0
+	}
+}
+
+// An expression is a tree, whose variants hold pointers the collector
+// has to follow.
+type Expr sealed interface {
+	int | *Add | *Mul
+}
+
+type Add struct {
+	x, y Expr
+}
+
+type Mul struct {
+	x, y Expr
+}
+
+func eval(e Expr) int {
+	switch e := e.(type) {
+	case int:
+		return e
+	case *Add:
+		return eval(e.x) + eval(e.y)
+	case *Mul:
+		return eval(e.x) * eval(e.y)
+	}
+	panic("nil expression")
+}
+
+func build(n int) Expr {
+	if n == 0 {
+		return 1
+	}
+	return &Add{build(n - 1), &Mul{2, build(n - 1)}}
+}
+
+func main() {
+	var s Shape
+	println(describe(s), s == nil)
+	s = Circle{2}
+	println(describe(s), s != nil, s.Area())
+	shapes := []Shape{Circle{1}, Square{3}, nil}
+	for _, s := range shapes {
+		println(describe(s), corners(s))
+	}
+	if c, ok := s.(Circle); ok {
+		println("circle of radius", c.r)
+	}
+	if _, ok := s.(Square); !ok {
+		println("not a square")
+	}
+	println(s.(Circle).r)
+	println(s == Shape(Circle{2}), s == Shape(Circle{1}), s == Shape(Square{2}))
+
+	var x any = s
+	if c, ok := x.(Circle); ok {
+		println("any holds a circle of radius", c.r)
+	}
+
+	areas := map[Shape]float64{}
+	for _, s := range shapes[:2] {
+		areas[s] = s.Area()
+	}
+	println(len(areas), areas[Square{3}], areas[Circle{1}])
+
+	total := 0
+	for i := 0; i < 100; i++ {
+		total += eval(build(8))
+	}
+	println(total)
+	var e Expr = 3
+	println(eval(&Mul{e, &Add{4, 5}}))
+}
@@ -0,0 +1,218 @@
+package main
+
+type Point struct {
+	x, y int
+}
+
+type Weekday int
+
+const (
+	Sunday Weekday = iota
+	Monday
+	Tuesday
+	Saturday = 6
+)
+
+var calls int
+
+func tag(i int) int {
+	calls++
+	return i
+}
+
+func say(s string, b bool) bool {
+	println("testing", s)
+	return b
+}
+
+func kind(d Weekday) string {
+	switch d {
+	case Saturday, Sunday:
+		return "weekend"
+	default:
+		return "weekday"
+	case Monday:
+		return "monday"
+	}
+}
+
+func count(i int) int {
+	n := 0
+	switch i {
+	case 0:
+		n++
+		fallthrough
+	case 1:
+		n += 10
+		fallthrough
+	default:
+		n += 100
+	case 2:
+		n += 1000
+	}
+	return n
+}
+
+func fallDefault(s string) string {
+	out := ""
+	switch s {
+	default:
+		out += "default "
+		fallthrough
+	case "a":
+		out += "a "
+	case "b":
+		out += "b "
+		fallthrough
+	case "c" + "":
+		out += "c"
+	}
+	return out
+}
+
+func sign(x int) string {
+	switch {
+	case x < 0:
+		return "negative"
+	case x == 0:
+		return "zero"
+	}
+	return "positive"
+}
+
+func color(s string) int {
+	switch s {
+	case "red":
+		return 1
+	case "green", "blue":
+		return 2
+	}
+	return 0
+}
+
+func describe(x any) string {
+	switch x {
+	case nil:
+		return "nil"
+	case 1, "one":
+		return "one"
+	case 2.5:
+		return "two and a half"
+	case Point{1, 2}:
+		return "point"
+	}
+	return "something else"
+}
+
+func find(grid [][]int, want int) int {
+	r, c := -1, -1
+	for i, row := range grid {
+		for j, v := range row {
+			switch v {
+			case want:
+				r, c = i, j
+			default:
+				continue
+			}
+			break
+		}
+		if r >= 0 {
+			break
+		}
+	}
+	return 10*r + c
+}
+
+func labeled(xs []int) int {
+	sum := 0
+Outer:
+	switch len(xs) {
+	case 0:
+		return -1
+	default:
+		for _, x := range xs {
+			if x < 0 {
+				break Outer
+			}
+			sum += x
+		}
+		sum *= 2
+	}
+	return sum
+}
+
+func main() {
+	for d := Sunday; d <= Saturday; d++ {
+		println(d, kind(d))
+	}
+	for i := 0; i < 4; i++ {
+		println(i, count(i))
+	}
+	for _, s := range []string{"a", "b", "c", "d"} {
+		println(s, fallDefault(s))
+	}
+	println(sign(-3), sign(0), sign(5))
+	println(color("red"), color("blue"), color("pink"))
+	var p *Point
+	println(describe(nil), describe(1), describe("one"), describe(2.5),
+		describe(Point{1, 2}), describe(Point{2, 1}), describe(p))
+
+	switch tag(3) {
+	case tag(1), tag(2):
+		println("wrong")
+	case tag(3), tag(4):
+		println("right")
+	case tag(5):
+		println("wrong")
+	}
+	println("calls", calls)
+
+	switch {
+	case say("first", false), say("second", true), say("third", true):
+		println("matched")
+	}
+
+	switch x := 5; x {
+	case 5:
+		println("five")
+		if x > 0 {
+			break
+		}
+		println("unreachable")
+	}
+
+	switch p {
+	case nil:
+		println("nil pointer")
+	}
+
+	grid := [][]int{{1, 2, 3}, {4, 5, 6}}
+	println(find(grid, 5), find(grid, 7))
+	println(labeled(nil), labeled([]int{1, 2, 3}), labeled([]int{1, -2, 3}))
+
+	n := 0
+	for i := 0; i < 10; i++ {
+		switch {
+		case i%2 == 0:
+			continue
+		case i == 7:
+			break
+		}
+		n += i
+	}
+	println(n)
+
+	b := byte('x')
+	switch b {
+	case 'a', 'e', 'i', 'o', 'u':
+		println("vowel")
+	case 'x':
+		println("ex")
+	}
+
+	switch {
+	}
+	switch tag(9) {
+	}
+	println("calls", calls)
+}
@@ -0,0 +1,30 @@
+//go:build ogo
+
+// This is an ogo program, built with the trace extension, which makes
+// each function print its name as it is called.
+package main
+
+type counter struct {
+	n int
+}
+
+func (c *counter) add(x int) {
+	c.n += x
+}
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func Double[T ~int | ~float64](x T) T {
+	return x + x
+}
+
+func main() {
+	var c counter
+	c.add(fib(3))
+	println(c.n, Double(2), Double(1.5))
+}
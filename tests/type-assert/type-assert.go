@@ -0,0 +1,21 @@
+package main
+
+type Shape interface {
+	Area() int
+}
+
+type Rect struct {
+	w, h int
+}
+
+func (r Rect) Area() int {
+	return r.w * r.h
+}
+
+func main() {
+	var s Shape = Rect{1, 2}
+	println(s.(Rect).Area())
+	var x any = "hello"
+	println(x.(string))
+	println(x.(int))
+}
@@ -0,0 +1,102 @@
+package main
+
+type Shape interface {
+	Area() int
+}
+
+type Stringer interface {
+	String() string
+}
+
+type Rect struct {
+	w, h int
+}
+
+func (r Rect) Area() int {
+	return r.w * r.h
+}
+
+type Square struct {
+	side int
+}
+
+func (s *Square) Area() int {
+	return s.side * s.side
+}
+
+func (s *Square) String() string {
+	return "square"
+}
+
+type Celsius float64
+
+func describe(x any) string {
+	switch v := x.(type) {
+	case nil:
+		return "nil"
+	case int:
+		return "int"
+	case string:
+		return "string " + v
+	case bool, float64:
+		if v == true {
+			return "true"
+		}
+		return "bool or float64"
+	case Stringer:
+		return "stringer " + v.String()
+	case Shape:
+		println("area", v.Area())
+		return "shape"
+	default:
+		return "something else"
+	}
+}
+
+func main() {
+	var things []any
+	things = append(things, nil, 7, "seven", true, false, 7.5, Rect{2, 3},
+		&Square{4}, Celsius(10), int8(3))
+	for i, x := range things {
+		println(i, describe(x))
+	}
+
+	var x any = Rect{3, 4}
+	r := x.(Rect)
+	println(r.w, r.h, r.Area())
+	s := x.(Shape)
+	println(s.Area())
+
+	if n, ok := x.(int); ok {
+		println("int", n)
+	} else {
+		println("not int", n)
+	}
+	var sq Shape = &Square{5}
+	p, ok := sq.(*Square)
+	println(p.side, ok)
+	_, ok = sq.(Stringer)
+	println(ok)
+	var str Stringer
+	str, ok = s.(Stringer)
+	println(str == nil, ok)
+	var empty any
+	_, ok = empty.(Shape)
+	println(ok)
+	var c, isC = x.(Celsius)
+	println(c, isC)
+
+	// A break leaves the switch, but not the loop around it.
+	total := 0
+	for _, x := range things {
+		switch x.(type) {
+		case int, int8:
+			total++
+			continue
+		case string:
+			break
+		}
+		total += 10
+	}
+	println(total)
+}
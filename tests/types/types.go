@@ -0,0 +1,77 @@
+//go:build ogo
+
+// This is an ogo program, which uses the builtin Type.
+package main
+
+type Shape interface {
+	Area() int
+}
+
+type Stringer interface {
+	String() string
+}
+
+type Point struct {
+	X, Y int
+	Name string
+}
+
+func (p Point) Area() int {
+	return 0
+}
+
+func (p *Point) String() string {
+	return p.Name
+}
+
+type List struct {
+	value int
+	next  *List
+}
+
+func describe(t Type) {
+	println(t.Name(), t.Kind(), t.Size(), t.Align())
+	if k := t.Kind(); k == "ptr" || k == "slice" {
+		println("  elem", t.Elem().Name())
+	} else if k == "array" {
+		println("  elem", t.Elem().Name(), "len", t.Len())
+	} else if k == "struct" {
+		for i := 0; i < t.NumField(); i++ {
+			println("  field", t.FieldName(i), t.FieldType(i).Name())
+		}
+	}
+	for i := 0; i < t.NumMethod(); i++ {
+		println("  method", t.Method(i))
+	}
+}
+
+func main() {
+	types := []Type{int, int8, float64, bool, string, []byte, [3]int16, Point, *Point,
+		List, Shape, any, Type}
+	for _, t := range types {
+		describe(t)
+	}
+
+	var t Type = Point
+	println(t == Point, t == *Point, t != nil)
+	var s Shape = Point{1, 2, "p"}
+	println(typeof(s) == Point, typeof(s).Name(), typeof(3).Name())
+	var empty any
+	println(typeof(empty) == nil)
+
+	shape, stringer, ptr := Type(Shape), Type(Stringer), Type(*Point)
+	println(t.Implements(shape), t.Implements(stringer))
+	println(ptr.Implements(shape), ptr.Implements(stringer))
+	println(shape.Implements(shape), shape.Implements(stringer))
+	println(typeof(3).Implements(any), typeof(3).Implements(int))
+
+	p := new(t)
+	pt := p.(*Point)
+	pt.Name = "new"
+	println(pt.X, pt.Y, pt.Name, typeof(p) == *Point)
+	n := new(Type(int)).(*int)
+	*n = 42
+	println(*n)
+	u := new([]byte)
+	println(typeof(u).Name(), typeof(u).Elem().Name())
+}
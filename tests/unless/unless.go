@@ -0,0 +1,29 @@
+//go:build ogo
+
+// This is an ogo program, built with the unless extension, which adds
+// the unless statement.
+package main
+
+func describe(n int) string {
+	unless n >= 0 {
+		return "negative"
+	}
+	unless n%2 == 1 {
+		return "even"
+	}
+	return "odd"
+}
+
+func main() {
+	for _, n := range []int{-3, 4, 7} {
+		println(n, describe(n))
+	}
+	done := false
+	for i := 0; ; i++ {
+		unless !done {
+			break
+		}
+		done = i == 2
+		println("step", i)
+	}
+}
@@ -0,0 +1,492 @@
+package transform
+
+import (
+	"bytes"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/constant"
+	"go/printer"
+	"go/token"
+)
+
+// inBounds finds the index expressions x[i] within f whose index is
+// surely in range, so that we needn't check it.  That is so
+//
+//   - within the body of a loop or if statement whose condition says
+//     that i < len(x), where i is never negative, until the body
+//     changes i or x,
+//   - when x is an array and i < n for a constant n no greater than
+//     its length, and
+//   - once x[i] has been checked, until i or x changes,
+//
+// where x and i are local variables whose addresses are never taken,
+// so that nothing else may change them.  A copy of such a variable,
+// such as those that EliminateRange makes, is as good as the original
+// until either changes.  The type checker has already checked constant
+// indices into arrays.
+func inBounds(f *ast.File, info *types.Info) map[*ast.IndexExpr]bool {
+	b := &bounds{info: info, addressed: make(map[*types.Object]bool),
+		safe: make(map[*ast.IndexExpr]bool)}
+	b.findNonneg(f)
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Body != nil {
+				b.stmts(d.Body.List, nil)
+			}
+		case *ast.GenDecl:
+			b.check(d, nil)
+		}
+	}
+	return b.safe
+}
+
+type bounds struct {
+	info *types.Info
+	// addressed holds the variables whose addresses are taken, and
+	// nonneg those that are never negative.
+	addressed map[*types.Object]bool
+	nonneg    map[*types.Object]bool
+	safe      map[*ast.IndexExpr]bool
+}
+
+// A fact is something we know at some point of a function: that
+// i < len(x), or i < n if x is nil, when op is LSS; that i >= 0 when
+// op is GEQ; that i == x when op is EQL; and that x[i], or x[n] if i
+// is nil, is in range when op is LBRACK.
+type fact struct {
+	op   token.Token
+	i, x *types.Object
+	n    int64
+}
+
+// local is the variable that e names, if it is a local variable that
+// only assignments may change.
+func (b *bounds) local(e ast.Expr) *types.Object {
+	id, ok := types.StripParens(e).(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	o := b.info.Objects[id]
+	if o == nil || o.Kind != types.Var || o.Global || o.Name == "_" || b.addressed[o] {
+		return nil
+	}
+	return o
+}
+
+// findNonneg finds the local variables that are never negative,
+// because they are only ever given values that aren't, and are
+// incremented but never decremented.  An increment of a 64-bit
+// integer could only overflow after more steps than any program
+// takes, and one of an unsigned integer wraps around to zero, but
+// one of a smaller signed integer may well overflow.
+func (b *bounds) findNonneg(f *ast.File) {
+	sources := make(map[*types.Object][]ast.Expr)
+	varying := make(map[*types.Object]bool)
+	vary := func(e ast.Expr) {
+		if id, ok := types.StripParens(e).(*ast.Ident); ok && b.info.Objects[id] != nil {
+			varying[b.info.Objects[id]] = true
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				if id, ok := types.StripParens(n.X).(*ast.Ident); ok && b.info.Objects[id] != nil {
+					b.addressed[b.info.Objects[id]] = true
+				}
+			}
+		case *ast.SelectorExpr:
+			// Calling a method with a pointer receiver takes the
+			// address of its receiver.
+			m := b.info.Objects[n.Sel]
+			if m != nil && m.Kind == types.Func && m.Recv != nil && types.IsPointer(m.Recv) {
+				if id, ok := types.StripParens(n.X).(*ast.Ident); ok && b.info.Objects[id] != nil {
+					b.addressed[b.info.Objects[id]] = true
+				}
+			}
+		case *ast.Field:
+			for _, name := range n.Names {
+				vary(name)
+			}
+		case *ast.ValueSpec:
+			for k, name := range n.Names {
+				o := b.info.Objects[name]
+				switch {
+				case len(n.Values) == len(n.Names):
+					sources[o] = append(sources[o], n.Values[k])
+				case len(n.Values) > 0:
+					varying[o] = true
+				default:
+					// The variable starts at zero.
+					if _, ok := sources[o]; !ok {
+						sources[o] = nil
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			for k, x := range n.Lhs {
+				id, ok := types.StripParens(x).(*ast.Ident)
+				if !ok || b.info.Objects[id] == nil {
+					continue
+				}
+				o := b.info.Objects[id]
+				if (n.Tok == token.ASSIGN || n.Tok == token.DEFINE) && len(n.Lhs) == len(n.Rhs) {
+					sources[o] = append(sources[o], n.Rhs[k])
+				} else {
+					varying[o] = true
+				}
+			}
+		case *ast.IncDecStmt:
+			if t := b.info.TypeOf(n.X); n.Tok == token.DEC || !types.IsUnsigned(t) && width(t) < 64 {
+				vary(n.X)
+			}
+		case *ast.RangeStmt:
+			vary(n.Key)
+			vary(n.Value)
+		}
+		return true
+	})
+	// Suppose that every local integer that nothing makes negative
+	// is nonnegative, and then drop those that might take the value
+	// of one that isn't, until none do.
+	b.nonneg = make(map[*types.Object]bool)
+	for o := range sources {
+		if o != nil && o.Kind == types.Var && !o.Global && !varying[o] &&
+			!b.addressed[o] && types.IsInteger(o.Type) {
+			b.nonneg[o] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for o := range b.nonneg {
+			for _, e := range sources[o] {
+				if !b.nonnegative(e) {
+					delete(b.nonneg, o)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+// nonnegative is true if e is surely not negative.
+func (b *bounds) nonnegative(e ast.Expr) bool {
+	if v, ok := b.info.Values[e]; ok {
+		return v.Kind() == constant.Int && constant.Sign(v) >= 0
+	}
+	switch e := types.StripParens(e).(type) {
+	case *ast.Ident:
+		return b.nonneg[b.info.Objects[e]]
+	case *ast.CallExpr:
+		return isBuiltinCall(e, b.info, "len", "cap")
+	}
+	return false
+}
+
+// stmts finds the index expressions in list that are in range, given
+// the facts that hold at its start.
+func (b *bounds) stmts(list []ast.Stmt, facts []fact) {
+	for _, s := range list {
+		facts = b.stmt(s, facts)
+	}
+}
+
+// stmt finds the index expressions in s that are in range, given the
+// facts that hold before it, and returns those that hold after it.
+func (b *bounds) stmt(s ast.Stmt, facts []fact) []fact {
+	switch s := s.(type) {
+	case nil:
+		return facts
+	case *ast.LabeledStmt:
+		// We could come here from anywhere.
+		return b.stmt(s.Stmt, nil)
+	case *ast.BlockStmt:
+		b.stmts(s.List, facts)
+	case *ast.IfStmt:
+		facts = b.stmt(s.Init, facts)
+		b.check(s.Cond, facts)
+		facts = append(facts, b.checked(s.Cond)...)
+		b.stmts(s.Body.List, append(b.cond(s.Cond), facts...))
+		b.stmt(s.Else, facts)
+	case *ast.ForStmt:
+		facts = b.kill(b.stmt(s.Init, facts), s)
+		b.check(s.Cond, facts)
+		b.stmts(s.Body.List, append(append(b.cond(s.Cond), b.checked(s.Cond)...), facts...))
+		b.stmt(s.Post, facts)
+		return facts
+	case *ast.RangeStmt:
+		b.check(s.X, facts)
+		facts = b.kill(facts, s)
+		b.stmts(s.Body.List, facts)
+		return facts
+	case *ast.SwitchStmt:
+		facts = b.stmt(s.Init, facts)
+		b.check(s.Tag, facts)
+		facts = b.kill(append(facts, b.checked(s.Tag)...), s)
+		for _, c := range s.Body.List {
+			c := c.(*ast.CaseClause)
+			for _, e := range c.List {
+				b.check(e, facts)
+			}
+			b.stmts(c.Body, facts)
+		}
+		return facts
+	case *ast.TypeSwitchStmt:
+		facts = b.kill(b.stmt(s.Assign, b.stmt(s.Init, facts)), s)
+		for _, c := range s.Body.List {
+			b.stmts(c.(*ast.CaseClause).Body, facts)
+		}
+		return facts
+	case *ast.SelectStmt:
+		facts = b.kill(facts, s)
+		for _, c := range s.Body.List {
+			c := c.(*ast.CommClause)
+			b.stmt(c.Comm, facts)
+			b.stmts(c.Body, facts)
+		}
+		return facts
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.IncDecStmt, *ast.SendStmt, *ast.DeclStmt:
+		// Everything in a simple statement is evaluated before it
+		// assigns anything.
+		b.check(s, facts)
+		return append(b.kill(append(facts, b.checked(s)...), s), b.copies(s)...)
+	default:
+		b.check(s, facts)
+	}
+	return b.kill(facts, s)
+}
+
+// kill drops the facts about the variables that n may change.
+func (b *bounds) kill(facts []fact, n ast.Node) []fact {
+	changed := assigned(n, b.info)
+	var out []fact
+	for _, f := range facts {
+		if !changed[f.i] && !changed[f.x] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// cond finds the facts that hold when the condition e is true.
+func (b *bounds) cond(e ast.Expr) []fact {
+	e = types.StripParens(e)
+	x, ok := e.(*ast.BinaryExpr)
+	if !ok {
+		return nil
+	}
+	switch x.Op {
+	case token.LAND:
+		return append(b.cond(x.X), b.cond(x.Y)...)
+	case token.LSS:
+		return b.less(x.X, x.Y)
+	case token.GTR:
+		return b.less(x.Y, x.X)
+	case token.GEQ:
+		return b.atLeast(x.X, x.Y)
+	case token.LEQ:
+		return b.atLeast(x.Y, x.X)
+	}
+	return nil
+}
+
+// less finds the facts that hold when i < e.
+func (b *bounds) less(i, e ast.Expr) []fact {
+	o := b.local(i)
+	if o == nil {
+		return nil
+	}
+	if v, ok := b.info.Values[e]; ok {
+		if n, exact := constant.Int64Val(constant.ToInt(v)); exact {
+			return []fact{{op: token.LSS, i: o, n: n}}
+		}
+		return nil
+	}
+	if c, ok := types.StripParens(e).(*ast.CallExpr); ok && isBuiltinCall(c, b.info, "len") {
+		if x := b.local(c.Args[0]); x != nil {
+			return []fact{{op: token.LSS, i: o, x: x}}
+		}
+	}
+	return nil
+}
+
+// atLeast finds the facts that hold when i >= e.
+func (b *bounds) atLeast(i, e ast.Expr) []fact {
+	if o := b.local(i); o != nil && b.nonnegative(e) {
+		return []fact{{op: token.GEQ, i: o}}
+	}
+	return nil
+}
+
+// checked finds the facts that hold once n is evaluated, which are
+// that the indices it must check are in range.  The right operand of
+// && or || may not be evaluated, and nor is a constant len(a[i]).
+func (b *bounds) checked(n ast.Node) []fact {
+	var facts []fact
+	if n == nil {
+		return nil
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				facts = append(facts, b.checked(n.X)...)
+				return false
+			}
+		case *ast.IndexExpr:
+			x := b.local(n.X)
+			if x == nil || types.IsMap(b.info.TypeOf(n.X)) {
+				break
+			}
+			if v, ok := b.info.Values[n.Index]; ok {
+				if c, exact := constant.Int64Val(constant.ToInt(v)); exact {
+					facts = append(facts, fact{op: token.LBRACK, x: x, n: c})
+				}
+			} else if i := b.local(n.Index); i != nil {
+				facts = append(facts, fact{op: token.LBRACK, i: i, x: x})
+			}
+		}
+		if e, ok := n.(ast.Expr); ok {
+			if _, isconst := b.info.Values[e]; isconst {
+				return false
+			}
+		}
+		return true
+	})
+	return facts
+}
+
+// copies finds the facts that hold once s copies one variable into
+// another.
+func (b *bounds) copies(s ast.Stmt) []fact {
+	var lhs, rhs []ast.Expr
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		if s.Tok == token.ASSIGN || s.Tok == token.DEFINE {
+			lhs, rhs = s.Lhs, s.Rhs
+		}
+	case *ast.DeclStmt:
+		if d, ok := s.Decl.(*ast.GenDecl); ok && d.Tok == token.VAR {
+			for _, spec := range d.Specs {
+				spec := spec.(*ast.ValueSpec)
+				for _, name := range spec.Names {
+					lhs = append(lhs, name)
+				}
+				rhs = append(rhs, spec.Values...)
+			}
+		}
+	}
+	var facts []fact
+	if len(lhs) == 1 && len(rhs) == 1 {
+		if x, y := b.local(lhs[0]), b.local(rhs[0]); x != nil && y != nil && x != y &&
+			types.Identical(x.Type, y.Type) {
+			facts = append(facts, fact{op: token.EQL, i: x, x: y})
+		}
+	}
+	return facts
+}
+
+// check finds the index expressions within n that are in range, given
+// the facts that hold before n, and looks within the function literals
+// in n, which know nothing of the facts where they are written.
+func (b *bounds) check(n ast.Node, facts []fact) {
+	if n == nil {
+		return
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			b.stmts(n.Body.List, nil)
+			return false
+		case *ast.IndexExpr:
+			if b.proves(n, facts) {
+				b.safe[n] = true
+			}
+		}
+		return true
+	})
+}
+
+// proves is true if the facts show that the index of e is in range.
+func (b *bounds) proves(e *ast.IndexExpr, facts []fact) bool {
+	t := b.info.TypeOf(e.X)
+	a, isarray := types.ArrayOf(t)
+	if !isarray && !types.IsString(t) && !types.IsSlice(t) {
+		return false
+	}
+	xs := b.same(b.local(e.X), facts)
+	if v, ok := b.info.Values[e.Index]; ok {
+		c, exact := constant.Int64Val(constant.ToInt(v))
+		for _, f := range facts {
+			if f.op == token.LBRACK && f.i == nil && f.n == c && exact && xs[f.x] {
+				return true
+			}
+		}
+		return false
+	}
+	is := b.same(b.local(e.Index), facts)
+	var above, below bool
+	for i := range is {
+		above = above || b.nonneg[i]
+	}
+	for _, f := range facts {
+		if !is[f.i] {
+			continue
+		}
+		switch {
+		case f.op == token.LBRACK && xs[f.x]:
+			return true
+		case f.op == token.GEQ:
+			above = true
+		case f.op == token.LSS && f.x == nil:
+			below = below || isarray && f.n <= a.Len
+		case f.op == token.LSS:
+			below = below || xs[f.x]
+		}
+	}
+	return above && below
+}
+
+// same finds the variables that the facts show equal to o.
+func (b *bounds) same(o *types.Object, facts []fact) map[*types.Object]bool {
+	out := make(map[*types.Object]bool)
+	if o == nil {
+		return out
+	}
+	out[o] = true
+	for changed := true; changed; {
+		changed = false
+		for _, f := range facts {
+			if f.op == token.EQL && out[f.i] != out[f.x] {
+				out[f.i], out[f.x] = true, true
+				changed = true
+			}
+		}
+	}
+	return out
+}
+
+// isBuiltinCall is true if c calls one of the named builtins.
+func isBuiltinCall(c *ast.CallExpr, info *types.Info, names ...string) bool {
+	id, ok := types.StripParens(c.Fun).(*ast.Ident)
+	if !ok || info.Objects[id] == nil || info.Objects[id].Kind != types.Builtin {
+		return false
+	}
+	for _, n := range names {
+		if id.Name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// goString is the Go source of e.
+func goString(e ast.Expr) string {
+	var b bytes.Buffer
+	printer.Fprint(&b, token.NewFileSet(), e)
+	return b.String()
+}
@@ -0,0 +1,403 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// captures finds the local variables that each function literal
+// within n captures, which are those it refers to that are declared
+// outside it, in the order it first refers to them.  A variable that
+// a nested literal captures is captured by the enclosing ones too, so
+// that they can hand it on.
+func captures(n ast.Node, info *types.Info) map[*ast.FuncLit][]*types.Object {
+	caps := make(map[*ast.FuncLit][]*types.Object)
+	captured := make(map[*ast.FuncLit]map[*types.Object]bool)
+	// A variable is declared where we first see it, since every
+	// declaration comes before the uses of what it declares.
+	declaredIn := make(map[*types.Object]*ast.FuncLit)
+	seen := make(map[*types.Object]bool)
+	var stack []ast.Node
+	var lits []*ast.FuncLit
+	ast.Inspect(n, func(n ast.Node) bool {
+		if n == nil {
+			if _, ok := stack[len(stack)-1].(*ast.FuncLit); ok {
+				lits = lits[:len(lits)-1]
+			}
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		var lit *ast.FuncLit
+		if len(lits) > 0 {
+			lit = lits[len(lits)-1]
+		}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			lits = append(lits, n)
+			captured[n] = make(map[*types.Object]bool)
+		case *ast.CaseClause:
+			if o := info.Implicits[n]; o != nil {
+				seen[o] = true
+				declaredIn[o] = lit
+			}
+		case *ast.Ident:
+			o := info.Objects[n]
+			if o == nil || o.Kind != types.Var || o.Global || o.Name == "_" {
+				break
+			}
+			if !seen[o] {
+				seen[o] = true
+				declaredIn[o] = lit
+				break
+			}
+			for i := len(lits) - 1; i >= 0 && lits[i] != declaredIn[o]; i-- {
+				if !captured[lits[i]][o] {
+					captured[lits[i]][o] = true
+					caps[lits[i]] = append(caps[lits[i]], o)
+				}
+			}
+		}
+		return true
+	})
+	return caps
+}
+
+// assigned finds the local variables within n that may change after
+// they are declared, because they are assigned to or have their
+// address taken.
+func assigned(n ast.Node, info *types.Info) map[*types.Object]bool {
+	out := addressed(n, info)
+	root := func(x ast.Expr) {
+		if o := rootVar(x, info); o != nil {
+			out[o] = true
+		}
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if n.Tok != token.DEFINE {
+				for _, x := range n.Lhs {
+					root(x)
+				}
+			}
+		case *ast.IncDecStmt:
+			root(n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				root(n.Key)
+				root(n.Value)
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// addressed finds the local variables within n whose address is
+// taken, so that they may outlive the function declaring them.
+func addressed(n ast.Node, info *types.Info) map[*types.Object]bool {
+	out := make(map[*types.Object]bool)
+	root := func(x ast.Expr) {
+		if o := rootVar(x, info); o != nil {
+			out[o] = true
+		}
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				root(n.X)
+			}
+		case *ast.SliceExpr:
+			if types.IsArray(info.TypeOf(n.X)) {
+				root(n.X)
+			}
+		case *ast.SelectorExpr:
+			// Calling a method with a pointer receiver takes the
+			// address of its receiver.
+			m := info.Objects[n.Sel]
+			if m != nil && m.Kind == types.Func && m.Recv != nil &&
+				types.IsPointer(m.Recv) && !types.IsPointer(info.TypeOf(n.X)) {
+				root(n.X)
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// rootVar is the local variable that holds the value x, if x is a
+// local variable or a part of one.
+func rootVar(x ast.Expr, info *types.Info) *types.Object {
+	switch x := types.StripParens(x).(type) {
+	case *ast.Ident:
+		if o := info.Objects[x]; o != nil && o.Kind == types.Var && !o.Global {
+			return o
+		}
+	case *ast.SelectorExpr:
+		if !types.IsPointer(info.TypeOf(x.X)) {
+			return rootVar(x.X, info)
+		}
+	case *ast.IndexExpr:
+		if types.IsArray(info.TypeOf(x.X)) {
+			return rootVar(x.X, info)
+		}
+	}
+	return nil
+}
+
+// BoxCaptured moves each variable that a function literal captures,
+// and that may change once it is captured, into a cell on the heap,
+// so that the literal and the function declaring the variable share
+// it.  So too does it move each variable whose address is taken, which
+// the pointer may outlive.  Thus
+//
+//	var n int = 0
+//	inc := func() { n++ }
+//
+// becomes
+//
+//	var n *int = new(int)
+//	*n = 0
+//	inc := func() { (*n)++ }
+//
+// A function parameter is copied into its cell when the function
+// starts, and a named result is copied out of it by each return, or if
+// the function defers calls, which may change it, by a deferred call
+// that runs after the others.  The
+// other variables that literals capture never change, so the literal
+// may simply copy them.
+func BoxCaptured(f *ast.File, info *types.Info) {
+	boxed := addressed(f, info)
+	changed := assigned(f, info)
+	for _, os := range captures(f, info) {
+		for _, o := range os {
+			if changed[o] {
+				boxed[o] = true
+			}
+		}
+	}
+	if len(boxed) == 0 {
+		return
+	}
+	isBoxed := func(id *ast.Ident) bool {
+		return boxed[info.Objects[id]]
+	}
+	deref := func(name string) ast.Expr {
+		return &ast.ParenExpr{X: &ast.StarExpr{X: ast.NewIdent(name)}}
+	}
+	// cell declares the cell holding a variable, which starts out
+	// holding value, if that isn't nil.
+	cell := func(name string, t types.Type, value ast.Expr) []ast.Stmt {
+		out := []ast.Stmt{varDecl(name, &types.Pointer{Elem: t},
+			&ast.CallExpr{Fun: ast.NewIdent("new"), Args: []ast.Expr{t.Expr()}})}
+		if value != nil {
+			out = append(out, &ast.AssignStmt{Lhs: []ast.Expr{deref(name)},
+				Tok: token.ASSIGN, Rhs: []ast.Expr{value}})
+		}
+		return out
+	}
+	// params replaces each boxed parameter in fields with a temporary,
+	// returning the statements that copy them into their cells.
+	params := func(fields *ast.FieldList) []ast.Stmt {
+		var out []ast.Stmt
+		if fields == nil {
+			return nil
+		}
+		for _, f := range fields.List {
+			for i, n := range f.Names {
+				if isBoxed(n) {
+					tmp := tempName()
+					f.Names[i] = ast.NewIdent(tmp)
+					out = append(out, cell(n.Name, info.TypeOf(n), ast.NewIdent(tmp))...)
+				}
+			}
+		}
+		return out
+	}
+	function := func(recv *ast.FieldList, ft *ast.FuncType, body *ast.BlockStmt) {
+		if body == nil {
+			return
+		}
+		var results []*ast.Ident
+		anyBoxed := false
+		if ft.Results != nil {
+			for _, f := range ft.Results.List {
+				for _, n := range f.Names {
+					results = append(results, n)
+					anyBoxed = anyBoxed || isBoxed(n)
+				}
+			}
+		}
+		defers := hasDefer(body)
+		prologue := params(recv)
+		prologue = append(prologue, params(ft.Params)...)
+		prologue = append(prologue, params(ft.Results)...)
+		if anyBoxed && defers {
+			// The deferred calls may change the results after a
+			// return, so the last of them copies the cells out.
+			i := 0
+			for _, f := range ft.Results.List {
+				for _, n := range f.Names {
+					if r := results[i]; isBoxed(r) {
+						p := &ast.StarExpr{X: info.TypeOf(r).Expr()}
+						prologue = append(prologue, &ast.DeferStmt{Call: &ast.CallExpr{
+							Fun: &ast.FuncLit{
+								Type: &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{{
+									Names: []*ast.Ident{ast.NewIdent("dst"), ast.NewIdent("src")},
+									Type:  p}}}},
+								Body: &ast.BlockStmt{List: []ast.Stmt{&ast.AssignStmt{
+									Lhs: []ast.Expr{&ast.StarExpr{X: ast.NewIdent("dst")}},
+									Tok: token.ASSIGN,
+									Rhs: []ast.Expr{&ast.StarExpr{X: ast.NewIdent("src")}}}}}},
+							Args: []ast.Expr{&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(n.Name)},
+								ast.NewIdent(r.Name)}}})
+					}
+					i++
+				}
+			}
+		}
+		body.List = append(prologue, body.List...)
+		if !anyBoxed {
+			return
+		}
+		// A return must now return the contents of the cells, or with
+		// deferred calls, leave the results in them.
+		setResults := func(list []ast.Stmt) {
+			for i, s := range list {
+				if r, ok := s.(*ast.ReturnStmt); ok && defers && len(r.Results) > 0 {
+					set := &ast.AssignStmt{Tok: token.ASSIGN, Rhs: r.Results}
+					for _, r := range results {
+						if isBoxed(r) {
+							set.Lhs = append(set.Lhs, deref(r.Name))
+						} else {
+							set.Lhs = append(set.Lhs, ast.NewIdent(r.Name))
+						}
+					}
+					list[i] = &ast.BlockStmt{List: []ast.Stmt{set, &ast.ReturnStmt{}}}
+				}
+			}
+		}
+		ast.Inspect(body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.BlockStmt:
+				setResults(n.List)
+			case *ast.CaseClause:
+				setResults(n.Body)
+			case *ast.CommClause:
+				setResults(n.Body)
+			case *ast.ReturnStmt:
+				if len(n.Results) == 0 && !defers {
+					for _, r := range results {
+						if isBoxed(r) {
+							n.Results = append(n.Results, deref(r.Name))
+						} else {
+							n.Results = append(n.Results, ast.NewIdent(r.Name))
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+	// declare replaces each declaration of a boxed variable in a
+	// statement list with the declaration of its cell.
+	declare := func(list []ast.Stmt) []ast.Stmt {
+		out := make([]ast.Stmt, 0, len(list))
+		for _, s := range list {
+			d, ok := s.(*ast.DeclStmt)
+			if !ok || d.Decl.(*ast.GenDecl).Tok != token.VAR {
+				out = append(out, s)
+				continue
+			}
+			for _, spec := range d.Decl.(*ast.GenDecl).Specs {
+				out = append(out, declareCells(spec.(*ast.ValueSpec), info, isBoxed, cell)...)
+			}
+		}
+		return out
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			function(n.Recv, n.Type, n.Body)
+		case *ast.FuncLit:
+			function(nil, n.Type, n.Body)
+		case *ast.BlockStmt:
+			n.List = declare(n.List)
+		case *ast.CaseClause:
+			if o := info.Implicits[n]; o != nil && boxed[o] {
+				panic("I can't yet box a type switch variable")
+			}
+			n.Body = declare(n.Body)
+		case *ast.CommClause:
+			n.Body = declare(n.Body)
+		case *ast.RangeStmt:
+			if n.Tok != token.DEFINE {
+				break
+			}
+			var prologue []ast.Stmt
+			for _, x := range []*ast.Expr{&n.Key, &n.Value} {
+				if id, ok := (*x).(*ast.Ident); ok && isBoxed(id) {
+					tmp := tempName()
+					*x = ast.NewIdent(tmp)
+					prologue = append(prologue, cell(id.Name, info.TypeOf(id), ast.NewIdent(tmp))...)
+				}
+			}
+			n.Body.List = append(prologue, n.Body.List...)
+		}
+		return true
+	})
+	// Finally, every use of a boxed variable uses its cell.
+	RewriteExprs(f, func(e ast.Expr) ast.Expr {
+		if id, ok := e.(*ast.Ident); ok && isBoxed(id) {
+			return deref(id.Name)
+		}
+		return e
+	})
+}
+
+// declareCells declares the variables of spec, giving those that are
+// boxed a cell, which the cell function declares.
+func declareCells(spec *ast.ValueSpec, info *types.Info, isBoxed func(*ast.Ident) bool,
+	cell func(string, types.Type, ast.Expr) []ast.Stmt) []ast.Stmt {
+	anyBoxed := false
+	for _, n := range spec.Names {
+		anyBoxed = anyBoxed || isBoxed(n)
+	}
+	if !anyBoxed {
+		return []ast.Stmt{&ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR,
+			Specs: []ast.Spec{spec}}}}
+	}
+	var out []ast.Stmt
+	values := make([]ast.Expr, len(spec.Names))
+	if len(spec.Values) > 0 {
+		// The values are computed first, since they may refer to
+		// variables that the new ones shadow.
+		temps := &ast.ValueSpec{Type: spec.Type, Values: spec.Values}
+		for i, n := range spec.Names {
+			tmp := tempName()
+			temps.Names = append(temps.Names, ast.NewIdent(tmp))
+			values[i] = ast.NewIdent(tmp)
+			if n.Name == "_" {
+				temps.Names[i].Name, values[i] = "_", nil
+			}
+		}
+		out = append(out, &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR,
+			Specs: []ast.Spec{temps}}})
+	}
+	for i, n := range spec.Names {
+		t := info.TypeOf(n)
+		switch {
+		case n.Name == "_":
+		case isBoxed(n):
+			out = append(out, cell(n.Name, t, values[i])...)
+		default:
+			out = append(out, varDecl(n.Name, t, values[i]))
+		}
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+)
+
+// ExplicitConversions makes every implicit conversion in the program
+// explicit, so the backend never needs to figure out what type an
+// untyped constant ends up with, or when a value needs converting to
+// the type of the variable it is stored in.  Thus
+//
+//	var x float64 = 1
+//	var e interface{} = x
+//
+// becomes
+//
+//	var x float64 = float64(1)
+//	var e interface{} = interface{}(x)
+func ExplicitConversions(f *ast.File, info *types.Info) {
+	RewriteExprs(f, func(e ast.Expr) ast.Expr {
+		if info.IsType(e) {
+			if t, ok := info.Implicit[e]; ok {
+				// A type used as a value
+				return Convert(e, t)
+			}
+			return e
+		}
+		out := e
+		if orig, ok := info.Untyped[e]; ok {
+			t := info.Types[e]
+			_, isconst := info.Values[e]
+			// A comparison gives an untyped bool, which we needn't
+			// bother converting to plain old bool.  And a slice or
+			// func may only be compared with an unconverted nil.
+			if (isconst || !types.Identical(t, types.Default(orig))) &&
+				!(orig == types.Typ[types.UntypedNil] && !types.Comparable(t)) {
+				out = Convert(out, t)
+			}
+		}
+		if t, ok := info.Implicit[e]; ok {
+			out = Convert(out, t)
+		}
+		return out
+	})
+}
+
+// Convert creates a conversion of e to type t.
+func Convert(e ast.Expr, t types.Type) *ast.CallExpr {
+	texpr := t.Expr()
+	switch texpr.(type) {
+	case *ast.Ident, *ast.SelectorExpr, *ast.ArrayType, *ast.MapType,
+		*ast.StructType, *ast.InterfaceType:
+		// These types can be used directly in a conversion.
+	default:
+		texpr = &ast.ParenExpr{X: texpr}
+	}
+	return &ast.CallExpr{Fun: texpr, Args: []ast.Expr{e}}
+}
@@ -0,0 +1,284 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// Unreachable finds the functions, methods, types and package-level
+// variables of f that the program can never use, in the order they
+// are declared.  Whatever main, the init functions, the functions in
+// keep and the constants refer to is reachable, as is whatever the
+// initializer of a variable refers to, if it calls anything, since
+// it must still run.  A method is reachable if it is called, or if a
+// value of its type is converted to an interface and some interface
+// of the program has a method of the same name.  Error and String
+// are always wanted, since a panic prints its value with them, and if
+// the program asks a Type about its methods, every method of a
+// reachable type is reachable.
+func Unreachable(f *ast.File, info *types.Info, keep ...string) []*types.Object {
+	r := reachable(f, info, keep)
+	var dead []*types.Object
+	for _, o := range r.all {
+		if !r.live[o] && o.Name != "_" {
+			dead = append(dead, o)
+		}
+	}
+	return dead
+}
+
+// EliminateDeadCode drops the declarations that Unreachable finds,
+// and those of blank variables whose initializers do nothing.
+func EliminateDeadCode(f *ast.File, info *types.Info, keep ...string) {
+	r := reachable(f, info, keep)
+	var decls []ast.Decl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if !r.reached[d] {
+				logf(d.Pos(), "dropping %s, which is unreachable", funcName(d))
+				continue
+			}
+		case *ast.GenDecl:
+			var specs []ast.Spec
+			for _, s := range d.Specs {
+				if _, ok := s.(*ast.ImportSpec); ok || r.reached[s] {
+					specs = append(specs, s)
+				}
+			}
+			if len(specs) == 0 && len(d.Specs) > 0 {
+				continue
+			}
+			d.Specs = specs
+		}
+		decls = append(decls, d)
+	}
+	f.Decls = decls
+}
+
+func reachable(f *ast.File, info *types.Info, keep []string) *reach {
+	r := &reach{info: info, decls: make(map[*types.Object]ast.Node),
+		methods: make(map[ast.Node]*types.Object),
+		live:    make(map[*types.Object]bool), reached: make(map[ast.Node]bool),
+		converted: make(map[*types.Named]bool),
+		names:     map[string]bool{"Error": true, "String": true}}
+	declare := func(o *types.Object, d ast.Node) {
+		if o != nil {
+			r.decls[o] = d
+			r.all = append(r.all, o)
+		}
+	}
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			declare(info.Objects[d.Name], d)
+			if d.Recv != nil {
+				r.methods[d] = info.Objects[d.Name]
+			}
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					declare(info.Objects[s.Name], s)
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						declare(info.Objects[name], s)
+					}
+					if d.Tok == token.CONST || r.effects(s) {
+						r.reach(s)
+					}
+				}
+			}
+		}
+	}
+	roots := map[string]bool{"main": true, "init": true}
+	for _, k := range keep {
+		roots[k] = true
+	}
+	for o, d := range r.decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Recv == nil && roots[o.Name] {
+			r.use(o)
+		}
+	}
+	r.run()
+	return r
+}
+
+// reach works out what a program can reach from its roots.
+type reach struct {
+	info *types.Info
+	// decls holds the declaration of each function, method, type
+	// and variable, all of them in order, and live those that are
+	// reachable.  reached holds the reachable declarations, and todo
+	// those that we have yet to look within.
+	decls map[*types.Object]ast.Node
+	all   []*types.Object
+	// methods holds the method that each method declaration
+	// declares, which a method of an instance of a generic type
+	// stands for.
+	methods map[ast.Node]*types.Object
+	live    map[*types.Object]bool
+	reached map[ast.Node]bool
+	todo    []ast.Node
+	// converted holds the types whose values are converted to
+	// interfaces, and names the names of the methods of interfaces.
+	// reflective is true if the program asks a Type for its methods.
+	converted  map[*types.Named]bool
+	names      map[string]bool
+	reflective bool
+}
+
+func (r *reach) use(o *types.Object) {
+	if o == nil || r.live[o] {
+		return
+	}
+	if d, ok := r.decls[o]; ok {
+		r.live[o] = true
+		r.reach(d)
+	} else if m, ok := r.methods[o.Decl]; ok {
+		r.use(m)
+	}
+}
+
+// reach notes that the declaration d is reachable, along with all
+// that it declares.
+func (r *reach) reach(d ast.Node) {
+	if r.reached[d] {
+		return
+	}
+	r.reached[d] = true
+	r.todo = append(r.todo, d)
+	if s, ok := d.(*ast.ValueSpec); ok {
+		for _, name := range s.Names {
+			if o := r.info.Objects[name]; o != nil {
+				r.live[o] = true
+			}
+		}
+	}
+}
+
+// run looks within the reachable declarations until there are no
+// more to find, including the methods that interfaces may call.
+func (r *reach) run() {
+	for len(r.todo) > 0 {
+		for len(r.todo) > 0 {
+			d := r.todo[len(r.todo)-1]
+			r.todo = r.todo[:len(r.todo)-1]
+			r.visit(d)
+		}
+		for o := range r.decls {
+			if o.Kind != types.Func || o.Recv == nil || r.live[o] {
+				continue
+			}
+			n := named(o.Recv)
+			if n != nil && (r.converted[n] && r.names[o.Name] ||
+				r.reflective && r.live[r.info.Globals[n.Name]]) {
+				r.use(o)
+			}
+		}
+	}
+}
+
+// visit finds what the declaration d refers to.
+func (r *reach) visit(d ast.Node) {
+	ast.Inspect(d, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			o := r.info.Objects[n]
+			if o != nil && (o.Global || o.Kind == types.Func && o.Recv != nil) {
+				r.use(o)
+			}
+		case *ast.SelectorExpr:
+			if _, ok := r.info.TypeOf(n.X).(types.TypeType); ok {
+				switch n.Sel.Name {
+				case "Method", "NumMethod", "Implements":
+					r.reflective = true
+				}
+			}
+		case *ast.CallExpr:
+			if r.info.IsType(n.Fun) && len(n.Args) == 1 && types.IsInterface(r.info.Types[n.Fun]) {
+				r.convert(r.info.TypeOf(n.Args[0]))
+			}
+		}
+		if e, ok := n.(ast.Expr); ok {
+			r.methodsOf(r.info.Types[e])
+			if t, ok := r.info.Implicit[e]; ok && types.IsInterface(t) {
+				r.methodsOf(t)
+				r.convert(r.info.TypeOf(e))
+			}
+			if inst, ok := r.info.Instances[e]; ok {
+				// A type argument may have to satisfy an
+				// interface.
+				for _, t := range inst.TypeArgs {
+					r.convert(t)
+				}
+			}
+		}
+		return true
+	})
+}
+
+// methodsOf notes the names of the methods of t, if it is an
+// interface.
+func (r *reach) methodsOf(t types.Type) {
+	if t == nil || !types.IsInterface(t) {
+		return
+	}
+	for _, m := range types.MethodSet(t) {
+		r.names[m.Name] = true
+	}
+}
+
+// convert notes that a value of type t is converted to an interface,
+// along with those of the fields it embeds, whose methods it may
+// promote.
+func (r *reach) convert(t types.Type) {
+	n := named(t)
+	if n != nil && n.Orig != nil {
+		// Its methods are those of the generic type.
+		n = n.Orig
+	}
+	if n == nil || r.converted[n] {
+		return
+	}
+	r.converted[n] = true
+	r.use(r.info.Globals[n.Name])
+	if s, ok := types.Underlying(n).(*types.Struct); ok {
+		for _, f := range s.Fields {
+			if f.Embedded {
+				r.convert(f.Type)
+			}
+		}
+	}
+}
+
+// effects is true if the initializer of s calls anything, or receives.
+func (r *reach) effects(s *ast.ValueSpec) bool {
+	found := false
+	for _, v := range s.Values {
+		ast.Inspect(v, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.CallExpr:
+				if !r.info.IsType(n.Fun) && !isBuiltinCall(n, r.info, "len", "cap", "make", "new", "append") {
+					found = true
+				}
+			case *ast.UnaryExpr:
+				found = found || n.Op == token.ARROW
+			}
+			return !found
+		})
+	}
+	return found
+}
+
+// named is the named type that t is, or points to.
+func named(t types.Type) *types.Named {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem
+	}
+	n, _ := t.(*types.Named)
+	return n
+}
@@ -0,0 +1,187 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// EliminateDefine replaces every short variable declaration with var
+// statements that give the type of each variable, followed by an
+// assignment if need be.  Thus
+//
+//	x, err := f()
+//
+// (where err has already been declared) becomes
+//
+//	var x int
+//	x, err = f()
+//
+// Short declarations in the init statements of if, for and switch
+// statements must already have been eliminated.
+func EliminateDefine(f *ast.File, info *types.Info) {
+	targets := gotoTargets(f)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = eliminateDefine(n.List, info, targets)
+		case *ast.CaseClause:
+			n.Body = eliminateDefine(n.Body, info, targets)
+		case *ast.CommClause:
+			n.Body = eliminateDefine(n.Body, info, targets)
+		}
+		return true
+	})
+}
+
+func eliminateDefine(list []ast.Stmt, info *types.Info, targets map[*ast.LabeledStmt]bool) []ast.Stmt {
+	out := make([]ast.Stmt, 0, len(list))
+	for _, s := range list {
+		inner := s
+		l, labeled := s.(*ast.LabeledStmt)
+		if labeled {
+			inner = l.Stmt
+		}
+		if a, ok := inner.(*ast.AssignStmt); ok && labeled && a.Tok == token.DEFINE {
+			// The label goes on an empty statement of its own.
+			out = append(out, &ast.LabeledStmt{Label: l.Label, Stmt: &ast.EmptyStmt{}})
+			out = append(out, define(a, info)...)
+			continue
+		}
+		if sel, ok := inner.(*ast.SelectStmt); ok {
+			if decls := selectDefines(sel, info); len(decls) > 0 {
+				b := &ast.BlockStmt{List: append(decls, inner)}
+				if labeled {
+					l.Stmt = b
+					out = append(out, relabel(l, map[ast.Stmt]bool{b: true}, targets))
+				} else {
+					out = append(out, b)
+				}
+				continue
+			}
+		}
+		a, ok := s.(*ast.AssignStmt)
+		if !ok || a.Tok != token.DEFINE {
+			out = append(out, s)
+			continue
+		}
+		out = append(out, define(a, info)...)
+	}
+	return out
+}
+
+// selectDefines eliminates the short variable declarations of the
+// cases of a select statement, so that
+//
+//	select {
+//	case v := <-ch:
+//		...
+//	}
+//
+// becomes
+//
+//	var tmp int
+//	select {
+//	case tmp = <-ch:
+//		var v int = tmp
+//		...
+//	}
+//
+// returning the declarations of the temporaries.
+func selectDefines(s *ast.SelectStmt, info *types.Info) []ast.Stmt {
+	var decls []ast.Stmt
+	for _, cc := range s.Body.List {
+		cc := cc.(*ast.CommClause)
+		a, ok := cc.Comm.(*ast.AssignStmt)
+		if !ok || a.Tok != token.DEFINE {
+			continue
+		}
+		var vars []ast.Stmt
+		for i, l := range a.Lhs {
+			if isBlank(l) {
+				continue
+			}
+			t := info.TypeOf(l)
+			tmp := tempName()
+			decls = append(decls, varDecl(tmp, t, nil))
+			vars = append(vars, varDecl(l.(*ast.Ident).Name, t, ast.NewIdent(tmp)))
+			a.Lhs[i] = ast.NewIdent(tmp)
+		}
+		a.Tok = token.ASSIGN
+		cc.Body = append(vars, cc.Body...)
+	}
+	return decls
+}
+
+// varDecl creates the statement var name t = value, where value may be
+// nil.
+func varDecl(name string, t types.Type, value ast.Expr) ast.Stmt {
+	spec := &ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: t.Expr()}
+	if value != nil {
+		spec.Values = []ast.Expr{value}
+	}
+	return &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{spec}}}
+}
+
+func define(a *ast.AssignStmt, info *types.Info) []ast.Stmt {
+	isnew := make([]bool, len(a.Lhs))
+	names := make(map[string]bool)
+	for i, l := range a.Lhs {
+		id := l.(*ast.Ident)
+		if o := info.Objects[id]; o != nil && o.Decl == a {
+			isnew[i] = true
+			names[id.Name] = true
+		}
+	}
+	// If the values refer to a variable that is shadowed by the new
+	// ones, they must be evaluated before we declare anything.
+	shadows := false
+	for _, r := range a.Rhs {
+		ast.Inspect(r, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && names[id.Name] {
+				shadows = true
+			}
+			return !shadows
+		})
+	}
+	var out []ast.Stmt
+	if shadows {
+		temps := make([]ast.Expr, len(a.Lhs))
+		for i, l := range a.Lhs {
+			temps[i] = ast.NewIdent("_")
+			if !isBlank(l) {
+				tmp := tempName()
+				out = append(out, varDecl(tmp, info.TypeOf(l), nil))
+				temps[i] = ast.NewIdent(tmp)
+			}
+		}
+		out = append(out, &ast.AssignStmt{Lhs: temps, Tok: token.ASSIGN, Rhs: a.Rhs})
+		for i, l := range a.Lhs {
+			switch {
+			case isnew[i]:
+				out = append(out, varDecl(l.(*ast.Ident).Name, info.TypeOf(l), temps[i]))
+			case !isBlank(l):
+				out = append(out, &ast.AssignStmt{Lhs: []ast.Expr{l}, Tok: token.ASSIGN,
+					Rhs: []ast.Expr{temps[i]}})
+			}
+		}
+		return out
+	}
+	allnew := len(a.Lhs) == len(a.Rhs)
+	for i := range a.Lhs {
+		allnew = allnew && isnew[i]
+	}
+	if allnew {
+		for i, l := range a.Lhs {
+			out = append(out, varDecl(l.(*ast.Ident).Name, info.TypeOf(l), a.Rhs[i]))
+		}
+		return out
+	}
+	for i, l := range a.Lhs {
+		if isnew[i] {
+			out = append(out, varDecl(l.(*ast.Ident).Name, info.TypeOf(l), nil))
+		}
+	}
+	a.Tok = token.ASSIGN
+	return append(out, a)
+}
@@ -0,0 +1,171 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+// An Extension is an experimental change to the language, which a
+// build asks for by name with ogo -x=name.  An extension that lives
+// outside of ogo registers itself from an init function, and needs
+// only a blank import in cmd/ogo.
+type Extension struct {
+	Name string
+	// Preprocess, if it isn't nil, rewrites the source of each file
+	// before it is parsed, so an extension may add syntax that the
+	// go parser doesn't know.
+	Preprocess func(filename string, src []byte) []byte
+	// Passes are the g2g passes of the extension, which run among the
+	// built-in passes in the order their After and Before ask for.
+	Passes []*Pass
+	// Runtime holds the C headers that the C program includes for
+	// the extension.  Each should include ogo.h itself.
+	Runtime []string
+	// Keep holds the functions, written as "path.Name", that the
+	// passes may add calls to, so TrackImports must keep them even if
+	// the program doesn't call them.
+	Keep []string
+}
+
+// A Pass is a g2g transformation, given the file with its types as
+// they are just before the pass.
+type Pass struct {
+	Name string
+	Run  func(f *ast.File, info *types.Info)
+	// After and Before name the passes that this one must follow or
+	// precede.  With neither, a pass of an extension runs after all
+	// the built-in passes.
+	After, Before []string
+}
+
+// BuiltinPasses are the g2g passes that every build runs, in the order
+// that they run in.
+var BuiltinPasses = []*Pass{
+	{Name: "Monomorphize", Run: Monomorphize},
+	{Name: "ExplicitConversions", Run: ExplicitConversions},
+	{Name: "FoldConstants", Run: FoldConstants},
+	{Name: "EliminateDeadCode", Run: func(f *ast.File, info *types.Info) { EliminateDeadCode(f, info) }},
+	{Name: "HoistLocalTypes", Run: HoistLocalTypes},
+	{Name: "ExplicitPromotion", Run: ExplicitPromotion},
+	{Name: "EliminateMethodValues", Run: EliminateMethodValues},
+	{Name: "EliminateRange", Run: EliminateRange},
+	{Name: "EliminateInits", Run: func(f *ast.File, _ *types.Info) { EliminateInits(f) }},
+	{Name: "EliminateDefine", Run: EliminateDefine},
+	{Name: "Inline", Run: Inline},
+	{Name: "BoxCaptured", Run: BoxCaptured},
+}
+
+var extensions = make(map[string]*Extension)
+
+// Register makes the extension x available to builds.
+func Register(x *Extension) {
+	if _, ok := extensions[x.Name]; ok {
+		panic("There is already an extension called " + x.Name)
+	}
+	extensions[x.Name] = x
+}
+
+// LookupExtension gives the extension registered with the given name,
+// or nil if there is none.
+func LookupExtension(name string) *Extension {
+	return extensions[name]
+}
+
+// Extensions gives the names of the registered extensions.
+func Extensions() []string {
+	var names []string
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Passes gives the built-in passes along with those of the extensions
+// xs, in an order that keeps to the After and Before of each.  Where
+// they leave the order open, the built-in passes keep theirs, and
+// those of the extensions follow in the order they are given.
+func Passes(xs []*Extension) []*Pass {
+	all := append([]*Pass{}, BuiltinPasses...)
+	var keep []string
+	for _, x := range xs {
+		all = append(all, x.Passes...)
+		for _, k := range x.Keep {
+			keep = append(keep, ManglePackageAndName(splitLast(k, ".")[0], splitLast(k, ".")[1]))
+		}
+	}
+	for i, p := range all {
+		if p.Name == "EliminateDeadCode" && keep != nil {
+			// The later passes may call the functions that the
+			// extensions keep.
+			all[i] = &Pass{Name: p.Name, Run: func(f *ast.File, info *types.Info) {
+				EliminateDeadCode(f, info, keep...)
+			}}
+		}
+	}
+	index := make(map[string]int)
+	for i, p := range all {
+		if _, ok := index[p.Name]; ok {
+			panic("There are two passes called " + p.Name)
+		}
+		index[p.Name] = i
+	}
+	lookup := func(p *Pass, name string) int {
+		i, ok := index[name]
+		if !ok {
+			panic(fmt.Sprintf("Pass %s is ordered against %s, which there is no pass called", p.Name, name))
+		}
+		return i
+	}
+	// follows[i] holds the passes that must run after pass i.
+	follows := make([][]int, len(all))
+	preceding := make([]int, len(all))
+	edge := func(from, to int) {
+		follows[from] = append(follows[from], to)
+		preceding[to]++
+	}
+	for i := 1; i < len(BuiltinPasses); i++ {
+		edge(i-1, i)
+	}
+	for i, p := range all[len(BuiltinPasses):] {
+		i += len(BuiltinPasses)
+		if p.After == nil && p.Before == nil {
+			edge(len(BuiltinPasses)-1, i)
+		}
+		for _, name := range p.After {
+			edge(lookup(p, name), i)
+		}
+		for _, name := range p.Before {
+			edge(i, lookup(p, name))
+		}
+	}
+	var out []*Pass
+	done := make([]bool, len(all))
+	for len(out) < len(all) {
+		next := -1
+		for i := range all {
+			if !done[i] && preceding[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var stuck []string
+			for i, p := range all {
+				if !done[i] {
+					stuck = append(stuck, p.Name)
+				}
+			}
+			panic("I can't order the passes " + strings.Join(stuck, ", "))
+		}
+		done[next] = true
+		for _, i := range follows[next] {
+			preceding[i]--
+		}
+		out = append(out, all[next])
+	}
+	return out
+}
@@ -0,0 +1,725 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"strconv"
+)
+
+// FoldConstants replaces each constant expression with its value, and
+// removes the code that can never run: the branch of an if statement
+// that its constant condition rules out, a for loop whose condition
+// is false, and the statements that follow a return, goto, break,
+// continue or panic.  A package-level variable of a basic type, that
+// is initialized with a constant and never changed, counts as that
+// constant.  Thus
+//
+//	var debug = false
+//	const n = 2
+//
+//	if debug {
+//		println("x is", x)
+//	}
+//	return n * n
+//
+// becomes
+//
+//	var debug = false
+//	const n = 2
+//
+//	return int(4)
+//
+// where x, if it is a local variable that isn't used anywhere else,
+// gets a _ = x after its declaration, so that it is still used.
+func FoldConstants(f *ast.File, info *types.Info) {
+	fo := &folder{info: info,
+		vals:    make(map[ast.Expr]constant.Value),
+		frozen:  make(map[*types.Object]constant.Value),
+		locals:  make(map[*types.Object]bool),
+		unused:  make(map[*ast.Ident]bool),
+		uses:    make(map[*types.Object]int),
+		dropped: make(map[*types.Object]bool),
+		made:    make(map[*ast.BlockStmt]bool),
+	}
+	fo.findVariables(f)
+	fo.count(f, 1)
+
+	// The values of constant declarations are left alone, since a
+	// later spec may repeat them with another iota, as are type
+	// expressions.
+	keep := make(map[ast.Expr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			if n.Tok == token.CONST {
+				markAll(n, keep)
+				return false
+			}
+		case ast.Expr:
+			if info.IsType(n) {
+				markAll(n, keep)
+				return false
+			}
+		}
+		return true
+	})
+	// Each value is found before any is replaced, since an
+	// expression's value depends on the expressions within it.  A
+	// value that comes from a frozen variable mustn't be written as a
+	// constant where it is the operand of an expression that we can't
+	// fold, or go might then work out that expression itself, and
+	// fail where the program would overflow.
+	var stack []ast.Node
+	operand := make(map[ast.Expr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		if e, ok := n.(ast.Expr); ok && !keep[e] && fo.value(e) != nil {
+			if p, ok := stack[len(stack)-1].(ast.Expr); ok && fo.value(p) == nil {
+				switch p := p.(type) {
+				case *ast.BinaryExpr, *ast.UnaryExpr, *ast.ParenExpr:
+					operand[e] = true
+				case *ast.CallExpr:
+					operand[e] = info.IsType(p.Fun)
+				}
+			}
+		}
+		stack = append(stack, n)
+		return true
+	})
+	RewriteExprs(f, func(e ast.Expr) ast.Expr {
+		v := fo.value(e)
+		_, isconst := info.Values[e]
+		if v == nil || keep[e] || fo.isLiteral(e) || operand[e] && !isconst {
+			return e
+		}
+		lit := fo.literal(e, v)
+		if lit != nil {
+			fo.count(e, -1)
+			fo.vals[lit] = v
+			return lit
+		}
+		return e
+	})
+
+	RewriteStmts(f, fo.prune)
+	// Removing a label can let us join or remove more statements.
+	fo.lists(f)
+	dropUnusedLabels(f)
+	fo.lists(f)
+	fo.keepUsed(f)
+}
+
+type folder struct {
+	info *types.Info
+	// vals holds the values of the expressions that are constant
+	// only because they use frozen variables, or nil for those
+	// that aren't.
+	vals map[ast.Expr]constant.Value
+	// frozen holds the value of each package-level variable that
+	// is never changed.
+	frozen map[*types.Object]constant.Value
+	// locals holds the local variables, and uses the number of
+	// times each is used, where an identifier in unused isn't a use
+	// but the declaration of, or an assignment to, its variable.
+	locals map[*types.Object]bool
+	unused map[*ast.Ident]bool
+	uses   map[*types.Object]int
+	// dropped holds the local variables whose uses we have removed.
+	dropped map[*types.Object]bool
+	// made holds the blocks that stand for the statements of a
+	// pruned statement, which can join the list that holds them.
+	made map[*ast.BlockStmt]bool
+}
+
+func markAll(n ast.Node, marks map[ast.Expr]bool) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		if e, ok := n.(ast.Expr); ok {
+			marks[e] = true
+		}
+		return true
+	})
+}
+
+// root gives the variable that an assignment to e changes, if any.
+func root(e ast.Expr) *ast.Ident {
+	for {
+		switch x := e.(type) {
+		case *ast.Ident:
+			return x
+		case *ast.ParenExpr:
+			e = x.X
+		case *ast.SelectorExpr:
+			e = x.X
+		case *ast.IndexExpr:
+			e = x.X
+		default:
+			return nil
+		}
+	}
+}
+
+// findVariables finds the local variables, the identifiers that
+// aren't uses of them, and the frozen package-level variables.
+func (fo *folder) findVariables(f *ast.File) {
+	changed := make(map[*types.Object]bool)
+	assigned := func(es ...ast.Expr) {
+		for _, e := range es {
+			if id := root(e); id != nil {
+				fo.unused[id] = true
+				changed[fo.info.Objects[id]] = true
+			}
+		}
+	}
+	declared := func(ids ...*ast.Ident) {
+		for _, id := range ids {
+			if o := fo.info.Objects[id]; o != nil && o.Kind == types.Var && !o.Global {
+				fo.locals[o] = true
+			}
+			fo.unused[id] = true
+		}
+	}
+	var globals []*ast.ValueSpec
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.VAR {
+			for _, s := range d.Specs {
+				globals = append(globals, s.(*ast.ValueSpec))
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			// The parameters of a function needn't be used.
+			if n.Body != nil {
+				ast.Inspect(n.Body, func(n ast.Node) bool {
+					if s, ok := n.(*ast.ValueSpec); ok {
+						declared(s.Names...)
+					}
+					return true
+				})
+			}
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, e := range n.Lhs {
+					declared(e.(*ast.Ident))
+				}
+			} else {
+				assigned(n.Lhs...)
+			}
+		case *ast.IncDecStmt:
+			assigned(n.X)
+		case *ast.RangeStmt:
+			for _, e := range []ast.Expr{n.Key, n.Value} {
+				if e == nil {
+				} else if n.Tok == token.DEFINE {
+					declared(e.(*ast.Ident))
+				} else {
+					assigned(e)
+				}
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				if id := root(n.X); id != nil {
+					changed[fo.info.Objects[id]] = true
+				}
+			}
+		}
+		return true
+	})
+	for _, o := range fo.info.Implicits {
+		fo.locals[o] = true
+	}
+	for _, s := range globals {
+		if len(s.Values) != len(s.Names) {
+			continue
+		}
+		for i, id := range s.Names {
+			o := fo.info.Objects[id]
+			v := fo.info.Values[s.Values[i]]
+			if o == nil || v == nil || changed[o] {
+				continue
+			}
+			if b, ok := o.Type.(*types.Basic); ok && !types.IsUntyped(b) &&
+				(types.IsInteger(b) || types.IsBoolean(b) || types.IsString(b)) {
+				fo.frozen[o] = v
+			}
+		}
+	}
+}
+
+// count adds n to the number of uses of each local variable that n
+// uses, noting those whose uses are dropped.
+func (fo *folder) count(node ast.Node, n int) {
+	ast.Inspect(node, func(x ast.Node) bool {
+		if id, ok := x.(*ast.Ident); ok && !fo.unused[id] {
+			if o := fo.info.Objects[id]; fo.locals[o] {
+				fo.uses[o] += n
+				if n < 0 {
+					fo.dropped[o] = true
+				}
+			}
+		}
+		return true
+	})
+}
+
+// value gives the value of e, if it is constant, or nil.
+func (fo *folder) value(e ast.Expr) constant.Value {
+	if v, ok := fo.info.Values[e]; ok {
+		return v
+	}
+	if v, ok := fo.vals[e]; ok {
+		return v
+	}
+	v := fo.eval(e)
+	fo.vals[e] = v
+	return v
+}
+
+// eval works out the value of an expression that uses frozen
+// variables, if it is an integer, boolean or string, just as the
+// program would.  Where the program would overflow, divide by zero or
+// shift by a negative count, we leave it to the program.
+func (fo *folder) eval(e ast.Expr) constant.Value {
+	simple := func(vs ...constant.Value) bool {
+		for _, v := range vs {
+			if v == nil {
+				return false
+			}
+			switch v.Kind() {
+			case constant.Int, constant.Bool, constant.String:
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	t := fo.info.Types[e]
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return fo.value(e.X)
+	case *ast.Ident:
+		return fo.frozen[fo.info.Objects[e]]
+	case *ast.UnaryExpr:
+		x := fo.value(e.X)
+		switch {
+		case !simple(x):
+		case e.Op == token.NOT:
+			return constant.UnaryOp(e.Op, x, 0)
+		case e.Op == token.ADD || e.Op == token.SUB:
+			return fits(constant.UnaryOp(e.Op, x, 0), t)
+		}
+	case *ast.BinaryExpr:
+		x, y := fo.value(e.X), fo.value(e.Y)
+		if !simple(x, y) {
+			return nil
+		}
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return constant.MakeBool(constant.Compare(x, e.Op, y))
+		case token.SHL, token.SHR:
+			s, ok := constant.Uint64Val(y)
+			if !ok || y.Kind() != constant.Int || s > 64 {
+				return nil
+			}
+			return fits(constant.Shift(x, e.Op, uint(s)), t)
+		case token.QUO, token.REM:
+			if y.Kind() != constant.Int || constant.Sign(y) == 0 {
+				return nil
+			}
+			if e.Op == token.QUO {
+				// Integer division, which truncates
+				return fits(constant.BinaryOp(x, token.QUO_ASSIGN, y), t)
+			}
+		case token.AND, token.OR, token.XOR, token.AND_NOT:
+			if types.IsUnsigned(t) != (constant.Sign(x) >= 0 && constant.Sign(y) >= 0) {
+				// Bitwise operations on negative numbers depend on
+				// the size of the type.
+				return nil
+			}
+		}
+		return fits(constant.BinaryOp(x, e.Op, y), t)
+	case *ast.CallExpr:
+		if len(e.Args) != 1 {
+			return nil
+		}
+		x := fo.value(e.Args[0])
+		if !simple(x) {
+			return nil
+		}
+		if fo.info.IsType(e.Fun) {
+			xt := fo.info.Types[e.Args[0]]
+			if types.IsInteger(t) && types.IsInteger(xt) ||
+				types.IsBoolean(t) && types.IsBoolean(xt) ||
+				types.IsString(t) && types.IsString(xt) {
+				return fits(x, t)
+			}
+		} else if id, ok := e.Fun.(*ast.Ident); ok && x.Kind() == constant.String {
+			if o := fo.info.Objects[id]; o != nil && o.Kind == types.Builtin && o.Name == "len" {
+				return constant.MakeInt64(int64(len(constant.StringVal(x))))
+			}
+		}
+	}
+	return nil
+}
+
+// fits gives v, if it is representable in type t, or nil.
+func fits(v constant.Value, t types.Type) constant.Value {
+	if v.Kind() != constant.Int || !types.IsInteger(t) || types.IsUntyped(t) {
+		return v
+	}
+	bits := width(t)
+	min, max := constant.MakeInt64(0), constant.Shift(constant.MakeInt64(1), token.SHL, bits)
+	if !types.IsUnsigned(t) {
+		max = constant.Shift(constant.MakeInt64(1), token.SHL, bits-1)
+		min = constant.UnaryOp(token.SUB, max, 0)
+	}
+	if constant.Compare(v, token.LSS, min) || constant.Compare(v, token.GEQ, max) {
+		return nil
+	}
+	return v
+}
+
+// width is the number of bits of the integer type t in C.
+func width(t types.Type) uint {
+	switch types.Underlying(t).(*types.Basic).Kind {
+	case types.Int, types.Uint, types.Uintptr:
+		// These are 64 bits in C, whatever types.IntSize says.
+		return 64
+	}
+	return uint(8 * t.Size())
+}
+
+// isLiteral tells whether e is already written as a constant, perhaps
+// converted to its type.
+func (fo *folder) isLiteral(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		o := fo.info.Objects[e]
+		return o != nil && o.Kind == types.Const && !o.Global && (o.Name == "true" || o.Name == "false")
+	case *ast.UnaryExpr:
+		_, ok := e.X.(*ast.BasicLit)
+		return ok && e.Op == token.SUB
+	case *ast.ParenExpr:
+		return fo.isLiteral(e.X)
+	case *ast.CallExpr:
+		return fo.info.IsType(e.Fun) && len(e.Args) == 1 && fo.isLiteral(e.Args[0])
+	}
+	return false
+}
+
+// literal writes the value v of e as a constant, converted to the
+// type of e unless e is untyped, or gives nil if it can't.
+func (fo *folder) literal(e ast.Expr, v constant.Value) ast.Expr {
+	t := fo.info.Types[e]
+	if _, ok := types.Underlying(t).(*types.Basic); !ok {
+		return nil
+	}
+	var lit ast.Expr
+	switch v.Kind() {
+	case constant.Bool:
+		lit = ast.NewIdent(strconv.FormatBool(constant.BoolVal(v)))
+	case constant.String:
+		lit = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(constant.StringVal(v))}
+	case constant.Int:
+		if types.IsFloat(t) {
+			v = constant.ToFloat(v)
+			break
+		}
+		if constant.Sign(v) < 0 {
+			lit = &ast.UnaryExpr{Op: token.SUB,
+				X: &ast.BasicLit{Kind: token.INT, Value: constant.UnaryOp(token.SUB, v, 0).ExactString()}}
+		} else {
+			lit = &ast.BasicLit{Kind: token.INT, Value: v.ExactString()}
+		}
+	}
+	if v.Kind() == constant.Float && types.IsFloat(t) && !types.IsUntyped(t) {
+		f, _ := constant.Float64Val(v)
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if types.Identical(types.Underlying(t), types.Typ[types.Float32]) {
+			f32, _ := constant.Float32Val(v)
+			s = strconv.FormatFloat(float64(f32), 'g', -1, 32)
+		}
+		if s[0] == '-' {
+			lit = &ast.UnaryExpr{Op: token.SUB, X: &ast.BasicLit{Kind: token.FLOAT, Value: s[1:]}}
+		} else {
+			lit = &ast.BasicLit{Kind: token.FLOAT, Value: s}
+		}
+	}
+	if lit == nil {
+		return nil
+	}
+	if _, untyped := fo.info.Untyped[e]; untyped || types.IsUntyped(t) {
+		return lit
+	}
+	return Convert(lit, t)
+}
+
+// prune replaces an if statement whose condition is constant with the
+// branch that runs, and removes a for loop that never runs.  A block
+// of nothing stands for no statement at all.
+func (fo *folder) prune(s ast.Stmt) ast.Stmt {
+	var init ast.Stmt
+	var run ast.Stmt
+	switch s := s.(type) {
+	case *ast.IfStmt:
+		v := fo.value(s.Cond)
+		if v == nil || v.Kind() != constant.Bool {
+			return s
+		}
+		init = s.Init
+		fo.count(s.Cond, -1)
+		if constant.BoolVal(v) {
+			run = s.Body
+			if s.Else != nil {
+				fo.count(s.Else, -1)
+			}
+		} else {
+			run = s.Else
+			fo.count(s.Body, -1)
+		}
+	case *ast.ForStmt:
+		if s.Cond == nil {
+			return s
+		}
+		v := fo.value(s.Cond)
+		if v == nil || v.Kind() != constant.Bool || constant.BoolVal(v) {
+			return s
+		}
+		init = s.Init
+		fo.count(s.Cond, -1)
+		if s.Post != nil {
+			fo.count(s.Post, -1)
+		}
+		fo.count(s.Body, -1)
+	default:
+		return s
+	}
+	if b, ok := run.(*ast.BlockStmt); ok && init == nil {
+		fo.made[b] = true
+		return b
+	}
+	if i, ok := run.(*ast.IfStmt); ok && init == nil {
+		return i
+	}
+	b := &ast.BlockStmt{}
+	for _, s := range []ast.Stmt{init, run} {
+		if s != nil {
+			b.List = append(b.List, s)
+		}
+	}
+	fo.made[b] = true
+	return b
+}
+
+func (fo *folder) lists(f *ast.File) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = fo.list(n.List)
+		case *ast.CaseClause:
+			n.Body = fo.list(n.Body)
+		case *ast.CommClause:
+			n.Body = fo.list(n.Body)
+		}
+		return true
+	})
+}
+
+// list joins the blocks that pruning made to the list that holds
+// them, where that doesn't change the scope of a declaration, and
+// removes the statements that follow one that never finishes.
+func (fo *folder) list(ss []ast.Stmt) []ast.Stmt {
+	var out []ast.Stmt
+	for _, s := range ss {
+		if b, ok := s.(*ast.BlockStmt); ok && fo.made[b] && !declares(b.List) {
+			out = append(out, b.List...)
+		} else {
+			out = append(out, s)
+		}
+	}
+	for i, s := range out {
+		if !fo.terminates(s) {
+			continue
+		}
+		j := i + 1
+		for j < len(out) {
+			if _, ok := out[j].(*ast.LabeledStmt); ok {
+				break
+			}
+			fo.count(out[j], -1)
+			j++
+		}
+		return append(out[:i+1], out[j:]...)
+	}
+	return out
+}
+
+// declares tells whether a statement of ss declares anything.
+func declares(ss []ast.Stmt) bool {
+	for _, s := range ss {
+		if _, ok := s.(*ast.LabeledStmt); ok {
+			// A label that might be jumped to from outside
+			return true
+		}
+		switch s := s.(type) {
+		case *ast.DeclStmt:
+			return true
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// terminates tells whether s never finishes, so that nothing after it
+// runs.
+func (fo *folder) terminates(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		return len(s.List) > 0 && fo.terminates(s.List[len(s.List)-1])
+	case *ast.BranchStmt:
+		return s.Tok != token.FALLTHROUGH
+	case *ast.ExprStmt:
+		if c, ok := s.X.(*ast.CallExpr); ok {
+			if id, ok := c.Fun.(*ast.Ident); ok {
+				o := fo.info.Objects[id]
+				return o != nil && o.Kind == types.Builtin && o.Name == "panic"
+			}
+		}
+	}
+	return false
+}
+
+// dropUnusedLabels removes the labels that no branch statement names.
+func dropUnusedLabels(f *ast.File) {
+	var body func(b *ast.BlockStmt)
+	body = func(b *ast.BlockStmt) {
+		named := make(map[string]bool)
+		ast.Inspect(b, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				body(n.Body)
+				return false
+			case *ast.BranchStmt:
+				if n.Label != nil {
+					named[n.Label.Name] = true
+				}
+			}
+			return true
+		})
+		RewriteStmts(b, func(s ast.Stmt) ast.Stmt {
+			if l, ok := s.(*ast.LabeledStmt); ok && !named[l.Label.Name] {
+				return l.Stmt
+			}
+			return s
+		})
+	}
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			body(d.Body)
+		}
+	}
+}
+
+// keepUsed adds a _ = x after the declaration of each local variable x
+// whose every use we have removed, since go insists that each is used.
+func (fo *folder) keepUsed(f *ast.File) {
+	use := func(ids ...*ast.Ident) []ast.Stmt {
+		var out []ast.Stmt
+		for _, id := range ids {
+			if o := fo.info.Objects[id]; id.Name != "_" && fo.dropped[o] && fo.uses[o] == 0 {
+				out = append(out, &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent("_")},
+					Tok: token.ASSIGN, Rhs: []ast.Expr{ast.NewIdent(id.Name)}})
+			}
+		}
+		return out
+	}
+	// declared gives the variables that s declares.
+	declared := func(s ast.Stmt) []*ast.Ident {
+		var ids []*ast.Ident
+		switch s := s.(type) {
+		case *ast.LabeledStmt:
+			return nil
+		case *ast.DeclStmt:
+			if d, ok := s.Decl.(*ast.GenDecl); ok && d.Tok == token.VAR {
+				for _, spec := range d.Specs {
+					ids = append(ids, spec.(*ast.ValueSpec).Names...)
+				}
+			}
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				for _, e := range s.Lhs {
+					ids = append(ids, e.(*ast.Ident))
+				}
+			}
+		}
+		return ids
+	}
+	list := func(ss []ast.Stmt) []ast.Stmt {
+		var out []ast.Stmt
+		for _, s := range ss {
+			out = append(out, s)
+			inner := s
+			if l, ok := s.(*ast.LabeledStmt); ok {
+				inner = l.Stmt
+			}
+			out = append(out, use(declared(inner)...)...)
+		}
+		return out
+	}
+	prepend := func(b *ast.BlockStmt, ids ...*ast.Ident) {
+		b.List = append(use(ids...), b.List...)
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = list(n.List)
+		case *ast.CaseClause:
+			n.Body = list(n.Body)
+			if o, ok := fo.info.Implicits[n]; ok && fo.dropped[o] && fo.uses[o] == 0 {
+				n.Body = append([]ast.Stmt{&ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent("_")},
+					Tok: token.ASSIGN, Rhs: []ast.Expr{ast.NewIdent(o.Name)}}}, n.Body...)
+			}
+		case *ast.CommClause:
+			n.Body = list(n.Body)
+			n.Body = append(use(declared(n.Comm)...), n.Body...)
+		case *ast.IfStmt:
+			prepend(n.Body, declared(n.Init)...)
+		case *ast.ForStmt:
+			prepend(n.Body, declared(n.Init)...)
+		case *ast.SwitchStmt:
+			if ids := declared(n.Init); len(ids) > 0 && len(n.Body.List) > 0 {
+				c := n.Body.List[0].(*ast.CaseClause)
+				c.Body = append(use(ids...), c.Body...)
+			}
+		case *ast.TypeSwitchStmt:
+			if ids := declared(n.Init); len(ids) > 0 && len(n.Body.List) > 0 {
+				c := n.Body.List[0].(*ast.CaseClause)
+				c.Body = append(use(ids...), c.Body...)
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				var ids []*ast.Ident
+				for _, e := range []ast.Expr{n.Key, n.Value} {
+					if id, ok := e.(*ast.Ident); ok {
+						ids = append(ids, id)
+					}
+				}
+				prepend(n.Body, ids...)
+			}
+		}
+		return true
+	})
+}
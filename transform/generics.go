@@ -0,0 +1,295 @@
+package transform
+
+import (
+	"bytes"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strings"
+)
+
+// Monomorphize replaces each generic function and type with a copy of
+// it for each list of type arguments that it is instantiated with,
+// named after them, so that nothing later needs to know about
+// generics.  Thus
+//
+//	type Box[T any] struct{ x T }
+//
+//	func (b Box[T]) Get() T { return b.x }
+//
+//	func Wrap[T any](x T) Box[T] { return Box[T]{x} }
+//
+//	var b = Wrap[int](1)
+//
+// becomes
+//
+//	var b = Wrap_int(1)
+//
+//	func Wrap_int(x int) Box_int { return Box_int{x} }
+//
+//	type Box_int struct{ x int }
+//
+//	func (b Box_int) Get() int { return b.x }
+//
+// Interfaces that are only constraints go too, since nothing can use
+// them once there are no type parameters.
+func Monomorphize(f *ast.File, info *types.Info) {
+	m := &monomorphizer{
+		info:    info,
+		funcs:   make(map[*types.Object]*ast.FuncDecl),
+		types:   make(map[*types.Object]*ast.TypeSpec),
+		methods: make(map[*types.Object][]*ast.FuncDecl),
+		done:    make(map[string]bool),
+	}
+	var decls []ast.Decl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if o := m.genericRecv(d); o != nil {
+				m.methods[o] = append(m.methods[o], d)
+				continue
+			}
+			if d.Type.TypeParams != nil {
+				m.funcs[info.Objects[d.Name]] = d
+				continue
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.TYPE {
+				var specs []ast.Spec
+				for _, spec := range d.Specs {
+					ts := spec.(*ast.TypeSpec)
+					switch {
+					case ts.TypeParams != nil:
+						m.types[info.Objects[ts.Name]] = ts
+					case !isConstraint(info.Types[ts.Name]):
+						specs = append(specs, ts)
+					}
+				}
+				if specs == nil {
+					continue
+				}
+				d.Specs = specs
+			}
+		}
+		decls = append(decls, d)
+	}
+	for _, d := range decls {
+		m.rewrite(d, nil, nil)
+	}
+	for len(m.todo) > 0 {
+		in := m.todo[0]
+		m.todo = m.todo[1:]
+		decls = append(decls, m.instantiate(in)...)
+	}
+	f.Decls = decls
+}
+
+type monomorphizer struct {
+	info    *types.Info
+	funcs   map[*types.Object]*ast.FuncDecl
+	types   map[*types.Object]*ast.TypeSpec
+	methods map[*types.Object][]*ast.FuncDecl
+	// done holds the names of the instances we have made (or will
+	// make), and todo those we have yet to make.
+	done map[string]bool
+	todo []instantiation
+}
+
+type instantiation struct {
+	obj  *types.Object
+	args []types.Type
+	name string
+}
+
+// genericRecv gives the generic type that d is a method of, if any.
+func (m *monomorphizer) genericRecv(d *ast.FuncDecl) *types.Object {
+	if d.Recv == nil {
+		return nil
+	}
+	t := d.Recv.List[0].Type
+	if s, ok := t.(*ast.StarExpr); ok {
+		t = s.X
+	}
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		return m.info.Objects[x.X.(*ast.Ident)]
+	case *ast.IndexListExpr:
+		return m.info.Objects[x.X.(*ast.Ident)]
+	}
+	return nil
+}
+
+// isConstraint tells whether t is an interface that may only be used
+// as a constraint.
+func isConstraint(t types.Type) bool {
+	i, ok := types.Underlying(t).(*types.Interface)
+	return ok && !i.Sealed && (i.Terms != nil || i.Comparable)
+}
+
+// instance gives the name of the instance of the generic function or
+// type obj with the type arguments args, making it if need be.
+func (m *monomorphizer) instance(obj *types.Object, args []types.Type) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		var b bytes.Buffer
+		printer.Fprint(&b, token.NewFileSet(), m.typeExpr(a))
+		names[i] = identifier(b.String())
+	}
+	name := obj.Name + "_" + strings.Join(names, "_")
+	if !m.done[name] {
+		m.done[name] = true
+		m.todo = append(m.todo, instantiation{obj, args, name})
+	}
+	return name
+}
+
+// typeExpr is the syntax of the type t, naming each instance of a
+// generic type by our copy of it.
+func (m *monomorphizer) typeExpr(t types.Type) ast.Expr {
+	switch t := t.(type) {
+	case *types.Named:
+		if t.Orig != nil {
+			return ast.NewIdent(m.instance(m.info.Globals[t.Orig.Name], t.TypeArgs))
+		}
+	case *types.Pointer:
+		return &ast.StarExpr{X: m.typeExpr(t.Elem)}
+	case *types.Slice:
+		return &ast.ArrayType{Elt: m.typeExpr(t.Elem)}
+	case *types.Array:
+		return &ast.ArrayType{Len: intLit(t.Len), Elt: m.typeExpr(t.Elem)}
+	case *types.Map:
+		return &ast.MapType{Key: m.typeExpr(t.Key), Value: m.typeExpr(t.Elem)}
+	case *types.Chan:
+		return &ast.ChanType{Dir: t.Dir, Value: m.typeExpr(t.Elem)}
+	case *types.Struct:
+		e := t.Expr().(*ast.StructType)
+		for i, f := range e.Fields.List {
+			f.Type = m.typeExpr(t.Fields[i].Type)
+		}
+		return e
+	case *types.Function:
+		e := t.Expr().(*ast.FuncType)
+		for i, p := range e.Params.List {
+			p.Type = m.typeExpr(t.Parameters[i])
+			if t.Variadic && i == len(t.Parameters)-1 {
+				p.Type = &ast.Ellipsis{Elt: p.Type.(*ast.ArrayType).Elt}
+			}
+		}
+		for i, r := range e.Results.List {
+			r.Type = m.typeExpr(t.Results[i])
+		}
+		return e
+	}
+	return t.Expr()
+}
+
+// instantiate makes the declarations of an instance of a generic
+// function, or of a generic type along with its methods.
+func (m *monomorphizer) instantiate(in instantiation) []ast.Decl {
+	subst := make(map[*types.TypeParam]types.Type)
+	if fn, ok := m.funcs[in.obj]; ok {
+		for i, p := range in.obj.Type.(*types.Function).TypeParams {
+			subst[p] = in.args[i]
+		}
+		orig := make(map[ast.Node]ast.Node)
+		d := clone(fn, orig).(*ast.FuncDecl)
+		d.Name = ast.NewIdent(in.name)
+		d.Type.TypeParams = nil
+		m.rewrite(d, orig, subst)
+		return []ast.Decl{d}
+	}
+	for i, p := range in.obj.Type.(*types.Named).TypeParams {
+		subst[p] = in.args[i]
+	}
+	orig := make(map[ast.Node]ast.Node)
+	ts := clone(m.types[in.obj], orig).(*ast.TypeSpec)
+	ts.Name = ast.NewIdent(in.name)
+	ts.TypeParams = nil
+	out := []ast.Decl{&ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{ts}}}
+	m.rewrite(out[0], orig, subst)
+	for _, fn := range m.methods[in.obj] {
+		d := clone(fn, orig).(*ast.FuncDecl)
+		m.rewrite(d, orig, subst)
+		out = append(out, d)
+	}
+	return out
+}
+
+// rewrite replaces each instantiation within n with the name of its
+// instance, and each type parameter with its type argument.  If n is
+// a copy, orig holds the original of each of its nodes, which is what
+// the type checker knows about.
+func (m *monomorphizer) rewrite(n ast.Node, orig map[ast.Node]ast.Node, subst map[*types.TypeParam]types.Type) {
+	RewriteExprs(n, func(e ast.Expr) ast.Expr {
+		o := e
+		if x, ok := orig[e]; ok {
+			o = x.(ast.Expr)
+		}
+		if in := m.info.Instances[o]; in != nil {
+			args := make([]types.Type, len(in.TypeArgs))
+			for i, a := range in.TypeArgs {
+				args[i] = types.Subst(a, subst)
+			}
+			return ast.NewIdent(m.instance(in.Obj, args))
+		}
+		if id, ok := o.(*ast.Ident); ok {
+			if obj := m.info.Objects[id]; obj != nil && obj.Kind == types.TypeName {
+				if p, ok := obj.Type.(*types.TypeParam); ok {
+					return m.typeExpr(subst[p])
+				}
+			}
+		}
+		return e
+	})
+}
+
+// clone copies the syntax tree n, noting the original of each node of
+// the copy in orig.
+func clone(n ast.Node, orig map[ast.Node]ast.Node) ast.Node {
+	return cloneValue(reflect.ValueOf(n), orig).Interface().(ast.Node)
+}
+
+func cloneValue(v reflect.Value, orig map[ast.Node]ast.Node) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		switch v.Interface().(type) {
+		case *ast.Object, *ast.Scope, *ast.CommentGroup:
+			// The parser's idea of scopes is of no use in a copy.
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+		if c.Elem().Kind() == reflect.Struct {
+			for i := 0; i < c.Elem().NumField(); i++ {
+				f := c.Elem().Field(i)
+				f.Set(cloneValue(f, orig))
+			}
+		}
+		if n, ok := c.Interface().(ast.Node); ok {
+			orig[n] = v.Interface().(ast.Node)
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(cloneValue(v.Elem(), orig))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cloneValue(v.Index(i), orig))
+		}
+		return c
+	}
+	return v
+}
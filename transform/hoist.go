@@ -0,0 +1,95 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// HoistLocalTypes moves the types declared within functions to the
+// top level, since C has no local types that outlive a declaration the
+// way go does, and two functions may declare types of the same name.
+// Each gets the name of its function as a prefix, so that
+//
+//	func main() {
+//		type point struct{ x, y int }
+//		var p point
+//	}
+//
+// becomes
+//
+//	func main() {
+//		var p main_point
+//	}
+//
+//	type main_point struct{ x, y int }
+//
+// An alias stays where it is, since it declares no new type.
+func HoistLocalTypes(f *ast.File, info *types.Info) {
+	used := make(map[string]bool)
+	for name := range info.Globals {
+		used[name] = true
+	}
+	renamed := make(map[*types.Object]string)
+	var specs []ast.Spec
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		prefix := fn.Name.Name
+		if fn.Recv != nil {
+			prefix = receiverName(fn) + "_" + prefix
+		}
+		RewriteStmts(fn, func(s ast.Stmt) ast.Stmt {
+			ds, ok := s.(*ast.DeclStmt)
+			if !ok || ds.Decl.(*ast.GenDecl).Tok != token.TYPE {
+				return s
+			}
+			g := ds.Decl.(*ast.GenDecl)
+			var aliases []ast.Spec
+			for _, spec := range g.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Assign.IsValid() {
+					aliases = append(aliases, ts)
+					continue
+				}
+				name := prefix + "_" + ts.Name.Name
+				for i := 2; used[name]; i++ {
+					name = fmt.Sprint(prefix, "_", ts.Name.Name, "_", i)
+				}
+				used[name] = true
+				renamed[info.Objects[ts.Name]] = name
+				ts.Name.Name = name
+				specs = append(specs, ts)
+			}
+			if aliases == nil {
+				return &ast.EmptyStmt{}
+			}
+			g.Specs = aliases
+			return s
+		})
+	}
+	if specs == nil {
+		return
+	}
+	for o, name := range renamed {
+		o.Type.(*types.Named).Name = name
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if name, ok := renamed[info.Objects[id]]; ok {
+				id.Name = name
+			}
+		}
+		return true
+	})
+	// The underlying type may refer to types that are local no longer,
+	// or to local constants, so we spell it out afresh.
+	for _, spec := range specs {
+		ts := spec.(*ast.TypeSpec)
+		ts.Type = info.Types[ts.Name].(*types.Named).Underlying.Expr()
+	}
+	f.Decls = append(f.Decls, &ast.GenDecl{Tok: token.TYPE, Specs: specs})
+}
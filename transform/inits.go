@@ -0,0 +1,201 @@
+package transform
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// EliminateInits moves the init statements of if, for and switch
+// statements into a block of their own, so
+//
+//	if x := f(); x > 0 {
+//		...
+//	}
+//
+// becomes
+//
+//	{
+//		x := f()
+//		if x > 0 {
+//			...
+//		}
+//	}
+//
+// Each iteration of a for loop has its own copy of the variables its
+// init statement declares, which matters when a function literal in
+// the body captures them, or the body takes their address, so then
+//
+//	for i := 0; i < n; i++ {
+//		fs = append(fs, func() int { return i })
+//	}
+//
+// becomes
+//
+//	{
+//		tmp := 0
+//		for ; tmp < n; tmp++ {
+//			i := tmp
+//			fs = append(fs, func() int { return i })
+//			tmp = i
+//		}
+//	}
+//
+// where a continue statement also copies i back to tmp.
+func EliminateInits(f *ast.File) {
+	made := make(map[ast.Stmt]bool)
+	copyBack := make(map[*ast.ForStmt][]ast.Stmt)
+	targets := gotoTargets(f)
+	RewriteStmts(f, func(s ast.Stmt) ast.Stmt {
+		var init *ast.Stmt
+		switch s := s.(type) {
+		case *ast.IfStmt:
+			init = &s.Init
+		case *ast.ForStmt:
+			if a, ok := s.Init.(*ast.AssignStmt); ok && a.Tok == token.DEFINE &&
+				(hasFuncLit(s.Body) || takesAddress(s.Body, a.Lhs)) {
+				copyBack[s] = perIteration(s, a)
+				continues(s.Body, "", copyBack[s])
+			}
+			init = &s.Init
+		case *ast.SwitchStmt:
+			init = &s.Init
+		case *ast.TypeSwitchStmt:
+			init = &s.Init
+		case *ast.LabeledStmt:
+			if b, ok := s.Stmt.(*ast.BlockStmt); ok && made[b] {
+				if loop, ok := b.List[len(b.List)-1].(*ast.ForStmt); ok && copyBack[loop] != nil {
+					continues(loop.Body, s.Label.Name, copyBack[loop])
+				}
+			}
+			return relabel(s, made, targets)
+		}
+		if init == nil || *init == nil {
+			return s
+		}
+		b := &ast.BlockStmt{List: []ast.Stmt{*init, s}}
+		*init = nil
+		made[b] = true
+		return b
+	})
+}
+
+func hasFuncLit(n ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		_, isfunc := n.(*ast.FuncLit)
+		found = found || isfunc
+		return !found
+	})
+	return found
+}
+
+// takesAddress tells whether n takes the address of any of the
+// variables named in names, or of a part of one, as far as we can
+// tell without types.
+func takesAddress(n ast.Node, names []ast.Expr) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		u, ok := n.(*ast.UnaryExpr)
+		if !ok || u.Op != token.AND {
+			return !found
+		}
+		x := u.X
+		for {
+			switch e := x.(type) {
+			case *ast.ParenExpr:
+				x = e.X
+				continue
+			case *ast.SelectorExpr:
+				x = e.X
+				continue
+			case *ast.IndexExpr:
+				x = e.X
+				continue
+			case *ast.Ident:
+				for _, name := range names {
+					found = found || name.(*ast.Ident).Name == e.Name
+				}
+			}
+			break
+		}
+		return !found
+	})
+	return found
+}
+
+// perIteration gives the body of the loop s its own copies of the
+// variables that its init statement a declares, which are renamed
+// in the init, condition and post statements.  It returns the
+// statements that copy them back for the next iteration.
+func perIteration(s *ast.ForStmt, a *ast.AssignStmt) []ast.Stmt {
+	renames := make(map[string]string)
+	var copies, back []ast.Stmt
+	for i, l := range a.Lhs {
+		if isBlank(l) {
+			continue
+		}
+		name, tmp := l.(*ast.Ident).Name, tempName()
+		renames[name] = tmp
+		a.Lhs[i] = ast.NewIdent(tmp)
+		copies = append(copies, &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(name)},
+			Tok: token.DEFINE, Rhs: []ast.Expr{ast.NewIdent(tmp)}})
+		back = append(back, &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(tmp)},
+			Tok: token.ASSIGN, Rhs: []ast.Expr{ast.NewIdent(name)}})
+	}
+	for _, n := range []ast.Node{s.Cond, s.Post} {
+		if n != nil {
+			ast.Inspect(n, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && renames[id.Name] != "" {
+					id.Name = renames[id.Name]
+				}
+				return true
+			})
+		}
+	}
+	s.Body.List = append(append(copies, s.Body.List...), back...)
+	return back
+}
+
+// continues makes each continue statement in s that continues the
+// loop with the given label (or the innermost loop, if label is
+// empty) first run the statements in back.
+func continues(s ast.Stmt, label string, back []ast.Stmt) {
+	list := func(ss []ast.Stmt) {
+		for i, s := range ss {
+			b, ok := s.(*ast.BranchStmt)
+			if ok && b.Tok == token.CONTINUE && (b.Label == nil && label == "" ||
+				b.Label != nil && b.Label.Name == label) {
+				ss[i] = &ast.BlockStmt{List: append(append([]ast.Stmt{}, back...), b)}
+				continue
+			}
+			continues(s, label, back)
+		}
+	}
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		list(s.List)
+	case *ast.LabeledStmt:
+		continues(s.Stmt, label, back)
+	case *ast.IfStmt:
+		continues(s.Body, label, back)
+		continues(s.Else, label, back)
+	case *ast.SwitchStmt:
+		continues(s.Body, label, back)
+	case *ast.TypeSwitchStmt:
+		continues(s.Body, label, back)
+	case *ast.SelectStmt:
+		continues(s.Body, label, back)
+	case *ast.CaseClause:
+		list(s.Body)
+	case *ast.CommClause:
+		list(s.Body)
+	case *ast.ForStmt:
+		if label != "" {
+			continues(s.Body, label, back)
+		}
+	case *ast.RangeStmt:
+		if label != "" {
+			continues(s.Body, label, back)
+		}
+	}
+}
@@ -0,0 +1,926 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// InlineBudget is the largest number of syntax nodes that the body of
+// a function may have, if its calls are to be inlined.
+var InlineBudget = 40
+
+// Log, if it isn't nil, hears of the decisions that a pass makes, such
+// as which calls Inline inlines and why it leaves the others alone.
+var Log func(pos token.Pos, msg string)
+
+func logf(pos token.Pos, format string, args ...interface{}) {
+	if Log != nil {
+		Log(pos, fmt.Sprintf(format, args...))
+	}
+}
+
+// Inline replaces calls of small leaf functions and methods, those
+// that call nothing but builtins, with their bodies.  A function whose
+// body is just return e, and whose arguments are variables, has its
+// calls replaced with e, so that
+//
+//	func sq(x int) int { return x * x }
+//
+//	y = sq(a) + 1
+//
+// becomes
+//
+//	y = (a)*(a) + 1
+//
+// Otherwise, a call that is a statement on its own, the value of an
+// assignment or declaration, or the value of a return statement, is
+// replaced with a block that declares the parameters and results, and
+// runs the body, with each return statement setting the results and
+// jumping to its end.  A call that Go runs before another operand of
+// its statement that calls or receives, and so might change what e
+// reads, runs this way too, into a temporary before the statement.
+// Thus y = abs(a) becomes
+//
+//	{
+//		var x int = a
+//		var r int
+//		{
+//			if x < 0 {
+//				{
+//					r = -x
+//					goto done
+//				}
+//			}
+//			r = x
+//		}
+//	done:
+//		y = r
+//	}
+//
+// where the variables and labels have fresh names.  Functions that
+// defer, recover, start goroutines, select, use labels or function
+// literals, or are variadic, are never inlined.
+func Inline(f *ast.File, info *types.Info) {
+	in := &inliner{info: info, costs: make(map[*ast.FuncDecl]int)}
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			if why := in.inlinable(d); why != "" {
+				logf(d.Pos(), "cannot inline %s: %s", funcName(d), why)
+			} else {
+				logf(d.Pos(), "can inline %s (cost %d)", funcName(d), in.costs[d])
+			}
+		}
+	}
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			in.inlineIn(d)
+		}
+	}
+}
+
+type inliner struct {
+	info *types.Info
+	// costs holds the size of each function that can be inlined.
+	costs map[*ast.FuncDecl]int
+}
+
+func funcName(d *ast.FuncDecl) string {
+	if d.Recv == nil {
+		return d.Name.Name
+	}
+	t := d.Recv.List[0].Type
+	if s, ok := t.(*ast.StarExpr); ok {
+		return fmt.Sprintf("(*%s).%s", s.X.(*ast.Ident).Name, d.Name.Name)
+	}
+	return fmt.Sprintf("%s.%s", t.(*ast.Ident).Name, d.Name.Name)
+}
+
+// inlinable tells why calls of d can't be inlined, or gives "" if
+// they can be.
+func (in *inliner) inlinable(d *ast.FuncDecl) string {
+	if d.Name.Name == "main" || d.Name.Name == "init" {
+		return "it is called by the runtime"
+	}
+	if sig, ok := in.info.TypeOf(d.Name).(*types.Function); !ok || sig.Variadic {
+		return "it is variadic"
+	}
+	why := ""
+	cost := 0
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if why != "" {
+			return false
+		}
+		cost++
+		switch n := n.(type) {
+		case *ast.DeferStmt:
+			why = "it defers a call"
+		case *ast.GoStmt:
+			why = "it starts a goroutine"
+		case *ast.SelectStmt:
+			why = "it selects"
+		case *ast.LabeledStmt:
+			why = "it has a label"
+		case *ast.FuncLit:
+			why = "it has a function literal"
+		case *ast.CallExpr:
+			if in.info.IsType(n.Fun) {
+				break
+			}
+			if id, ok := n.Fun.(*ast.Ident); ok {
+				if o := in.info.Objects[id]; o != nil && o.Kind == types.Builtin {
+					if o.Name == "recover" {
+						why = "it recovers"
+					}
+					break
+				}
+			}
+			switch fun := n.Fun.(type) {
+			case *ast.Ident:
+				why = "it calls " + fun.Name
+			case *ast.SelectorExpr:
+				why = "it calls " + fun.Sel.Name
+			default:
+				why = "it calls a function value"
+			}
+		}
+		return true
+	})
+	if why == "" && cost > InlineBudget {
+		why = fmt.Sprintf("it is too big (cost %d > %d)", cost, InlineBudget)
+	}
+	if why == "" {
+		in.costs[d] = cost
+	}
+	return why
+}
+
+// callee gives the declaration of the function that c calls, if it
+// can be inlined, along with the receiver of a method.
+func (in *inliner) callee(c *ast.CallExpr) (*ast.FuncDecl, ast.Expr) {
+	var o *types.Object
+	var recv ast.Expr
+	switch fun := c.Fun.(type) {
+	case *ast.Ident:
+		o = in.info.Objects[fun]
+	case *ast.SelectorExpr:
+		if in.info.IsType(fun.X) {
+			return nil, nil
+		}
+		o, recv = in.info.Objects[fun.Sel], fun.X
+	}
+	if o == nil || o.Kind != types.Func {
+		return nil, nil
+	}
+	d, ok := o.Decl.(*ast.FuncDecl)
+	if _, can := in.costs[d]; !ok || !can || (d.Recv == nil) != (recv == nil) {
+		return nil, nil
+	}
+	return d, recv
+}
+
+// inlineIn inlines the calls within the function d.
+func (in *inliner) inlineIn(d *ast.FuncDecl) {
+	// The names that d declares, which would shadow those that an
+	// inlined body uses.
+	names := make(map[string]bool)
+	declare := func(ids ...*ast.Ident) {
+		for _, id := range ids {
+			names[id.Name] = true
+		}
+	}
+	ast.Inspect(d, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Field:
+			declare(n.Names...)
+		case *ast.ValueSpec:
+			declare(n.Names...)
+		case *ast.TypeSpec:
+			declare(n.Name)
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, l := range n.Lhs {
+					declare(l.(*ast.Ident))
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				for _, e := range []ast.Expr{n.Key, n.Value} {
+					if id, ok := e.(*ast.Ident); ok {
+						declare(id)
+					}
+				}
+			}
+		}
+		return true
+	})
+	// The call of a go or defer statement must stay a call.
+	stay := make(map[*ast.CallExpr]bool)
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GoStmt:
+			stay[n.Call] = true
+		case *ast.DeferStmt:
+			stay[n.Call] = true
+		}
+		return true
+	})
+
+	ordered := in.ordered(d.Body)
+
+	RewriteExprs(d.Body, func(e ast.Expr) ast.Expr {
+		c, ok := e.(*ast.CallExpr)
+		if !ok || stay[c] {
+			return e
+		}
+		callee, recv := in.callee(c)
+		if callee == nil || in.single(callee) == nil {
+			return e
+		}
+		if why := in.shadowed(callee, names); why != "" {
+			logf(c.Pos(), "not inlining call to %s: %s", funcName(callee), why)
+			stay[c] = true
+			return e
+		}
+		if ordered[c] {
+			return e
+		}
+		if x := in.expr(c, callee, recv); x != nil {
+			logf(c.Pos(), "inlining call to %s", funcName(callee))
+			return x
+		}
+		return e
+	})
+
+	// A labeled declaration can't be replaced by several statements.
+	labeled := make(map[ast.Stmt]bool)
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if l, ok := n.(*ast.LabeledStmt); ok {
+			labeled[l.Stmt] = true
+		}
+		return true
+	})
+	splice := make(map[*ast.BlockStmt]bool)
+	var inlineStmt func(s ast.Stmt) ast.Stmt
+	inlineStmt = func(s ast.Stmt) ast.Stmt {
+		var c *ast.CallExpr
+		var store func(results []ast.Expr) []ast.Stmt
+		// A declaration of the variables must come before the block
+		// that runs the body, which sets them at its end.
+		var decls []ast.Stmt
+		switch s := s.(type) {
+		case *ast.ExprStmt:
+			c, _ = s.X.(*ast.CallExpr)
+			store = func(results []ast.Expr) []ast.Stmt {
+				var out []ast.Stmt
+				for _, r := range results {
+					out = append(out, assignStmt(ast.NewIdent("_"), r))
+				}
+				return out
+			}
+		case *ast.AssignStmt:
+			if len(s.Rhs) != 1 || s.Tok == token.DEFINE || len(s.Lhs) > 1 && s.Tok != token.ASSIGN {
+				return s
+			}
+			c, _ = s.Rhs[0].(*ast.CallExpr)
+			store = func(results []ast.Expr) []ast.Stmt {
+				return []ast.Stmt{&ast.AssignStmt{Lhs: s.Lhs, Tok: s.Tok, Rhs: results}}
+			}
+		case *ast.ReturnStmt:
+			if len(s.Results) != 1 {
+				return s
+			}
+			c, _ = s.Results[0].(*ast.CallExpr)
+			store = func(results []ast.Expr) []ast.Stmt {
+				return []ast.Stmt{&ast.ReturnStmt{Results: results}}
+			}
+		case *ast.DeclStmt:
+			g := s.Decl.(*ast.GenDecl)
+			if g.Tok != token.VAR || len(g.Specs) != 1 || labeled[s] {
+				return s
+			}
+			spec := g.Specs[0].(*ast.ValueSpec)
+			if len(spec.Values) != 1 {
+				return s
+			}
+			c, _ = spec.Values[0].(*ast.CallExpr)
+			var lhs []ast.Expr
+			for _, n := range spec.Names {
+				decls = append(decls, varDecl(n.Name, in.info.TypeOf(n), nil))
+				lhs = append(lhs, ast.NewIdent(n.Name))
+			}
+			store = func(results []ast.Expr) []ast.Stmt {
+				return []ast.Stmt{&ast.AssignStmt{Lhs: lhs, Tok: token.ASSIGN, Rhs: results}}
+			}
+		}
+		if c == nil || stay[c] || ordered[c] {
+			return s
+		}
+		callee, recv := in.callee(c)
+		if callee == nil {
+			return s
+		}
+		if why := in.shadowed(callee, names); why != "" {
+			logf(c.Pos(), "not inlining call to %s: %s", funcName(callee), why)
+			return s
+		}
+		logf(c.Pos(), "inlining call to %s", funcName(callee))
+		b := in.block(c, callee, recv, store)
+		if decls != nil {
+			// The variables must stay in the scope they were declared
+			// in, so this block joins the list that holds it.
+			b = &ast.BlockStmt{List: append(decls, b)}
+			splice[b] = true
+		}
+		return b
+	}
+	RewriteStmts(d.Body, func(s ast.Stmt) ast.Stmt {
+		if labeled[s] {
+			return inlineStmt(s)
+		}
+		// A call that must run before the other operands of its
+		// statement runs first, into a temporary.
+		var out []ast.Stmt
+		for {
+			c := in.first(s, ordered)
+			if c == nil {
+				break
+			}
+			callee, recv := in.callee(c)
+			if why := in.shadowed(callee, names); why != "" {
+				logf(c.Pos(), "not inlining call to %s: %s", funcName(callee), why)
+				break
+			}
+			logf(c.Pos(), "inlining call to %s", funcName(callee))
+			tmp := tempName()
+			out = append(out, varDecl(tmp, in.info.TypeOf(c), nil),
+				in.block(c, callee, recv, func(results []ast.Expr) []ast.Stmt {
+					return []ast.Stmt{assignStmt(ast.NewIdent(tmp), results[0])}
+				}))
+			RewriteExprs(s, func(e ast.Expr) ast.Expr {
+				if e == c {
+					return ast.NewIdent(tmp)
+				}
+				return e
+			})
+		}
+		if out == nil {
+			return inlineStmt(s)
+		}
+		b := &ast.BlockStmt{List: append(out, inlineStmt(s))}
+		splice[b] = true
+		return b
+	})
+	var join func(ss []ast.Stmt) []ast.Stmt
+	join = func(ss []ast.Stmt) []ast.Stmt {
+		var out []ast.Stmt
+		for _, s := range ss {
+			if b, ok := s.(*ast.BlockStmt); ok && splice[b] {
+				out = append(out, join(b.List)...)
+			} else {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.BlockStmt:
+			n.List = join(n.List)
+		case *ast.CaseClause:
+			n.Body = join(n.Body)
+		case *ast.CommClause:
+			n.Body = join(n.Body)
+		}
+		return true
+	})
+}
+
+// single gives the value that d returns, if its body is just one
+// return statement with a single value.
+func (in *inliner) single(d *ast.FuncDecl) ast.Expr {
+	if len(d.Body.List) != 1 {
+		return nil
+	}
+	r, ok := d.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(r.Results) != 1 {
+		return nil
+	}
+	return r.Results[0]
+}
+
+// operands gives the expressions that the statement s itself
+// evaluates, leaving out those of the statements within it.
+func operands(s ast.Stmt) []ast.Expr {
+	switch s := s.(type) {
+	case *ast.ExprStmt:
+		return []ast.Expr{s.X}
+	case *ast.IncDecStmt:
+		return []ast.Expr{s.X}
+	case *ast.AssignStmt:
+		return append(append([]ast.Expr{}, s.Lhs...), s.Rhs...)
+	case *ast.DeclStmt:
+		var es []ast.Expr
+		if g, ok := s.Decl.(*ast.GenDecl); ok && g.Tok == token.VAR {
+			for _, spec := range g.Specs {
+				es = append(es, spec.(*ast.ValueSpec).Values...)
+			}
+		}
+		return es
+	case *ast.ReturnStmt:
+		return s.Results
+	case *ast.SendStmt:
+		return []ast.Expr{s.Chan, s.Value}
+	case *ast.GoStmt:
+		return []ast.Expr{s.Call}
+	case *ast.DeferStmt:
+		return []ast.Expr{s.Call}
+	case *ast.IfStmt:
+		return []ast.Expr{s.Cond}
+	case *ast.ForStmt:
+		if s.Cond != nil {
+			return []ast.Expr{s.Cond}
+		}
+	case *ast.SwitchStmt:
+		if s.Tag != nil {
+			return []ast.Expr{s.Tag}
+		}
+	case *ast.CaseClause:
+		return s.List
+	}
+	return nil
+}
+
+// pureBuiltins are the builtins that neither change anything nor
+// panic in a way that matters here.
+var pureBuiltins = map[string]bool{"len": true, "cap": true, "new": true, "make": true,
+	"complex": true, "real": true, "imag": true, "min": true, "max": true}
+
+// runs tells whether n is a call or receive, which runs code that may
+// change variables, or panic, and which Go runs in order with the
+// others of its statement.
+func (in *inliner) runs(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.UnaryExpr:
+		return n.Op == token.ARROW
+	case *ast.CallExpr:
+		if in.info.IsType(n.Fun) {
+			return false
+		}
+		if id, ok := types.StripParens(n.Fun).(*ast.Ident); ok {
+			if o := in.info.Objects[id]; o != nil && o.Kind == types.Builtin {
+				return !pureBuiltins[o.Name]
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// pure tells whether the call c only reads variables: whether it calls
+// a function whose body returns a value that neither calls anything
+// nor receives.
+func (in *inliner) pure(c *ast.CallExpr) bool {
+	callee, _ := in.callee(c)
+	if callee == nil || in.single(callee) == nil {
+		return false
+	}
+	found := false
+	ast.Inspect(in.single(callee), func(n ast.Node) bool {
+		found = found || in.runs(n)
+		return !found
+	})
+	return !found
+}
+
+// panics tells whether evaluating n may panic, not counting its
+// operands.
+func (in *inliner) panics(n ast.Node) bool {
+	switch n := n.(type) {
+	case *ast.IndexExpr:
+		return !types.IsMap(in.info.TypeOf(n.X))
+	case *ast.SliceExpr, *ast.TypeAssertExpr:
+		return true
+	case *ast.StarExpr:
+		return !in.info.IsType(n)
+	case *ast.BinaryExpr:
+		return (n.Op == token.QUO || n.Op == token.REM) && types.IsInteger(in.info.TypeOf(n))
+	case *ast.SelectorExpr:
+		o := in.info.Objects[n.Sel]
+		return (o == nil || o.Kind != types.Func) && types.IsPointer(in.info.TypeOf(n.X))
+	case *ast.CallExpr:
+		if in.pure(n) {
+			callee, _ := in.callee(n)
+			return in.mayPanic(in.single(callee))
+		}
+		return in.runs(n)
+	}
+	return false
+}
+
+// mayPanic tells whether evaluating n may panic, leaving out the
+// function literals within it.
+func (in *inliner) mayPanic(n ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+		found = found || in.panics(n)
+		return !found
+	})
+	return found
+}
+
+// contains tells whether x is within n.
+func contains(n, x ast.Node) bool {
+	found := false
+	ast.Inspect(n, func(m ast.Node) bool {
+		found = found || m == x
+		return !found
+	})
+	return found
+}
+
+// ordered finds the calls within body that mustn't be replaced by the
+// values their bodies return, since another operand of the statement
+// holding them runs code, which Go runs in order with the calls, or
+// may panic where the value may panic too.
+func (in *inliner) ordered(body *ast.BlockStmt) map[*ast.CallExpr]bool {
+	out := make(map[*ast.CallExpr]bool)
+	ast.Inspect(body, func(n ast.Node) bool {
+		s, ok := n.(ast.Stmt)
+		if !ok {
+			return true
+		}
+		es := operands(s)
+		var calls []*ast.CallExpr
+		for _, e := range es {
+			ast.Inspect(e, func(n ast.Node) bool {
+				if c, ok := n.(*ast.CallExpr); ok {
+					if callee, _ := in.callee(c); callee != nil && in.single(callee) != nil {
+						calls = append(calls, c)
+					}
+				}
+				_, isLit := n.(*ast.FuncLit)
+				return !isLit
+			})
+		}
+		for _, c := range calls {
+			runs, panics := false, false
+			for _, e := range es {
+				ast.Inspect(e, func(n ast.Node) bool {
+					switch n := n.(type) {
+					case *ast.FuncLit:
+						return false
+					case *ast.CallExpr:
+						if n == c {
+							return false
+						}
+						if contains(n, c) {
+							// It runs once c is evaluated.
+							return true
+						}
+						if in.pure(n) {
+							panics = panics || in.panics(n)
+							return true
+						}
+					}
+					runs = runs || in.runs(n)
+					panics = panics || in.panics(n)
+					return !runs
+				})
+			}
+			if runs || panics && in.mayPanic(c) {
+				callee, _ := in.callee(c)
+				logf(c.Pos(), "not inlining call to %s in place: another operand may run first",
+					funcName(callee))
+				out[c] = true
+			}
+		}
+		return true
+	})
+	return out
+}
+
+// first gives the call or receive that Go runs first among the
+// operands of s, if it is a call that ordered holds, and that no &&
+// or || may skip, so that it may run into a temporary before s, or
+// else nil.
+func (in *inliner) first(s ast.Stmt, ordered map[*ast.CallExpr]bool) *ast.CallExpr {
+	switch s := s.(type) {
+	case *ast.ExprStmt, *ast.AssignStmt, *ast.DeclStmt, *ast.ReturnStmt, *ast.SendStmt:
+	case *ast.IfStmt:
+		if s.Init != nil {
+			return nil
+		}
+	case *ast.SwitchStmt:
+		if s.Init != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	var found ast.Node
+	skippable := false
+	maybe := make(map[ast.Node]bool)
+	var stack []ast.Node
+	for _, e := range operands(s) {
+		ast.Inspect(e, func(n ast.Node) bool {
+			if n == nil {
+				// The operands of n have been evaluated, so n
+				// runs now.
+				n, stack = stack[len(stack)-1], stack[:len(stack)-1]
+				if found == nil && in.runs(n) {
+					found = n
+					for _, m := range append(stack, n) {
+						skippable = skippable || maybe[m]
+					}
+				}
+				return false
+			}
+			if _, isLit := n.(*ast.FuncLit); isLit || found != nil {
+				return false
+			}
+			if b, ok := n.(*ast.BinaryExpr); ok && (b.Op == token.LAND || b.Op == token.LOR) {
+				maybe[b.Y] = true
+			}
+			stack = append(stack, n)
+			return true
+		})
+	}
+	c, ok := found.(*ast.CallExpr)
+	if !ok || skippable || !ordered[c] {
+		return nil
+	}
+	return c
+}
+
+// shadowed tells why the body of callee can't go where the names are
+// declared, if one of them would hide something that the body uses,
+// or gives "".
+func (in *inliner) shadowed(callee *ast.FuncDecl, names map[string]bool) string {
+	why := ""
+	ast.Inspect(callee.Body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && why == "" {
+			if o := in.info.Objects[id]; o != nil && (o.Global || o.Kind != types.Var) && names[id.Name] {
+				why = fmt.Sprintf("%s would be shadowed", id.Name)
+			}
+		}
+		return why == ""
+	})
+	return why
+}
+
+// params gives the parameters of d, along with its receiver, if any,
+// as identifiers, which are nil for those that have no name, and
+// their types.
+func (in *inliner) params(d *ast.FuncDecl, fl *ast.FieldList) ([]*ast.Ident, []types.Type) {
+	var ids []*ast.Ident
+	var ts []types.Type
+	if fl == nil {
+		return nil, nil
+	}
+	for _, f := range fl.List {
+		t := in.info.Types[f.Type]
+		if len(f.Names) == 0 {
+			ids = append(ids, nil)
+			ts = append(ts, t)
+		}
+		for _, n := range f.Names {
+			ids = append(ids, n)
+			ts = append(ts, t)
+		}
+	}
+	return ids, ts
+}
+
+// args gives the arguments of the call c of d, with the receiver
+// first, adjusted to the type of the receiver that d has.
+func (in *inliner) args(c *ast.CallExpr, d *ast.FuncDecl, recv ast.Expr) []ast.Expr {
+	if recv == nil {
+		return c.Args
+	}
+	_, isptr := types.Underlying(in.info.Types[d.Recv.List[0].Type]).(*types.Pointer)
+	_, gotptr := types.Underlying(in.info.TypeOf(recv)).(*types.Pointer)
+	switch {
+	case isptr && !gotptr:
+		recv = &ast.UnaryExpr{Op: token.AND, X: recv}
+	case !isptr && gotptr:
+		recv = &ast.StarExpr{X: recv}
+	}
+	return append([]ast.Expr{recv}, c.Args...)
+}
+
+// expr gives the value of the call c of d, whose body is just a
+// return statement, with the parameters replaced by the arguments, or
+// nil if that isn't safe.  Each argument must be a variable, or the
+// address of one, or what one points to, so it doesn't matter how
+// often it is evaluated, and the value mustn't call anything.  The
+// value reads those variables only where the call is evaluated, so
+// ordered must already have found that nothing else in the statement
+// may run first and change them.
+func (in *inliner) expr(c *ast.CallExpr, d *ast.FuncDecl, recv ast.Expr) ast.Expr {
+	ids, ts := in.params(d, d.Recv)
+	pids, pts := in.params(d, d.Type.Params)
+	ids, ts = append(ids, pids...), append(ts, pts...)
+	args := in.args(c, d, recv)
+	value := make(map[*types.Object]ast.Expr)
+	for i, a := range args {
+		x := a
+		switch u := x.(type) {
+		case *ast.UnaryExpr:
+			if u.Op == token.AND {
+				x = u.X
+			}
+		case *ast.StarExpr:
+			x = u.X
+		}
+		id, ok := x.(*ast.Ident)
+		if !ok || ids[i] == nil || in.info.Objects[id] == nil || in.info.Objects[id].Kind != types.Var {
+			return nil
+		}
+		if t := in.info.Types[a]; t != nil && !types.Identical(t, ts[i]) {
+			return nil
+		}
+		value[in.info.Objects[ids[i]]] = a
+	}
+	result := in.single(d)
+	calls := false
+	used := make(map[*types.Object]bool)
+	ast.Inspect(result, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			calls = calls || !in.info.IsType(n.Fun)
+		case *ast.Ident:
+			used[in.info.Objects[n]] = true
+		}
+		return true
+	})
+	if calls {
+		return nil
+	}
+	for o := range value {
+		if !used[o] {
+			// The argument mustn't vanish, or its variable could
+			// go unused.
+			return nil
+		}
+	}
+	orig := make(map[ast.Node]ast.Node)
+	x := clone(result, orig).(ast.Expr)
+	return rewriteExpr(x, func(e ast.Expr) ast.Expr {
+		if id, ok := e.(*ast.Ident); ok {
+			if a, ok := value[in.info.Objects[orig[id].(*ast.Ident)]]; ok {
+				return &ast.ParenExpr{X: clone(a, orig).(ast.Expr)}
+			}
+		}
+		return e
+	})
+}
+
+// rewriteExpr is RewriteExprs for an expression that may itself be
+// replaced.
+func rewriteExpr(e ast.Expr, f func(ast.Expr) ast.Expr) ast.Expr {
+	p := &ast.ParenExpr{X: e}
+	RewriteExprs(p, f)
+	return p.X
+}
+
+// block gives the statements that run the body of d for the call c,
+// and then hand its results to store.
+func (in *inliner) block(c *ast.CallExpr, d *ast.FuncDecl, recv ast.Expr,
+	store func([]ast.Expr) []ast.Stmt) *ast.BlockStmt {
+	var out []ast.Stmt
+	// Each parameter, result and local variable gets a fresh name.
+	rename := make(map[*types.Object]string)
+	fresh := func(id *ast.Ident) string {
+		if id == nil || id.Name == "_" {
+			return tempName()
+		}
+		name := tempName()
+		rename[in.info.Objects[id]] = name
+		return name
+	}
+	ids, ts := in.params(d, d.Recv)
+	pids, pts := in.params(d, d.Type.Params)
+	ids, ts = append(ids, pids...), append(ts, pts...)
+	used := make(map[*types.Object]bool)
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			used[in.info.Objects[id]] = true
+		}
+		return true
+	})
+	for i, a := range in.args(c, d, recv) {
+		name := fresh(ids[i])
+		out = append(out, varDecl(name, ts[i], a))
+		if ids[i] == nil || !used[in.info.Objects[ids[i]]] {
+			out = append(out, assignStmt(ast.NewIdent("_"), ast.NewIdent(name)))
+		}
+	}
+	rids, rts := in.params(d, d.Type.Results)
+	var results []ast.Expr
+	for i, id := range rids {
+		name := fresh(id)
+		out = append(out, varDecl(name, rts[i], nil))
+		results = append(results, ast.NewIdent(name))
+	}
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for _, id := range n.Names {
+				fresh(id)
+			}
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, l := range n.Lhs {
+					if o := in.info.Objects[l.(*ast.Ident)]; o != nil && o.Decl == n {
+						fresh(l.(*ast.Ident))
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if n.Tok == token.DEFINE {
+				for _, e := range []ast.Expr{n.Key, n.Value} {
+					if id, ok := e.(*ast.Ident); ok {
+						fresh(id)
+					}
+				}
+			}
+		}
+		return true
+	})
+	// The variable of a type switch is named in the switch, and is
+	// a different variable in each clause.
+	renameIdent := make(map[*ast.Ident]string)
+	ast.Inspect(d.Body, func(n ast.Node) bool {
+		if ts, ok := n.(*ast.TypeSwitchStmt); ok {
+			if a, ok := ts.Assign.(*ast.AssignStmt); ok {
+				name := tempName()
+				renameIdent[a.Lhs[0].(*ast.Ident)] = name
+				for _, cc := range ts.Body.List {
+					if o, ok := in.info.Implicits[cc.(*ast.CaseClause)]; ok {
+						rename[o] = name
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	orig := make(map[ast.Node]ast.Node)
+	body := clone(d.Body, orig).(*ast.BlockStmt)
+	ast.Inspect(body, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			o := orig[id].(*ast.Ident)
+			if name, ok := rename[in.info.Objects[o]]; ok {
+				id.Name = name
+			} else if name, ok := renameIdent[o]; ok {
+				id.Name = name
+			}
+		}
+		return true
+	})
+
+	done := tempName()
+	jumps := false
+	last := body.List[len(body.List)-1:]
+	RewriteStmts(body, func(s ast.Stmt) ast.Stmt {
+		r, ok := s.(*ast.ReturnStmt)
+		if !ok {
+			return s
+		}
+		var set []ast.Stmt
+		if len(r.Results) > 0 {
+			set = append(set, &ast.AssignStmt{Lhs: results, Tok: token.ASSIGN, Rhs: r.Results})
+		}
+		if len(last) == 1 && last[0] == s {
+			// The last statement needn't jump anywhere.
+			if len(set) == 0 {
+				return &ast.EmptyStmt{Implicit: true}
+			}
+			return set[0]
+		}
+		jumps = true
+		jump := &ast.BranchStmt{Tok: token.GOTO, Label: ast.NewIdent(done)}
+		if len(set) == 0 {
+			return jump
+		}
+		return &ast.BlockStmt{List: append(set, jump)}
+	})
+	out = append(out, body)
+	after := store(results)
+	if jumps {
+		if len(after) == 0 {
+			after = []ast.Stmt{&ast.EmptyStmt{Implicit: true}}
+		}
+		after[0] = &ast.LabeledStmt{Label: ast.NewIdent(done), Stmt: after[0]}
+	}
+	return &ast.BlockStmt{List: append(out, after...)}
+}
+
+func assignStmt(lhs, rhs ast.Expr) ast.Stmt {
+	return &ast.AssignStmt{Lhs: []ast.Expr{lhs}, Tok: token.ASSIGN, Rhs: []ast.Expr{rhs}}
+}
@@ -0,0 +1,157 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// A channel is an ogo_chan, and the runtime (see runtime/ogo.h) sends
+// and receives the values of its elements through pointers, so that
+// we lower each operation to a statement expression holding the value
+// in a temporary.
+
+// chanElem is the element type of a channel x.
+func (l *lowering) chanElem(x ast.Expr) types.Type {
+	elem := types.Underlying(l.info.TypeOf(x)).(*types.Chan).Elem
+	l.needType(elem)
+	return elem
+}
+
+// waiting lowers x, the channel or the value of a channel operation,
+// which is kept while the goroutine waits, unless it is stable.
+func (l *lowering) waiting(x ast.Expr) ast.Expr {
+	if l.stable(x) {
+		return l.expr(x)
+	}
+	return l.keep(l.info.TypeOf(x), l.place(x))
+}
+
+// send lowers sending v on the channel ch.
+func (l *lowering) send(ch, v ast.Expr) ast.Expr {
+	c, tmp := ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(c, l.info.TypeOf(ch), l.waiting(ch))},
+		&ast.DeclStmt{Decl: varSpec(tmp, l.chanElem(ch), l.waiting(v))},
+		&ast.ExprStmt{X: call("ogo_chan_send", ast.NewIdent(c.Name),
+			&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(tmp.Name)})},
+	}}}
+}
+
+// recv lowers receiving from the channel ch, which gives the value
+// received, storing whether the channel was open in ok, if it isn't
+// nil.
+func (l *lowering) recv(ch, ok ast.Expr) ast.Expr {
+	tmp := ast.NewIdent(tempName())
+	var r ast.Expr = call("ogo_chan_recv", l.waiting(ch),
+		&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(tmp.Name)})
+	if ok != nil {
+		r = &ast.BinaryExpr{X: ok, Op: token.ASSIGN, Y: r}
+	}
+	elem := l.chanElem(ch)
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(tmp, elem, l.zero(elem))},
+		&ast.ExprStmt{X: r},
+		&ast.ExprStmt{X: ast.NewIdent(tmp.Name)},
+	}}}
+}
+
+// recvOk lowers the assignment to lhs of the value received from the
+// channel ch and whether it was open.
+func (l *lowering) recvOk(lhs []ast.Expr, ch ast.Expr) ast.Stmt {
+	v, ok := ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	list := []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(ok, types.Typ[types.Bool], nil)},
+		&ast.DeclStmt{Decl: varSpec(v, l.chanElem(ch), l.recv(ch, ast.NewIdent(ok.Name)))},
+	}
+	for i, val := range []*ast.Ident{v, ok} {
+		if !isBlank(lhs[i]) {
+			list = append(list, l.store(lhs[i], ast.NewIdent(val.Name)))
+		}
+	}
+	return &ast.BlockStmt{List: list}
+}
+
+// goStmt lowers a go statement, which starts a goroutine with a record
+// of the call, just like the record of a deferred call.
+func (l *lowering) goStmt(s *ast.GoStmt) ast.Stmt {
+	l.ngos++
+	name := fmt.Sprint("ogo_go", l.ngos)
+	return &ast.ExprStmt{X: call("ogo_go", ast.NewIdent(name), l.saveCall(s.Call, name, nil, nil))}
+}
+
+// selectStmt lowers a select statement to a call of ogo_select with an
+// array of its cases, followed by a chain of if statements running the
+// case it chose.  As with a type switch, we wrap it all in a switch so
+// that a break leaves it.
+func (l *lowering) selectStmt(s *ast.SelectStmt) ast.Stmt {
+	var list []ast.Stmt
+	var cases []ast.Expr
+	block := intLit(1)
+	chosen, ok := tempName(), tempName()
+	var chain ast.Stmt
+	var ifs []*ast.IfStmt
+	for _, cc := range s.Body.List {
+		cc := cc.(*ast.CommClause)
+		if cc.Comm == nil {
+			block = intLit(0)
+			chain = &ast.BlockStmt{List: l.stmts(cc.Body)}
+			continue
+		}
+		// The channels and the values to send are evaluated in order,
+		// before choosing a case.
+		var body []ast.Stmt
+		var ch, v ast.Expr
+		send := intLit(0)
+		c, tmp := ast.NewIdent(tempName()), ast.NewIdent(fmt.Sprint(chosen, "_", len(cases)))
+		switch comm := cc.Comm.(type) {
+		case *ast.SendStmt:
+			ch, v, send = comm.Chan, comm.Value, intLit(1)
+		case *ast.ExprStmt:
+			ch = types.StripParens(comm.X).(*ast.UnaryExpr).X
+		case *ast.AssignStmt:
+			if comm.Tok == token.DEFINE {
+				panic("Short variable declarations must be eliminated before lowering to C")
+			}
+			ch = types.StripParens(comm.Rhs[0]).(*ast.UnaryExpr).X
+			vals := []string{tmp.Name, ok}
+			for i, x := range comm.Lhs {
+				if !isBlank(x) {
+					body = append(body, l.store(x, ast.NewIdent(vals[i])))
+				}
+			}
+		}
+		elem := l.chanElem(ch)
+		if v == nil {
+			v = l.zero(elem)
+		} else {
+			v = l.waiting(v)
+		}
+		list = append(list,
+			&ast.DeclStmt{Decl: varSpec(c, l.info.TypeOf(ch), l.waiting(ch))},
+			&ast.DeclStmt{Decl: varSpec(tmp, elem, v)})
+		ifs = append(ifs, &ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: ast.NewIdent(chosen), Op: token.EQL, Y: intLit(int64(len(cases)))},
+			Body: &ast.BlockStmt{List: append(body, l.stmts(cc.Body)...)}})
+		cases = append(cases, &ast.CompositeLit{Elts: []ast.Expr{ast.NewIdent(c.Name),
+			&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(tmp.Name)}, send}})
+	}
+	for i := len(ifs) - 1; i >= 0; i-- {
+		ifs[i].Else = chain
+		chain = ifs[i]
+	}
+	var array ast.Expr = intLit(0)
+	if len(cases) > 0 {
+		array = &ast.CompositeLit{Type: &ast.ParenExpr{X: ast.NewIdent("ogo_select_case[]")}, Elts: cases}
+	}
+	list = append(list,
+		&ast.DeclStmt{Decl: varSpec(ast.NewIdent(ok), types.Typ[types.Bool], nil)},
+		cVar(chosen, "ogo_int", call("ogo_select", array, intLit(int64(len(cases))), block,
+			&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(ok)})))
+	if chain != nil {
+		list = append(list, chain)
+	}
+	return &ast.SwitchStmt{Tag: intLit(0), Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.CaseClause{Body: []ast.Stmt{&ast.BlockStmt{List: list}}}}}}
+}
@@ -0,0 +1,222 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// A function with defer statements has an ogo_frame (see
+// runtime/ogo.h), and its body becomes
+//
+//	ogo_frame ogo_f;
+//	ogo_enter(&ogo_f);
+//	if (setjmp(ogo_f.jmp) == 0) {
+//		...
+//	}
+//	ogo_epilogue: ogo_run_defers(&ogo_f);
+//	return ogo_result;
+//
+// where each return statement stores its result and jumps to the
+// epilogue, which a panic reaches by jumping to the frame.  A
+// function that calls recover first finds out whether it is running
+// as a deferred call, which is the only way it can recover.
+
+// inBody tells whether the body of a function holds a node for which
+// f is true, leaving out the bodies of function literals within it.
+func inBody(body *ast.BlockStmt, f func(ast.Node) bool) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		if _, islit := n.(*ast.FuncLit); islit || found {
+			return false
+		}
+		found = n != nil && f(n)
+		return !found
+	})
+	return found
+}
+
+func hasDefer(body *ast.BlockStmt) bool {
+	return inBody(body, func(n ast.Node) bool {
+		_, ok := n.(*ast.DeferStmt)
+		return ok
+	})
+}
+
+func (l *lowering) callsRecover(body *ast.BlockStmt) bool {
+	return inBody(body, func(n ast.Node) bool {
+		c, ok := n.(*ast.CallExpr)
+		return ok && l.isBuiltin(c.Fun, "recover")
+	})
+}
+
+// cVar declares a variable of the C type ctype.
+func cVar(name, ctype string, value ast.Expr) ast.Stmt {
+	spec := &ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: ast.NewIdent(ctype)}
+	if value != nil {
+		spec.Values = []ast.Expr{value}
+	}
+	return &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{spec}}}
+}
+
+// result is the variable that holds the result of a function with a
+// frame.
+func (l *lowering) result() *ast.Ident {
+	if len(l.results) == 1 {
+		return ast.NewIdent(l.results[0].Name)
+	}
+	return ast.NewIdent("ogo_result")
+}
+
+// withFrame wraps the lowered body of a function with the signature
+// sig in a frame.
+func (l *lowering) withFrame(body []ast.Stmt, sig *types.Function) []ast.Stmt {
+	frame := &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("ogo_f")}
+	out := []ast.Stmt{cVar("ogo_f", "ogo_frame", nil),
+		&ast.ExprStmt{X: call("ogo_enter", frame)},
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{Op: token.EQL, Y: intLit(0), X: call("setjmp",
+				&ast.SelectorExpr{X: ast.NewIdent("ogo_f"), Sel: ast.NewIdent("jmp")})},
+			Body: &ast.BlockStmt{List: body},
+		},
+		&ast.LabeledStmt{Label: ast.NewIdent("ogo_epilogue"),
+			Stmt: &ast.ExprStmt{X: call("ogo_run_defers", frame)}}}
+	if len(sig.Results) == 1 {
+		out = append(out, &ast.ReturnStmt{Results: []ast.Expr{l.result()}})
+	}
+	return out
+}
+
+// frameReturn lowers a return statement in a function with a frame.
+func (l *lowering) frameReturn(s *ast.ReturnStmt) ast.Stmt {
+	if len(s.Results) > 1 {
+		panic("I can't yet return multiple results in C")
+	}
+	var out []ast.Stmt
+	if len(s.Results) == 1 {
+		out = append(out, assign(l.result(), l.expr(s.Results[0])))
+	}
+	out = append(out, &ast.BranchStmt{Tok: token.GOTO, Label: ast.NewIdent("ogo_epilogue")})
+	return &ast.BlockStmt{List: out}
+}
+
+// deferStmt lowers a defer statement, which pushes a record of the
+// call onto the frame, beginning with an ogo_defer.
+func (l *lowering) deferStmt(s *ast.DeferStmt) ast.Stmt {
+	l.ndefers++
+	name := fmt.Sprint("ogo_defer", l.ndefers)
+	head := &ast.Field{Names: []*ast.Ident{ast.NewIdent("defer")}, Type: ast.NewIdent("ogo_defer")}
+	rec := l.saveCall(s.Call, name, head,
+		&ast.CompositeLit{Elts: []ast.Expr{intLit(0), ast.NewIdent(name)}})
+	return &ast.ExprStmt{X: call("ogo_push_defer",
+		&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("ogo_f")}, cast("ogo_defer*", rec))}
+}
+
+// saveCall lowers the call c of a defer or go statement, which
+// evaluates the function and its arguments at once, saving them in a
+// record on the heap, which it returns.  It generates the function
+// name, which makes the call given the record, much as a closure is
+// given its environment.  The record of a deferred call begins with
+// the field head, holding value, and its function first tells which
+// code it runs, since only that may recover.  Other calls have no head.
+func (l *lowering) saveCall(c *ast.CallExpr, name string, head *ast.Field, value ast.Expr) ast.Expr {
+	rec := name + "_rec"
+	fields := &ast.FieldList{}
+	lit := &ast.CompositeLit{Type: &ast.ParenExpr{X: ast.NewIdent(rec)}}
+	// The pointers of the record are those of the values saved, and
+	// the link to the next deferred call, at the start of the head.
+	var names []string
+	var ts []types.Type
+	if head != nil {
+		fields.List = append(fields.List, head)
+		lit.Elts = append(lit.Elts, value)
+		names, ts = append(names, head.Names[0].Name), append(ts, types.Typ[types.UnsafePointer])
+	}
+	env := make(map[*types.Object]bool)
+	// save evaluates e now, giving what refers to its value later.
+	save := func(e ast.Expr) ast.Expr {
+		if _, isconst := l.info.Values[e]; isconst || l.isNil(e) {
+			return e
+		}
+		t := l.info.TypeOf(e)
+		o := &types.Object{Kind: types.Var, Name: fmt.Sprint("a", len(env)), Type: t}
+		id := ast.NewIdent(o.Name)
+		l.info.Objects[id] = o
+		l.info.Types[id] = t
+		env[o] = true
+		l.needType(t)
+		fields.List = append(fields.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(o.Name)},
+			Type: ctype(t)})
+		names, ts = append(names, o.Name), append(ts, t)
+		lit.Elts = append(lit.Elts, l.expr(e))
+		return id
+	}
+	later := &ast.CallExpr{Fun: c.Fun, Args: make([]ast.Expr, len(c.Args)), Ellipsis: c.Ellipsis}
+	l.info.Types[later] = l.info.TypeOf(c)
+	// code is the code that the deferred call runs.
+	var code ast.Expr = intLit(0)
+	switch f := types.StripParens(c.Fun).(type) {
+	case *ast.Ident:
+		if o := l.info.Objects[f]; o != nil && o.Kind == types.Builtin {
+			break
+		} else if o != nil && o.Kind == types.Func && o.Global {
+			code = ast.NewIdent(f.Name)
+			break
+		}
+		later.Fun = save(c.Fun)
+	case *ast.SelectorExpr:
+		m := l.info.Objects[f.Sel]
+		if m == nil || m.Kind != types.Func || l.info.IsType(f.X) {
+			later.Fun = save(c.Fun)
+			break
+		}
+		// The receiver is evaluated now too, taking its address or
+		// following a pointer to it as the method needs.
+		recv, xt := f.X, l.info.TypeOf(f.X)
+		_, ptr := m.Recv.(*types.Pointer)
+		switch {
+		case types.IsInterface(xt):
+		case ptr && !types.IsPointer(xt):
+			recv = &ast.UnaryExpr{Op: token.AND, X: f.X}
+			l.info.Types[recv] = &types.Pointer{Elem: xt}
+		case !ptr && types.IsPointer(xt):
+			recv = &ast.StarExpr{X: f.X}
+			l.info.Types[recv] = types.Underlying(xt).(*types.Pointer).Elem
+		}
+		l.exposed[recv] = l.exposed[f.X]
+		if !types.IsInterface(xt) {
+			code = ast.NewIdent(methodName(m))
+		}
+		sel := &ast.SelectorExpr{X: save(recv), Sel: f.Sel}
+		l.info.Types[sel] = l.info.TypeOf(c.Fun)
+		later.Fun = sel
+	default:
+		later.Fun = save(c.Fun)
+	}
+	for i, a := range c.Args {
+		later.Args[i] = save(a)
+	}
+	// The function that makes the call may not itself recover.
+	outer, direct := l.env, l.direct
+	l.env, l.direct = env, false
+	if id, isvalue := later.Fun.(*ast.Ident); isvalue && env[l.info.Objects[id]] {
+		code = call("ogo_func_code", l.expr(later.Fun))
+	}
+	body := []ast.Stmt{cVar("ogo_env", rec+"*", cast(rec+"*", ast.NewIdent("ogo_d")))}
+	if head != nil {
+		body = append(body, assign(ast.NewIdent("ogo_deferred"), cast("void (*)(void)", code)))
+	}
+	body = append(body, l.stmt(&ast.ExprStmt{X: later}))
+	l.env, l.direct = outer, direct
+	ftype := &ast.FuncType{Params: &ast.FieldList{List: []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("ogo_d")}, Type: ast.NewIdent("void*")}}}}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype,
+		Body: &ast.BlockStmt{List: body}})
+	l.forwards = append(l.forwards, typeDecl(rec, ast.NewIdent("struct "+rec)))
+	l.types = append(l.types, typeDecl(rec, &ast.StructType{Fields: fields}))
+	gcmap := "ogo_gcmap_" + rec
+	l.gcmapTable(gcmap, rec, names, ts)
+	return call("OGO_NEW", ast.NewIdent(rec), &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(gcmap)}, lit)
+}
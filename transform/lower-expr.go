@@ -0,0 +1,913 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"strconv"
+)
+
+func call(f string, args ...ast.Expr) *ast.CallExpr {
+	return &ast.CallExpr{Fun: ast.NewIdent(f), Args: args}
+}
+
+// cast converts x to the C type t.  The parentheses around the whole
+// thing keep it in one piece whatever expression it ends up in.
+func cast(t string, x ast.Expr) ast.Expr {
+	return &ast.ParenExpr{X: &ast.CallExpr{
+		Fun: &ast.ParenExpr{X: ast.NewIdent(t)}, Args: []ast.Expr{x}}}
+}
+
+func sizeof(t types.Type) ast.Expr {
+	return call("sizeof", ctype(t))
+}
+
+func intLit(i int64) ast.Expr {
+	return &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(i, 10)}
+}
+
+// str is a C string literal holding the go string s.
+func str(s string) ast.Expr {
+	return call("OGO_STR", &ast.BasicLit{Kind: token.STRING, Value: cString(s)})
+}
+
+// cString quotes a string for C.  Bytes other than printable ASCII
+// are written as three-digit octal escapes, which can't run into the
+// following character.
+func cString(s string) string {
+	out := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '?':
+			out = append(out, '\\', c)
+		case c >= ' ' && c <= '~':
+			out = append(out, c)
+		default:
+			out = append(out, fmt.Sprintf("\\%03o", c)...)
+		}
+	}
+	return string(append(out, '"'))
+}
+
+func (l *lowering) isBuiltin(f ast.Expr, names ...string) bool {
+	id, ok := f.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	o := l.info.Objects[id]
+	if o == nil || o.Kind != types.Builtin {
+		return false
+	}
+	for _, n := range names {
+		if o.Name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// constant is the C literal for the constant v of type t.
+func (l *lowering) constant(v constant.Value, t types.Type) ast.Expr {
+	t = types.Default(t)
+	switch {
+	case types.IsBoolean(t):
+		b := "0"
+		if constant.BoolVal(v) {
+			b = "1"
+		}
+		return cast(CType(t), &ast.BasicLit{Kind: token.INT, Value: b})
+	case types.IsString(t):
+		s := str(constant.StringVal(v))
+		if _, ok := t.(*types.Named); ok {
+			return cast(CType(t), s)
+		}
+		return s
+	case types.IsInteger(t):
+		v = constant.ToInt(v)
+		lit := &ast.BasicLit{Kind: token.INT, Value: v.ExactString()}
+		if i, ok := constant.Int64Val(v); ok {
+			switch {
+			case i == math.MinInt64:
+				// C has no literal for this, since it makes it by
+				// negating 9223372036854775808, which is too big.
+				return cast(CType(t), &ast.BinaryExpr{X: intLit(math.MinInt64 + 1),
+					Op: token.SUB, Y: intLit(1)})
+			case i >= math.MinInt32 && i <= math.MaxInt32:
+				if t == types.Typ[types.Int] {
+					return lit
+				}
+			case i < 0:
+				lit.Value += "LL"
+			default:
+				lit.Value += "ULL"
+			}
+		} else {
+			lit.Value += "ULL"
+		}
+		return cast(CType(t), lit)
+	case types.IsFloat(t):
+		return cast(CType(t), floatLit(v, t))
+	case types.IsComplex(t):
+		f := "CMPLX"
+		if types.Identical(types.Underlying(t), types.Typ[types.Complex64]) {
+			f = "CMPLXF"
+		}
+		re, im := constant.Real(v), constant.Imag(v)
+		return cast(CType(t), call(f, floatLit(re, t), floatLit(im, t)))
+	}
+	panic(fmt.Sprintf("I don't know how to write a constant of type %v in C", t))
+}
+
+// floatLit is the C literal for a floating point number, which has
+// the precision of t.
+func floatLit(v constant.Value, t types.Type) ast.Expr {
+	bits := 64
+	if types.Identical(types.Underlying(t), types.Typ[types.Float32]) ||
+		types.Identical(types.Underlying(t), types.Typ[types.Complex64]) {
+		bits = 32
+	}
+	f, _ := constant.Float64Val(constant.ToFloat(v))
+	if bits == 32 {
+		f32, _ := constant.Float32Val(constant.ToFloat(v))
+		f = float64(f32)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, bits)
+	if s == "+Inf" || s == "-Inf" {
+		panic("Constant overflows " + t.String())
+	}
+	lit := &ast.BasicLit{Kind: token.FLOAT, Value: s}
+	for _, c := range s {
+		if c == '.' || c == 'e' {
+			return lit
+		}
+	}
+	lit.Value += ".0"
+	return lit
+}
+
+// zero is the zero value of type t.
+func (l *lowering) zero(t types.Type) ast.Expr {
+	switch u := types.Underlying(t).(type) {
+	case *types.Basic:
+		if u.Kind == types.String {
+			return &ast.CompositeLit{Type: &ast.ParenExpr{X: ctype(t)}}
+		}
+		return cast(CType(t), intLit(0))
+	case *types.Pointer, *types.Function, *types.Chan, *types.Map, types.TypeType:
+		return cast(CType(t), intLit(0))
+	}
+	return &ast.CompositeLit{Type: &ast.ParenExpr{X: ctype(t)}}
+}
+
+// expr lowers an expression.  It never modifies e, since the same
+// expression may be lowered twice, as with the x in x += y.
+func (l *lowering) expr(e ast.Expr) ast.Expr {
+	x := l.place(e)
+	if l.exposed[e] && !l.stable(e) {
+		return l.keep(l.info.TypeOf(e), x)
+	}
+	return x
+}
+
+// place lowers an expression that may be assigned to, or have its
+// address taken, which is an expression that we mustn't keep.
+func (l *lowering) place(e ast.Expr) ast.Expr {
+	t := l.info.TypeOf(e)
+	if v, ok := l.info.Values[e]; ok && !l.info.IsType(e) {
+		return l.constant(v, t)
+	}
+	switch e := e.(type) {
+	case *ast.Ident:
+		o := l.info.Objects[e]
+		switch {
+		case o == nil:
+		case o.Kind == types.Nil:
+			return l.zero(t)
+		case l.env[o]:
+			return envField(e.Name)
+		case o.Kind == types.Func && o.Global:
+			return l.funcValue(e.Name, t.(*types.Function))
+		}
+		return ast.NewIdent(e.Name)
+	case *ast.FuncLit:
+		return l.funcLit(e)
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{X: l.place(e.X)}
+	case *ast.CompositeLit:
+		return l.compositeLit(e, t)
+	case *ast.SelectorExpr:
+		if o := l.info.Objects[e.Sel]; o != nil && o.Kind == types.Func {
+			panic("Method values must be eliminated before lowering to C")
+		}
+		if types.IsPointer(l.info.TypeOf(e.X)) {
+			return &ast.SelectorExpr{X: &ast.StarExpr{X: l.expr(e.X)}, Sel: ast.NewIdent(e.Sel.Name)}
+		}
+		return &ast.SelectorExpr{X: l.place(e.X), Sel: ast.NewIdent(e.Sel.Name)}
+	case *ast.IndexExpr:
+		if l.isMapIndex(e) {
+			return l.mapIndex(e, nil)
+		}
+		xt := l.info.TypeOf(e.X)
+		a, isarray := types.ArrayOf(xt)
+		_, isconst := l.info.Values[e.Index]
+		// The type checker has checked constant indices into arrays.
+		check := !l.inbounds[e] && !(isarray && isconst)
+		if check {
+			logf(e.Pos(), "checking the index of %s", goString(e))
+		}
+		x, i := l.operand(e.X), l.index(e.Index)
+		switch {
+		case types.IsString(xt) && check:
+			return call("ogo_string_index", x, i)
+		case types.IsString(xt):
+			return call("ogo_string_at", x, i)
+		case isarray && check:
+			i = call("ogo_check_index", i, intLit(a.Len))
+			fallthrough
+		case isarray:
+			return &ast.IndexExpr{X: l.elems(x, xt), Index: i}
+		case check:
+			return &ast.StarExpr{X: cast(CType(t)+"*", call("ogo_slice_index", x, i, sizeof(t)))}
+		}
+		return &ast.StarExpr{X: cast(CType(t)+"*", call("ogo_slice_at", x, i, sizeof(t)))}
+	case *ast.SliceExpr:
+		x := l.operand(e.X)
+		lo, hi := intLit(0), ast.Expr(ast.NewIdent("OGO_NOINDEX"))
+		if e.Low != nil {
+			lo = l.index(e.Low)
+		}
+		if e.High != nil {
+			hi = l.index(e.High)
+		}
+		xt := l.info.TypeOf(e.X)
+		if types.IsString(xt) {
+			return call("ogo_string_slice", x, lo, hi)
+		}
+		var size ast.Expr
+		if a, ok := types.ArrayOf(xt); ok {
+			size = sizeof(a.Elem)
+			x = &ast.CompositeLit{Type: &ast.ParenExpr{X: ast.NewIdent("ogo_slice")},
+				Elts: []ast.Expr{l.elems(x, xt), intLit(a.Len), intLit(a.Len)}}
+		} else {
+			size = sizeof(types.Underlying(xt).(*types.Slice).Elem)
+		}
+		if e.Slice3 {
+			return call("ogo_slice_slice3", x, lo, hi, l.index(e.Max), size)
+		}
+		return call("ogo_slice_slice", x, lo, hi, size)
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: l.expr(e.X)}
+	case *ast.TypeAssertExpr:
+		return l.assertion(e)
+	case *ast.UnaryExpr:
+		switch e.Op {
+		case token.AND:
+			if lit, ok := types.StripParens(e.X).(*ast.CompositeLit); ok {
+				return l.new(l.info.TypeOf(lit), l.expr(lit))
+			}
+		case token.SUB:
+			if t := l.info.TypeOf(e); types.IsInteger(t) && wraps(t) {
+				return cast(CType(t), &ast.BinaryExpr{X: intLit(0), Op: token.SUB,
+					Y: cast("uint64_t", l.expr(e.X))})
+			}
+		case token.XOR:
+			x := &ast.UnaryExpr{Op: token.TILDE, X: l.expr(e.X)}
+			if t := l.info.TypeOf(e); width(t) < 32 {
+				return cast(CType(t), x)
+			}
+			return x
+		case token.ARROW:
+			return l.recv(e.X, nil)
+		}
+		if e.Op == token.AND {
+			return &ast.UnaryExpr{Op: e.Op, X: l.place(e.X)}
+		}
+		return &ast.UnaryExpr{Op: e.Op, X: l.expr(e.X)}
+	case *ast.BinaryExpr:
+		return l.binary(e)
+	case *ast.CallExpr:
+		return l.call(e)
+	}
+	panic(fmt.Sprintf("I can't yet lower expressions of type %T to C", e))
+}
+
+// operand lowers x, which is indexed or sliced, and which is a place if
+// it is an array.
+func (l *lowering) operand(x ast.Expr) ast.Expr {
+	if types.IsArray(l.info.TypeOf(x)) {
+		return l.place(x)
+	}
+	return l.expr(x)
+}
+
+// elems is the C array holding the elements of x, which is an array or
+// a pointer to one.
+func (l *lowering) elems(x ast.Expr, t types.Type) ast.Expr {
+	if types.IsPointer(t) {
+		x = &ast.StarExpr{X: x}
+	}
+	return &ast.SelectorExpr{X: x, Sel: ast.NewIdent("a")}
+}
+
+// index lowers an index, which C wants as an ogo_int.
+func (l *lowering) index(e ast.Expr) ast.Expr {
+	x := l.expr(e)
+	if t := l.info.TypeOf(e); !types.Identical(t, types.Typ[types.Int]) &&
+		!types.IsUntyped(t) {
+		return cast("ogo_int", x)
+	}
+	return x
+}
+
+// new allocates a value of type t on the heap, initialized to v.
+func (l *lowering) new(t types.Type, v ast.Expr) ast.Expr {
+	return call("OGO_NEW", ctype(t), l.gcmap(t), v)
+}
+
+func (l *lowering) binary(e *ast.BinaryExpr) ast.Expr {
+	if e.Op == token.LAND || e.Op == token.LOR {
+		// C runs the right operand after the left.
+		return &ast.BinaryExpr{X: l.expr(e.X), Op: e.Op, Y: l.expr(e.Y)}
+	}
+	es := []ast.Expr{e.X, e.Y}
+	temps := l.inOrder(es)
+	return sequence(temps, l.orderedBinary(e, es[0], es[1]))
+}
+
+// orderedBinary lowers e, whose operands, in order, are ex and ey.
+func (l *lowering) orderedBinary(e *ast.BinaryExpr, ex, ey ast.Expr) ast.Expr {
+	xt, yt := l.info.TypeOf(e.X), l.info.TypeOf(e.Y)
+	x, y := l.expr(ex), l.expr(ey)
+	switch {
+	case types.IsString(xt) && types.IsString(yt):
+		switch e.Op {
+		case token.ADD:
+			return call("ogo_string_concat", x, y)
+		case token.EQL:
+			return call("ogo_string_eq", x, y)
+		case token.NEQ:
+			return &ast.UnaryExpr{Op: token.NOT, X: call("ogo_string_eq", x, y)}
+		}
+		return &ast.BinaryExpr{X: call("ogo_string_cmp", x, y), Op: e.Op, Y: intLit(0)}
+	case l.isNil(e.X) || l.isNil(e.Y):
+		if l.isNil(e.X) {
+			x, xt = y, yt
+		}
+		return &ast.BinaryExpr{X: isNil(x, xt), Op: e.Op, Y: intLit(0)}
+	case (e.Op == token.EQL || e.Op == token.NEQ) && types.Identical(xt, yt):
+		eq := l.equal(xt, x, y)
+		if e.Op == token.NEQ {
+			return &ast.UnaryExpr{Op: token.NOT, X: eq}
+		}
+		return eq
+	case e.Op == token.AND_NOT:
+		return &ast.BinaryExpr{X: x, Op: token.AND, Y: &ast.UnaryExpr{Op: token.TILDE, X: y}}
+	}
+	return l.arith(e.Op, l.info.TypeOf(e), x, ey, y)
+}
+
+// arith lowers x op y, an operation on values of type t, where x and
+// y are already lowered and e is the right operand before lowering.
+// Go's integers wrap around where C's signed integers may overflow,
+// and C works out the small integers as int, so arith does the
+// arithmetic of those types on uint64_t and converts the result back.
+// A division checks its divisor, and the quotient of the most negative
+// integer and -1 wraps around, while a shift by the width of t or more
+// gives 0, or -1 for a negative x shifted right, and a shift by a
+// negative count panics.
+func (l *lowering) arith(op token.Token, t types.Type, x, e, y ast.Expr) ast.Expr {
+	plain := &ast.BinaryExpr{X: x, Op: op, Y: y}
+	if !types.IsInteger(t) {
+		return plain
+	}
+	ct := CType(t)
+	n, isconst := int64(0), false
+	if v, ok := l.info.Values[e]; ok {
+		n, isconst = constant.Int64Val(constant.ToInt(v))
+	}
+	switch op {
+	case token.ADD, token.SUB, token.MUL:
+		if wraps(t) {
+			return cast(ct, &ast.BinaryExpr{X: cast("uint64_t", x), Op: op, Y: cast("uint64_t", y)})
+		}
+	case token.QUO, token.REM:
+		switch {
+		case isconst && n != -1:
+		case types.IsUnsigned(t):
+			plain.Y = call("OGO_DIVISOR", y)
+		case op == token.QUO:
+			return call("OGO_QUO", ast.NewIdent(ct), x, y)
+		default:
+			return call("OGO_REM", ast.NewIdent(ct), x, y)
+		}
+	case token.SHL, token.SHR:
+		switch {
+		case !isconst || n >= int64(width(t)):
+			if op == token.SHL {
+				return call("OGO_SHL", ast.NewIdent(ct), x, y)
+			}
+			return call("OGO_SHR", ast.NewIdent(ct), x, y)
+		case op == token.SHL && wraps(t):
+			return cast(ct, &ast.BinaryExpr{X: cast("uint64_t", x), Op: op, Y: y})
+		}
+	}
+	return plain
+}
+
+// wraps is true if C's arithmetic on the integer type t may not wrap
+// around as Go's does.
+func wraps(t types.Type) bool {
+	return !types.IsUnsigned(t) || width(t) < 32
+}
+
+// isNil gives the part of x, a value of type t, that is 0 if x is nil.
+func isNil(x ast.Expr, t types.Type) ast.Expr {
+	switch {
+	case types.IsSlice(t):
+		return &ast.SelectorExpr{X: x, Sel: ast.NewIdent("ptr")}
+	case types.IsSealed(t):
+		return tag(x)
+	case types.IsInterface(t):
+		return &ast.SelectorExpr{X: x, Sel: ast.NewIdent("itab")}
+	}
+	return x
+}
+
+// isNil tells whether e is nil, or a conversion of nil.
+func (l *lowering) isNil(e ast.Expr) bool {
+	switch e := types.StripParens(e).(type) {
+	case *ast.Ident:
+		return l.info.Objects[e] != nil && l.info.Objects[e].Kind == types.Nil
+	case *ast.CallExpr:
+		return l.info.IsType(e.Fun) && l.isNil(e.Args[0])
+	}
+	return false
+}
+
+// equal is a C expression telling whether x and y, which are values
+// of type t, are equal.
+func (l *lowering) equal(t types.Type, x, y ast.Expr) ast.Expr {
+	switch types.Underlying(t).(type) {
+	case *types.Array, *types.Struct:
+		return call(l.equalFunc(t), x, y)
+	case *types.Interface:
+		if types.IsSealed(t) {
+			return call(l.sealedEqualFunc(t), x, y)
+		}
+		return call("ogo_iface_eq", x, y)
+	}
+	if types.IsString(t) {
+		return call("ogo_string_eq", x, y)
+	}
+	return &ast.BinaryExpr{X: x, Op: token.EQL, Y: y}
+}
+
+// equalFunc generates a function comparing two arrays or structs of
+// type t element by element, and returns its name.
+func (l *lowering) equalFunc(t types.Type) string {
+	name := "ogo_equal_" + mangle(cbase(t))
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	l.needType(t)
+	id := ast.NewIdent
+	var body []ast.Stmt
+	switch u := types.Underlying(t).(type) {
+	case *types.Array:
+		// for (i = 0; i < len; i++) if (!(a[i] == b[i])) return 0;
+		elem := func(v string) ast.Expr {
+			return &ast.IndexExpr{X: &ast.SelectorExpr{X: id(v), Sel: id("a")}, Index: id("i")}
+		}
+		body = []ast.Stmt{
+			&ast.DeclStmt{Decl: varSpec(id("i"), types.Typ[types.Int], intLit(0))},
+			&ast.ForStmt{
+				Cond: &ast.BinaryExpr{X: id("i"), Op: token.LSS, Y: intLit(u.Len)},
+				Post: &ast.IncDecStmt{X: id("i"), Tok: token.INC},
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.IfStmt{
+					Cond: &ast.UnaryExpr{Op: token.NOT,
+						X: l.equal(u.Elem, elem("a"), elem("b"))},
+					Body: &ast.BlockStmt{List: []ast.Stmt{
+						&ast.ReturnStmt{Results: []ast.Expr{intLit(0)}}}},
+				}}},
+			},
+			&ast.ReturnStmt{Results: []ast.Expr{intLit(1)}},
+		}
+	case *types.Struct:
+		// return a.x == b.x && a.y == b.y && ...;
+		var eq ast.Expr
+		for _, f := range u.Fields {
+			if f.Name == "_" {
+				continue
+			}
+			feq := l.equal(f.Type, &ast.SelectorExpr{X: id("a"), Sel: id(f.Name)},
+				&ast.SelectorExpr{X: id("b"), Sel: id(f.Name)})
+			if eq == nil {
+				eq = feq
+			} else {
+				eq = &ast.BinaryExpr{X: eq, Op: token.LAND, Y: feq}
+			}
+		}
+		if eq == nil {
+			eq = intLit(1)
+		}
+		body = []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{eq}}}
+	}
+	params := &ast.FieldList{List: []*ast.Field{
+		{Names: []*ast.Ident{id("a"), id("b")}, Type: ctype(t)}}}
+	ftype := &ast.FuncType{Params: params, Results: &ast.FieldList{
+		List: []*ast.Field{{Type: id("ogo_bool")}}}}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: id(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: id(name), Type: ftype,
+		Body: &ast.BlockStmt{List: body}})
+	return name
+}
+
+func (l *lowering) call(e *ast.CallExpr) ast.Expr {
+	if l.info.IsType(e.Fun) {
+		return l.conversion(l.info.Types[e.Fun], e.Args[0])
+	}
+	// The function, or the receiver of a method, comes before the
+	// arguments.
+	c := *e
+	l.info.Types[&c] = l.info.Types[e]
+	es := append([]ast.Expr{nil}, e.Args...)
+	sel, _ := types.StripParens(e.Fun).(*ast.SelectorExpr)
+	m := l.method(sel)
+	switch {
+	case m == nil:
+		es[0] = e.Fun
+	case l.info.IsType(sel.X):
+	case !types.IsPointer(m.Recv) || types.IsPointer(l.info.TypeOf(sel.X)):
+		// A receiver whose address is taken can't be copied.
+		es[0] = sel.X
+	}
+	temps := l.inOrder(es)
+	switch {
+	case m == nil:
+		c.Fun = es[0]
+	case es[0] != nil:
+		c.Fun = &ast.SelectorExpr{X: es[0], Sel: sel.Sel}
+	}
+	c.Args = es[1:]
+	return sequence(temps, l.orderedCall(&c))
+}
+
+// method is the method that sel selects, if it does.
+func (l *lowering) method(sel *ast.SelectorExpr) *types.Object {
+	if sel == nil {
+		return nil
+	}
+	if m := l.info.Objects[sel.Sel]; m != nil && m.Kind == types.Func {
+		return m
+	}
+	return nil
+}
+
+// inOrder evaluates into temporaries those of the operands es whose
+// calls and receives must run before others', since Go runs them from
+// left to right where C evaluates operands in any order: all but the
+// last of those that have any.  It puts each temporary in es in place
+// of its operand, and gives the declarations of the temporaries.
+func (l *lowering) inOrder(es []ast.Expr) []ast.Stmt {
+	var calls []int
+	for i, e := range es {
+		if e != nil && l.collects(e) {
+			calls = append(calls, i)
+		}
+	}
+	if len(calls) < 2 {
+		return nil
+	}
+	var temps []ast.Stmt
+	for _, i := range calls[:len(calls)-1] {
+		t, tmp := l.info.TypeOf(es[i]), tempName()
+		temps = append(temps, &ast.DeclStmt{Decl: varSpec(ast.NewIdent(tmp), t, l.expr(es[i]))})
+		es[i] = l.typed(tmp, t)
+	}
+	return temps
+}
+
+// sequence is x, evaluated after the statements list, if there are any.
+func sequence(list []ast.Stmt, x ast.Expr) ast.Expr {
+	if len(list) == 0 {
+		return x
+	}
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{
+		List: append(list, &ast.ExprStmt{X: x})}}
+}
+
+// orderedCall lowers the call e, whose operands are already in order.
+func (l *lowering) orderedCall(e *ast.CallExpr) ast.Expr {
+	switch f := types.StripParens(e.Fun).(type) {
+	case *ast.Ident:
+		o := l.info.Objects[f]
+		if o != nil && o.Kind == types.Builtin {
+			return l.builtin(e, o.Name)
+		}
+		if o != nil && o.Kind == types.Func && o.Global {
+			sig := o.Type.(*types.Function)
+			return &ast.CallExpr{Fun: ast.NewIdent(f.Name), Args: l.args(e, sig)}
+		}
+	case *ast.SelectorExpr:
+		if m := l.info.Objects[f.Sel]; m != nil && m.Kind == types.Func {
+			if l.info.IsType(f.X) {
+				panic("Method expressions must be eliminated before lowering to C")
+			}
+			return l.methodCall(e, f, m)
+		}
+	}
+	return l.callValue(e, types.Underlying(l.info.TypeOf(e.Fun)).(*types.Function))
+}
+
+// args lowers the arguments of a call to a function with signature
+// sig.
+func (l *lowering) args(e *ast.CallExpr, sig *types.Function) []ast.Expr {
+	if len(e.Args) == 1 {
+		if _, ok := l.info.TypeOf(e.Args[0]).(*types.Tuple); ok {
+			panic("I can't yet pass multiple results to a function in C")
+		}
+	}
+	args := make([]ast.Expr, 0, len(e.Args))
+	for i, a := range e.Args {
+		if sig.Variadic && i == len(sig.Parameters)-1 && !e.Ellipsis.IsValid() {
+			// The variadic arguments are passed in a slice, which we
+			// keep if they need keeping.
+			s := l.sliceLit(sig.Parameters[i], e.Args[i:])
+			for _, v := range e.Args[i:] {
+				if l.exposed[v] {
+					s = l.keep(sig.Parameters[i], s)
+					break
+				}
+			}
+			args = append(args, s)
+			break
+		}
+		args = append(args, l.expr(a))
+	}
+	if sig.Variadic && len(e.Args) < len(sig.Parameters) {
+		args = append(args, l.zero(sig.Parameters[len(sig.Parameters)-1]))
+	}
+	return args
+}
+
+func (l *lowering) conversion(to types.Type, arg ast.Expr) ast.Expr {
+	from := l.info.TypeOf(arg)
+	if l.info.IsType(arg) {
+		// A type used as a value is a pointer to its descriptor.
+		return l.typeDesc(from)
+	}
+	x := l.expr(arg)
+	switch {
+	case types.IsInterface(to):
+		return l.toInterface(from, to, x)
+	case types.IsString(to) && types.IsInteger(from):
+		return call("ogo_string_from_rune", x)
+	case types.IsString(to) && types.IsSlice(from):
+		if types.IsInteger(types.Underlying(from).(*types.Slice).Elem) &&
+			types.Underlying(from).(*types.Slice).Elem.Size() == 1 {
+			return call("ogo_string_from_bytes", x)
+		}
+		return call("ogo_string_from_runes", x)
+	case types.IsSlice(to) && types.IsString(from):
+		if types.Underlying(to).(*types.Slice).Elem.Size() == 1 {
+			return call("ogo_bytes_from_string", x)
+		}
+		return call("ogo_runes_from_string", x)
+	case cbase(from) == cbase(to):
+		return x
+	case isScalar(from) && isScalar(to):
+		return cast(CType(to), x)
+	}
+	// The two types must have identical underlying types, so we
+	// reinterpret the bits.
+	return call("OGO_CONVERT", ctype(to), x)
+}
+
+func isScalar(t types.Type) bool {
+	return types.IsNumeric(t) || types.IsBoolean(t) || types.IsPointer(t)
+}
+
+func (l *lowering) builtin(e *ast.CallExpr, name string) ast.Expr {
+	args := e.Args
+	switch name {
+	case "len", "cap":
+		if a, ok := types.ArrayOf(l.info.TypeOf(args[0])); ok {
+			// The type checker only leaves the length of an array
+			// unknown when finding the array has side effects.
+			return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{
+				List: []ast.Stmt{l.discard(args[0]), &ast.ExprStmt{X: intLit(a.Len)}}}}
+		}
+		if types.IsChan(l.info.TypeOf(args[0])) {
+			return call("ogo_chan_"+name, l.expr(args[0]))
+		}
+		if types.IsMap(l.info.TypeOf(args[0])) {
+			return call("ogo_map_len", l.expr(args[0]))
+		}
+		return &ast.SelectorExpr{X: l.expr(args[0]), Sel: ast.NewIdent(name)}
+	case "close":
+		return call("ogo_chan_close", l.expr(args[0]))
+	case "delete":
+		return l.mapDelete(args[0], args[1])
+	case "panic":
+		return call("ogo_panic", l.expr(args[0]))
+	case "recover":
+		direct := ast.NewIdent("ogo_direct")
+		if !l.direct {
+			direct.Name = "0"
+		}
+		return call("ogo_recover", direct)
+	case "new":
+		t := l.info.Types[args[0]]
+		if !l.info.IsType(args[0]) {
+			return call("ogo_new", l.expr(args[0]), l.typeDesc(&types.Interface{}))
+		}
+		l.needType(t)
+		return cast(CType(t)+"*", call("ogo_alloc", sizeof(t), l.gcmap(t)))
+	case "typeof":
+		t := l.info.TypeOf(args[0])
+		if types.IsSealed(t) {
+			return call("ogo_typeof", l.sealedToInterface(t, &types.Interface{}, l.expr(args[0])))
+		}
+		if types.IsInterface(t) {
+			return call("ogo_typeof", l.expr(args[0]))
+		}
+		return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{
+			List: []ast.Stmt{l.discard(args[0]), &ast.ExprStmt{X: l.typeDesc(t)}}}}
+	case "make":
+		t := l.info.Types[args[0]]
+		if ch, ok := types.Underlying(t).(*types.Chan); ok {
+			size := ast.Expr(ast.NewIdent("OGO_NOINDEX"))
+			if len(args) > 1 {
+				size = l.index(args[1])
+			}
+			l.needType(ch.Elem)
+			return call("ogo_make_chan", sizeof(ch.Elem), size, l.gcmap(ch.Elem))
+		}
+		if types.IsMap(t) {
+			hint := intLit(0)
+			if len(args) > 1 {
+				hint = l.index(args[1])
+			}
+			return l.makeMap(t, hint)
+		}
+		elem := types.Underlying(t).(*types.Slice).Elem
+		capacity := ast.Expr(ast.NewIdent("OGO_NOINDEX"))
+		if len(args) > 2 {
+			capacity = l.index(args[2])
+		}
+		return call("ogo_make_slice", sizeof(elem), l.index(args[1]), capacity, l.gcmap(elem))
+	case "append":
+		s := l.expr(args[0])
+		elem := types.Underlying(l.info.TypeOf(args[0])).(*types.Slice).Elem
+		switch {
+		case e.Ellipsis.IsValid() && types.IsString(l.info.TypeOf(args[1])):
+			return call("ogo_append_string", s, l.expr(args[1]))
+		case e.Ellipsis.IsValid():
+			return call("ogo_append_slice", s, l.expr(args[1]), sizeof(elem), l.gcmap(elem))
+		case len(args) == 1:
+			return s
+		}
+		return call("ogo_append", s, l.array(elem, args[1:]),
+			intLit(int64(len(args)-1)), sizeof(elem), l.gcmap(elem))
+	case "copy":
+		dst, src := l.expr(args[0]), l.expr(args[1])
+		if types.IsString(l.info.TypeOf(args[1])) {
+			return call("ogo_copy_string", dst, src)
+		}
+		elem := types.Underlying(l.info.TypeOf(args[0])).(*types.Slice).Elem
+		return call("ogo_copy_slice", dst, src, sizeof(elem))
+	case "real", "imag":
+		f := "creal"
+		if name == "imag" {
+			f = "cimag"
+		}
+		return cast(CType(l.info.TypeOf(e)), call(f, l.expr(args[0])))
+	case "complex":
+		f := "CMPLX"
+		if types.Identical(l.info.TypeOf(e), types.Typ[types.Complex64]) {
+			f = "CMPLXF"
+		}
+		return call(f, l.expr(args[0]), l.expr(args[1]))
+	}
+	panic("I can't yet lower the builtin " + name + " to C")
+}
+
+// array is a C array literal holding the elements es of type elem.
+func (l *lowering) array(elem types.Type, es []ast.Expr) ast.Expr {
+	l.needType(elem)
+	return &ast.CompositeLit{Type: &ast.ParenExpr{X: &ast.ArrayType{Elt: ctype(elem)}},
+		Elts: l.elements(es)}
+}
+
+// elements lowers the elements of an array or slice literal, whose
+// keys become C designators.
+func (l *lowering) elements(es []ast.Expr) []ast.Expr {
+	elts := make([]ast.Expr, len(es))
+	for i, x := range es {
+		if kv, ok := x.(*ast.KeyValueExpr); ok {
+			k, _ := constant.Int64Val(l.info.Values[kv.Key])
+			elts[i] = &ast.KeyValueExpr{Key: ast.NewIdent(fmt.Sprint("[", k, "]")),
+				Value: l.expr(kv.Value)}
+		} else {
+			elts[i] = l.expr(x)
+		}
+	}
+	return elts
+}
+
+// sliceLit creates a slice of type t holding the elements es, which
+// may have keys.
+func (l *lowering) sliceLit(t types.Type, es []ast.Expr) ast.Expr {
+	elem := types.Underlying(t).(*types.Slice).Elem
+	n, i := int64(0), int64(0)
+	for _, x := range es {
+		if kv, ok := x.(*ast.KeyValueExpr); ok {
+			i, _ = constant.Int64Val(l.info.Values[kv.Key])
+		}
+		i++
+		if i > n {
+			n = i
+		}
+	}
+	if n == 0 {
+		return call("ogo_make_slice", sizeof(elem), intLit(0), intLit(0), l.gcmap(elem))
+	}
+	arr := l.array(elem, es)
+	if int64(len(es)) < n {
+		// Make sure C gives the array its full length.
+		arr.(*ast.CompositeLit).Type = &ast.ParenExpr{
+			X: &ast.ArrayType{Len: intLit(n), Elt: ctype(elem)}}
+	}
+	return call("ogo_slice_lit", arr, intLit(n), sizeof(elem), l.gcmap(elem))
+}
+
+func (l *lowering) compositeLit(e *ast.CompositeLit, t types.Type) ast.Expr {
+	if types.IsMap(t) {
+		// A map literal stores its elements in order.
+		return l.mapLit(e, t)
+	}
+	values := make([]ast.Expr, len(e.Elts))
+	for i, x := range e.Elts {
+		values[i] = x
+		if kv, ok := x.(*ast.KeyValueExpr); ok {
+			values[i] = kv.Value
+		}
+	}
+	temps := l.inOrder(values)
+	if temps == nil {
+		return l.orderedLit(e, t)
+	}
+	lit := *e
+	lit.Elts = make([]ast.Expr, len(e.Elts))
+	for i, x := range e.Elts {
+		lit.Elts[i] = values[i]
+		if kv, ok := x.(*ast.KeyValueExpr); ok {
+			lit.Elts[i] = &ast.KeyValueExpr{Key: kv.Key, Value: values[i]}
+		}
+	}
+	l.info.Types[&lit] = l.info.Types[e]
+	return sequence(temps, l.orderedLit(&lit, t))
+}
+
+// orderedLit lowers the composite literal e, whose elements are
+// already in order.
+func (l *lowering) orderedLit(e *ast.CompositeLit, t types.Type) ast.Expr {
+	if p, ok := types.Underlying(t).(*types.Pointer); ok {
+		// An elided &T in a composite literal
+		lit := *e
+		l.info.Types[&lit] = p.Elem
+		return l.new(p.Elem, l.compositeLit(&lit, p.Elem))
+	}
+	switch u := types.Underlying(t).(type) {
+	case *types.Slice:
+		return l.sliceLit(t, e.Elts)
+	case *types.Map:
+		return l.mapLit(e, t)
+	case *types.Array:
+		l.needType(t)
+		a := &ast.KeyValueExpr{Key: ast.NewIdent(".a"),
+			Value: &ast.CompositeLit{Elts: l.elements(e.Elts)}}
+		return &ast.CompositeLit{Type: &ast.ParenExpr{X: ctype(t)}, Elts: []ast.Expr{a}}
+	case *types.Struct:
+		l.needType(t)
+		elts := make([]ast.Expr, len(e.Elts))
+		for i, x := range e.Elts {
+			if kv, ok := x.(*ast.KeyValueExpr); ok {
+				elts[i] = &ast.KeyValueExpr{
+					Key:   ast.NewIdent("." + kv.Key.(*ast.Ident).Name),
+					Value: l.expr(kv.Value)}
+			} else {
+				elts[i] = &ast.KeyValueExpr{
+					Key:   ast.NewIdent("." + u.Fields[i].Name),
+					Value: l.expr(x)}
+			}
+		}
+		return &ast.CompositeLit{Type: &ast.ParenExpr{X: ctype(t)}, Elts: elts}
+	}
+	panic(fmt.Sprintf("I can't yet lower composite literals of type %v to C", t))
+}
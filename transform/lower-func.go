@@ -0,0 +1,145 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// A func value is an ogo_func, which points to a closure (see
+// runtime/ogo.h).  We lift each function literal into a C function
+// that takes the closure as its first parameter, and finds the
+// variables it captures in the environment that follows the
+// ogo_closure.  BoxCaptured has already moved each captured variable
+// that may change into a cell on the heap, so that the environment may
+// hold a copy of every variable.
+
+// envField refers to the captured variable named name within the
+// function literal being lowered.
+func envField(name string) ast.Expr {
+	return &ast.SelectorExpr{X: &ast.ParenExpr{X: &ast.StarExpr{X: ast.NewIdent("ogo_env")}},
+		Sel: ast.NewIdent(name)}
+}
+
+// codeType is the C type of a pointer to the code of a func value with
+// signature sig.
+func codeType(sig *types.Function) string {
+	params := []string{"ogo_func"}
+	for _, p := range sig.Parameters {
+		params = append(params, CType(p))
+	}
+	switch len(sig.Results) {
+	case 0:
+		return "void (*)(" + strings.Join(params, ", ") + ")"
+	case 1:
+		return CType(sig.Results[0]) + " (*)(" + strings.Join(params, ", ") + ")"
+	}
+	panic("I can't yet lower functions with multiple results to C")
+}
+
+// funcLit lifts a function literal into a C function, and returns a
+// closure for it, holding the variables that it captures.
+func (l *lowering) funcLit(e *ast.FuncLit) ast.Expr {
+	sig := types.Underlying(l.info.TypeOf(e)).(*types.Function)
+	l.nlits++
+	name := fmt.Sprint("ogo_lit", l.nlits)
+	caps := l.captures[e]
+	d := &ast.FuncDecl{Name: ast.NewIdent(name), Type: e.Type, Body: e.Body}
+	l.info.Types[d.Name] = sig
+	results, env, frame, direct := l.results, l.env, l.frame, l.direct
+	nroots, maxroots := l.nroots, l.maxroots
+	l.env = make(map[*types.Object]bool)
+	for _, o := range caps {
+		l.env[o] = true
+	}
+	l.funcDecl(d, true)
+	l.results, l.env, l.frame, l.direct = results, env, frame, direct
+	l.nroots, l.maxroots = nroots, maxroots
+	l.protos = append(l.protos, &ast.FuncDecl{Name: d.Name, Type: d.Type})
+	l.helpers = append(l.helpers, d)
+	code := cast("void (*)(void)", ast.NewIdent(name))
+	if len(caps) == 0 {
+		closure := name + "_closure"
+		l.table(closure, ast.NewIdent("ogo_closure"), &ast.CompositeLit{Elts: []ast.Expr{code}})
+		return cast("ogo_func", &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(closure)})
+	}
+	// The environment is a struct beginning with the closure.
+	envType := name + "_env"
+	fields := &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("closure")},
+		Type: ast.NewIdent("ogo_closure")}}}
+	value := &ast.CompositeLit{Type: &ast.ParenExpr{X: ast.NewIdent(envType)},
+		Elts: []ast.Expr{&ast.CompositeLit{Elts: []ast.Expr{code}}}}
+	var names []string
+	var ts []types.Type
+	for _, o := range caps {
+		names, ts = append(names, o.Name), append(ts, o.Type)
+		l.needType(o.Type)
+		fields.List = append(fields.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(o.Name)},
+			Type: ctype(o.Type)})
+		id := ast.NewIdent(o.Name)
+		l.info.Objects[id] = o
+		value.Elts = append(value.Elts, l.expr(id))
+	}
+	l.forwards = append(l.forwards, typeDecl(envType, ast.NewIdent("struct "+envType)))
+	l.types = append(l.types, typeDecl(envType, &ast.StructType{Fields: fields}))
+	d.Body.List = append([]ast.Stmt{cVar("ogo_env", envType+"*",
+		cast(envType+"*", ast.NewIdent("ogo_ctx")))}, d.Body.List...)
+	gcmap := "ogo_gcmap_" + envType
+	l.gcmapTable(gcmap, envType, names, ts)
+	return cast("ogo_func", call("OGO_NEW", ast.NewIdent(envType),
+		&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(gcmap)}, value))
+}
+
+// funcValue is the func value of the top-level function name, whose
+// static closure has code that calls the function.
+func (l *lowering) funcValue(name string, sig *types.Function) ast.Expr {
+	closure := "ogo_closure_" + name
+	if !l.generated[closure] {
+		l.generated[closure] = true
+		code := "ogo_code_" + name
+		params := &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("ogo_ctx")},
+			Type: ast.NewIdent("ogo_func")}}}
+		var args []ast.Expr
+		for i, t := range sig.Parameters {
+			p := ast.NewIdent(fmt.Sprint("p", i))
+			params.List = append(params.List, &ast.Field{Names: []*ast.Ident{p}, Type: ctype(t)})
+			args = append(args, p)
+		}
+		var body []ast.Stmt
+		if l.recovers[name] {
+			// A deferred call of the func value may recover.
+			body = append(body, &ast.ExprStmt{X: call("ogo_forward_deferred",
+				cast("void (*)(void)", ast.NewIdent(code)), cast("void (*)(void)", ast.NewIdent(name)))})
+		}
+		var results *ast.FieldList
+		switch len(sig.Results) {
+		case 0:
+			body = append(body, &ast.ExprStmt{X: call(name, args...)})
+		case 1:
+			results = &ast.FieldList{List: []*ast.Field{{Type: ctype(sig.Results[0])}}}
+			body = append(body, &ast.ReturnStmt{Results: []ast.Expr{call(name, args...)}})
+		default:
+			panic("I can't yet lower functions with multiple results to C")
+		}
+		ftype := &ast.FuncType{Params: params, Results: results}
+		l.protos = append(l.protos, &ast.FuncDecl{Name: ast.NewIdent(code), Type: ftype})
+		l.helpers = append(l.helpers, &ast.FuncDecl{Name: ast.NewIdent(code), Type: ftype,
+			Body: &ast.BlockStmt{List: body}})
+		l.table(closure, ast.NewIdent("ogo_closure"), &ast.CompositeLit{Elts: []ast.Expr{
+			cast("void (*)(void)", ast.NewIdent(code))}})
+	}
+	return cast("ogo_func", &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(closure)})
+}
+
+// callValue calls a func value, passing it to its own code.
+func (l *lowering) callValue(e *ast.CallExpr, sig *types.Function) ast.Expr {
+	f := tempName()
+	code := cast(codeType(sig), call("ogo_func_code", ast.NewIdent(f)))
+	args := append([]ast.Expr{ast.NewIdent(f)}, l.args(e, sig)...)
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(ast.NewIdent(f), sig, l.expr(e.Fun))},
+		&ast.ExprStmt{X: &ast.CallExpr{Fun: code, Args: args}},
+	}}}
+}
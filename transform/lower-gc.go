@@ -0,0 +1,281 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// The precise collector (see runtime/ogo.h) finds the pointers in the
+// heap with a pointer map for each object, which we generate for each
+// type that has pointers, and finds those on the stack with a shadow
+// stack, on which each function registers the variables holding
+// pointers as its roots.  It collects only at the safe point before
+// each statement, so a value that is part way through an expression
+// needs keeping only if something else in the same statement may
+// collect, by calling a function or waiting for a channel.  We work
+// out which values those are, statement by statement, before lowering
+// them.
+
+// gcmap is a pointer to the pointer map of type t, or 0 if values of
+// type t have no pointers.
+func (l *lowering) gcmap(t types.Type) ast.Expr {
+	if !types.HasPointers(t) {
+		return intLit(0)
+	}
+	ref := func(name string) ast.Expr {
+		return &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(name)}
+	}
+	switch u := types.Underlying(t).(type) {
+	case *types.Basic:
+		if u.Kind == types.String {
+			return ref("ogo_gcmap_string")
+		}
+		return ref("ogo_gcmap_pointer")
+	case *types.Slice:
+		return ref("ogo_gcmap_slice")
+	case *types.Interface:
+		if u.Sealed {
+			return ref(l.sealedGcmap(t))
+		}
+		return ref("ogo_gcmap_iface")
+	case *types.Array:
+		name := "ogo_gcmap_" + typeName(t)
+		if !l.generated[name] {
+			l.generated[name] = true
+			l.needType(t)
+			l.gcmapTable(name, CType(t), []string{"a"}, []types.Type{u})
+		}
+		return ref(name)
+	case *types.Struct:
+		name := "ogo_gcmap_" + typeName(t)
+		if !l.generated[name] {
+			l.generated[name] = true
+			l.needType(t)
+			var names []string
+			var ts []types.Type
+			for _, f := range u.Fields {
+				names = append(names, f.Name)
+				ts = append(ts, f.Type)
+			}
+			l.gcmapTable(name, CType(t), names, ts)
+		}
+		return ref(name)
+	}
+	return ref("ogo_gcmap_pointer")
+}
+
+// gcmapTable generates the pointer map name of the C struct type
+// ctype, whose fields with pointers are among those named names, of
+// the types ts.  An array field is one entry, counting its elements.
+func (l *lowering) gcmapTable(name, ctype string, names []string, ts []types.Type) {
+	l.protos = append(l.protos, &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+		&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: ast.NewIdent("ogo_gcmap")}}})
+	var entries []ast.Expr
+	for i, t := range ts {
+		if !types.HasPointers(t) {
+			continue
+		}
+		offset := call("offsetof", ast.NewIdent(ctype), ast.NewIdent(names[i]))
+		count := int64(1)
+		if a, ok := types.Underlying(t).(*types.Array); ok {
+			t, count = a.Elem, a.Len
+		}
+		// A nil map stands for count pointers one word apart, and the
+		// pointer of a string or a slice is its first word.
+		var m ast.Expr = intLit(0)
+		switch types.Underlying(t).(type) {
+		case *types.Pointer, *types.Function, *types.Chan, *types.Map, types.TypeType:
+		case *types.Basic, *types.Slice:
+			if types.IsString(t) || types.IsSlice(t) {
+				if count > 1 {
+					m = l.gcmap(t)
+				}
+			}
+		default:
+			m = l.gcmap(t)
+		}
+		entries = append(entries, &ast.CompositeLit{Elts: []ast.Expr{offset, intLit(count), m}})
+	}
+	ptrs := "ogo_gcptrs" + name[len("ogo_gcmap"):]
+	l.table(ptrs, &ast.ArrayType{Elt: ast.NewIdent("ogo_gcptr")}, &ast.CompositeLit{Elts: entries})
+	l.table(name, ast.NewIdent("ogo_gcmap"), &ast.CompositeLit{Elts: []ast.Expr{
+		call("sizeof", ast.NewIdent(ctype)), intLit(int64(len(entries))), ast.NewIdent(ptrs)}})
+}
+
+// root registers the variable name, of type t, on the shadow stack, if
+// it has pointers, giving nil if it hasn't.
+func (l *lowering) root(name string, t types.Type) ast.Stmt {
+	if !types.HasPointers(t) {
+		return nil
+	}
+	i := l.nroots
+	l.nroots++
+	if l.nroots > l.maxroots {
+		l.maxroots = l.nroots
+	}
+	return &ast.ExprStmt{X: call("OGO_ROOT", intLit(int64(i)), ast.NewIdent(name), l.gcmap(t))}
+}
+
+// declare declares the variable n of type t, with the value v, and
+// makes it a root.
+func (l *lowering) declare(n *ast.Ident, t types.Type, v ast.Expr) []ast.Stmt {
+	out := []ast.Stmt{&ast.DeclStmt{Decl: varSpec(n, t, v)}}
+	if r := l.root(n.Name, t); r != nil {
+		out = append(out, r)
+	}
+	return out
+}
+
+// keep keeps x, a lowered value of type t, until the next safe point.
+func (l *lowering) keep(t types.Type, x ast.Expr) ast.Expr {
+	switch t.(type) {
+	case nil, *types.Tuple:
+		return x
+	}
+	if !types.HasPointers(t) {
+		return x
+	}
+	return call("OGO_KEEP", l.gcmap(t), x)
+}
+
+// stable tells whether e is somewhere that the collector sees for as
+// long as the statement runs, like a variable on the shadow stack or
+// a constant, so that its value needs no keeping.
+func (l *lowering) stable(e ast.Expr) bool {
+	if _, isconst := l.info.Values[e]; isconst {
+		return true
+	}
+	switch e := types.StripParens(e).(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		o := l.info.Objects[e]
+		return o == nil || o.Kind != types.Var || !o.Global
+	}
+	return false
+}
+
+// collects tells whether the collector may run while e is evaluated,
+// since it calls a function or receives from a channel.
+func (l *lowering) collects(e ast.Node) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if id, ok := types.StripParens(n.Fun).(*ast.Ident); ok &&
+				l.info.Objects[id] != nil && l.info.Objects[id].Kind == types.Builtin {
+				break
+			}
+			found = found || !l.info.IsType(n.Fun)
+		case *ast.UnaryExpr:
+			found = found || n.Op == token.ARROW
+		}
+		return !found
+	})
+	return found
+}
+
+// expose finds which of the expressions es, which are evaluated
+// together, must be kept: those whose parent must be, if exposed is
+// true, and those with another that collects.
+func (l *lowering) expose(exposed bool, es ...ast.Expr) {
+	var collecting []int
+	for i, e := range es {
+		if e != nil && l.collects(e) {
+			collecting = append(collecting, i)
+		}
+	}
+	for i, e := range es {
+		if e == nil {
+			continue
+		}
+		ex := exposed || len(collecting) > 1 || len(collecting) == 1 && collecting[0] != i
+		if ex {
+			l.exposed[e] = true
+		}
+		switch e := e.(type) {
+		case *ast.ParenExpr:
+			l.expose(ex, e.X)
+		case *ast.SelectorExpr:
+			l.expose(ex, e.X)
+		case *ast.IndexExpr:
+			l.expose(ex, e.X, e.Index)
+		case *ast.SliceExpr:
+			l.expose(ex, e.X, e.Low, e.High, e.Max)
+		case *ast.StarExpr:
+			l.expose(ex, e.X)
+		case *ast.UnaryExpr:
+			l.expose(ex, e.X)
+		case *ast.TypeAssertExpr:
+			l.expose(ex, e.X)
+		case *ast.KeyValueExpr:
+			l.expose(ex, e.Key, e.Value)
+		case *ast.BinaryExpr:
+			if e.Op == token.LAND || e.Op == token.LOR {
+				// The right operand runs after the left is done.
+				l.expose(ex, e.X)
+				l.expose(ex, e.Y)
+			} else {
+				l.expose(ex, e.X, e.Y)
+			}
+		case *ast.CallExpr:
+			l.expose(ex, append([]ast.Expr{e.Fun}, e.Args...)...)
+		case *ast.CompositeLit:
+			l.expose(ex, e.Elts...)
+		}
+	}
+}
+
+// exposeStmt finds which values must be kept in the expressions that
+// the statement s evaluates itself, leaving out those of the
+// statements within it.
+func (l *lowering) exposeStmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.ExprStmt:
+		l.expose(false, s.X)
+	case *ast.AssignStmt:
+		l.expose(false, append(append([]ast.Expr{}, s.Lhs...), s.Rhs...)...)
+	case *ast.IncDecStmt:
+		l.expose(false, s.X)
+	case *ast.ReturnStmt:
+		l.expose(false, s.Results...)
+	case *ast.SendStmt:
+		l.expose(false, s.Chan, s.Value)
+	case *ast.GoStmt:
+		l.expose(false, s.Call)
+	case *ast.DeferStmt:
+		l.expose(false, s.Call)
+	case *ast.IfStmt:
+		l.expose(false, s.Cond)
+	case *ast.ForStmt:
+		l.expose(false, s.Cond)
+	case *ast.RangeStmt:
+		l.expose(false, s.X)
+	case *ast.SwitchStmt:
+		// The tag is evaluated first, and then the cases one by one.
+		l.expose(false, s.Tag)
+		for _, cc := range s.Body.List {
+			for _, e := range cc.(*ast.CaseClause).List {
+				l.expose(false, e)
+			}
+		}
+	case *ast.TypeSwitchStmt:
+		l.exposeStmt(s.Assign)
+	case *ast.SelectStmt:
+		var es []ast.Expr
+		for _, cc := range s.Body.List {
+			switch comm := cc.(*ast.CommClause).Comm.(type) {
+			case *ast.SendStmt:
+				es = append(es, comm.Chan, comm.Value)
+			case *ast.ExprStmt:
+				es = append(es, comm.X)
+			case *ast.AssignStmt:
+				es = append(es, comm.Rhs...)
+			}
+		}
+		l.expose(false, es...)
+	}
+}
@@ -0,0 +1,526 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// An interface value is an ogo_iface, which points to an itable and
+// to the data it holds (see runtime/ogo.h).  We generate an itable
+// for each conversion of a concrete type to an interface, while the
+// runtime creates those needed when converting one interface to
+// another.
+
+// methodName is the name of the C function that implements the
+// method m.
+func methodName(m *types.Object) string {
+	if _, ok := m.Recv.(types.TypeType); ok {
+		return "ogo_Type__" + m.Name
+	}
+	recv := m.Recv
+	if p, ok := recv.(*types.Pointer); ok {
+		recv = p.Elem
+	}
+	return recv.(*types.Named).Name + "__" + m.Name
+}
+
+// methodKey is what the runtime uses to find a method, which is its
+// name and signature.
+func methodKey(m *types.Object) string {
+	return m.Name + strings.TrimPrefix(m.Type.String(), "func")
+}
+
+// typeName is a part of a C identifier that stands for the go type t,
+// so that identical types have the same name.
+func typeName(t types.Type) string {
+	return identifier(t.String())
+}
+
+// identifier turns the go syntax of a type into something that may be
+// part of an identifier.  An underscore of the type becomes _0, since
+// the punctuation becomes underscores never followed by a digit, so
+// that the names of different types differ.
+func identifier(s string) string {
+	return strings.NewReplacer("_", "_0", "<-", "arrow_", "*", "ptr_", "[]", "slice_", "[", "array", "]", "_",
+		" ", "_", "{", "_", "}", "_", "(", "_", ")", "_", ",", "_", ";", "_",
+		".", "_", "|", "or").Replace(s)
+}
+
+// goName is the name of the go type t as gc writes it, e.g. in a
+// panic message.
+func goName(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Named:
+		if i := strings.Index(t.Name, "_"); i > 0 {
+			return t.Name[:i] + "." + t.Name[i+1:]
+		}
+		return t.Name
+	case *types.Pointer:
+		return "*" + goName(t.Elem)
+	case *types.Slice:
+		return "[]" + goName(t.Elem)
+	case *types.Chan:
+		return strings.TrimSuffix(t.String(), t.Elem.String()) + goName(t.Elem)
+	case *types.Map:
+		return "map[" + goName(t.Key) + "]" + goName(t.Elem)
+	case *types.Array:
+		return fmt.Sprint("[", t.Len, "]", goName(t.Elem))
+	case *types.Interface:
+		if len(t.Methods) == 0 {
+			return "interface {}"
+		}
+	}
+	return t.String()
+}
+
+// isDirect tells whether values of type t are stored directly in the
+// data pointer of an interface.
+func isDirect(t types.Type) bool {
+	_, isfunc := types.Underlying(t).(*types.Function)
+	return types.IsPointer(t) || isfunc || types.IsChan(t) || types.IsMap(t) ||
+		types.Identical(t, types.TypeType{})
+}
+
+// table declares a C variable holding runtime data, such as a type
+// descriptor or an itable.
+func (l *lowering) table(name string, ctype, value ast.Expr) {
+	l.tables = append(l.tables, &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+		&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)},
+			Type: ctype, Values: []ast.Expr{value}}}})
+}
+
+// typeDesc generates the runtime descriptor of type t, along with
+// those of the types it is made of, and returns a pointer to it.
+func (l *lowering) typeDesc(t types.Type) ast.Expr {
+	name := "ogo_type_" + typeName(t)
+	desc := &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(name)}
+	if l.generated[name] {
+		return desc
+	}
+	l.generated[name] = true
+	l.needType(t)
+	// The descriptor is declared first, since a type may refer to
+	// itself.
+	l.protos = append(l.protos, &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+		&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: ast.NewIdent("ogo_type")}}})
+	lit := &ast.CompositeLit{}
+	l.descs[name] = lit
+	field := func(name string, v ast.Expr) {
+		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{Key: ast.NewIdent("." + name), Value: v})
+	}
+	field("name", str(goName(t)))
+	field("kind", ast.NewIdent("OGO_KIND_"+kind(t)))
+	field("size", sizeof(t))
+	field("align", call("_Alignof", ctype(t)))
+	if types.HasPointers(t) {
+		field("gcmap", l.gcmap(t))
+	}
+	switch {
+	case types.IsInterface(t) && !types.IsSealed(t) || !types.Comparable(t):
+	case isDirect(t):
+		field("equal", ast.NewIdent("ogo_equal_direct"))
+		field("hash", ast.NewIdent("ogo_hash_direct"))
+	default:
+		field("equal", ast.NewIdent(l.dataEqualFunc(t)))
+		field("hash", ast.NewIdent(l.hashFunc(t)))
+	}
+	switch u := types.Underlying(t).(type) {
+	case *types.Pointer:
+		field("elem", l.typeDesc(u.Elem))
+		if elem := l.descs["ogo_type_"+typeName(u.Elem)]; u == t {
+			elem.Elts = append(elem.Elts, &ast.KeyValueExpr{Key: ast.NewIdent(".ptrto"),
+				Value: &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(name)}})
+		}
+	case *types.Slice:
+		field("elem", l.typeDesc(u.Elem))
+	case *types.Chan:
+		field("elem", l.typeDesc(u.Elem))
+	case *types.Map:
+		field("key", l.typeDesc(u.Key))
+		field("elem", l.typeDesc(u.Elem))
+	case *types.Array:
+		field("elem", l.typeDesc(u.Elem))
+		field("len", intLit(u.Len))
+	case *types.Struct:
+		elts := make([]ast.Expr, len(u.Fields))
+		for i, f := range u.Fields {
+			elts[i] = &ast.CompositeLit{Elts: []ast.Expr{str(f.Name), l.typeDesc(f.Type),
+				call("offsetof", ctype(t), ast.NewIdent(f.Name))}}
+		}
+		if len(elts) > 0 {
+			fields := "ogo_fields_" + typeName(t)
+			l.table(fields, &ast.ArrayType{Elt: ast.NewIdent("ogo_field")},
+				&ast.CompositeLit{Elts: elts})
+			field("nfields", intLit(int64(len(elts))))
+			field("fields", ast.NewIdent(fields))
+		}
+	}
+	if ms := types.MethodSet(t); len(ms) > 0 {
+		elts := make([]ast.Expr, len(ms))
+		for i, m := range ms {
+			var fn ast.Expr = intLit(0)
+			if !types.IsInterface(t) {
+				fn = cast("void (*)(void)", ast.NewIdent(l.wrapper(m)))
+			}
+			elts[i] = &ast.CompositeLit{Elts: []ast.Expr{str(methodKey(m)), fn}}
+		}
+		methods := "ogo_methods_" + typeName(t)
+		l.table(methods, &ast.ArrayType{Elt: ast.NewIdent("ogo_method")},
+			&ast.CompositeLit{Elts: elts})
+		field("nmethods", intLit(int64(len(ms))))
+		field("methods", ast.NewIdent(methods))
+	}
+	l.table(name, ast.NewIdent("ogo_type"), lit)
+	return desc
+}
+
+// kind is the kind of the type t, as the runtime names it.
+func kind(t types.Type) string {
+	switch u := types.Underlying(t).(type) {
+	case *types.Basic:
+		if u.Kind == types.UnsafePointer {
+			return "UNSAFE_POINTER"
+		}
+		return strings.ToUpper(u.Name)
+	case *types.Pointer:
+		return "POINTER"
+	case *types.Slice:
+		return "SLICE"
+	case *types.Chan:
+		return "CHAN"
+	case *types.Map:
+		return "MAP"
+	case *types.Array:
+		return "ARRAY"
+	case *types.Struct:
+		return "STRUCT"
+	case *types.Interface:
+		return "INTERFACE"
+	case *types.Function:
+		return "FUNC"
+	case types.TypeType:
+		return "TYPE"
+	}
+	return "INVALID"
+}
+
+// itab generates the itable for values of type t held in interfaces of
+// type iface, and returns a pointer to it.
+func (l *lowering) itab(t, iface types.Type) ast.Expr {
+	name := "ogo_itab_" + typeName(t) + "__" + typeName(iface)
+	if !l.generated[name] {
+		l.generated[name] = true
+		desc := l.typeDesc(t)
+		var fns []ast.Expr
+		for _, im := range types.Underlying(iface).(*types.Interface).Methods {
+			for _, m := range types.MethodSet(t) {
+				if m.Name == im.Name {
+					fns = append(fns, cast("void (*)(void)", ast.NewIdent(l.wrapper(m))))
+				}
+			}
+		}
+		l.table(name, ast.NewIdent("ogo_itab"), &ast.CompositeLit{Elts: []ast.Expr{
+			desc, &ast.CompositeLit{Elts: fns}}})
+	}
+	return &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(name)}
+}
+
+// wrapper generates a function that calls the method m with a receiver
+// taken from the data pointer of an interface, which is how the
+// method is called through an itable, and returns its name.
+func (l *lowering) wrapper(m *types.Object) string {
+	name := "ogo_wrap_" + methodName(m)
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	sig := m.Type.(*types.Function)
+	data := ast.NewIdent("data")
+	var recv ast.Expr = cast(CType(m.Recv), data)
+	if !isDirect(m.Recv) {
+		recv = &ast.StarExpr{X: cast(CType(m.Recv)+"*", data)}
+	}
+	params := &ast.FieldList{List: []*ast.Field{
+		{Names: []*ast.Ident{data}, Type: ast.NewIdent("void*")}}}
+	args := []ast.Expr{recv}
+	for i, t := range sig.Parameters {
+		p := ast.NewIdent(fmt.Sprint("p", i))
+		params.List = append(params.List, &ast.Field{Names: []*ast.Ident{p}, Type: ctype(t)})
+		args = append(args, p)
+	}
+	body := []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent(methodName(m)), Args: args}}}
+	var results *ast.FieldList
+	switch len(sig.Results) {
+	case 0:
+	case 1:
+		results = &ast.FieldList{List: []*ast.Field{{Type: ctype(sig.Results[0])}}}
+		body = []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{body[0].(*ast.ExprStmt).X}}}
+	default:
+		panic("I can't yet lower methods with multiple results to C")
+	}
+	ftype := &ast.FuncType{Params: params, Results: results}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype,
+		Body: &ast.BlockStmt{List: body}})
+	return name
+}
+
+// dataEqualFunc generates a function comparing the data of two
+// interfaces that hold values of type t, and returns its name.
+func (l *lowering) dataEqualFunc(t types.Type) string {
+	name := "ogo_equal_data_" + typeName(t)
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	a, b := ast.NewIdent("a"), ast.NewIdent("b")
+	deref := func(p ast.Expr) ast.Expr {
+		return &ast.StarExpr{X: cast(CType(t)+"*", p)}
+	}
+	ftype := &ast.FuncType{
+		Params: &ast.FieldList{List: []*ast.Field{
+			{Names: []*ast.Ident{a, b}, Type: ast.NewIdent("const void*")}}},
+		Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent("ogo_bool")}}}}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype,
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{
+			Results: []ast.Expr{l.equal(t, deref(a), deref(b))}}}}})
+	return name
+}
+
+// toInterface converts x, a value of type from, to the interface type
+// to.
+func (l *lowering) toInterface(from, to types.Type, x ast.Expr) ast.Expr {
+	switch {
+	case types.IsSealed(to):
+		return l.toSealed(from, to, x)
+	case types.IsSealed(from):
+		return l.sealedToInterface(from, to, x)
+	}
+	if types.IsInterface(from) {
+		if types.Identical(types.Underlying(from), types.Underlying(to)) {
+			return x
+		}
+		return call("ogo_iface_convert", x, l.typeDesc(to))
+	}
+	data := cast("void*", x)
+	if !isDirect(from) {
+		data = l.new(from, x)
+	}
+	return &ast.CompositeLit{Type: &ast.ParenExpr{X: ast.NewIdent("ogo_iface")},
+		Elts: []ast.Expr{
+			&ast.KeyValueExpr{Key: ast.NewIdent(".itab"), Value: l.itab(from, to)},
+			&ast.KeyValueExpr{Key: ast.NewIdent(".data"), Value: data},
+		}}
+}
+
+// methodCall lowers a call of the method m.
+func (l *lowering) methodCall(e *ast.CallExpr, sel *ast.SelectorExpr, m *types.Object) ast.Expr {
+	sig := m.Type.(*types.Function)
+	xt := l.info.TypeOf(sel.X)
+	if types.IsSealed(xt) {
+		return l.sealedMethodCall(e, sel, m)
+	}
+	if iface, ok := types.Underlying(xt).(*types.Interface); ok {
+		// We call the method through the itable, passing the data
+		// pointer as its receiver.
+		index := 0
+		for i, im := range iface.Methods {
+			if im.Name == m.Name {
+				index = i
+			}
+		}
+		ptype := []string{"void*"}
+		for _, p := range sig.Parameters {
+			l.needType(p)
+			ptype = append(ptype, CType(p))
+		}
+		result := "void"
+		if len(sig.Results) == 1 {
+			l.needType(sig.Results[0])
+			result = CType(sig.Results[0])
+		}
+		fntype := result + " (*)(" + strings.Join(ptype, ", ") + ")"
+		tmp := ast.NewIdent(tempName())
+		fn := cast(fntype, call("ogo_method_fn", ast.NewIdent(tmp.Name), intLit(int64(index))))
+		args := append([]ast.Expr{&ast.SelectorExpr{X: ast.NewIdent(tmp.Name),
+			Sel: ast.NewIdent("data")}}, l.args(e, sig)...)
+		return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.DeclStmt{Decl: varSpec(tmp, xt, l.expr(sel.X))},
+			&ast.ExprStmt{X: &ast.CallExpr{Fun: fn, Args: args}},
+		}}}
+	}
+	_, ptr := m.Recv.(*types.Pointer)
+	var x ast.Expr
+	switch {
+	case ptr && !types.IsPointer(xt):
+		// The receiver may be within something that needs keeping.
+		x = &ast.UnaryExpr{Op: token.AND, X: l.place(sel.X)}
+		if l.exposed[sel.X] && !l.stable(sel.X) {
+			x = l.keep(m.Recv, x)
+		}
+	case !ptr && types.IsPointer(xt):
+		x = &ast.StarExpr{X: l.expr(sel.X)}
+	default:
+		x = l.expr(sel.X)
+	}
+	return &ast.CallExpr{Fun: ast.NewIdent(methodName(m)),
+		Args: append([]ast.Expr{x}, l.args(e, sig)...)}
+}
+
+// assertion lowers the type assertion e, which panics if it fails.
+func (l *lowering) assertion(e *ast.TypeAssertExpr) ast.Expr {
+	x, xt, t := l.expr(e.X), l.info.TypeOf(e.X), l.info.TypeOf(e.Type)
+	l.needType(t)
+	switch {
+	case types.IsSealed(t):
+		panic(fmt.Sprintf("I can't yet assert that a %v is the sealed %v", xt, t))
+	case types.IsSealed(xt) && !types.IsInterface(t):
+		return l.sealedAssertion(x, xt, t)
+	case types.IsSealed(xt):
+		// We look up the methods of the variant as we would if it
+		// were in any other interface.
+		x, xt = l.sealedToInterface(xt, &types.Interface{}, x), &types.Interface{}
+	}
+	if types.IsInterface(t) {
+		return call("ogo_assert_iface", x, l.typeDesc(t))
+	}
+	return l.unbox(t, call("ogo_assert_type", x, l.typeDesc(xt), l.typeDesc(t)))
+}
+
+// unbox gives the value of type t held by an interface with the data
+// pointer data.
+func (l *lowering) unbox(t types.Type, data ast.Expr) ast.Expr {
+	if isDirect(t) {
+		return cast(CType(t), data)
+	}
+	return &ast.StarExpr{X: cast(CType(t)+"*", data)}
+}
+
+// hasType tests whether x, a value of the interface type xt, holds a
+// value of type t or, if t is an interface type, of a type that
+// implements t.
+func (l *lowering) hasType(x ast.Expr, xt, t types.Type) ast.Expr {
+	switch {
+	case types.IsSealed(xt):
+		return l.hasVariant(x, xt, t)
+	case types.IsSealed(t):
+		panic(fmt.Sprintf("I can't yet test whether a %v is the sealed %v", xt, t))
+	}
+	if types.IsInterface(t) {
+		return call("ogo_implements", x, l.typeDesc(t))
+	}
+	return call("ogo_is_type", x, l.typeDesc(t))
+}
+
+// fromInterface converts x, a value of the interface type from that is
+// known to hold a t, to the type t.
+func (l *lowering) fromInterface(from, t types.Type, x ast.Expr) ast.Expr {
+	if types.IsSealed(from) {
+		return l.fromSealed(from, t, x)
+	}
+	if types.IsInterface(t) {
+		return l.toInterface(from, t, x)
+	}
+	return l.unbox(t, &ast.SelectorExpr{X: x, Sel: ast.NewIdent("data")})
+}
+
+// commaOk lowers the assignment to lhs of the two values of the comma-ok
+// expression e.
+func (l *lowering) commaOk(lhs []ast.Expr, e ast.Expr) ast.Stmt {
+	switch x := types.StripParens(e).(type) {
+	case *ast.UnaryExpr:
+		return l.recvOk(lhs, x.X)
+	case *ast.IndexExpr:
+		return l.mapIndexOk(lhs, x)
+	}
+	a := types.StripParens(e).(*ast.TypeAssertExpr)
+	xt, t := l.info.TypeOf(a.X), l.info.TypeOf(a.Type)
+	l.needType(t)
+	x, ok, v := ast.NewIdent(tempName()), ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	list := []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(x, xt, l.expr(a.X))},
+		&ast.DeclStmt{Decl: varSpec(ok, types.Typ[types.Bool], l.hasType(ast.NewIdent(x.Name), xt, t))},
+		&ast.DeclStmt{Decl: varSpec(v, t, l.zero(t))},
+		&ast.IfStmt{Cond: ast.NewIdent(ok.Name), Body: &ast.BlockStmt{List: []ast.Stmt{
+			assign(ast.NewIdent(v.Name), l.fromInterface(xt, t, ast.NewIdent(x.Name)))}}},
+	}
+	for i, val := range []*ast.Ident{v, ok} {
+		if !isBlank(lhs[i]) {
+			list = append(list, l.store(lhs[i], ast.NewIdent(val.Name)))
+		}
+	}
+	return &ast.BlockStmt{List: list}
+}
+
+// typeSwitch lowers a type switch to a chain of if statements testing
+// the type held by the guard, which is evaluated just once.  We wrap
+// the chain in a switch so that a break leaves it.
+func (l *lowering) typeSwitch(s *ast.TypeSwitchStmt) ast.Stmt {
+	if s.Init != nil {
+		panic("Type switches must have no init statement when lowered to C")
+	}
+	var lhs *ast.Ident
+	var guard ast.Expr
+	switch a := s.Assign.(type) {
+	case *ast.ExprStmt:
+		guard = a.X
+	case *ast.AssignStmt:
+		lhs = a.Lhs[0].(*ast.Ident)
+		guard = a.Rhs[0]
+	}
+	ta := types.StripParens(guard).(*ast.TypeAssertExpr)
+	xt := l.info.TypeOf(ta.X)
+	x := ast.NewIdent(tempName())
+	var chain ast.Stmt
+	var ifs []*ast.IfStmt
+	for _, cc := range s.Body.List {
+		cc := cc.(*ast.CaseClause)
+		vt := xt
+		var cond ast.Expr
+		for _, e := range cc.List {
+			var test ast.Expr
+			if l.info.IsType(e) {
+				t := l.info.TypeOf(e)
+				l.needType(t)
+				test = l.hasType(ast.NewIdent(x.Name), xt, t)
+				if len(cc.List) == 1 {
+					vt = t
+				}
+			} else {
+				test = &ast.BinaryExpr{X: isNil(ast.NewIdent(x.Name), xt), Op: token.EQL, Y: intLit(0)}
+			}
+			if cond == nil {
+				cond = test
+			} else {
+				cond = &ast.BinaryExpr{X: cond, Op: token.LOR, Y: test}
+			}
+		}
+		var body []ast.Stmt
+		nroots := l.nroots
+		if lhs != nil {
+			body = append(body, l.declare(ast.NewIdent(lhs.Name), vt,
+				l.fromInterface(xt, vt, ast.NewIdent(x.Name)))...)
+		}
+		body = append(body, l.stmts(cc.Body)...)
+		l.nroots = nroots
+		if cond == nil {
+			chain = &ast.BlockStmt{List: body}
+			continue
+		}
+		ifs = append(ifs, &ast.IfStmt{Cond: cond, Body: &ast.BlockStmt{List: body}})
+	}
+	for i := len(ifs) - 1; i >= 0; i-- {
+		ifs[i].Else = chain
+		chain = ifs[i]
+	}
+	list := []ast.Stmt{&ast.DeclStmt{Decl: varSpec(x, xt, l.expr(ta.X))}}
+	if chain != nil {
+		list = append(list, chain)
+	}
+	return &ast.SwitchStmt{Tag: intLit(0), Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.CaseClause{Body: []ast.Stmt{&ast.BlockStmt{List: list}}}}}}
+}
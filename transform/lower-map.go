@@ -0,0 +1,270 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// A map is an ogo_map, which points to the runtime's hash table (see
+// runtime/ogo.h).  The runtime handles keys and elements through
+// pointers, knowing only their sizes, so for each map type we generate
+// a table holding those sizes along with functions that hash and
+// compare its keys.  As with channels, each operation keeps its key
+// in a temporary, so that it may pass a pointer to it.
+
+// mapOf is the map type of the map x.
+func (l *lowering) mapOf(x ast.Expr) *types.Map {
+	m := types.Underlying(l.info.TypeOf(x)).(*types.Map)
+	l.needType(m.Key)
+	l.needType(m.Elem)
+	return m
+}
+
+// isMapIndex tells whether e is an element of a map, which needs
+// looking up, or storing with the runtime.
+func (l *lowering) isMapIndex(e ast.Expr) bool {
+	ix, ok := types.StripParens(e).(*ast.IndexExpr)
+	return ok && types.IsMap(l.info.TypeOf(ix.X))
+}
+
+// typed creates an identifier of type t, for a temporary that we
+// give to the lowering as part of a go expression.
+func (l *lowering) typed(name string, t types.Type) *ast.Ident {
+	id := ast.NewIdent(name)
+	l.info.Types[id] = t
+	return id
+}
+
+// mapType generates the table describing the map type t to the
+// runtime, and returns a pointer to it.
+func (l *lowering) mapType(t types.Type) ast.Expr {
+	name := "ogo_maptype_" + typeName(t)
+	if !l.generated[name] {
+		l.generated[name] = true
+		m := types.Underlying(t).(*types.Map)
+		l.needType(m.Key)
+		l.needType(m.Elem)
+		l.table(name, ast.NewIdent("ogo_maptype"), &ast.CompositeLit{Elts: []ast.Expr{
+			sizeof(m.Key), call("_Alignof", ctype(m.Key)),
+			sizeof(m.Elem), call("_Alignof", ctype(m.Elem)),
+			ast.NewIdent(l.hashFunc(m.Key)), ast.NewIdent(l.dataEqualFunc(m.Key)),
+			l.gcmap(m.Key), l.gcmap(m.Elem),
+		}})
+	}
+	return &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(name)}
+}
+
+// hashFunc gives the name of a function that hashes a value of type
+// t, given a pointer to it, generating the function if the runtime
+// has none for t.  Values that are equal have the same hash.
+func (l *lowering) hashFunc(t types.Type) string {
+	switch u := types.Underlying(t).(type) {
+	case *types.Basic:
+		switch u.Kind {
+		case types.String, types.Float32, types.Float64, types.Complex64, types.Complex128:
+			return "ogo_hash_" + u.Name
+		}
+	case *types.Interface:
+		if !u.Sealed {
+			return "ogo_hash_iface"
+		}
+	}
+	name := "ogo_hash_" + typeName(t)
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	l.needType(t)
+	id := ast.NewIdent
+	p, h := id("p"), id("h")
+	// field gives a pointer to part of the value at p.
+	field := func(part ast.Expr) ast.Expr {
+		return &ast.UnaryExpr{Op: token.AND, X: part}
+	}
+	value := &ast.ParenExpr{X: &ast.StarExpr{X: cast(CType(t)+"*", p)}}
+	var body []ast.Stmt
+	switch u := types.Underlying(t).(type) {
+	case *types.Array:
+		elem := &ast.IndexExpr{X: &ast.SelectorExpr{X: value, Sel: id("a")}, Index: id("i")}
+		body = []ast.Stmt{
+			&ast.DeclStmt{Decl: varSpec(id("i"), types.Typ[types.Int], intLit(0))},
+			&ast.ForStmt{
+				Cond: &ast.BinaryExpr{X: id("i"), Op: token.LSS, Y: intLit(u.Len)},
+				Post: &ast.IncDecStmt{X: id("i"), Tok: token.INC},
+				Body: &ast.BlockStmt{List: []ast.Stmt{
+					assign(h, call(l.hashFunc(u.Elem), field(elem), h))}},
+			},
+		}
+	case *types.Struct:
+		for _, f := range u.Fields {
+			if f.Name != "_" {
+				body = append(body, assign(h, call(l.hashFunc(f.Type),
+					field(&ast.SelectorExpr{X: value, Sel: id(f.Name)}), h)))
+			}
+		}
+	case *types.Interface:
+		// The hash of the tag, and then of the variant that it
+		// chooses.
+		body = []ast.Stmt{assign(h, call("ogo_memhash", field(tag(value)),
+			sizeof(types.Typ[types.Int]), h))}
+		var clauses []ast.Stmt
+		for k, v := range types.Variants(t) {
+			clauses = append(clauses, &ast.CaseClause{List: []ast.Expr{intLit(int64(k + 1))},
+				Body: []ast.Stmt{assign(h, call(l.hashFunc(v), field(member(value, k)), h)),
+					&ast.BranchStmt{Tok: token.BREAK}}})
+		}
+		body = append(body, &ast.SwitchStmt{Tag: tag(value), Body: &ast.BlockStmt{List: clauses}})
+	default:
+		body = []ast.Stmt{assign(h, call("ogo_memhash", p, sizeof(t), h))}
+	}
+	body = append(body, &ast.ReturnStmt{Results: []ast.Expr{h}})
+	ftype := &ast.FuncType{
+		Params: &ast.FieldList{List: []*ast.Field{
+			{Names: []*ast.Ident{p}, Type: id("const void*")},
+			{Names: []*ast.Ident{h}, Type: id("ogo_uint")}}},
+		Results: &ast.FieldList{List: []*ast.Field{{Type: id("ogo_uint")}}}}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: id(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: id(name), Type: ftype,
+		Body: &ast.BlockStmt{List: body}})
+	return name
+}
+
+// makeMap makes a map of type t with room for hint elements.
+func (l *lowering) makeMap(t types.Type, hint ast.Expr) ast.Expr {
+	return call("ogo_make_map", l.mapType(t), hint)
+}
+
+// mapIndex lowers looking up the element of a map, which gives the
+// zero value if it isn't there, storing whether it was in ok, if ok
+// isn't nil.
+func (l *lowering) mapIndex(e *ast.IndexExpr, ok ast.Expr) ast.Expr {
+	m := l.mapOf(e.X)
+	x, k, p, v := ast.NewIdent(tempName()), ast.NewIdent(tempName()), tempName(), tempName()
+	list := []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(x, l.info.TypeOf(e.X), l.expr(e.X))},
+		&ast.DeclStmt{Decl: varSpec(k, m.Key, l.expr(e.Index))},
+		cVar(p, CType(m.Elem)+"*", cast(CType(m.Elem)+"*", call("ogo_map_access",
+			ast.NewIdent(x.Name), &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(k.Name)}))),
+		&ast.DeclStmt{Decl: varSpec(ast.NewIdent(v), m.Elem, l.zero(m.Elem))},
+		&ast.IfStmt{Cond: &ast.BinaryExpr{X: ast.NewIdent(p), Op: token.NEQ, Y: intLit(0)},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				assign(ast.NewIdent(v), &ast.StarExpr{X: ast.NewIdent(p)})}}},
+	}
+	if ok != nil {
+		list = append(list, assign(ok, &ast.BinaryExpr{X: ast.NewIdent(p),
+			Op: token.NEQ, Y: intLit(0)}))
+	}
+	list = append(list, &ast.ExprStmt{X: ast.NewIdent(v)})
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: list}}
+}
+
+// mapIndexOk lowers the assignment to lhs of an element of a map and
+// whether it was there.
+func (l *lowering) mapIndexOk(lhs []ast.Expr, e *ast.IndexExpr) ast.Stmt {
+	v, ok := ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	list := []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(ok, types.Typ[types.Bool], nil)},
+		&ast.DeclStmt{Decl: varSpec(v, l.mapOf(e.X).Elem, l.mapIndex(e, ast.NewIdent(ok.Name)))},
+	}
+	for i, val := range []*ast.Ident{v, ok} {
+		if !isBlank(lhs[i]) {
+			list = append(list, l.store(lhs[i], ast.NewIdent(val.Name)))
+		}
+	}
+	return &ast.BlockStmt{List: list}
+}
+
+// mapStore lowers storing v, a lowered value, as the element of a map,
+// evaluating the map and the key before v.
+func (l *lowering) mapStore(e *ast.IndexExpr, v ast.Expr) ast.Stmt {
+	m := l.mapOf(e.X)
+	x, k, tmp := ast.NewIdent(tempName()), ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	elem := cast(CType(m.Elem)+"*", call("ogo_map_assign", ast.NewIdent(x.Name),
+		&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(k.Name)}))
+	return &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(x, l.info.TypeOf(e.X), l.expr(e.X))},
+		&ast.DeclStmt{Decl: varSpec(k, m.Key, l.expr(e.Index))},
+		&ast.DeclStmt{Decl: varSpec(tmp, m.Elem, v)},
+		assign(&ast.StarExpr{X: elem}, ast.NewIdent(tmp.Name)),
+	}}
+}
+
+// mapAssignOp lowers an assignment operation like m[k] += y, which
+// looks up the element before it stores the result, so that a panic
+// in the operation leaves the map as it was.
+func (l *lowering) mapAssignOp(e *ast.IndexExpr, op token.Token, y ast.Expr) ast.Stmt {
+	m := l.mapOf(e.X)
+	x, k := tempName(), tempName()
+	ix := &ast.IndexExpr{X: l.typed(x, l.info.TypeOf(e.X)), Index: l.typed(k, m.Key)}
+	l.info.Types[ix] = m.Elem
+	b := &ast.BinaryExpr{X: ix, Op: op, Y: y}
+	l.info.Types[b] = m.Elem
+	return &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(ast.NewIdent(x), l.info.TypeOf(e.X), l.expr(e.X))},
+		&ast.DeclStmt{Decl: varSpec(ast.NewIdent(k), m.Key, l.expr(e.Index))},
+		l.mapStore(ix, l.expr(b)),
+	}}
+}
+
+// mapLit lowers a map literal, which makes the map and then stores
+// each of its elements in order.
+func (l *lowering) mapLit(e *ast.CompositeLit, t types.Type) ast.Expr {
+	x := tempName()
+	list := []ast.Stmt{&ast.DeclStmt{Decl: varSpec(ast.NewIdent(x), t,
+		l.makeMap(t, intLit(int64(len(e.Elts)))))}}
+	for _, elt := range e.Elts {
+		kv := elt.(*ast.KeyValueExpr)
+		ix := &ast.IndexExpr{X: l.typed(x, t), Index: kv.Key}
+		list = append(list, l.mapStore(ix, l.expr(kv.Value)))
+	}
+	list = append(list, &ast.ExprStmt{X: ast.NewIdent(x)})
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: list}}
+}
+
+// mapDelete lowers a call of delete.
+func (l *lowering) mapDelete(m, key ast.Expr) ast.Expr {
+	x, k := ast.NewIdent(tempName()), ast.NewIdent(tempName())
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(x, l.info.TypeOf(m), l.expr(m))},
+		&ast.DeclStmt{Decl: varSpec(k, l.mapOf(m).Key, l.expr(key))},
+		&ast.ExprStmt{X: call("ogo_map_delete", ast.NewIdent(x.Name),
+			&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(k.Name)})},
+	}}}
+}
+
+// mapRange lowers a range over a map to a loop over an iterator, which
+// the runtime starts at random, just as gc does.
+func (l *lowering) mapRange(s *ast.RangeStmt) ast.Stmt {
+	m := l.mapOf(s.X)
+	it := tempName()
+	// The iterator holds pointers into the map.
+	root := &ast.ExprStmt{X: call("OGO_ROOT", intLit(int64(l.nroots)), ast.NewIdent(it),
+		&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("ogo_gcmap_map_iter")})}
+	l.root(it, types.Typ[types.UnsafePointer])
+	var body []ast.Stmt
+	for _, kv := range []struct {
+		x     ast.Expr
+		t     types.Type
+		field string
+	}{{s.Key, m.Key, "key"}, {s.Value, m.Elem, "elem"}} {
+		if kv.x == nil || isBlank(kv.x) {
+			continue
+		}
+		v := &ast.StarExpr{X: cast(CType(kv.t)+"*",
+			&ast.SelectorExpr{X: ast.NewIdent(it), Sel: ast.NewIdent(kv.field)})}
+		if s.Tok == token.DEFINE {
+			body = append(body, l.declare(kv.x.(*ast.Ident), kv.t, v)...)
+		} else {
+			body = append(body, l.store(kv.x, v))
+		}
+	}
+	s.Body.List = append(body, l.stmts(s.Body.List)...)
+	iter := &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(it)}
+	return &ast.BlockStmt{List: []ast.Stmt{
+		cVar(it, "ogo_map_iter", nil),
+		&ast.ExprStmt{X: call("ogo_map_iterinit", iter, l.expr(s.X))},
+		root,
+		&ast.ForStmt{Cond: call("ogo_map_next", iter), Body: s.Body},
+	}}
+}
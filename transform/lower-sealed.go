@@ -0,0 +1,291 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// A value of a sealed interface type is a tagged union rather than an
+// ogo_iface, so that
+//
+//	type Shape interface {
+//		sealed
+//		Circle | Square
+//	}
+//
+// becomes
+//
+//	struct ogo_interface_sealed_main_Circle_or_main_Square_ {
+//		ogo_int tag;
+//		union {
+//			main_Circle v0;
+//			main_Square v1;
+//		} u;
+//	};
+//	typedef ogo_interface_sealed_main_Circle_or_main_Square_ main_Shape;
+//
+// where the tag is 0 for nil and i + 1 for the variant vi.  Nothing
+// about a variant needs to be looked up at run time, so there are no
+// itables: a type switch tests the tag, and a method call switches on
+// it to call the method of the variant.
+
+// declareSealed declares the C struct for the sealed interface type i.
+func (l *lowering) declareSealed(i *types.Interface) {
+	name := CType(i)
+	if l.generated[name] {
+		return
+	}
+	l.generated[name] = true
+	l.forwards = append(l.forwards, typeDecl(name, ast.NewIdent("struct "+name)))
+	// C has no interfaces, so an InterfaceType stands for a union.
+	variants := &ast.FieldList{}
+	for k, v := range types.Variants(i) {
+		l.needType(v)
+		variants.List = append(variants.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(fmt.Sprint("v", k))}, Type: ctype(v)})
+	}
+	fields := &ast.FieldList{List: []*ast.Field{
+		{Names: []*ast.Ident{ast.NewIdent("tag")}, Type: ast.NewIdent("ogo_int")},
+		{Names: []*ast.Ident{ast.NewIdent("u")}, Type: &ast.InterfaceType{Methods: variants}},
+	}}
+	l.types = append(l.types, typeDecl(name, &ast.StructType{Fields: fields}))
+}
+
+// member is the variant k held by x, a value of a sealed interface
+// type.
+func member(x ast.Expr, k int) ast.Expr {
+	return &ast.SelectorExpr{X: &ast.SelectorExpr{X: x, Sel: ast.NewIdent("u")},
+		Sel: ast.NewIdent(fmt.Sprint("v", k))}
+}
+
+func tag(x ast.Expr) ast.Expr {
+	return &ast.SelectorExpr{X: x, Sel: ast.NewIdent("tag")}
+}
+
+// sealedGcmap generates the pointer map of the sealed interface type
+// t, which has a map for each variant with pointers, and returns its
+// name.
+func (l *lowering) sealedGcmap(t types.Type) string {
+	name := "ogo_gcmap_" + typeName(t)
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	l.needType(t)
+	l.protos = append(l.protos, &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{
+		&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: ast.NewIdent("ogo_gcmap")}}})
+	var maps []ast.Expr
+	for k, v := range types.Variants(t) {
+		if !types.HasPointers(v) {
+			maps = append(maps, intLit(0))
+			continue
+		}
+		vmap := fmt.Sprint(name, "_v", k)
+		l.gcmapTable(vmap, CType(t), []string{fmt.Sprint("u.v", k)}, []types.Type{v})
+		maps = append(maps, &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(vmap)})
+	}
+	variants := "ogo_gcvariants" + name[len("ogo_gcmap"):]
+	l.table(variants, &ast.ArrayType{Elt: ast.NewIdent("const ogo_gcmap*")},
+		&ast.CompositeLit{Elts: maps})
+	l.table(name, ast.NewIdent("ogo_gcmap"), &ast.CompositeLit{Elts: []ast.Expr{
+		call("sizeof", ctype(t)), intLit(0), intLit(0), ast.NewIdent(variants)}})
+	return name
+}
+
+// variantDescs generates the table of the type descriptors of the
+// variants of the sealed interface type t, and returns its name.
+func (l *lowering) variantDescs(t types.Type) string {
+	name := "ogo_variants_" + typeName(t)
+	if !l.generated[name] {
+		l.generated[name] = true
+		var descs []ast.Expr
+		for _, v := range types.Variants(t) {
+			descs = append(descs, l.typeDesc(v))
+		}
+		l.table(name, &ast.ArrayType{Elt: ast.NewIdent("const ogo_type*")},
+			&ast.CompositeLit{Elts: descs})
+	}
+	return name
+}
+
+// toSealed converts x, a value of type from, to the sealed interface
+// type to.
+func (l *lowering) toSealed(from, to types.Type, x ast.Expr) ast.Expr {
+	if types.Identical(types.Underlying(from), types.Underlying(to)) {
+		return x
+	}
+	k := types.Variant(to, from)
+	if k < 0 {
+		panic(fmt.Sprintf("I can't yet convert %v to the sealed %v", from, to))
+	}
+	l.needType(to)
+	return &ast.CompositeLit{Type: &ast.ParenExpr{X: ctype(to)}, Elts: []ast.Expr{
+		&ast.KeyValueExpr{Key: ast.NewIdent(".tag"), Value: intLit(int64(k + 1))},
+		&ast.KeyValueExpr{Key: ast.NewIdent(fmt.Sprint(".u.v", k)), Value: x},
+	}}
+}
+
+// hasVariant tests whether x, a value of the sealed interface type
+// xt, holds a t or, if t is an interface type, a variant that
+// implements t.
+func (l *lowering) hasVariant(x ast.Expr, xt, t types.Type) ast.Expr {
+	var test ast.Expr
+	for k, v := range types.Variants(xt) {
+		if types.Identical(v, t) ||
+			types.IsInterface(t) && types.MissingMethod(v, types.Underlying(t).(*types.Interface)) == nil {
+			is := &ast.BinaryExpr{X: tag(x), Op: token.EQL, Y: intLit(int64(k + 1))}
+			if test == nil {
+				test = is
+			} else {
+				test = &ast.BinaryExpr{X: test, Op: token.LOR, Y: is}
+			}
+		}
+	}
+	if test == nil {
+		return intLit(0)
+	}
+	return test
+}
+
+// fromSealed converts x, a value of the sealed interface type from
+// that is known to hold a t, to the type t.
+func (l *lowering) fromSealed(from, t types.Type, x ast.Expr) ast.Expr {
+	if types.IsInterface(t) {
+		return l.toInterface(from, t, x)
+	}
+	return member(x, types.Variant(from, t))
+}
+
+// sealedSwitch generates a function that switches on the tag of a
+// value of the sealed interface type t, returning the result of each
+// for the variant that it holds, or that of none if it holds nil, and
+// returns its name.  The function takes the value, and then the
+// parameters params.
+func (l *lowering) sealedSwitch(name string, t types.Type, params []*ast.Field, result types.Type,
+	each func(x ast.Expr, k int, v types.Type) ast.Expr, none ast.Stmt) string {
+	if l.generated[name] {
+		return name
+	}
+	l.generated[name] = true
+	l.needType(t)
+	x := ast.NewIdent("x")
+	var clauses []ast.Stmt
+	for k, v := range types.Variants(t) {
+		var s ast.Stmt = &ast.ReturnStmt{Results: []ast.Expr{each(ast.NewIdent(x.Name), k, v)}}
+		if result == nil {
+			s = &ast.ExprStmt{X: each(ast.NewIdent(x.Name), k, v)}
+			clauses = append(clauses, &ast.CaseClause{List: []ast.Expr{intLit(int64(k + 1))},
+				Body: []ast.Stmt{s, &ast.ReturnStmt{}}})
+			continue
+		}
+		clauses = append(clauses, &ast.CaseClause{List: []ast.Expr{intLit(int64(k + 1))},
+			Body: []ast.Stmt{s}})
+	}
+	var results *ast.FieldList
+	if result != nil {
+		l.needType(result)
+		results = &ast.FieldList{List: []*ast.Field{{Type: ctype(result)}}}
+	}
+	ftype := &ast.FuncType{
+		Params: &ast.FieldList{List: append([]*ast.Field{
+			{Names: []*ast.Ident{x}, Type: ctype(t)}}, params...)},
+		Results: results}
+	l.protos = append(l.protos, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype})
+	l.helpers = append(l.helpers, &ast.FuncDecl{Name: ast.NewIdent(name), Type: ftype,
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.SwitchStmt{Tag: tag(ast.NewIdent(x.Name)), Body: &ast.BlockStmt{List: clauses}},
+			none,
+		}}})
+	return name
+}
+
+// sealedToInterface converts x, a value of the sealed interface type
+// from, to the interface type to, which holds its variant.
+func (l *lowering) sealedToInterface(from, to types.Type, x ast.Expr) ast.Expr {
+	l.needType(to)
+	name := l.sealedSwitch("ogo_convert_"+typeName(from)+"__"+typeName(to), from, nil, to,
+		func(x ast.Expr, k int, v types.Type) ast.Expr {
+			return l.toInterface(v, to, member(x, k))
+		},
+		&ast.ReturnStmt{Results: []ast.Expr{l.zero(to)}})
+	return call(name, x)
+}
+
+// sealedMethodCall lowers a call of the method m of a value of the
+// sealed interface type xt, which calls the method of its variant.
+func (l *lowering) sealedMethodCall(e *ast.CallExpr, sel *ast.SelectorExpr, m *types.Object) ast.Expr {
+	xt := l.info.TypeOf(sel.X)
+	sig := m.Type.(*types.Function)
+	var params []*ast.Field
+	var args []ast.Expr
+	for i, p := range sig.Parameters {
+		l.needType(p)
+		arg := ast.NewIdent(fmt.Sprint("p", i))
+		params = append(params, &ast.Field{Names: []*ast.Ident{arg}, Type: ctype(p)})
+		args = append(args, arg)
+	}
+	var result types.Type
+	switch len(sig.Results) {
+	case 0:
+	case 1:
+		result = sig.Results[0]
+	default:
+		panic("I can't yet lower methods with multiple results to C")
+	}
+	name := l.sealedSwitch("ogo_call_"+typeName(xt)+"__"+m.Name, xt, params, result,
+		func(x ast.Expr, k int, v types.Type) ast.Expr {
+			var vm *types.Object
+			for _, o := range types.MethodSet(v) {
+				if o.Name == m.Name {
+					vm = o
+				}
+			}
+			recv := member(x, k)
+			_, ptr := vm.Recv.(*types.Pointer)
+			if !ptr && types.IsPointer(v) {
+				recv = &ast.StarExpr{X: recv}
+			}
+			return &ast.CallExpr{Fun: ast.NewIdent(methodName(vm)), Args: append([]ast.Expr{recv}, args...)}
+		},
+		// A method of nil has no receiver to be called with.
+		&ast.ExprStmt{X: call("ogo_panic_nil")})
+	return call(name, append([]ast.Expr{l.expr(sel.X)}, l.args(e, sig)...)...)
+}
+
+// sealedAssertion lowers the type assertion of x, a value of the
+// sealed interface type xt, to the variant t, which panics if x holds
+// anything else.
+func (l *lowering) sealedAssertion(x ast.Expr, xt, t types.Type) ast.Expr {
+	k := types.Variant(xt, t)
+	tmp := ast.NewIdent(tempName())
+	return &ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.DeclStmt{Decl: varSpec(tmp, xt, x)},
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: tag(ast.NewIdent(tmp.Name)), Op: token.NEQ, Y: intLit(int64(k + 1))},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call("ogo_panic_variant",
+				l.typeDesc(xt), ast.NewIdent(l.variantDescs(xt)), tag(ast.NewIdent(tmp.Name)),
+				l.typeDesc(t))}}}},
+		&ast.ExprStmt{X: member(ast.NewIdent(tmp.Name), k)},
+	}}}
+}
+
+// sealedEqualFunc generates a function comparing two values of the
+// sealed interface type t, which are equal if they are both nil or
+// hold the same variant with equal values, and returns its name.
+func (l *lowering) sealedEqualFunc(t types.Type) string {
+	name := "ogo_equal_" + mangle(cbase(t))
+	b := ast.NewIdent("b")
+	// Both are nil if the tags match and the switch finds no variant.
+	return l.sealedSwitch(name, t, []*ast.Field{{Names: []*ast.Ident{b}, Type: ctype(t)}},
+		types.Typ[types.Bool],
+		func(x ast.Expr, k int, v types.Type) ast.Expr {
+			return &ast.BinaryExpr{
+				X:  &ast.BinaryExpr{X: tag(ast.NewIdent("b")), Op: token.EQL, Y: intLit(int64(k + 1))},
+				Op: token.LAND,
+				Y:  l.equal(v, member(x, k), member(ast.NewIdent("b"), k))}
+		},
+		&ast.ReturnStmt{Results: []ast.Expr{
+			&ast.BinaryExpr{X: tag(ast.NewIdent("b")), Op: token.EQL, Y: intLit(0)}}})
+}
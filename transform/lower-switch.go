@@ -0,0 +1,115 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// switchStmt lowers a switch statement.  If its tag is an integer and
+// every case is a constant, it becomes a C switch, in which each clause
+// ends with a break unless it falls through.  Otherwise it becomes a
+// chain of if statements comparing the tag, which is evaluated just
+// once, with each case in turn (or testing each case, if there is no
+// tag), and as with a type switch we wrap the chain in a switch so that
+// a break leaves it.  A fallthrough in the chain jumps to a label at
+// the start of the next clause.
+func (l *lowering) switchStmt(s *ast.SwitchStmt) ast.Stmt {
+	if s.Init != nil {
+		panic("Switch statements must have no init statement when lowered to C")
+	}
+	var clauses []*ast.CaseClause
+	var falls []bool
+	constant := s.Tag != nil && types.IsInteger(l.info.TypeOf(s.Tag))
+	for _, cc := range s.Body.List {
+		cc := cc.(*ast.CaseClause)
+		fall := false
+		if n := len(cc.Body); n > 0 {
+			if b, ok := cc.Body[n-1].(*ast.BranchStmt); ok && b.Tok == token.FALLTHROUGH {
+				fall, cc.Body = true, cc.Body[:n-1]
+			}
+		}
+		for _, e := range cc.List {
+			if _, isconst := l.info.Values[e]; !isconst {
+				constant = false
+			}
+		}
+		clauses = append(clauses, cc)
+		falls = append(falls, fall)
+	}
+	if constant {
+		tag := l.expr(s.Tag)
+		var body []ast.Stmt
+		for i, cc := range clauses {
+			list := []ast.Stmt{&ast.BlockStmt{List: l.stmts(cc.Body)}}
+			if !falls[i] {
+				list = append(list, &ast.BranchStmt{Tok: token.BREAK})
+			}
+			if cc.List == nil {
+				body = append(body, &ast.CaseClause{Body: list})
+			}
+			// C has just one value in each case.
+			for j, e := range cc.List {
+				c := &ast.CaseClause{List: []ast.Expr{l.expr(e)}}
+				if j == len(cc.List)-1 {
+					c.Body = list
+				}
+				body = append(body, c)
+			}
+		}
+		return &ast.SwitchStmt{Tag: tag, Body: &ast.BlockStmt{List: body}}
+	}
+
+	var list []ast.Stmt
+	tag := ast.NewIdent(tempName())
+	if s.Tag != nil {
+		t := l.info.TypeOf(s.Tag)
+		l.info.Types[tag] = t
+		list = l.declare(tag, t, l.expr(s.Tag))
+	}
+	labels := make([]string, len(clauses)+1)
+	for i := range clauses {
+		if falls[i] {
+			labels[i+1] = tempName()
+		}
+	}
+	var chain ast.Stmt
+	var ifs []*ast.IfStmt
+	for i, cc := range clauses {
+		var cond ast.Expr
+		for _, e := range cc.List {
+			test := e
+			if s.Tag != nil {
+				test = &ast.BinaryExpr{X: tag, Op: token.EQL, Y: e}
+			}
+			if cond == nil {
+				cond = l.expr(test)
+			} else {
+				cond = &ast.BinaryExpr{X: cond, Op: token.LOR, Y: l.expr(test)}
+			}
+		}
+		var body []ast.Stmt
+		if labels[i] != "" {
+			body = append(body, &ast.LabeledStmt{Label: ast.NewIdent(labels[i]), Stmt: &ast.EmptyStmt{}})
+		}
+		body = append(body, l.stmts(cc.Body)...)
+		if falls[i] {
+			body = append(body, &ast.BranchStmt{Tok: token.GOTO, Label: ast.NewIdent(labels[i+1])})
+		}
+		if cc.List == nil {
+			// The default clause comes last, wherever it is written.
+			chain = &ast.BlockStmt{List: body}
+			continue
+		}
+		ifs = append(ifs, &ast.IfStmt{Cond: cond, Body: &ast.BlockStmt{List: body}})
+	}
+	for i := len(ifs) - 1; i >= 0; i-- {
+		ifs[i].Else = chain
+		chain = ifs[i]
+	}
+	if chain != nil {
+		list = append(list, chain)
+	}
+	return &ast.SwitchStmt{Tag: intLit(0), Body: &ast.BlockStmt{List: []ast.Stmt{
+		&ast.CaseClause{Body: []ast.Stmt{&ast.BlockStmt{List: list}}}}}}
+}
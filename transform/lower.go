@@ -0,0 +1,873 @@
+package transform
+
+import (
+	"fmt"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"strings"
+)
+
+// LowerToC turns the program into C.  The result is still a go AST,
+// so that the cprinter can print it, but it is no longer go:
+//
+//   - every type is an identifier naming a C type, e.g. ogo_int,
+//     ogo_slice or main_Point* (see runtime/ogo.h), and an array
+//     type is a struct wrapping a C array, so that it is a value,
+//   - every operation that C lacks (on strings and slices, say) is a
+//     call to the ogo runtime,
+//   - constants are C literals, and constant declarations are gone,
+//   - each var declares a single variable, and global variables are
+//     initialized at the start of main,
+//   - the declarations are sorted so that C sees every type before it
+//     is used, and a prototype of every function.
+//
+// A function literal in the result (which has no parameters) stands
+// for a GNU C statement expression, whose value is that of its last
+// statement.  The function literals of the program are lifted into C
+// functions.
+//
+// The go-to-go passes must already have eliminated := and the init
+// statements of if, for and switch statements, and boxed the captured
+// variables that need it.
+func LowerToC(f *ast.File, info *types.Info) {
+	l := &lowering{info: info, declared: make(map[*types.Named]bool),
+		generated: make(map[string]bool), descs: make(map[string]*ast.CompositeLit),
+		captures: captures(f, info), recovers: make(map[string]bool),
+		exposed: make(map[ast.Expr]bool), inbounds: inBounds(f, info)}
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Recv == nil && d.Body != nil && l.callsRecover(d.Body) {
+			l.recovers[d.Name.Name] = true
+		}
+	}
+	var funcs []ast.Decl
+	var mainfn *ast.FuncDecl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.GenDecl:
+			switch d.Tok {
+			case token.TYPE:
+				for _, s := range d.Specs {
+					l.declareType(info.Types[s.(*ast.TypeSpec).Name])
+				}
+			case token.VAR:
+				for _, s := range d.Specs {
+					l.globalVar(s.(*ast.ValueSpec))
+				}
+			}
+		case *ast.FuncDecl:
+			l.funcDecl(d, false)
+			if d.Name.Name == "main" && d.Recv == nil {
+				mainfn = d
+			} else {
+				l.protos = append(l.protos, &ast.FuncDecl{Name: d.Name, Type: d.Type})
+			}
+			funcs = append(funcs, d)
+		}
+	}
+	if mainfn != nil {
+		// The globals are initialized once main has its frame on the
+		// shadow stack.
+		inits := l.inits
+		if len(l.globals) > 0 {
+			l.table("ogo_gcglobals", &ast.ArrayType{Elt: ast.NewIdent("ogo_gcroot")},
+				&ast.CompositeLit{Elts: l.globals})
+			inits = append([]ast.Stmt{&ast.ExprStmt{X: call("ogo_gc_globals",
+				ast.NewIdent("ogo_gcglobals"), intLit(int64(len(l.globals))))}}, inits...)
+		}
+		list := mainfn.Body.List
+		mainfn.Body.List = append(append(list[:1:1], inits...), list[1:]...)
+	}
+	decls := append(l.forwards, l.types...)
+	decls = append(decls, l.vars...)
+	decls = append(decls, l.protos...)
+	decls = append(decls, l.tables...)
+	decls = append(decls, funcs...)
+	f.Decls = append(decls, l.helpers...)
+	f.Imports = nil
+}
+
+type lowering struct {
+	info *types.Info
+	// forwards holds typedefs for all the struct types, so that they
+	// may point to one another.
+	forwards []ast.Decl
+	// types holds the type declarations, each after those it needs.
+	types    []ast.Decl
+	declared map[*types.Named]bool
+	vars     []ast.Decl
+	protos   []ast.Decl
+	// tables holds the runtime type descriptors and itables, and
+	// descs holds the value of each descriptor by name.
+	tables []ast.Decl
+	descs  map[string]*ast.CompositeLit
+	// helpers holds the functions that we generate, such as those
+	// comparing arrays, and generated holds the names of those
+	// functions, and of the array types and tables we have declared.
+	helpers   []ast.Decl
+	generated map[string]bool
+	// inits holds the initialization of global variables, and globals
+	// the roots of those holding pointers.
+	inits   []ast.Stmt
+	globals []ast.Expr
+	// results holds the named results of the function being lowered.
+	results []*ast.Ident
+	// captures holds the variables that each function literal
+	// captures, and env those of the literal being lowered, which it
+	// finds in its environment.
+	captures map[*ast.FuncLit][]*types.Object
+	env      map[*types.Object]bool
+	nlits    int
+	// frame is true if the function being lowered has a frame, since
+	// it defers calls, and direct if it may recover.  recovers holds
+	// the top-level functions that may recover.
+	frame, direct bool
+	recovers      map[string]bool
+	ndefers, ngos int
+	// nroots is the number of roots that the function being lowered
+	// has in scope, and maxroots the most it has at once.  exposed
+	// holds the expressions whose values must be kept (see lower-gc.go).
+	nroots, maxroots int
+	exposed          map[ast.Expr]bool
+	// inbounds holds the index expressions whose indices we know to
+	// be in range (see bounds.go).
+	inbounds map[*ast.IndexExpr]bool
+}
+
+// CType is the name of the C type that represents values of type t.
+func CType(t types.Type) string {
+	switch t := t.(type) {
+	case *types.Basic:
+		if types.IsUntyped(t) {
+			t = types.Default(t).(*types.Basic)
+		}
+		if t.Kind == types.UnsafePointer {
+			return "void*"
+		}
+		return "ogo_" + t.Name
+	case *types.Named:
+		return t.Name
+	case *types.Pointer:
+		return CType(t.Elem) + "*"
+	case *types.Slice:
+		return "ogo_slice"
+	case *types.Array:
+		return fmt.Sprint("ogo_array_", t.Len, "_", mangle(CType(t.Elem)))
+	case *types.Struct:
+		return "ogo_" + typeName(t)
+	case *types.Interface:
+		if t.Sealed {
+			return "ogo_" + typeName(t)
+		}
+		return "ogo_iface"
+	case *types.Function:
+		return "ogo_func"
+	case *types.Chan:
+		return "ogo_chan"
+	case *types.Map:
+		return "ogo_map"
+	case types.TypeType:
+		return "ogo_Type"
+	}
+	panic(fmt.Sprintf("I can't yet represent %v in C", t))
+}
+
+// mangle turns a C type into something that may be part of an
+// identifier.
+func mangle(ctype string) string {
+	return strings.Replace(ctype, "*", "_ptr", -1)
+}
+
+func ctype(t types.Type) *ast.Ident {
+	return ast.NewIdent(CType(t))
+}
+
+// cbase is the C type that a value of type t really is, seeing
+// through typedefs, so that we know when a conversion is a no-op.
+func cbase(t types.Type) string {
+	if n, ok := t.(*types.Named); ok {
+		if _, isstruct := n.Underlying.(*types.Struct); !isstruct {
+			return cbase(n.Underlying)
+		}
+	}
+	return CType(t)
+}
+
+func typeDecl(name string, t ast.Expr) ast.Decl {
+	return &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{
+		&ast.TypeSpec{Name: ast.NewIdent(name), Type: t}}}
+}
+
+// declareType declares a named type in C, after any types that its
+// declaration needs.
+func (l *lowering) declareType(t types.Type) {
+	n, ok := t.(*types.Named)
+	if !ok || l.declared[n] {
+		return
+	}
+	l.declared[n] = true
+	switch u := n.Underlying.(type) {
+	case *types.Struct:
+		l.forwards = append(l.forwards, typeDecl(n.Name, ast.NewIdent("struct "+n.Name)))
+		fields := &ast.FieldList{}
+		for _, f := range u.Fields {
+			l.needType(f.Type)
+			fields.List = append(fields.List, &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent(f.Name)}, Type: ctype(f.Type)})
+		}
+		l.types = append(l.types, typeDecl(n.Name, &ast.StructType{Fields: fields}))
+	default:
+		l.needType(u)
+		l.types = append(l.types, typeDecl(n.Name, ctype(u)))
+	}
+}
+
+// declareArray declares the struct that holds an array of type a,
+// which is named by its length and element type, so that identical
+// array types are the same C type.
+func (l *lowering) declareArray(a *types.Array) {
+	name := CType(a)
+	if l.generated[name] {
+		return
+	}
+	l.generated[name] = true
+	l.forwards = append(l.forwards, typeDecl(name, ast.NewIdent("struct "+name)))
+	l.needType(a.Elem)
+	fields := &ast.FieldList{List: []*ast.Field{{
+		Names: []*ast.Ident{ast.NewIdent("a")},
+		Type:  &ast.ArrayType{Len: intLit(a.Len), Elt: ctype(a.Elem)}}}}
+	l.types = append(l.types, typeDecl(name, &ast.StructType{Fields: fields}))
+}
+
+// declareStruct declares the C struct for the unnamed struct type s,
+// which is named by its fields, so that identical struct types are the
+// same C type.
+func (l *lowering) declareStruct(s *types.Struct) {
+	name := CType(s)
+	if l.generated[name] {
+		return
+	}
+	l.generated[name] = true
+	l.forwards = append(l.forwards, typeDecl(name, ast.NewIdent("struct "+name)))
+	fields := &ast.FieldList{}
+	for _, f := range s.Fields {
+		l.needType(f.Type)
+		fields.List = append(fields.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(f.Name)}, Type: ctype(f.Type)})
+	}
+	l.types = append(l.types, typeDecl(name, &ast.StructType{Fields: fields}))
+}
+
+// needType declares the types that must be complete before a value
+// of type t can be declared.
+func (l *lowering) needType(t types.Type) {
+	switch t := t.(type) {
+	case *types.Named:
+		l.declareType(t)
+	case *types.Array:
+		l.declareArray(t)
+	case *types.Struct:
+		l.declareStruct(t)
+	case *types.Interface:
+		if t.Sealed {
+			l.declareSealed(t)
+		}
+	case *types.Pointer:
+		if n, ok := t.Elem.(*types.Named); ok {
+			if _, isstruct := n.Underlying.(*types.Struct); isstruct {
+				// The forward typedef is enough for a pointer.
+				return
+			}
+		}
+		l.needType(t.Elem)
+	}
+}
+
+// varSpec creates a declaration of a single variable.
+func varSpec(name *ast.Ident, t types.Type, value ast.Expr) *ast.GenDecl {
+	spec := &ast.ValueSpec{Names: []*ast.Ident{name}, Type: ctype(t)}
+	if value != nil {
+		spec.Values = []ast.Expr{value}
+	}
+	return &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{spec}}
+}
+
+func (l *lowering) globalVar(s *ast.ValueSpec) {
+	if len(s.Values) == 1 && l.isCommaOk(s.Values[0]) {
+		lhs := make([]ast.Expr, 2)
+		for i, n := range s.Names {
+			lhs[i] = n
+			if n.Name != "_" {
+				l.needType(l.info.TypeOf(n))
+				l.vars = append(l.vars, varSpec(n, l.info.TypeOf(n), nil))
+			}
+		}
+		l.expose(false, s.Values[0])
+		l.inits = append(l.inits, l.commaOk(lhs, s.Values[0]))
+		l.rootGlobals(s)
+		return
+	}
+	if len(s.Values) != 0 && len(s.Values) != len(s.Names) {
+		panic("I can't yet initialize variables from a function with multiple results")
+	}
+	for i, n := range s.Names {
+		t := l.info.TypeOf(n)
+		l.needType(t)
+		if n.Name == "_" {
+			if len(s.Values) > 0 {
+				l.inits = append(l.inits, l.discard(s.Values[i]))
+			}
+			continue
+		}
+		l.vars = append(l.vars, varSpec(n, t, nil))
+		if len(s.Values) > 0 {
+			l.expose(false, s.Values[i])
+			l.inits = append(l.inits, assign(n, l.expr(s.Values[i])))
+		}
+	}
+	l.rootGlobals(s)
+}
+
+// rootGlobals makes roots of the global variables of s that hold
+// pointers.
+func (l *lowering) rootGlobals(s *ast.ValueSpec) {
+	for _, n := range s.Names {
+		if t := l.info.TypeOf(n); n.Name != "_" && types.HasPointers(t) {
+			l.globals = append(l.globals, &ast.CompositeLit{Elts: []ast.Expr{
+				&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(n.Name)}, l.gcmap(t)}})
+		}
+	}
+}
+
+// isCommaOk tells whether e is a comma-ok expression giving two values.
+func (l *lowering) isCommaOk(e ast.Expr) bool {
+	_, ok := l.info.TypeOf(e).(*types.Tuple)
+	_, call := types.StripParens(e).(*ast.CallExpr)
+	return ok && !call
+}
+
+func assign(lhs, rhs ast.Expr) ast.Stmt {
+	return &ast.AssignStmt{Lhs: []ast.Expr{lhs}, Tok: token.ASSIGN, Rhs: []ast.Expr{rhs}}
+}
+
+// store lowers the assignment of v, which is already lowered, to lhs,
+// which may be an element of a map.
+func (l *lowering) store(lhs, v ast.Expr) ast.Stmt {
+	if l.isMapIndex(lhs) {
+		return l.mapStore(types.StripParens(lhs).(*ast.IndexExpr), v)
+	}
+	return assign(l.place(lhs), v)
+}
+
+// discard evaluates e for its side effects.
+func (l *lowering) discard(e ast.Expr) ast.Stmt {
+	return &ast.ExprStmt{X: cast("void", l.expr(e))}
+}
+
+// funcDecl lowers a function.  A function literal, which is a
+// closure, also takes the closure as its first parameter.
+func (l *lowering) funcDecl(d *ast.FuncDecl, closure bool) {
+	sig := l.info.Types[d.Name].(*types.Function)
+	var body, roots []ast.Stmt
+	l.nroots, l.maxroots = 0, 0
+	params := &ast.FieldList{}
+	// param adds a parameter, which is a root if it holds pointers.
+	param := func(n *ast.Ident, t types.Type, ctype ast.Expr) {
+		params.List = append(params.List, &ast.Field{Names: []*ast.Ident{n}, Type: ctype})
+		if r := l.root(n.Name, t); r != nil {
+			roots = append(roots, r)
+		}
+	}
+	if closure {
+		param(ast.NewIdent("ogo_ctx"), sig, ast.NewIdent("ogo_func"))
+	}
+	if d.Recv != nil {
+		// A method takes its receiver as its first parameter.
+		m := l.info.Objects[d.Name]
+		n := ast.NewIdent(tempName())
+		if names := d.Recv.List[0].Names; len(names) == 1 && names[0].Name != "_" {
+			n = names[0]
+		}
+		l.needType(m.Recv)
+		param(n, m.Recv, ctype(m.Recv))
+		d.Name = ast.NewIdent(methodName(m))
+		d.Recv = nil
+	}
+	i := 0
+	for _, f := range d.Type.Params.List {
+		names := f.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{ast.NewIdent("_")}
+		}
+		for _, n := range names {
+			t := sig.Parameters[i]
+			i++
+			l.needType(t)
+			if n.Name == "_" {
+				n = ast.NewIdent(tempName())
+			}
+			param(n, t, ctype(t))
+		}
+	}
+	if d.Body != nil {
+		l.frame, l.direct = hasDefer(d.Body), l.callsRecover(d.Body)
+	}
+	var results *ast.FieldList
+	l.results = nil
+	switch len(sig.Results) {
+	case 0:
+	case 1:
+		l.needType(sig.Results[0])
+		results = &ast.FieldList{List: []*ast.Field{{Type: ctype(sig.Results[0])}}}
+		if names := d.Type.Results.List[0].Names; len(names) == 1 {
+			n := names[0]
+			if n.Name == "_" {
+				n = ast.NewIdent(tempName())
+			}
+			l.results = []*ast.Ident{n}
+		}
+		if len(l.results) == 1 || l.frame {
+			body = append(body, l.declare(l.result(), sig.Results[0], l.zero(sig.Results[0]))...)
+		}
+	default:
+		panic("I can't yet lower functions with multiple results to C")
+	}
+	d.Type = &ast.FuncType{Params: params, Results: results}
+	if d.Body == nil {
+		return
+	}
+	body = append(roots, body...)
+	if l.direct {
+		// Only the deferred call itself may recover.
+		body = append([]ast.Stmt{cVar("ogo_direct", "ogo_bool", call("ogo_take_deferred",
+			cast("void (*)(void)", ast.NewIdent(d.Name.Name))))}, body...)
+	}
+	if l.frame {
+		body = append(body, l.withFrame(l.stmts(d.Body.List), sig)...)
+	} else {
+		body = append(body, l.stmts(d.Body.List)...)
+	}
+	// The function has room on the shadow stack for all its roots.
+	d.Body.List = append([]ast.Stmt{&ast.ExprStmt{X: call("OGO_GCFRAME", intLit(int64(l.maxroots)))}},
+		body...)
+}
+
+func (l *lowering) block(b *ast.BlockStmt) *ast.BlockStmt {
+	b.List = l.stmts(b.List)
+	return b
+}
+
+// stmts lowers a list of statements, each preceded by a safe point at
+// which the collector may run.
+func (l *lowering) stmts(list []ast.Stmt) []ast.Stmt {
+	out := make([]ast.Stmt, 0, 2*len(list))
+	nroots := l.nroots
+	for _, s := range list {
+		safe := &ast.ExprStmt{X: call("OGO_GCSAFE", intLit(int64(l.nroots)))}
+		if lab, ok := s.(*ast.LabeledStmt); ok {
+			if d, ok := lab.Stmt.(*ast.DeclStmt); ok {
+				// C has no labeled declarations.
+				out = append(out, &ast.LabeledStmt{Label: lab.Label, Stmt: &ast.EmptyStmt{}})
+				s = d
+			}
+		}
+		if d, ok := s.(*ast.DeclStmt); ok {
+			// The variables must be declared in the enclosing block.
+			if decls := l.declStmt(d); len(decls) > 0 {
+				out = append(append(out, safe), decls...)
+			}
+			continue
+		}
+		n := l.nroots
+		if s := l.stmt(s); s != nil {
+			out = append(out, safe, s)
+		}
+		l.nroots = n
+	}
+	l.nroots = nroots
+	return out
+}
+
+// stmt lowers a statement, returning nil if nothing is left of it.
+func (l *lowering) stmt(s ast.Stmt) ast.Stmt {
+	l.exposeStmt(s)
+	switch s := s.(type) {
+	case nil:
+		return nil
+	case *ast.EmptyStmt, *ast.BranchStmt:
+		return s
+	case *ast.BlockStmt:
+		return l.block(s)
+	case *ast.ExprStmt:
+		if c, ok := s.X.(*ast.CallExpr); ok && l.isBuiltin(c.Fun, "print", "println") {
+			return l.print(c)
+		}
+		s.X = l.expr(s.X)
+		return s
+	case *ast.IncDecStmt:
+		if l.isMapIndex(s.X) {
+			one := &ast.BasicLit{Kind: token.INT, Value: "1"}
+			l.info.Types[one] = l.info.TypeOf(s.X)
+			l.info.Values[one] = constant.MakeInt64(1)
+			op := token.ADD
+			if s.Tok == token.DEC {
+				op = token.SUB
+			}
+			return l.mapAssignOp(types.StripParens(s.X).(*ast.IndexExpr), op, one)
+		}
+		op := token.ADD
+		if s.Tok == token.DEC {
+			op = token.SUB
+		}
+		return l.update(s, s.X, op, nil, intLit(1))
+	case *ast.AssignStmt:
+		return l.assignStmt(s)
+	case *ast.DeclStmt:
+		switch out := l.declStmt(s); len(out) {
+		case 0:
+			return nil
+		case 1:
+			return out[0]
+		default:
+			return &ast.BlockStmt{List: out}
+		}
+	case *ast.ReturnStmt:
+		if l.frame {
+			return l.frameReturn(s)
+		}
+		if len(s.Results) == 0 && len(l.results) == 1 {
+			s.Results = []ast.Expr{l.results[0]}
+			return s
+		}
+		if len(s.Results) > 1 {
+			panic("I can't yet return multiple results in C")
+		}
+		for i := range s.Results {
+			s.Results[i] = l.expr(s.Results[i])
+		}
+		return s
+	case *ast.LabeledStmt:
+		return l.labeled(s)
+	case *ast.IfStmt:
+		if s.Init != nil {
+			panic("If statements must have no init statement when lowered to C")
+		}
+		s.Cond = l.expr(s.Cond)
+		l.block(s.Body)
+		if s.Else != nil {
+			s.Else = l.stmt(s.Else)
+		}
+		return s
+	case *ast.ForStmt:
+		if s.Init != nil {
+			panic("For statements must have no init statement when lowered to C")
+		}
+		if s.Cond != nil {
+			s.Cond = l.expr(s.Cond)
+		}
+		s.Post = l.stmt(s.Post)
+		l.block(s.Body)
+		return s
+	case *ast.RangeStmt:
+		return l.rangeStmt(s)
+	case *ast.SwitchStmt:
+		return l.switchStmt(s)
+	case *ast.TypeSwitchStmt:
+		return l.typeSwitch(s)
+	case *ast.DeferStmt:
+		return l.deferStmt(s)
+	case *ast.GoStmt:
+		return l.goStmt(s)
+	case *ast.SendStmt:
+		return &ast.ExprStmt{X: l.send(s.Chan, s.Value)}
+	case *ast.SelectStmt:
+		return l.selectStmt(s)
+	}
+	panic(fmt.Sprintf("I can't yet lower statements of type %T to C", s))
+}
+
+// labeled lowers a labeled statement.  C has no labeled break or
+// continue, so a break naming the label becomes a goto to a label just
+// after the statement, and a continue a goto to a label at the end of
+// the body of the loop.  A goto is the same in C as in go.
+func (l *lowering) labeled(s *ast.LabeledStmt) ast.Stmt {
+	brk, cont := "ogo_break_"+s.Label.Name, "ogo_continue_"+s.Label.Name
+	breaks, continues := false, false
+	ast.Inspect(s.Stmt, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BranchStmt:
+			if n.Label == nil || n.Label.Name != s.Label.Name {
+				break
+			}
+			switch n.Tok {
+			case token.BREAK:
+				n.Tok, n.Label, breaks = token.GOTO, ast.NewIdent(brk), true
+			case token.CONTINUE:
+				n.Tok, n.Label, continues = token.GOTO, ast.NewIdent(cont), true
+			}
+		}
+		return true
+	})
+	if continues {
+		var body *ast.BlockStmt
+		switch loop := s.Stmt.(type) {
+		case *ast.ForStmt:
+			body = loop.Body
+		case *ast.RangeStmt:
+			body = loop.Body
+		}
+		body.List = append(body.List, &ast.LabeledStmt{Label: ast.NewIdent(cont), Stmt: &ast.EmptyStmt{}})
+	}
+	s.Stmt = l.stmt(s.Stmt)
+	if s.Stmt == nil {
+		s.Stmt = &ast.EmptyStmt{}
+	}
+	if !breaks {
+		return s
+	}
+	return &ast.BlockStmt{List: []ast.Stmt{s,
+		&ast.LabeledStmt{Label: ast.NewIdent(brk), Stmt: &ast.EmptyStmt{}}}}
+}
+
+// declStmt lowers a declaration, giving a declaration for each
+// variable.
+func (l *lowering) declStmt(s *ast.DeclStmt) []ast.Stmt {
+	d := s.Decl.(*ast.GenDecl)
+	switch d.Tok {
+	case token.CONST:
+		return nil
+	case token.TYPE:
+		for _, spec := range d.Specs {
+			l.declareType(l.info.Types[spec.(*ast.TypeSpec).Name])
+		}
+		return nil
+	}
+	var out []ast.Stmt
+	for _, spec := range d.Specs {
+		spec := spec.(*ast.ValueSpec)
+		if len(spec.Values) == 1 && l.isCommaOk(spec.Values[0]) {
+			lhs := make([]ast.Expr, 2)
+			for i, n := range spec.Names {
+				lhs[i] = n
+				if n.Name != "_" {
+					t := l.info.TypeOf(n)
+					l.needType(t)
+					out = append(out, l.declare(n, t, l.zero(t))...)
+				}
+			}
+			l.expose(false, spec.Values[0])
+			out = append(out, l.commaOk(lhs, spec.Values[0]))
+			continue
+		}
+		if len(spec.Values) != 0 && len(spec.Values) != len(spec.Names) {
+			panic("I can't yet initialize variables from a function with multiple results")
+		}
+		for i, n := range spec.Names {
+			t := l.info.TypeOf(n)
+			l.needType(t)
+			var v ast.Expr
+			if len(spec.Values) > 0 {
+				l.expose(false, spec.Values[i])
+				v = l.expr(spec.Values[i])
+			} else {
+				v = l.zero(t)
+			}
+			if n.Name == "_" {
+				out = append(out, &ast.ExprStmt{X: cast("void", v)})
+				continue
+			}
+			out = append(out, l.declare(n, t, v)...)
+		}
+	}
+	return out
+}
+
+func (l *lowering) assignStmt(s *ast.AssignStmt) ast.Stmt {
+	switch s.Tok {
+	case token.DEFINE:
+		panic("Short variable declarations must be eliminated before lowering to C")
+	case token.ASSIGN:
+		if l.isCommaOk(s.Rhs[0]) && len(s.Lhs) == 2 {
+			return l.commaOk(s.Lhs, s.Rhs[0])
+		}
+		if len(s.Lhs) != len(s.Rhs) {
+			panic("I can't yet assign from a function with multiple results in C")
+		}
+		if len(s.Lhs) == 1 {
+			if isBlank(s.Lhs[0]) {
+				return l.discard(s.Rhs[0])
+			}
+			return l.store(s.Lhs[0], l.expr(s.Rhs[0]))
+		}
+		// A tuple assignment evaluates everything before assigning
+		// anything, which we do using temporaries.
+		var temps, assigns []ast.Stmt
+		for i, r := range s.Rhs {
+			if isBlank(s.Lhs[i]) {
+				temps = append(temps, l.discard(r))
+				continue
+			}
+			tmp := ast.NewIdent(tempName())
+			temps = append(temps, &ast.DeclStmt{
+				Decl: varSpec(tmp, l.info.TypeOf(s.Lhs[i]), l.expr(r))})
+			assigns = append(assigns, l.store(s.Lhs[i], ast.NewIdent(tmp.Name)))
+		}
+		return &ast.BlockStmt{List: append(temps, assigns...)}
+	}
+	// An assignment operation like x += y
+	op := s.Tok - (token.ADD_ASSIGN - token.ADD)
+	t := l.info.TypeOf(s.Lhs[0])
+	if l.isMapIndex(s.Lhs[0]) {
+		return l.mapAssignOp(types.StripParens(s.Lhs[0]).(*ast.IndexExpr), op, s.Rhs[0])
+	}
+	if types.IsString(t) || op == token.AND_NOT {
+		x := s.Lhs[0]
+		b := &ast.BinaryExpr{X: x, Op: op, Y: s.Rhs[0]}
+		l.info.Types[b] = t
+		return assign(l.place(x), l.expr(b))
+	}
+	return l.update(s, s.Lhs[0], op, s.Rhs[0], l.expr(s.Rhs[0]))
+}
+
+// update lowers s, which is x op= e, where e is lowered to y, or x++
+// or x--, where e is nil and y is 1.  Where C's operator does what
+// Go's does, s keeps it; otherwise x becomes x op y as arith works it
+// out, and if x takes calls to work out, OGO_AT works it out once.
+func (l *lowering) update(s ast.Stmt, x ast.Expr, op token.Token, e, y ast.Expr) ast.Stmt {
+	t := l.info.TypeOf(x)
+	p := l.place(x)
+	v := l.arith(op, t, p, e, y)
+	if b, ok := v.(*ast.BinaryExpr); ok && b.X == p && b.Op == op {
+		switch s := s.(type) {
+		case *ast.IncDecStmt:
+			s.X = p
+		case *ast.AssignStmt:
+			s.Lhs[0], s.Rhs[0] = p, b.Y
+		}
+		return s
+	}
+	calls := false
+	ast.Inspect(p, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok {
+			// A cast is no call.
+			_, iscast := c.Fun.(*ast.ParenExpr)
+			calls = calls || !iscast
+		}
+		return !calls
+	})
+	if !calls {
+		return assign(p, v)
+	}
+	at := &ast.StarExpr{X: ast.NewIdent("ogo_at")}
+	return &ast.ExprStmt{X: call("OGO_AT", &ast.UnaryExpr{Op: token.AND, X: p}, l.arith(op, t, at, e, y))}
+}
+
+// rangeStmt lowers a range over a string or a map; the go-to-go
+// passes have already turned other range statements into ordinary
+// loops.
+func (l *lowering) rangeStmt(s *ast.RangeStmt) ast.Stmt {
+	if types.IsMap(l.info.Types[s.X]) {
+		return l.mapRange(s)
+	}
+	if !types.IsString(l.info.Types[s.X]) {
+		panic(fmt.Sprintf("I can't yet range over %v in C", l.info.Types[s.X]))
+	}
+	str, i, next, r := tempName(), tempName(), tempName(), tempName()
+	id := ast.NewIdent
+	decl := func(name string, t types.Type, v ast.Expr) ast.Stmt {
+		return &ast.DeclStmt{Decl: varSpec(id(name), t, v)}
+	}
+	x := l.expr(s.X)
+	root := l.root(str, types.Typ[types.String])
+	body := []ast.Stmt{
+		decl(r, types.Typ[types.Int32], nil),
+		assign(id(next), call("ogo_decode_rune", id(str), id(i),
+			&ast.UnaryExpr{Op: token.AND, X: id(r)})),
+	}
+	for _, kv := range [][2]ast.Expr{{s.Key, id(i)}, {s.Value, id(r)}} {
+		switch {
+		case kv[0] == nil || isBlank(kv[0]):
+		case s.Tok == token.DEFINE:
+			body = append(body, l.declare(kv[0].(*ast.Ident), l.info.TypeOf(kv[0]), kv[1])...)
+		default:
+			body = append(body, l.store(kv[0], kv[1]))
+		}
+	}
+	s.Body.List = append(body, l.stmts(s.Body.List)...)
+	zero := &ast.BasicLit{Kind: token.INT, Value: "0"}
+	return &ast.BlockStmt{List: []ast.Stmt{
+		decl(str, types.Typ[types.String], x),
+		root,
+		decl(i, types.Typ[types.Int], zero),
+		decl(next, types.Typ[types.Int], zero),
+		&ast.ForStmt{
+			Cond: &ast.BinaryExpr{X: id(i), Op: token.LSS,
+				Y: &ast.SelectorExpr{X: id(str), Sel: id("len")}},
+			Post: assign(id(i), id(next)),
+			Body: s.Body,
+		},
+	}}
+}
+
+// print lowers a call to print or println, which evaluates all its
+// arguments before printing any of them.
+func (l *lowering) print(c *ast.CallExpr) ast.Stmt {
+	var out, prints []ast.Stmt
+	printc := func(f string, args ...ast.Expr) {
+		prints = append(prints, &ast.ExprStmt{X: call(f, args...)})
+	}
+	ln := l.isBuiltin(c.Fun, "println")
+	for i, a := range c.Args {
+		if i > 0 && ln {
+			printc("ogo_print_space")
+		}
+		t := l.info.Types[a]
+		if t == types.Typ[types.UntypedNil] {
+			printc("ogo_print_string", str("nil"))
+			continue
+		}
+		x := l.expr(a)
+		if _, isconst := l.info.Values[a]; !isconst {
+			tmp := tempName()
+			out = append(out, &ast.DeclStmt{Decl: varSpec(ast.NewIdent(tmp), t, x)})
+			x = ast.NewIdent(tmp)
+		}
+		bits := &ast.BasicLit{Kind: token.INT, Value: "64"}
+		if types.Identical(types.Underlying(t), types.Typ[types.Float32]) ||
+			types.Identical(types.Underlying(t), types.Typ[types.Complex64]) {
+			bits.Value = "32"
+		}
+		switch {
+		case types.IsBoolean(t):
+			printc("ogo_print_bool", x)
+		case types.IsUnsigned(t):
+			printc("ogo_print_uint", x)
+		case types.IsInteger(t):
+			printc("ogo_print_int", x)
+		case types.IsFloat(t):
+			printc("ogo_print_float", x, bits)
+		case types.IsComplex(t):
+			printc("ogo_print_complex", x, bits)
+		case types.IsString(t):
+			printc("ogo_print_string", x)
+		case types.IsPointer(t) || types.IsChan(t) || types.IsMap(t) ||
+			types.Identical(t, types.TypeType{}):
+			printc("ogo_print_pointer", x)
+		case types.IsSlice(t):
+			printc("ogo_print_slice", x)
+		case types.IsSealed(t):
+			printc("ogo_print_iface", l.sealedToInterface(t, &types.Interface{}, x))
+		case types.IsInterface(t):
+			printc("ogo_print_iface", x)
+		default:
+			panic(fmt.Sprintf("I can't yet print a %v", t))
+		}
+	}
+	if ln {
+		printc("ogo_print_nl")
+	}
+	return &ast.BlockStmt{List: append(out, prints...)}
+}
@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// EliminateMethodValues turns each method value, a method selected
+// but not called, into a function literal closing over its receiver,
+// and each method expression into a function literal taking the
+// receiver as its first parameter, so that the backend only ever sees
+// methods called.  Thus
+//
+//	f := c.Add
+//	g := (*Counter).Add
+//
+// becomes
+//
+//	f := func(recv *Counter) func(param0 int) {
+//		return func(param0 int) { recv.Add(param0) }
+//	}(&c)
+//	g := func(param0 *Counter, param1 int) { param0.Add(param1) }
+//
+// where the receiver is evaluated, and if need be its address taken
+// or the pointer to it followed, once when the method value is, as Go
+// does.
+func EliminateMethodValues(f *ast.File, info *types.Info) {
+	called := make(map[ast.Expr]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok {
+			called[types.StripParens(c.Fun)] = true
+		}
+		return true
+	})
+	RewriteExprs(f, func(e ast.Expr) ast.Expr {
+		sel, ok := e.(*ast.SelectorExpr)
+		if !ok {
+			return e
+		}
+		m := info.Objects[sel.Sel]
+		if m == nil || m.Kind != types.Func {
+			return e
+		}
+		if info.IsType(sel.X) {
+			return methodExpr(sel, info.TypeOf(sel).(*types.Function))
+		}
+		if called[sel] {
+			return e
+		}
+		return methodValue(sel, m, info)
+	})
+}
+
+// methodExpr gives a function literal that calls the method of the
+// method expression sel, whose type is sig.
+func methodExpr(sel *ast.SelectorExpr, sig *types.Function) ast.Expr {
+	ft := sig.Expr().(*ast.FuncType)
+	var args []ast.Expr
+	for _, p := range ft.Params.List[1:] {
+		args = append(args, ast.NewIdent(p.Names[0].Name))
+	}
+	recv := ast.NewIdent(ft.Params.List[0].Names[0].Name)
+	return &ast.FuncLit{Type: ft,
+		Body: forward(&ast.SelectorExpr{X: recv, Sel: ast.NewIdent(sel.Sel.Name)}, args, sig)}
+}
+
+// methodValue gives a call of a function literal that takes the
+// receiver of the method value sel, and gives a function literal
+// calling the method m on it.
+func methodValue(sel *ast.SelectorExpr, m *types.Object, info *types.Info) ast.Expr {
+	sig := info.TypeOf(sel).(*types.Function)
+	xt := info.TypeOf(sel.X)
+	x := sel.X
+	if m.Recv != nil {
+		switch p, isptr := types.Underlying(xt).(*types.Pointer); {
+		case types.IsPointer(m.Recv) && !isptr:
+			xt = &types.Pointer{Elem: xt}
+			x = &ast.UnaryExpr{Op: token.AND, X: x}
+		case !types.IsPointer(m.Recv) && isptr:
+			xt = p.Elem
+			x = &ast.StarExpr{X: x}
+		}
+	}
+	ft := sig.Expr().(*ast.FuncType)
+	var args []ast.Expr
+	for _, p := range ft.Params.List {
+		args = append(args, ast.NewIdent(p.Names[0].Name))
+	}
+	inner := &ast.FuncLit{Type: ft,
+		Body: forward(&ast.SelectorExpr{X: ast.NewIdent("recv"), Sel: ast.NewIdent(sel.Sel.Name)}, args, sig)}
+	outer := &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{List: []*ast.Field{
+				{Names: []*ast.Ident{ast.NewIdent("recv")}, Type: xt.Expr()}}},
+			Results: &ast.FieldList{List: []*ast.Field{{Type: sig.Expr()}}}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{inner}}}},
+	}
+	return &ast.CallExpr{Fun: outer, Args: []ast.Expr{x}}
+}
+
+// forward gives the body of a function of type sig that calls fun
+// with args, returning whatever it returns.
+func forward(fun ast.Expr, args []ast.Expr, sig *types.Function) *ast.BlockStmt {
+	c := &ast.CallExpr{Fun: fun, Args: args}
+	if sig.Variadic {
+		c.Ellipsis = 1
+	}
+	var body ast.Stmt = &ast.ExprStmt{X: c}
+	if len(sig.Results) > 0 {
+		body = &ast.ReturnStmt{Results: []ast.Expr{c}}
+	}
+	return &ast.BlockStmt{List: []ast.Stmt{body}}
+}
@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// ExplicitPromotion spells out the embedded fields that each selector
+// of a promoted field or method goes through, and declares each
+// promoted method as a method of its own, so that nothing later needs
+// to know about promotion.  Thus
+//
+//	type Inner struct{ x int }
+//
+//	func (i *Inner) Bump() { i.x++ }
+//
+//	type Outer struct{ Inner }
+//
+//	var o Outer
+//	o.x = o.x + 1
+//
+// becomes
+//
+//	type Inner struct{ x int }
+//
+//	func (i *Inner) Bump() { i.x++ }
+//
+//	type Outer struct{ Inner }
+//
+//	func (recv *Outer) Bump() { recv.Inner.Bump() }
+//
+//	var o Outer
+//	o.Inner.x = o.Inner.x + 1
+//
+// where the new method has a pointer receiver because Bump has one,
+// and Outer holds Inner itself rather than a pointer to it.
+func ExplicitPromotion(f *ast.File, info *types.Info) {
+	RewriteExprs(f, func(e ast.Expr) ast.Expr {
+		sel, ok := e.(*ast.SelectorExpr)
+		if !ok || info.Promoted[sel] == nil {
+			return e
+		}
+		for _, name := range info.Promoted[sel] {
+			sel.X = &ast.SelectorExpr{X: sel.X, Sel: ast.NewIdent(name)}
+		}
+		return sel
+	})
+	var methods []ast.Decl
+	for _, d := range f.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.TYPE {
+			for _, spec := range g.Specs {
+				methods = append(methods, promote(info.Types[spec.(*ast.TypeSpec).Name])...)
+			}
+		}
+	}
+	f.Decls = append(f.Decls, methods...)
+}
+
+// promote declares the methods promoted to the named type t.
+func promote(t types.Type) []ast.Decl {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return nil
+	}
+	own := make(map[*types.Object]bool)
+	for _, m := range n.Methods {
+		own[m] = true
+	}
+	onValue := make(map[*types.Object]bool)
+	for _, m := range types.MethodSet(n) {
+		onValue[m] = true
+	}
+	var out []ast.Decl
+	for _, m := range types.MethodSet(&types.Pointer{Elem: n}) {
+		if own[m] {
+			continue
+		}
+		var recvType ast.Expr = ast.NewIdent(n.Name)
+		if !onValue[m] {
+			recvType = &ast.StarExpr{X: recvType}
+		}
+		sig := m.Type.(*types.Function)
+		ft := sig.Expr().(*ast.FuncType)
+		var args []ast.Expr
+		for _, p := range ft.Params.List {
+			args = append(args, ast.NewIdent(p.Names[0].Name))
+		}
+		var x ast.Expr = ast.NewIdent("recv")
+		for _, name := range types.EmbeddedPath(n, m.Name) {
+			x = &ast.SelectorExpr{X: x, Sel: ast.NewIdent(name)}
+		}
+		c := &ast.CallExpr{Fun: &ast.SelectorExpr{X: x, Sel: ast.NewIdent(m.Name)}, Args: args}
+		if sig.Variadic {
+			c.Ellipsis = 1
+		}
+		var body ast.Stmt = &ast.ExprStmt{X: c}
+		if len(sig.Results) > 0 {
+			body = &ast.ReturnStmt{Results: []ast.Expr{c}}
+		}
+		out = append(out, &ast.FuncDecl{
+			Recv: &ast.FieldList{List: []*ast.Field{
+				{Names: []*ast.Ident{ast.NewIdent("recv")}, Type: recvType}}},
+			Name: ast.NewIdent(m.Name),
+			Type: ft,
+			Body: &ast.BlockStmt{List: []ast.Stmt{body}},
+		})
+	}
+	return out
+}
@@ -0,0 +1,133 @@
+package transform
+
+import (
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+)
+
+// EliminateRange turns range statements over slices, arrays (or
+// pointers to them) and integers into
+// ordinary for loops that index explicitly, so
+//
+//	for i, v := range xs {
+//		...
+//	}
+//
+// becomes
+//
+//	{
+//		tmp := xs
+//		for j := 0; j < len(tmp); j++ {
+//			i := j
+//			v := tmp[j]
+//			...
+//		}
+//	}
+//
+// where the fresh variables for each iteration give the same result
+// as the range statement, even if the body modifies i or captures it.
+// A range over a channel receives until the channel is closed.
+func EliminateRange(f *ast.File, info *types.Info) {
+	made := make(map[ast.Stmt]bool)
+	targets := gotoTargets(f)
+	RewriteStmts(f, func(s ast.Stmt) ast.Stmt {
+		switch s := s.(type) {
+		case *ast.LabeledStmt:
+			return relabel(s, made, targets)
+		case *ast.RangeStmt:
+			t := types.Underlying(info.Types[s.X])
+			x, i := tempName(), tempName()
+			var limit, value ast.Expr
+			switch {
+			case types.IsSlice(t) || types.IsArray(t) || types.IsPointer(t):
+				limit = &ast.CallExpr{Fun: ast.NewIdent("len"),
+					Args: []ast.Expr{ast.NewIdent(x)}}
+				value = &ast.IndexExpr{X: ast.NewIdent(x), Index: ast.NewIdent(i)}
+			case types.IsInteger(t):
+				limit = ast.NewIdent(x)
+			case types.IsChan(t):
+				b := rangeChan(s, x)
+				made[b] = true
+				return b
+			default:
+				return s
+			}
+			zero := ast.Expr(&ast.BasicLit{Kind: token.INT, Value: "0"})
+			if types.IsInteger(t) {
+				zero = Convert(zero, info.Types[s.X])
+			}
+			loop := &ast.ForStmt{
+				Init: &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(i)},
+					Tok: token.DEFINE, Rhs: []ast.Expr{zero}},
+				Cond: &ast.BinaryExpr{X: ast.NewIdent(i), Op: token.LSS, Y: limit},
+				Post: &ast.IncDecStmt{X: ast.NewIdent(i), Tok: token.INC},
+				Body: s.Body,
+			}
+			var vars []ast.Stmt
+			for _, kv := range [][2]ast.Expr{{s.Key, ast.NewIdent(i)}, {s.Value, value}} {
+				if kv[0] == nil || isBlank(kv[0]) {
+					continue
+				}
+				vars = append(vars, &ast.AssignStmt{Lhs: []ast.Expr{kv[0]},
+					Tok: s.Tok, Rhs: []ast.Expr{kv[1]}})
+			}
+			loop.Body.List = append(vars, loop.Body.List...)
+			b := &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(x)}, Tok: token.DEFINE,
+					Rhs: []ast.Expr{s.X}},
+				loop,
+			}}
+			made[b] = true
+			return b
+		}
+		return s
+	})
+}
+
+// rangeChan turns a range statement over a channel into a loop that
+// receives from it, with x holding the channel, so that
+//
+//	for v := range ch {
+//		...
+//	}
+//
+// becomes
+//
+//	{
+//		tmp := ch
+//		for {
+//			w, ok := <-tmp
+//			if !ok {
+//				break
+//			}
+//			v := w
+//			...
+//		}
+//	}
+func rangeChan(s *ast.RangeStmt, x string) *ast.BlockStmt {
+	v, ok := ast.NewIdent("_"), tempName()
+	if s.Key != nil && !isBlank(s.Key) {
+		v = ast.NewIdent(tempName())
+	}
+	body := []ast.Stmt{
+		&ast.AssignStmt{Lhs: []ast.Expr{v, ast.NewIdent(ok)}, Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent(x)}}},
+		&ast.IfStmt{Cond: &ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent(ok)},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.BranchStmt{Tok: token.BREAK}}}},
+	}
+	if !isBlank(v) {
+		body = append(body, &ast.AssignStmt{Lhs: []ast.Expr{s.Key}, Tok: s.Tok,
+			Rhs: []ast.Expr{ast.NewIdent(v.Name)}})
+	}
+	return &ast.BlockStmt{List: []ast.Stmt{
+		&ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent(x)}, Tok: token.DEFINE,
+			Rhs: []ast.Expr{s.X}},
+		&ast.ForStmt{Body: &ast.BlockStmt{List: append(body, s.Body.List...)}},
+	}}
+}
+
+func isBlank(e ast.Expr) bool {
+	id, ok := e.(*ast.Ident)
+	return ok && id.Name == "_"
+}
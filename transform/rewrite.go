@@ -0,0 +1,457 @@
+package transform
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// RewriteExprs calls f on every expression within n (after rewriting
+// the subexpressions of that expression), and replaces the expression
+// with whatever f returns.  Identifiers that merely name things
+// (labels, fields in selectors and the names of declarations) are not
+// visited.
+func RewriteExprs(n ast.Node, f func(ast.Expr) ast.Expr) {
+	r := rewriter(f)
+	switch n := n.(type) {
+	case *ast.File:
+		for _, d := range n.Decls {
+			r.decl(d)
+		}
+	case ast.Decl:
+		r.decl(n)
+	case ast.Stmt:
+		r.stmt(n)
+	case ast.Expr:
+		r.expr(n)
+	default:
+		panic(fmt.Sprintf("RewriteExprs can't handle %T", n))
+	}
+}
+
+type rewriter func(ast.Expr) ast.Expr
+
+func (r rewriter) decl(d ast.Decl) {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		r.fieldList(d.Recv)
+		r.funcType(d.Type)
+		if d.Body != nil {
+			r.stmt(d.Body)
+		}
+	case *ast.GenDecl:
+		for _, s := range d.Specs {
+			switch s := s.(type) {
+			case *ast.ValueSpec:
+				if s.Type != nil {
+					s.Type = r.expr(s.Type)
+				}
+				r.exprs(s.Values)
+			case *ast.TypeSpec:
+				r.fieldList(s.TypeParams)
+				s.Type = r.expr(s.Type)
+			case *ast.ImportSpec:
+				// Nothing to do here.
+			}
+		}
+	}
+}
+
+func (r rewriter) fieldList(fl *ast.FieldList) {
+	if fl != nil {
+		for _, f := range fl.List {
+			f.Type = r.expr(f.Type)
+		}
+	}
+}
+
+func (r rewriter) funcType(ft *ast.FuncType) {
+	r.fieldList(ft.TypeParams)
+	r.fieldList(ft.Params)
+	r.fieldList(ft.Results)
+}
+
+func (r rewriter) exprs(es []ast.Expr) {
+	for i := range es {
+		es[i] = r.expr(es[i])
+	}
+}
+
+func (r rewriter) expr(e ast.Expr) ast.Expr {
+	switch e := e.(type) {
+	case nil:
+		return nil
+	case *ast.Ident, *ast.BasicLit, *ast.BadExpr:
+	case *ast.ParenExpr:
+		e.X = r.expr(e.X)
+	case *ast.SelectorExpr:
+		e.X = r.expr(e.X)
+	case *ast.StarExpr:
+		e.X = r.expr(e.X)
+	case *ast.UnaryExpr:
+		e.X = r.expr(e.X)
+	case *ast.BinaryExpr:
+		e.X = r.expr(e.X)
+		e.Y = r.expr(e.Y)
+	case *ast.KeyValueExpr:
+		if _, isname := e.Key.(*ast.Ident); !isname {
+			e.Key = r.expr(e.Key)
+		}
+		e.Value = r.expr(e.Value)
+	case *ast.IndexExpr:
+		e.X = r.expr(e.X)
+		e.Index = r.expr(e.Index)
+	case *ast.IndexListExpr:
+		e.X = r.expr(e.X)
+		r.exprs(e.Indices)
+	case *ast.SliceExpr:
+		e.X = r.expr(e.X)
+		e.Low = r.expr(e.Low)
+		e.High = r.expr(e.High)
+		e.Max = r.expr(e.Max)
+	case *ast.TypeAssertExpr:
+		e.X = r.expr(e.X)
+		e.Type = r.expr(e.Type)
+	case *ast.CallExpr:
+		e.Fun = r.expr(e.Fun)
+		r.exprs(e.Args)
+	case *ast.CompositeLit:
+		e.Type = r.expr(e.Type)
+		r.exprs(e.Elts)
+	case *ast.FuncLit:
+		r.funcType(e.Type)
+		r.stmt(e.Body)
+	case *ast.Ellipsis:
+		e.Elt = r.expr(e.Elt)
+	case *ast.ArrayType:
+		e.Len = r.expr(e.Len)
+		e.Elt = r.expr(e.Elt)
+	case *ast.MapType:
+		e.Key = r.expr(e.Key)
+		e.Value = r.expr(e.Value)
+	case *ast.ChanType:
+		e.Value = r.expr(e.Value)
+	case *ast.FuncType:
+		r.funcType(e)
+	case *ast.StructType:
+		r.fieldList(e.Fields)
+	case *ast.InterfaceType:
+		r.fieldList(e.Methods)
+	default:
+		panic(fmt.Sprintf("RewriteExprs can't handle expression %T", e))
+	}
+	return r(e)
+}
+
+func (r rewriter) stmts(ss []ast.Stmt) {
+	for _, s := range ss {
+		r.stmt(s)
+	}
+}
+
+func (r rewriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case nil, *ast.EmptyStmt, *ast.BranchStmt, *ast.BadStmt:
+	case *ast.BlockStmt:
+		if s != nil {
+			r.stmts(s.List)
+		}
+	case *ast.ExprStmt:
+		s.X = r.expr(s.X)
+	case *ast.IncDecStmt:
+		s.X = r.expr(s.X)
+	case *ast.AssignStmt:
+		r.exprs(s.Lhs)
+		r.exprs(s.Rhs)
+	case *ast.DeclStmt:
+		r.decl(s.Decl)
+	case *ast.ReturnStmt:
+		r.exprs(s.Results)
+	case *ast.LabeledStmt:
+		r.stmt(s.Stmt)
+	case *ast.GoStmt:
+		s.Call = r.call(s.Call)
+	case *ast.DeferStmt:
+		s.Call = r.call(s.Call)
+	case *ast.SendStmt:
+		s.Chan = r.expr(s.Chan)
+		s.Value = r.expr(s.Value)
+	case *ast.IfStmt:
+		r.stmt(s.Init)
+		s.Cond = r.expr(s.Cond)
+		r.stmt(s.Body)
+		r.stmt(s.Else)
+	case *ast.ForStmt:
+		r.stmt(s.Init)
+		s.Cond = r.expr(s.Cond)
+		r.stmt(s.Post)
+		r.stmt(s.Body)
+	case *ast.RangeStmt:
+		s.Key = r.expr(s.Key)
+		s.Value = r.expr(s.Value)
+		s.X = r.expr(s.X)
+		r.stmt(s.Body)
+	case *ast.SwitchStmt:
+		r.stmt(s.Init)
+		s.Tag = r.expr(s.Tag)
+		r.stmt(s.Body)
+	case *ast.TypeSwitchStmt:
+		r.stmt(s.Init)
+		r.stmt(s.Assign)
+		r.stmt(s.Body)
+	case *ast.SelectStmt:
+		r.stmt(s.Body)
+	case *ast.CaseClause:
+		r.exprs(s.List)
+		r.stmts(s.Body)
+	case *ast.CommClause:
+		r.stmt(s.Comm)
+		r.stmts(s.Body)
+	default:
+		panic(fmt.Sprintf("RewriteExprs can't handle statement %T", s))
+	}
+}
+
+// call rewrites the call of a go or defer statement, which must
+// remain a call.
+func (r rewriter) call(c *ast.CallExpr) *ast.CallExpr {
+	if c, ok := r.expr(c).(*ast.CallExpr); ok {
+		return c
+	}
+	panic("A go or defer statement must call something")
+}
+
+// RewriteStmts calls f on every statement within n that sits in a
+// statement list, the else branch of an if, or a labeled statement
+// (after rewriting the statements within that statement), and
+// replaces the statement with whatever f returns.  Statements within
+// function literals are visited too.
+func RewriteStmts(n ast.Node, f func(ast.Stmt) ast.Stmt) {
+	r := stmtRewriter(f)
+	switch n := n.(type) {
+	case *ast.File:
+		for _, d := range n.Decls {
+			r.decl(d)
+		}
+	case ast.Decl:
+		r.decl(n)
+	case ast.Stmt:
+		r.stmt(n)
+	default:
+		panic(fmt.Sprintf("RewriteStmts can't handle %T", n))
+	}
+}
+
+type stmtRewriter func(ast.Stmt) ast.Stmt
+
+func (r stmtRewriter) decl(d ast.Decl) {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		if d.Body != nil {
+			r.stmt(d.Body)
+		}
+	case *ast.GenDecl:
+		for _, s := range d.Specs {
+			if s, ok := s.(*ast.ValueSpec); ok {
+				for _, v := range s.Values {
+					r.exprs(v)
+				}
+			}
+		}
+	}
+}
+
+// exprs finds the function literals within e.  Those within a
+// function literal are left to the visit of its body, so that no
+// statement is visited twice.
+func (r stmtRewriter) exprs(e ast.Expr) {
+	ast.Inspect(e, func(n ast.Node) bool {
+		if fl, ok := n.(*ast.FuncLit); ok {
+			r.stmt(fl.Body)
+			return false
+		}
+		return true
+	})
+}
+
+func (r stmtRewriter) list(ss []ast.Stmt) {
+	for i := range ss {
+		ss[i] = r.rewrite(ss[i])
+	}
+}
+
+func (r stmtRewriter) rewrite(s ast.Stmt) ast.Stmt {
+	if s == nil {
+		return nil
+	}
+	r.stmt(s)
+	return r(s)
+}
+
+func (r stmtRewriter) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case nil, *ast.EmptyStmt, *ast.BranchStmt, *ast.BadStmt:
+	case *ast.BlockStmt:
+		r.list(s.List)
+	case *ast.ExprStmt:
+		r.exprs(s.X)
+	case *ast.IncDecStmt:
+		r.exprs(s.X)
+	case *ast.AssignStmt:
+		for _, e := range s.Lhs {
+			r.exprs(e)
+		}
+		for _, e := range s.Rhs {
+			r.exprs(e)
+		}
+	case *ast.DeclStmt:
+		r.decl(s.Decl)
+	case *ast.ReturnStmt:
+		for _, e := range s.Results {
+			r.exprs(e)
+		}
+	case *ast.LabeledStmt:
+		s.Stmt = r.rewrite(s.Stmt)
+	case *ast.GoStmt:
+		r.exprs(s.Call)
+	case *ast.DeferStmt:
+		r.exprs(s.Call)
+	case *ast.SendStmt:
+		r.exprs(s.Chan)
+		r.exprs(s.Value)
+	case *ast.IfStmt:
+		r.stmt(s.Init)
+		r.exprs(s.Cond)
+		r.stmt(s.Body)
+		s.Else = r.rewrite(s.Else)
+	case *ast.ForStmt:
+		r.stmt(s.Init)
+		if s.Cond != nil {
+			r.exprs(s.Cond)
+		}
+		r.stmt(s.Post)
+		r.stmt(s.Body)
+	case *ast.RangeStmt:
+		r.exprs(s.X)
+		r.stmt(s.Body)
+	case *ast.SwitchStmt:
+		r.stmt(s.Init)
+		if s.Tag != nil {
+			r.exprs(s.Tag)
+		}
+		r.stmt(s.Body)
+	case *ast.TypeSwitchStmt:
+		r.stmt(s.Init)
+		r.stmt(s.Assign)
+		r.stmt(s.Body)
+	case *ast.SelectStmt:
+		r.stmt(s.Body)
+	case *ast.CaseClause:
+		for _, e := range s.List {
+			r.exprs(e)
+		}
+		r.list(s.Body)
+	case *ast.CommClause:
+		r.stmt(s.Comm)
+		r.list(s.Body)
+	default:
+		panic(fmt.Sprintf("RewriteStmts can't handle statement %T", s))
+	}
+}
+
+var ntemps int
+
+// tempName gives a fresh name for a temporary variable.
+func tempName() string {
+	ntemps++
+	return fmt.Sprint("ogo_tmp", ntemps)
+}
+
+// relabel moves the label of a labeled statement that a pass has
+// turned into a block, onto the last statement of that block, which
+// is the loop or switch the label originally labeled.  If a goto
+// jumps to the label, though, it must run the whole block, so the
+// label stays put, and the breaks and continues that named it name a
+// new label on the last statement instead.
+func relabel(l *ast.LabeledStmt, made map[ast.Stmt]bool, targets map[*ast.LabeledStmt]bool) ast.Stmt {
+	b, ok := l.Stmt.(*ast.BlockStmt)
+	if !ok || !made[b] {
+		return l
+	}
+	last := len(b.List) - 1
+	if targets[l] {
+		inner := ast.NewIdent(tempName())
+		if renameBranches(b.List[last], l.Label.Name, inner.Name) {
+			b.List[last] = &ast.LabeledStmt{Label: inner, Stmt: b.List[last]}
+		}
+		return l
+	}
+	l.Stmt = b.List[last]
+	b.List[last] = l
+	made[b] = true
+	return b
+}
+
+// renameBranches makes the break and continue statements in s that
+// name the label from name the label to instead, telling whether
+// there were any.
+func renameBranches(s ast.Stmt, from, to string) bool {
+	found := false
+	ast.Inspect(s, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.BranchStmt:
+			if n.Tok != token.GOTO && n.Label != nil && n.Label.Name == from {
+				n.Label = ast.NewIdent(to)
+				found = true
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// gotoTargets finds the labeled statements that some goto jumps to.
+// Each function has its labels to itself.
+func gotoTargets(f *ast.File) map[*ast.LabeledStmt]bool {
+	targets := make(map[*ast.LabeledStmt]bool)
+	var body func(b *ast.BlockStmt)
+	body = func(b *ast.BlockStmt) {
+		gotos := make(map[string]bool)
+		var labels []*ast.LabeledStmt
+		ast.Inspect(b, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				body(n.Body)
+				return false
+			case *ast.BranchStmt:
+				if n.Tok == token.GOTO {
+					gotos[n.Label.Name] = true
+				}
+			case *ast.LabeledStmt:
+				labels = append(labels, n)
+			}
+			return true
+		})
+		for _, l := range labels {
+			if gotos[l.Label.Name] {
+				targets[l] = true
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			if n.Body != nil {
+				body(n.Body)
+			}
+			return false
+		case *ast.FuncLit:
+			body(n.Body)
+			return false
+		}
+		return true
+	})
+	return targets
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -25,8 +26,10 @@ func splitLast(in, sep string) [2]string {
 
 // Track imports simplifies all imports into a single large package
 // with mangled names.  In the process, it drops functions that are
-// never referred to.
-func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
+// never referred to, other than those in keep, written as "path.Name".
+// It tells progress, if it isn't nil, of the functions and names it
+// works through.
+func TrackImports(progress io.Writer, pkgs map[string](map[string]*ast.File), keep ...string) (main *ast.File) {
 	// Let's first set of the package we're going to generate...
 	main = new(ast.File)
 	main.Name = ast.NewIdent("main")
@@ -35,13 +38,18 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 	todo := make(map[string]struct{})
 	todo["main.init"] = struct{}{}
 	todo["main.main"] = struct{}{}
+	for _, k := range keep {
+		todo[k] = struct{}{}
+	}
 	done := make(map[string]struct{})
 
 	for len(todo) > 0 {
 		for pkgfn := range todo {
 			pkg := splitLast(pkgfn, ".")[0] // FIXME:  Need to split after last "." only
 			fn := splitLast(pkgfn, ".")[1]
-			fmt.Println("Working on", fn, "in", pkg)
+			if progress != nil {
+				fmt.Fprintln(progress, "Working on", fn, "in", pkg)
+			}
 			if _, ok := done[pkg+".init"]; !ok && fn != "init" {
 				// We still need to init this package!
 				todo[pkg+".init"] = struct{}{}
@@ -56,8 +64,10 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 
 				// First we'll track down the import declarations...
 				sc := PackageScoping{
-					Imports: make(map[string]string),
-					Globals: make(map[string]string),
+					Progress: progress,
+					Imports:  make(map[string]string),
+					Globals:  make(map[string]string),
+					Types:    make(map[string]bool),
 				}
 				for _, d := range f.Decls {
 					if i, ok := d.(*ast.GenDecl); ok && i.Tok == token.IMPORT {
@@ -74,7 +84,7 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 							}
 							sc.Imports[name] = path
 						}
-					} else if vdecl, ok := d.(*ast.GenDecl); ok && vdecl.Tok == token.VAR {
+					} else if vdecl, ok := d.(*ast.GenDecl); ok && (vdecl.Tok == token.VAR || vdecl.Tok == token.CONST) {
 						for _, spec0 := range vdecl.Specs {
 							spec := spec0.(*ast.ValueSpec)
 							for _, n := range spec.Names {
@@ -85,15 +95,42 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 						for _, spec0 := range tdecl.Specs {
 							spec := spec0.(*ast.TypeSpec)
 							sc.Globals[spec.Name.Name] = pkg
+							sc.Types[spec.Name.Name] = true
 						}
-					} else if fdecl, ok := d.(*ast.FuncDecl); ok {
+					} else if fdecl, ok := d.(*ast.FuncDecl); ok && fdecl.Recv == nil {
 						sc.Globals[fdecl.Name.Name] = pkg
 					}
 				}
 				// Now we'll go ahead and mangle things...
 				for _, d := range f.Decls {
 					if cdecl, ok := d.(*ast.GenDecl); ok && cdecl.Tok == token.CONST {
-						fmt.Println("FIXME: I don't handle const yet at all... (ignoring)")
+						if !declaresName(cdecl, fn) {
+							continue
+						}
+						// A const declaration may depend on iota and on the
+						// implicit repetition of earlier specs, so we keep
+						// the whole thing together.
+						newdecl := &ast.GenDecl{Tok: token.CONST}
+						for _, spec0 := range cdecl.Specs {
+							spec := spec0.(*ast.ValueSpec)
+							newspec := &ast.ValueSpec{
+								Type:   sc.MangleExpr(spec.Type),
+								Values: spec.Values,
+							}
+							for i := range spec.Values {
+								spec.Values[i] = sc.MangleExpr(spec.Values[i])
+							}
+							for _, n := range spec.Names {
+								newspec.Names = append(newspec.Names,
+									ast.NewIdent(ManglePackageAndName(pkg, n.Name)))
+								if n.Name != fn && n.Name != "_" {
+									done[pkg+"."+n.Name] = struct{}{}
+									delete(todo, pkg+"."+n.Name)
+								}
+							}
+							newdecl.Specs = append(newdecl.Specs, newspec)
+						}
+						main.Decls = append(main.Decls, newdecl)
 					} else if tdecl, ok := d.(*ast.GenDecl); ok && tdecl.Tok == token.TYPE {
 						for _, spec0 := range tdecl.Specs {
 							spec := spec0.(*ast.TypeSpec)
@@ -101,6 +138,7 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 								// fmt.Println("Got type declaration of", spec.Name)
 								spec := *spec
 								spec.Name = ast.NewIdent(fn)
+								sc.MangleFields(spec.TypeParams)
 								spec.Type = sc.MangleExpr(spec.Type)
 								sc.MangleExpr(spec.Name)
 								d := &ast.GenDecl{
@@ -138,12 +176,27 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 								}
 							}
 						}
+					} else if fdecl, ok := d.(*ast.FuncDecl); ok && fdecl.Recv != nil {
+						if receiverName(fdecl) == fn {
+							// The methods of a type come along with it,
+							// keeping their names.
+							fdecl := *fdecl
+							fdecl.Name = ast.NewIdent(fdecl.Name.Name)
+							sc.MangleMember(fdecl.Name)
+							sc.MangleFields(fdecl.Recv)
+							sc.MangleFields(fdecl.Type.TypeParams)
+							sc.MangleFields(fdecl.Type.Params)
+							sc.MangleFields(fdecl.Type.Results)
+							sc.MangleStatement(fdecl.Body)
+							main.Decls = append(main.Decls, &fdecl)
+						}
 					} else if fdecl, ok := d.(*ast.FuncDecl); ok {
 						if fdecl.Name.Name == fn {
 							// first, let's update the name... but in a copy of the
 							// function declaration
 							fdecl := *fdecl
-							fdecl.Name = ast.NewIdent(pkg + "_" + fn)
+							fdecl.Name = ast.NewIdent(ManglePackageAndName(pkg, fn))
+							sc.MangleFields(fdecl.Type.TypeParams)
 							if fdecl.Type.Params != nil {
 								for _, f := range fdecl.Type.Params.List {
 									sc.MangleExpr(f.Type)
@@ -159,7 +212,7 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 							main.Decls = append(main.Decls, &fdecl)
 							if fn == "init" && fdecl.Recv == nil {
 								initstmts = append(initstmts,
-									&ast.ExprStmt{&ast.CallExpr{Fun: fdecl.Name}})
+									&ast.ExprStmt{X: &ast.CallExpr{Fun: fdecl.Name}})
 							}
 						}
 					}
@@ -188,16 +241,64 @@ func TrackImports(pkgs map[string](map[string]*ast.File)) (main *ast.File) {
 	mainfn.Name = ast.NewIdent("main")
 	mainfn.Type = &ast.FuncType{Params: &ast.FieldList{}}
 	initstmts = append(initstmts,
-		&ast.ExprStmt{&ast.CallExpr{Fun: ast.NewIdent("main_main")}})
+		&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent("main_main")}})
 	mainfn.Body = &ast.BlockStmt{List: initstmts}
 	main.Decls = append(main.Decls, mainfn)
 	return main
 }
 
+// receiverName is the name of the type that a method is declared on.
+func receiverName(d *ast.FuncDecl) string {
+	t := d.Recv.List[0].Type
+	if s, ok := t.(*ast.StarExpr); ok {
+		t = s.X
+	}
+	// The type of a method of a generic type has its type parameters.
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		t = x.X
+	case *ast.IndexListExpr:
+		t = x.X
+	}
+	return t.(*ast.Ident).Name
+}
+
+func declaresName(d *ast.GenDecl, name string) bool {
+	for _, spec := range d.Specs {
+		for _, n := range spec.(*ast.ValueSpec).Names {
+			if n.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type PackageScoping struct {
 	Imports map[string]string
 	Globals map[string]string
-	ToDo    []string
+	// Types holds the names of the types of the package, which are
+	// also the names of the fields that embed them.
+	Types map[string]bool
+	ToDo  []string
+	// Progress, if it isn't nil, hears of the names that are mangled.
+	Progress io.Writer
+}
+
+func (sc *PackageScoping) progress(args ...interface{}) {
+	if sc.Progress != nil {
+		fmt.Fprintln(sc.Progress, args...)
+	}
+}
+
+// MangleMember mangles the name of a field or method, if it is the
+// name of a type of the package, since an embedded field has the
+// (mangled) name of its type, and every field or method with that
+// name must be mangled to match.
+func (sc *PackageScoping) MangleMember(n *ast.Ident) {
+	if sc.Types[n.Name] {
+		n.Name = ManglePackageAndName(sc.Globals[n.Name], n.Name)
+	}
 }
 
 func (sc *PackageScoping) Do(pkgid string) {
@@ -210,7 +311,7 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 		for i := range e.Args {
 			e.Args[i] = sc.MangleExpr(e.Args[i])
 		}
-		sc.MangleExpr(e.Fun)
+		e.Fun = sc.MangleExpr(e.Fun)
 		if fn, ok := e.Fun.(*ast.Ident); ok {
 			switch fn.Name {
 			case "print", "println":
@@ -226,7 +327,7 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 			sc.Do(pkg + "." + e.Name)
 			oldname := e.Name
 			e.Name = ManglePackageAndName(pkg, e.Name)
-			fmt.Println("Name is", e.Name, "from", pkg, oldname)
+			sc.progress("Name is", e.Name, "from", pkg, oldname)
 		} else {
 			// Nothing to do here, it is a local identifier or builtin.
 		}
@@ -249,6 +350,11 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 	case *ast.IndexExpr:
 		e.X = sc.MangleExpr(e.X)
 		e.Index = sc.MangleExpr(e.Index)
+	case *ast.IndexListExpr:
+		e.X = sc.MangleExpr(e.X)
+		for i := range e.Indices {
+			e.Indices[i] = sc.MangleExpr(e.Indices[i])
+		}
 	case *ast.SliceExpr:
 		e.X = sc.MangleExpr(e.X)
 		e.Low = sc.MangleExpr(e.Low)
@@ -263,22 +369,29 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 				e.Sel.Name = ManglePackageAndName(theimp, e.Sel.Name)
 				return e.Sel
 			} else {
-				fmt.Println("not a package: ", b.Name)
-				fmt.Println("Imports are", sc.Imports)
+				sc.progress("not a package: ", b.Name)
+				sc.progress("Imports are", sc.Imports)
 				sc.MangleExpr(e.X)
+				sc.MangleMember(e.Sel)
 			}
 		} else {
 			sc.MangleExpr(e.X)
+			sc.MangleMember(e.Sel)
 		}
 	case *ast.StructType:
 		for _, f := range e.Fields.List {
 			sc.MangleExpr(f.Type)
+			for _, n := range f.Names {
+				sc.MangleMember(n)
+			}
 		}
 	case *ast.CompositeLit:
 		e.Type = sc.MangleExpr(e.Type)
 		for i := range e.Elts {
 			e.Elts[i] = sc.MangleExpr(e.Elts[i])
 		}
+	case *ast.ChanType:
+		e.Value = sc.MangleExpr(e.Value)
 	case *ast.MapType:
 		e.Key = sc.MangleExpr(e.Key)
 		e.Value = sc.MangleExpr(e.Value)
@@ -287,6 +400,9 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 	case *ast.InterfaceType:
 		for _, field := range e.Methods.List {
 			field.Type = sc.MangleExpr(field.Type)
+			for _, n := range field.Names {
+				sc.MangleMember(n)
+			}
 		}
 	case *ast.ArrayType:
 		e.Len = sc.MangleExpr(e.Len)
@@ -295,19 +411,11 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 		e.X = sc.MangleExpr(e.X)
 		e.Type = sc.MangleExpr(e.Type)
 	case *ast.FuncType:
-		for _, field := range e.Params.List {
-			field.Type = sc.MangleExpr(field.Type)
-		}
-		for _, field := range e.Results.List {
-			field.Type = sc.MangleExpr(field.Type)
-		}
+		sc.MangleFields(e.Params)
+		sc.MangleFields(e.Results)
 	case *ast.FuncLit:
-		for _, field := range e.Type.Params.List {
-			field.Type = sc.MangleExpr(field.Type)
-		}
-		for _, field := range e.Type.Results.List {
-			field.Type = sc.MangleExpr(field.Type)
-		}
+		sc.MangleFields(e.Type.Params)
+		sc.MangleFields(e.Type.Results)
 		sc.MangleStatement(e.Body)
 	case *ast.KeyValueExpr:
 		e.Key = sc.MangleExpr(e.Key)
@@ -320,6 +428,14 @@ func (sc *PackageScoping) MangleExpr(e ast.Expr) ast.Expr {
 	return e
 }
 
+func (sc *PackageScoping) MangleFields(fl *ast.FieldList) {
+	if fl != nil {
+		for _, field := range fl.List {
+			field.Type = sc.MangleExpr(field.Type)
+		}
+	}
+}
+
 func (sc *PackageScoping) MangleStatement(st ast.Stmt) {
 	switch st := st.(type) {
 	case *ast.IncDecStmt:
@@ -349,18 +465,39 @@ func (sc *PackageScoping) MangleStatement(st ast.Stmt) {
 		}
 	case *ast.ExprStmt:
 		sc.MangleExpr(st.X)
+	case *ast.DeferStmt:
+		sc.MangleExpr(st.Call)
+	case *ast.GoStmt:
+		sc.MangleExpr(st.Call)
+	case *ast.SendStmt:
+		st.Chan = sc.MangleExpr(st.Chan)
+		st.Value = sc.MangleExpr(st.Value)
+	case *ast.SelectStmt:
+		sc.MangleStatement(st.Body)
+	case *ast.CommClause:
+		sc.MangleStatement(st.Comm)
+		for _, st2 := range st.Body {
+			sc.MangleStatement(st2)
+		}
 	case *ast.DeclStmt:
 		switch decl := st.Decl.(type) {
 		case *ast.GenDecl:
-			if decl.Tok != token.VAR {
-				panic(fmt.Sprint("I don't understand decl with tok", decl.Tok))
-			}
-			for _, spec := range decl.Specs {
-				s := spec.(*ast.ValueSpec)
-				sc.MangleExpr(s.Type)
-				for _, v := range s.Values {
-					sc.MangleExpr(v)
+			switch decl.Tok {
+			case token.VAR, token.CONST:
+				for _, spec := range decl.Specs {
+					s := spec.(*ast.ValueSpec)
+					s.Type = sc.MangleExpr(s.Type)
+					for i := range s.Values {
+						s.Values[i] = sc.MangleExpr(s.Values[i])
+					}
+				}
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					s := spec.(*ast.TypeSpec)
+					s.Type = sc.MangleExpr(s.Type)
 				}
+			default:
+				panic(fmt.Sprint("I don't understand decl with tok", decl.Tok))
 			}
 		default:
 			panic("Weird Decl here...")
@@ -378,6 +515,10 @@ func (sc *PackageScoping) MangleStatement(st ast.Stmt) {
 		st.Tag = sc.MangleExpr(st.Tag)
 		sc.MangleStatement(st.Init)
 		sc.MangleStatement(st.Body)
+	case *ast.TypeSwitchStmt:
+		sc.MangleStatement(st.Init)
+		sc.MangleStatement(st.Assign)
+		sc.MangleStatement(st.Body)
 	case *ast.CaseClause:
 		for i := range st.List {
 			st.List[i] = sc.MangleExpr(st.List[i])
@@ -385,6 +526,10 @@ func (sc *PackageScoping) MangleStatement(st ast.Stmt) {
 		for _, st2 := range st.Body {
 			sc.MangleStatement(st2)
 		}
+	case *ast.LabeledStmt:
+		sc.MangleStatement(st.Stmt)
+	case *ast.BranchStmt, *ast.EmptyStmt:
+		// Nothing to do here, a label is never imported.
 	case nil:
 		// Nothing to do with a statement of type nil!
 	default:
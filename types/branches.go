@@ -0,0 +1,182 @@
+package types
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// A place is the position of a statement in the list of statements of
+// a block, or of a case.
+type place struct {
+	block ast.Node
+	list  []ast.Stmt
+	index int
+}
+
+type label struct {
+	place
+	pos  token.Pos
+	used bool
+}
+
+// A jump is a goto, with the places of the statements that hold it,
+// outermost first.
+type jump struct {
+	name string
+	pos  token.Pos
+	path []place
+}
+
+// An enclosing statement is one that a break may leave, which a
+// continue may continue if it is a loop.
+type enclosing struct {
+	label string
+	loop  bool
+}
+
+type branches struct {
+	labels map[string]*label
+	order  []string
+	gotos  []jump
+}
+
+// checkBranches checks the labels and the branch statements in the
+// body of a function, as gc does: a goto may jump neither into a block
+// nor over a variable declaration, a break or continue must be within
+// the statement it names, and each label must be used.
+func checkBranches(body *ast.BlockStmt) {
+	b := &branches{labels: make(map[string]*label)}
+	b.list(body, body.List, nil, nil)
+	for _, j := range b.gotos {
+		l := b.labels[j.name]
+		if l == nil {
+			errorf(j.pos, "label %s not defined", j.name)
+		}
+		l.used = true
+		var from *place
+		for i := range j.path {
+			if j.path[i].block == l.block {
+				from = &j.path[i]
+			}
+		}
+		if from == nil {
+			errorf(j.pos, "goto %s jumps into block", j.name)
+		}
+		for i := from.index + 1; i < l.index; i++ {
+			if declaresVar(from.list[i]) {
+				errorf(j.pos, "goto %s jumps over variable declaration", j.name)
+			}
+		}
+	}
+	for _, name := range b.order {
+		if !b.labels[name].used {
+			errorf(b.labels[name].pos, "label %s defined and not used", name)
+		}
+	}
+}
+
+// declaresVar tells whether s declares a variable.
+func declaresVar(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.LabeledStmt:
+		return declaresVar(s.Stmt)
+	case *ast.AssignStmt:
+		return s.Tok == token.DEFINE
+	case *ast.DeclStmt:
+		return s.Decl.(*ast.GenDecl).Tok == token.VAR
+	}
+	return false
+}
+
+func (b *branches) list(block ast.Node, list []ast.Stmt, path []place, outer []enclosing) {
+	for i, s := range list {
+		here := append(path[:len(path):len(path)], place{block, list, i})
+		name := ""
+		for {
+			l, ok := s.(*ast.LabeledStmt)
+			if !ok {
+				break
+			}
+			name = l.Label.Name
+			if b.labels[name] != nil {
+				errorf(l.Pos(), "label %s already defined", name)
+			}
+			b.labels[name] = &label{place: place{block, list, i}, pos: l.Pos()}
+			b.order = append(b.order, name)
+			s = l.Stmt
+		}
+		b.stmt(s, name, here, outer)
+	}
+}
+
+// stmt checks the branches in s, which has the given label, if any.
+func (b *branches) stmt(s ast.Stmt, name string, path []place, outer []enclosing) {
+	switch s := s.(type) {
+	case *ast.BlockStmt:
+		b.list(s, s.List, path, outer)
+	case *ast.IfStmt:
+		b.stmt(s.Body, "", path, outer)
+		if s.Else != nil {
+			b.stmt(s.Else, "", path, outer)
+		}
+	case *ast.ForStmt:
+		b.list(s.Body, s.Body.List, path, append(outer, enclosing{name, true}))
+	case *ast.RangeStmt:
+		b.list(s.Body, s.Body.List, path, append(outer, enclosing{name, true}))
+	case *ast.SwitchStmt:
+		inner := append(outer, enclosing{name, false})
+		for i, cc := range s.Body.List {
+			cc := cc.(*ast.CaseClause)
+			body := cc.Body
+			// Any clause but the last may end with a fallthrough.
+			if n := len(body); n > 0 && i < len(s.Body.List)-1 {
+				if f, ok := body[n-1].(*ast.BranchStmt); ok && f.Tok == token.FALLTHROUGH {
+					body = body[:n-1]
+				}
+			}
+			b.list(cc, body, path, inner)
+		}
+	case *ast.TypeSwitchStmt:
+		inner := append(outer, enclosing{name, false})
+		for _, cc := range s.Body.List {
+			b.list(cc, cc.(*ast.CaseClause).Body, path, inner)
+		}
+	case *ast.SelectStmt:
+		inner := append(outer, enclosing{name, false})
+		for _, cc := range s.Body.List {
+			b.list(cc, cc.(*ast.CommClause).Body, path, inner)
+		}
+	case *ast.BranchStmt:
+		b.branch(s, path, outer)
+	}
+}
+
+func (b *branches) branch(s *ast.BranchStmt, path []place, outer []enclosing) {
+	switch s.Tok {
+	case token.GOTO:
+		b.gotos = append(b.gotos, jump{s.Label.Name, s.Pos(), path})
+		return
+	case token.FALLTHROUGH:
+		errorf(s.Pos(), "fallthrough statement out of place")
+	}
+	for i := len(outer) - 1; i >= 0; i-- {
+		e := outer[i]
+		if s.Label == nil && (s.Tok == token.BREAK || e.loop) {
+			return
+		}
+		if s.Label != nil && e.label == s.Label.Name {
+			if s.Tok == token.CONTINUE && !e.loop {
+				break
+			}
+			b.labels[e.label].used = true
+			return
+		}
+	}
+	switch {
+	case s.Label != nil:
+		errorf(s.Pos(), "invalid %s label %s", s.Tok, s.Label.Name)
+	case s.Tok == token.BREAK:
+		errorf(s.Pos(), "break is not in a loop, switch, or select")
+	}
+	errorf(s.Pos(), "continue is not in a loop")
+}
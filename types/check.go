@@ -0,0 +1,829 @@
+package types
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"sort"
+)
+
+// Info holds what the type checker learns about a program.
+type Info struct {
+	// Types holds the type of every expression, including type
+	// expressions.  An untyped expression that is converted by its
+	// context is given the type it is converted to.
+	Types map[ast.Expr]Type
+	// Values holds the value of every constant expression.
+	Values map[ast.Expr]constant.Value
+	// Untyped holds the original type of every untyped expression
+	// that its context gave a type.
+	Untyped map[ast.Expr]Type
+	// Implicit holds the type that an expression is implicitly
+	// converted to, wherever a value flows into a place of a type
+	// that is assignable from, but not identical to, its own.
+	Implicit map[ast.Expr]Type
+	// Objects holds the object that each identifier denotes.
+	Objects map[*ast.Ident]*Object
+	// Implicits holds the variable that a type switch declares in
+	// each of its clauses.
+	Implicits map[*ast.CaseClause]*Object
+	// Promoted holds the names of the embedded fields through which
+	// a selector finds a promoted field or method.
+	Promoted map[*ast.SelectorExpr][]string
+	// Instances holds the generic function or type that each
+	// instantiation instantiates, with its type arguments.
+	Instances map[ast.Expr]*Instance
+	// Globals holds every package-level object by name.
+	Globals map[string]*Object
+
+	typexprs map[ast.Expr]bool
+}
+
+// IsType tells whether e is a type expression.
+func (info *Info) IsType(e ast.Expr) bool {
+	return info.typexprs[e]
+}
+
+// TypeOf is the type of an expression, which may have been created
+// since the type check, if it is an identifier referring to a known
+// object.
+func (info *Info) TypeOf(e ast.Expr) Type {
+	if t, ok := info.Types[e]; ok {
+		return t
+	}
+	if id, ok := e.(*ast.Ident); ok {
+		if o, ok := info.Objects[id]; ok {
+			return o.Type
+		}
+	}
+	return nil
+}
+
+type Scope struct {
+	objects map[string]*Object
+	outer   *Scope
+}
+
+func NewScope(outer *Scope) *Scope {
+	return &Scope{make(map[string]*Object), outer}
+}
+
+func (s *Scope) Lookup(name string) *Object {
+	for ; s != nil; s = s.outer {
+		if o, ok := s.objects[name]; ok {
+			return o
+		}
+	}
+	return nil
+}
+
+func (s *Scope) Insert(o *Object) {
+	if o.Name != "_" {
+		s.objects[o.Name] = o
+	}
+}
+
+var Universe = NewScope(nil)
+
+func init() {
+	for _, t := range Typ {
+		if t.Kind != Invalid && t.Kind < UnsafePointer {
+			Universe.Insert(&Object{Kind: TypeName, Name: t.Name, Type: t})
+		}
+	}
+	Universe.Insert(&Object{Kind: TypeName, Name: "byte", Type: Typ[Uint8]})
+	Universe.Insert(&Object{Kind: TypeName, Name: "rune", Type: Typ[Int32]})
+	Universe.Insert(&Object{Kind: TypeName, Name: "any", Type: &Interface{}})
+	Universe.Insert(&Object{Kind: TypeName, Name: "Type", Type: TypeType{}})
+	Universe.Insert(&Object{Kind: TypeName, Name: "comparable",
+		Type: &Named{Name: "comparable", Underlying: &Interface{Comparable: true}}})
+	Universe.Insert(&Object{Kind: TypeName, Name: "sealed",
+		Type: &Named{Name: "sealed", Underlying: &Interface{Sealed: true}}})
+	errorType := &Interface{}
+	errorType.Methods = []*Object{{Kind: Func, Name: "Error",
+		Type: &Function{Results: []Type{Typ[String]}}, Recv: errorType, state: resolved}}
+	Universe.Insert(&Object{Kind: TypeName, Name: "error",
+		Type: &Named{Name: "error", Underlying: errorType}})
+	Universe.Insert(&Object{Kind: Const, Name: "true", Type: Typ[UntypedBool],
+		Value: constant.MakeBool(true)})
+	Universe.Insert(&Object{Kind: Const, Name: "false", Type: Typ[UntypedBool],
+		Value: constant.MakeBool(false)})
+	Universe.Insert(&Object{Kind: Const, Name: "iota", Type: Typ[UntypedInt]})
+	Universe.Insert(&Object{Kind: Nil, Name: "nil", Type: Typ[UntypedNil]})
+	for _, b := range []string{"append", "cap", "clear", "close", "complex",
+		"copy", "delete", "imag", "len", "make", "max", "min", "new", "panic",
+		"print", "println", "real", "recover", "typeof"} {
+		Universe.Insert(&Object{Kind: Builtin, Name: b})
+	}
+}
+
+type checker struct {
+	*Info
+	global *Scope
+	scope  *Scope
+	// the signature of the function whose body we are checking
+	sig *Function
+	// the value of iota in the constant declaration we are checking
+	iota constant.Value
+	// the sealed interfaces, whose variants must have their methods
+	sealed []sealedDecl
+	// untyped expressions whose final type is not yet known
+	untyped map[ast.Expr]Type
+}
+
+const (
+	unresolved = iota
+	resolving
+	resolved
+)
+
+func TypeCheck(bigfile *ast.File) *Info {
+	c := &checker{
+		Info: &Info{
+			Types:     make(map[ast.Expr]Type),
+			Values:    make(map[ast.Expr]constant.Value),
+			Untyped:   make(map[ast.Expr]Type),
+			Implicit:  make(map[ast.Expr]Type),
+			Objects:   make(map[*ast.Ident]*Object),
+			Implicits: make(map[*ast.CaseClause]*Object),
+			Promoted:  make(map[*ast.SelectorExpr][]string),
+			Instances: make(map[ast.Expr]*Instance),
+			Globals:   make(map[string]*Object),
+			typexprs:  make(map[ast.Expr]bool),
+		},
+		global:  NewScope(Universe),
+		untyped: make(map[ast.Expr]Type),
+	}
+	c.scope = c.global
+	c.collect(bigfile.Decls)
+	for _, d := range bigfile.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Recv != nil {
+			c.funcDecl(d)
+		}
+	}
+	// First check types of all global variables and functions
+	names := make([]string, 0, len(c.Globals))
+	for n := range c.Globals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c.resolve(c.Globals[n])
+	}
+	// Finally, go into functions and check types inside
+	for _, d := range bigfile.Decls {
+		if d, ok := d.(*ast.FuncDecl); ok && d.Body != nil {
+			c.funcBody(d.Type, d.Recv, d.Body, c.Types[d.Name].(*Function))
+		}
+	}
+	c.checkVariants()
+	for e, t := range c.untyped {
+		c.Types[e] = t
+	}
+	return c.Info
+}
+
+// collect creates objects for all the global declarations, without
+// yet working out their types.
+func (c *checker) collect(ds []ast.Decl) {
+	for _, d := range ds {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				c.declareGlobal(d.Name, Func, d)
+			}
+		case *ast.GenDecl:
+			switch d.Tok {
+			case token.IMPORT:
+				// Nothing to do!
+			case token.CONST, token.VAR:
+				for _, s := range d.Specs {
+					for _, n := range s.(*ast.ValueSpec).Names {
+						kind := Var
+						if d.Tok == token.CONST {
+							kind = Const
+						}
+						c.declareGlobal(n, kind, d)
+					}
+				}
+			case token.TYPE:
+				for _, s := range d.Specs {
+					s := s.(*ast.TypeSpec)
+					c.declareGlobal(s.Name, TypeName, s)
+				}
+			default:
+				panic("Invalid token in GenDecl.Tok!")
+			}
+		default:
+			panic(fmt.Sprintf("Unhandled case %T in collect!", d))
+		}
+	}
+}
+
+func (c *checker) declareGlobal(n *ast.Ident, kind ObjKind, decl ast.Node) {
+	o := &Object{Kind: kind, Name: n.Name, Decl: decl, Pos: n.Pos(), Global: true}
+	c.Objects[n] = o
+	if n.Name != "_" {
+		c.Globals[n.Name] = o
+	}
+	c.global.Insert(o)
+}
+
+// resolve works out the type (and value, for constants) of a global
+// object, checking its declaration if this hasn't yet been done.
+func (c *checker) resolve(o *Object) {
+	if !o.Global || o.state == resolved {
+		return
+	}
+	if o.state == resolving {
+		if o.Kind == TypeName {
+			// A recursive type refers to itself through its name.
+			return
+		}
+		panic("Initialization cycle involving " + o.Name)
+	}
+	o.state = resolving
+	// Global declarations are checked in the global scope, no matter
+	// where we were when we needed them.
+	scope, sig, iota := c.scope, c.sig, c.iota
+	c.scope, c.sig = c.global, nil
+	switch d := o.Decl.(type) {
+	case *ast.FuncDecl:
+		c.scope = NewScope(c.global)
+		tparams := c.typeParams(d.Type.TypeParams)
+		sig := c.signature(d.Type, nil)
+		sig.TypeParams = tparams
+		o.Type = sig
+		c.Types[d.Name] = o.Type
+	case *ast.TypeSpec:
+		if d.Assign.IsValid() {
+			o.Type = c.typExpr(d.Type)
+		} else {
+			n := &Named{Name: o.Name}
+			o.Type = n
+			if d.TypeParams != nil {
+				c.scope = NewScope(c.global)
+				n.TypeParams = c.typeParams(d.TypeParams)
+			}
+			n.Underlying = Underlying(c.typExpr(d.Type))
+			for _, in := range n.instances {
+				in.Underlying = Subst(n.Underlying, in.substitution())
+			}
+		}
+		c.recordType(d.Name, o.Type)
+	case *ast.GenDecl:
+		c.valueDecl(d, o)
+	default:
+		panic(fmt.Sprintf("Weird declaration %T for %s", d, o.Name))
+	}
+	c.scope, c.sig, c.iota = scope, sig, iota
+	o.state = resolved
+}
+
+// valueDecl checks the spec of a var or const declaration that
+// declares o.  If o is nil, it checks every spec.
+func (c *checker) valueDecl(d *ast.GenDecl, o *Object) {
+	var last *ast.ValueSpec
+	for i, s := range d.Specs {
+		s := s.(*ast.ValueSpec)
+		if d.Tok == token.CONST {
+			if s.Type != nil || s.Values != nil {
+				last = s
+			}
+			if o != nil && !declares(s, o) {
+				continue
+			}
+			c.iota = constant.MakeInt64(int64(i))
+			c.constSpec(s, last)
+			c.iota = nil
+		} else {
+			if o != nil && !declares(s, o) {
+				continue
+			}
+			c.varSpec(s)
+		}
+	}
+}
+
+func declares(s *ast.ValueSpec, o *Object) bool {
+	for _, n := range s.Names {
+		if n.Name == o.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) constSpec(s, last *ast.ValueSpec) {
+	var t Type
+	if last.Type != nil {
+		t = c.typExpr(last.Type)
+	}
+	for i, n := range s.Names {
+		o := c.objectFor(n, Const)
+		x := c.expr(last.Values[i], nil)
+		if t != nil {
+			c.assign(x, t)
+		}
+		o.Type = x.typ
+		o.Value = x.val
+		o.Decl = s
+		o.state = resolved
+		c.Types[n] = o.Type
+		c.Values[n] = o.Value
+		if !o.Global {
+			c.scope.Insert(o)
+		}
+	}
+}
+
+func (c *checker) varSpec(s *ast.ValueSpec) {
+	var t Type
+	if s.Type != nil {
+		t = c.typExpr(s.Type)
+	}
+	objs := make([]*Object, len(s.Names))
+	for i, n := range s.Names {
+		objs[i] = c.objectFor(n, Var)
+		objs[i].Decl = s
+		objs[i].Type = t
+	}
+	switch {
+	case len(s.Values) == 0:
+	case len(s.Values) == len(s.Names):
+		for i, v := range s.Values {
+			x := c.expr(v, t)
+			if t == nil {
+				c.assign(x, nil)
+				objs[i].Type = x.typ
+			} else {
+				c.assign(x, t)
+			}
+		}
+	default:
+		ts := c.values(s.Values[0], len(s.Names))
+		for i, o := range objs {
+			if o.Type == nil {
+				o.Type = ts[i]
+			}
+		}
+	}
+	if s.Type == nil && len(objs) > 0 {
+		same := true
+		for _, o := range objs {
+			same = same && Identical(o.Type, objs[0].Type)
+		}
+		if same {
+			s.Type = objs[0].Type.Expr()
+			c.typExpr(s.Type)
+		}
+	}
+	for i, o := range objs {
+		o.state = resolved
+		c.Types[s.Names[i]] = o.Type
+		if !o.Global {
+			c.scope.Insert(o)
+		}
+	}
+}
+
+// objectFor returns the object for an identifier being declared,
+// creating it if this is a local declaration.
+func (c *checker) objectFor(n *ast.Ident, kind ObjKind) *Object {
+	if o, ok := c.Objects[n]; ok {
+		return o
+	}
+	o := &Object{Kind: kind, Name: n.Name, Pos: n.Pos()}
+	c.Objects[n] = o
+	return o
+}
+
+func (c *checker) funcDecl(d *ast.FuncDecl) {
+	scope := c.scope
+	c.scope = NewScope(c.global)
+	c.recvTypeParams(d.Recv)
+	recv := c.typExpr(d.Recv.List[0].Type)
+	sig := c.signature(d.Type, nil)
+	c.scope = scope
+	c.Types[d.Name] = sig
+	base := recv
+	if p, ok := recv.(*Pointer); ok {
+		base = p.Elem
+	}
+	named := base.(*Named)
+	m := &Object{Kind: Func, Name: d.Name.Name, Type: sig, Decl: d,
+		Pos: d.Name.Pos(), Recv: recv, state: resolved}
+	c.Objects[d.Name] = m
+	named.Methods = append(named.Methods, m)
+	sort.Sort(byName(named.Methods))
+	for _, in := range named.instances {
+		in.Methods = append(in.Methods, in.method(m))
+		sort.Sort(byName(in.Methods))
+	}
+}
+
+type byName []*Object
+
+func (ms byName) Len() int           { return len(ms) }
+func (ms byName) Less(i, j int) bool { return ms[i].Name < ms[j].Name }
+func (ms byName) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+
+// signature works out the type of a function, declaring its
+// parameters in scope if scope is non-nil.
+func (c *checker) signature(ft *ast.FuncType, scope *Scope) *Function {
+	sig := &Function{}
+	if ft.Params != nil {
+		for _, f := range ft.Params.List {
+			t := f.Type
+			if e, ok := t.(*ast.Ellipsis); ok {
+				sig.Variadic = true
+				t = &ast.ArrayType{Lbrack: e.Pos(), Elt: e.Elt}
+				c.typExpr(e.Elt)
+			}
+			pt := c.typExpr(t)
+			c.declareFields(f, pt, scope)
+			for i := 0; i < len(f.Names) || i == 0 && len(f.Names) == 0; i++ {
+				sig.Parameters = append(sig.Parameters, pt)
+			}
+		}
+	}
+	if ft.Results != nil {
+		for _, f := range ft.Results.List {
+			rt := c.typExpr(f.Type)
+			c.declareFields(f, rt, scope)
+			for i := 0; i < len(f.Names) || i == 0 && len(f.Names) == 0; i++ {
+				sig.Results = append(sig.Results, rt)
+			}
+		}
+	}
+	return sig
+}
+
+func (c *checker) declareFields(f *ast.Field, t Type, scope *Scope) {
+	for _, n := range f.Names {
+		o := c.objectFor(n, Var)
+		o.Type = t
+		o.Decl = f
+		o.state = resolved
+		if scope != nil {
+			scope.Insert(o)
+		}
+	}
+}
+
+func (c *checker) funcBody(ft *ast.FuncType, recv *ast.FieldList, body *ast.BlockStmt, sig *Function) {
+	scope, outersig := c.scope, c.sig
+	c.scope = NewScope(c.scope)
+	c.sig = sig
+	c.typeParams(ft.TypeParams)
+	if recv != nil {
+		c.recvTypeParams(recv)
+		c.declareFields(recv.List[0], c.typExpr(recv.List[0].Type), c.scope)
+	}
+	c.signature(ft, c.scope)
+	c.stmtList(body.List)
+	checkBranches(body)
+	c.scope, c.sig = scope, outersig
+}
+
+func (c *checker) openScope() {
+	c.scope = NewScope(c.scope)
+}
+
+func (c *checker) closeScope() {
+	c.scope = c.scope.outer
+}
+
+func (c *checker) stmtList(list []ast.Stmt) {
+	for _, s := range list {
+		c.stmt(s)
+	}
+}
+
+func (c *checker) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case nil, *ast.EmptyStmt:
+		// Nothing to check
+	case *ast.BlockStmt:
+		c.openScope()
+		c.stmtList(s.List)
+		c.closeScope()
+	case *ast.ExprStmt:
+		c.expr(s.X, nil)
+	case *ast.IncDecStmt:
+		c.expr(s.X, nil)
+	case *ast.AssignStmt:
+		c.assignStmt(s)
+	case *ast.DeclStmt:
+		d := s.Decl.(*ast.GenDecl)
+		switch d.Tok {
+		case token.VAR, token.CONST:
+			c.valueDecl(d, nil)
+		case token.TYPE:
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				o := c.objectFor(ts.Name, TypeName)
+				o.Decl = ts
+				if ts.Assign.IsValid() {
+					o.Type = c.typExpr(ts.Type)
+				} else {
+					n := &Named{Name: o.Name}
+					o.Type = n
+					c.scope.Insert(o)
+					n.Underlying = Underlying(c.typExpr(ts.Type))
+				}
+				c.scope.Insert(o)
+				c.recordType(ts.Name, o.Type)
+			}
+		default:
+			panic(fmt.Sprint("I don't understand decl with tok", d.Tok))
+		}
+	case *ast.ReturnStmt:
+		if len(s.Results) == 1 && len(c.sig.Results) > 1 {
+			c.expr(s.Results[0], nil)
+			return
+		}
+		for i, r := range s.Results {
+			c.assign(c.expr(r, c.sig.Results[i]), c.sig.Results[i])
+		}
+	case *ast.IfStmt:
+		c.openScope()
+		c.stmt(s.Init)
+		c.expr(s.Cond, nil)
+		c.stmt(s.Body)
+		c.stmt(s.Else)
+		c.closeScope()
+	case *ast.ForStmt:
+		c.openScope()
+		c.stmt(s.Init)
+		if s.Cond != nil {
+			c.expr(s.Cond, nil)
+		}
+		c.stmt(s.Post)
+		c.stmt(s.Body)
+		c.closeScope()
+	case *ast.RangeStmt:
+		c.rangeStmt(s)
+	case *ast.SwitchStmt:
+		c.openScope()
+		c.stmt(s.Init)
+		var tag *operand
+		if s.Tag != nil {
+			tag = c.expr(s.Tag, nil)
+			c.assign(tag, nil)
+		}
+		oneDefault(s.Body)
+		seen := make(map[string]bool)
+		for _, cc := range s.Body.List {
+			cc := cc.(*ast.CaseClause)
+			for _, e := range cc.List {
+				x := c.expr(e, nil)
+				if tag != nil {
+					c.comparison(x, tag)
+					if x.mode == constval {
+						k := fmt.Sprint(x.typ, " ", x.val.ExactString())
+						if seen[k] {
+							errorf(e.Pos(), "duplicate case %s in expression switch", x.val)
+						}
+						seen[k] = true
+					}
+				}
+			}
+			c.openScope()
+			c.stmtList(cc.Body)
+			c.closeScope()
+		}
+		c.closeScope()
+	case *ast.TypeSwitchStmt:
+		c.typeSwitch(s)
+	case *ast.LabeledStmt:
+		c.stmt(s.Stmt)
+	case *ast.BranchStmt:
+		// Nothing to check
+	case *ast.DeferStmt:
+		c.expr(s.Call, nil)
+	case *ast.GoStmt:
+		c.expr(s.Call, nil)
+	case *ast.SendStmt:
+		x := c.expr(s.Chan, nil)
+		ch, ok := Underlying(x.typ).(*Chan)
+		if !ok || ch.Dir == ast.RECV {
+			panic(fmt.Sprintf("invalid operation: cannot send to %v", x.typ))
+		}
+		c.assign(c.expr(s.Value, ch.Elem), ch.Elem)
+	case *ast.SelectStmt:
+		for _, cc := range s.Body.List {
+			cc := cc.(*ast.CommClause)
+			c.openScope()
+			c.stmt(cc.Comm)
+			c.stmtList(cc.Body)
+			c.closeScope()
+		}
+	default:
+		panic(fmt.Sprintf("Type checker can't handle statement of type %T", s))
+	}
+}
+
+func (c *checker) assignStmt(s *ast.AssignStmt) {
+	switch s.Tok {
+	case token.DEFINE, token.ASSIGN:
+		var lhs []Type
+		newvars := []*Object{}
+		for _, l := range s.Lhs {
+			id, isid := l.(*ast.Ident)
+			switch {
+			case isid && id.Name == "_":
+				c.Objects[id] = &Object{Kind: Var, Name: "_"}
+				lhs = append(lhs, nil)
+			case isid && s.Tok == token.DEFINE && c.scope.objects[id.Name] == nil:
+				o := c.objectFor(id, Var)
+				o.Decl = s
+				o.state = resolved
+				newvars = append(newvars, o)
+				lhs = append(lhs, nil)
+			default:
+				lhs = append(lhs, c.expr(l, nil).typ)
+			}
+		}
+		if len(s.Rhs) == len(s.Lhs) {
+			for i, r := range s.Rhs {
+				x := c.expr(r, lhs[i])
+				c.assign(x, lhs[i])
+				if lhs[i] == nil {
+					c.setLhsType(s.Lhs[i], x.typ)
+				}
+			}
+		} else {
+			ts := c.values(s.Rhs[0], len(s.Lhs))
+			for i, l := range s.Lhs {
+				if lhs[i] == nil {
+					c.setLhsType(l, ts[i])
+				}
+			}
+		}
+		for _, o := range newvars {
+			c.scope.Insert(o)
+		}
+	default:
+		// an assignment operation like +=
+		lhs := c.expr(s.Lhs[0], nil)
+		rhs := c.expr(s.Rhs[0], nil)
+		op := s.Tok - (token.ADD_ASSIGN - token.ADD)
+		operator(op, lhs.typ)
+		if op == token.SHL || op == token.SHR {
+			c.shiftCount(rhs)
+		} else {
+			c.assign(rhs, lhs.typ)
+		}
+	}
+}
+
+// oneDefault checks that the body of a switch has at most one default
+// clause.
+func oneDefault(body *ast.BlockStmt) {
+	seen := false
+	for _, cc := range body.List {
+		if cc.(*ast.CaseClause).List == nil {
+			if seen {
+				errorf(cc.Pos(), "multiple defaults in switch")
+			}
+			seen = true
+		}
+	}
+}
+
+func (c *checker) typeSwitch(s *ast.TypeSwitchStmt) {
+	c.openScope()
+	c.stmt(s.Init)
+	var lhs *ast.Ident
+	var guard ast.Expr
+	switch a := s.Assign.(type) {
+	case *ast.ExprStmt:
+		guard = a.X
+	case *ast.AssignStmt:
+		lhs = a.Lhs[0].(*ast.Ident)
+		guard = a.Rhs[0]
+	}
+	x := c.expr(StripParens(guard).(*ast.TypeAssertExpr).X, nil)
+	c.assertion(x, x.typ)
+	if lhs != nil {
+		c.Objects[lhs] = &Object{Kind: Var, Name: lhs.Name, Type: x.typ, Decl: s, state: resolved}
+		c.Types[lhs] = x.typ
+	}
+	oneDefault(s.Body)
+	var cases []Type
+	hasDefault, hasNil := false, false
+	for _, cc := range s.Body.List {
+		cc := cc.(*ast.CaseClause)
+		hasDefault = hasDefault || cc.List == nil
+		// The variable has the type of the case if there is just
+		// one, and otherwise the type of the guard.
+		vt := x.typ
+		for _, e := range cc.List {
+			t := c.expr(e, nil)
+			switch {
+			case t.typ == Typ[UntypedNil]:
+				if hasNil {
+					errorf(e.Pos(), "multiple nil cases in type switch")
+				}
+				hasNil = true
+			case t.mode != typexpr:
+				panic(fmt.Sprintf("%v is not a type", t.typ))
+			default:
+				for _, u := range cases {
+					if Identical(t.typ, u) {
+						errorf(e.Pos(), "duplicate case %v in type switch", t.typ)
+					}
+				}
+				c.assertion(x, t.typ)
+				cases = append(cases, t.typ)
+				if len(cc.List) == 1 {
+					vt = t.typ
+				}
+			}
+		}
+		c.openScope()
+		if lhs != nil {
+			o := &Object{Kind: Var, Name: lhs.Name, Type: vt, Decl: cc, Pos: lhs.Pos(),
+				state: resolved}
+			c.Implicits[cc] = o
+			c.scope.Insert(o)
+		}
+		c.stmtList(cc.Body)
+		c.closeScope()
+	}
+	if IsSealed(x.typ) && !hasDefault {
+		c.exhaustive(s, x, cases)
+	}
+	c.closeScope()
+}
+
+// setLhsType gives a new variable (or blank identifier) the type of the
+// value assigned to it.
+func (c *checker) setLhsType(l ast.Expr, t Type) {
+	o := c.Objects[l.(*ast.Ident)]
+	if o.Type == nil {
+		o.Type = t
+	}
+	c.Types[l] = o.Type
+}
+
+func (c *checker) rangeStmt(s *ast.RangeStmt) {
+	c.openScope()
+	x := c.expr(s.X, nil)
+	var k, v Type
+	switch t := Underlying(x.typ).(type) {
+	case *Basic:
+		if IsString(t) {
+			k, v = Typ[Int], Typ[Int32]
+			c.assign(x, nil)
+		} else {
+			c.assign(x, nil)
+			k = x.typ
+		}
+	case *Slice:
+		k, v = Typ[Int], t.Elem
+	case *Array:
+		k, v = Typ[Int], t.Elem
+	case *Chan:
+		k = t.Elem
+	case *Map:
+		k, v = t.Key, t.Elem
+	case *Pointer:
+		a, ok := Underlying(t.Elem).(*Array)
+		if !ok {
+			panic(fmt.Sprintf("I can't range over %s", x.typ))
+		}
+		k, v = Typ[Int], a.Elem
+	case *Function:
+		k = t.Parameters[0].(*Function).Parameters[0]
+		if len(t.Parameters[0].(*Function).Parameters) > 1 {
+			v = t.Parameters[0].(*Function).Parameters[1]
+		}
+	default:
+		panic(fmt.Sprintf("I can't range over %s", x.typ))
+	}
+	for i, e := range []ast.Expr{s.Key, s.Value} {
+		t := k
+		if i == 1 {
+			t = v
+		}
+		if e == nil {
+			continue
+		}
+		if s.Tok == token.DEFINE {
+			id := e.(*ast.Ident)
+			o := c.objectFor(id, Var)
+			o.Type = t
+			o.Decl = s
+			o.state = resolved
+			c.Types[id] = t
+			c.scope.Insert(o)
+		} else {
+			c.expr(e, nil)
+		}
+	}
+	c.stmt(s.Body)
+	c.closeScope()
+}
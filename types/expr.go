@@ -0,0 +1,1251 @@
+package types
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"sort"
+)
+
+type mode int
+
+const (
+	novalue mode = iota
+	value
+	variable // an addressable value
+	constval
+	typexpr
+	builtin
+)
+
+// An operand describes an expression that has been checked.
+type operand struct {
+	mode mode
+	typ  Type
+	val  constant.Value
+	expr ast.Expr
+	id   string // name of a builtin
+}
+
+func (c *checker) record(x *operand) {
+	if x.mode == typexpr {
+		c.recordType(x.expr, x.typ)
+		return
+	}
+	if x.typ != nil {
+		c.Types[x.expr] = x.typ
+	}
+	if x.mode == constval {
+		c.Values[x.expr] = x.val
+	}
+	if IsUntyped(x.typ) {
+		c.untyped[x.expr] = x.typ
+	}
+}
+
+func (c *checker) recordType(e ast.Expr, t Type) {
+	c.Types[e] = t
+	c.typexprs[e] = true
+}
+
+// typExpr checks an expression that must be a type, and returns that
+// type.
+func (c *checker) typExpr(e ast.Expr) Type {
+	x := c.expr(e, nil)
+	if x.mode != typexpr {
+		panic(fmt.Sprintf("Expected a type, but got %T with type %v", e, x.typ))
+	}
+	if n, ok := x.typ.(*Named); ok && len(n.TypeParams) > 0 && !isInstantiation(e) {
+		panic(fmt.Sprintf("cannot use generic type %s without instantiation", n.Name))
+	}
+	return x.typ
+}
+
+func isInstantiation(e ast.Expr) bool {
+	switch StripParens(e).(type) {
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return true
+	}
+	return false
+}
+
+// isGeneric tells whether x is a generic function or type, which must
+// be instantiated before it is used.
+func isGeneric(x *operand) bool {
+	switch t := x.typ.(type) {
+	case *Named:
+		return x.mode == typexpr && len(t.TypeParams) > 0
+	case *Function:
+		return x.mode == value && len(t.TypeParams) > 0
+	}
+	return false
+}
+
+// expr checks an expression.  The hint gives the type of the
+// composite literal if e is a composite literal with its type elided.
+func (c *checker) expr(e ast.Expr, hint Type) *operand {
+	x := c.expr0(e, hint)
+	x.expr = e
+	c.record(x)
+	return x
+}
+
+func (c *checker) expr0(e ast.Expr, hint Type) *operand {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		x := &operand{mode: constval, val: constant.MakeFromLiteral(e.Value, e.Kind, 0)}
+		switch e.Kind {
+		case token.INT:
+			x.typ = Typ[UntypedInt]
+		case token.FLOAT:
+			x.typ = Typ[UntypedFloat]
+		case token.IMAG:
+			x.typ = Typ[UntypedComplex]
+		case token.CHAR:
+			x.typ = Typ[UntypedRune]
+		case token.STRING:
+			x.typ = Typ[UntypedString]
+		}
+		return x
+	case *ast.Ident:
+		return c.ident(e)
+	case *ast.ParenExpr:
+		x := c.expr(e.X, hint)
+		return &operand{mode: x.mode, typ: x.typ, val: x.val, id: x.id}
+	case *ast.FuncLit:
+		sig := c.signature(e.Type, nil)
+		c.funcBody(e.Type, nil, e.Body, sig)
+		return &operand{mode: value, typ: sig}
+	case *ast.CompositeLit:
+		return c.compositeLit(e, hint)
+	case *ast.SelectorExpr:
+		return c.selector(e)
+	case *ast.IndexListExpr:
+		return c.instantiate(e, c.expr(e.X, nil), e.Indices)
+	case *ast.IndexExpr:
+		x := c.expr(e.X, nil)
+		if isGeneric(x) {
+			return c.instantiate(e, x, []ast.Expr{e.Index})
+		}
+		if m, ok := Underlying(x.typ).(*Map); ok {
+			// An element of a map may be assigned, but it isn't
+			// addressable.
+			c.assign(c.expr(e.Index, m.Key), m.Key)
+			return &operand{mode: value, typ: m.Elem}
+		}
+		idx := c.expr(e.Index, nil)
+		c.index(idx)
+		if IsString(x.typ) {
+			if x.mode == constval && idx.mode == constval {
+				s := constant.StringVal(x.val)
+				i, _ := constant.Int64Val(idx.val)
+				return &operand{mode: constval, typ: Typ[Uint8],
+					val: constant.MakeInt64(int64(s[i]))}
+			}
+			c.assign(x, nil)
+			return &operand{mode: value, typ: Typ[Uint8]}
+		}
+		if s, ok := Underlying(x.typ).(*Slice); ok {
+			return &operand{mode: variable, typ: s.Elem}
+		}
+		if a, ok := ArrayOf(x.typ); ok {
+			if idx.mode == constval {
+				i, _ := constant.Int64Val(idx.val)
+				if i < 0 || i >= a.Len {
+					panic(fmt.Sprintf("invalid argument: index %d out of bounds [0:%d]", i, a.Len))
+				}
+			}
+			if x.mode == variable || IsPointer(x.typ) {
+				return &operand{mode: variable, typ: a.Elem}
+			}
+			return &operand{mode: value, typ: a.Elem}
+		}
+		panic(fmt.Sprintf("I can't index a %v", x.typ))
+	case *ast.SliceExpr:
+		x := c.expr(e.X, nil)
+		for _, i := range []ast.Expr{e.Low, e.High, e.Max} {
+			if i != nil {
+				c.index(c.expr(i, nil))
+			}
+		}
+		if IsString(x.typ) && !e.Slice3 {
+			c.assign(x, nil)
+			if IsUntyped(x.typ) {
+				return &operand{mode: value, typ: Typ[String]}
+			}
+			return &operand{mode: value, typ: x.typ}
+		}
+		if IsSlice(x.typ) {
+			return &operand{mode: value, typ: x.typ}
+		}
+		if a, ok := ArrayOf(x.typ); ok {
+			if x.mode != variable && !IsPointer(x.typ) {
+				panic(fmt.Sprintf("invalid operation: %v (slice of unaddressable value)", x.typ))
+			}
+			return &operand{mode: value, typ: &Slice{a.Elem}}
+		}
+		panic(fmt.Sprintf("I can't slice a %v", x.typ))
+	case *ast.StarExpr:
+		x := c.expr(e.X, nil)
+		if x.mode == typexpr {
+			return &operand{mode: typexpr, typ: &Pointer{x.typ}}
+		}
+		return &operand{mode: variable, typ: Underlying(x.typ).(*Pointer).Elem}
+	case *ast.UnaryExpr:
+		return c.unary(e)
+	case *ast.BinaryExpr:
+		return c.binary(e)
+	case *ast.CallExpr:
+		return c.call(e)
+	case *ast.TypeAssertExpr:
+		if e.Type == nil {
+			panic("invalid syntax tree: use of .(type) outside type switch")
+		}
+		x := c.expr(e.X, nil)
+		t := c.typExpr(e.Type)
+		c.assertion(x, t)
+		return &operand{mode: value, typ: t}
+	case *ast.KeyValueExpr:
+		panic("KeyValueExpr outside of a composite literal")
+
+	// Now come the type expressions...
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return &operand{mode: typexpr, typ: &Slice{c.typExpr(e.Elt)}}
+		}
+		if _, ok := e.Len.(*ast.Ellipsis); ok {
+			panic("invalid use of [...] array (outside a composite literal)")
+		}
+		n := c.expr(e.Len, nil)
+		if n.mode != constval || !IsInteger(n.typ) && !(IsUntyped(n.typ) && isIntegral(n.val)) {
+			panic(fmt.Sprintf("array length %v must be a constant integer", n.typ))
+		}
+		c.assign(n, Typ[Int])
+		length, ok := constant.Int64Val(constant.ToInt(n.val))
+		if !ok || length < 0 {
+			panic(fmt.Sprintf("invalid array length %v", n.val))
+		}
+		return &operand{mode: typexpr, typ: &Array{length, c.typExpr(e.Elt)}}
+	case *ast.ChanType:
+		return &operand{mode: typexpr, typ: &Chan{e.Dir, c.typExpr(e.Value)}}
+	case *ast.MapType:
+		k := c.typExpr(e.Key)
+		if p, ok := k.(*TypeParam); ok && p.Constraint == nil {
+			// The constraint of a type parameter may be a map keyed
+			// by a later type parameter, whose constraint we have yet
+			// to see.
+		} else if !Comparable(k) {
+			panic(fmt.Sprintf("invalid map key type %v", k))
+		}
+		return &operand{mode: typexpr, typ: &Map{k, c.typExpr(e.Value)}}
+	case *ast.FuncType:
+		return &operand{mode: typexpr, typ: c.signature(e, nil)}
+	case *ast.StructType:
+		t := &Struct{}
+		for _, f := range e.Fields.List {
+			ft := c.typExpr(f.Type)
+			if len(f.Names) == 0 {
+				name := f.Type
+				if s, ok := name.(*ast.StarExpr); ok {
+					name = s.X
+				}
+				t.Fields = append(t.Fields, Field{name.(*ast.Ident).Name, ft, true})
+			}
+			for _, n := range f.Names {
+				t.Fields = append(t.Fields, Field{n.Name, ft, false})
+			}
+		}
+		return &operand{mode: typexpr, typ: t}
+	case *ast.InterfaceType:
+		t := &Interface{}
+		for _, f := range e.Methods.List {
+			if isUnion(f.Type) {
+				t.Terms = intersect(t.Terms, c.terms(f.Type))
+				continue
+			}
+			ft := c.typExpr(f.Type)
+			if len(f.Names) == 0 {
+				// An embedded interface contributes its methods and
+				// its type set, while any other type is a union of
+				// just that type.
+				i, ok := Underlying(ft).(*Interface)
+				if !ok {
+					t.Terms = intersect(t.Terms, []*Term{{Type: ft}})
+					continue
+				}
+				t.Methods = append(t.Methods, i.Methods...)
+				t.Terms = intersect(t.Terms, i.Terms)
+				t.Comparable = t.Comparable || i.Comparable
+				t.Sealed = t.Sealed || i.Sealed
+			}
+			for _, n := range f.Names {
+				m := &Object{Kind: Func, Name: n.Name, Type: ft, Pos: n.Pos(),
+					Recv: t, state: resolved}
+				c.Objects[n] = m
+				t.Methods = append(t.Methods, m)
+			}
+		}
+		sort.Sort(byName(t.Methods))
+		if t.Sealed {
+			c.sealedType(e, t)
+		}
+		return &operand{mode: typexpr, typ: t}
+	}
+	panic(fmt.Sprintf("Type checker can't handle expression of type %T", e))
+}
+
+func (c *checker) ident(e *ast.Ident) *operand {
+	o := c.scope.Lookup(e.Name)
+	if o == nil {
+		panic("Undefined: " + e.Name)
+	}
+	c.Objects[e] = o
+	c.resolve(o)
+	switch o.Kind {
+	case Var:
+		return &operand{mode: variable, typ: o.Type}
+	case Const:
+		if o.Name == "iota" && o.Type == Typ[UntypedInt] && o.Value == nil {
+			return &operand{mode: constval, typ: o.Type, val: c.iota}
+		}
+		return &operand{mode: constval, typ: o.Type, val: o.Value}
+	case TypeName:
+		return &operand{mode: typexpr, typ: o.Type}
+	case Func:
+		return &operand{mode: value, typ: o.Type}
+	case Builtin:
+		return &operand{mode: builtin, id: o.Name}
+	case Nil:
+		return &operand{mode: value, typ: Typ[UntypedNil]}
+	}
+	panic("Weird object kind for " + e.Name)
+}
+
+// lookup finds a field or method of a value of type t.  It returns
+// either the field type or the method object.
+func lookup(t Type, name string) (Type, *Object) {
+	s := find(t, name)
+	return s.typ, s.obj
+}
+
+// A selection is what a selector finds: a field of type typ, or a
+// method obj of type typ, through the embedded fields path.  While
+// find looks, multiple tells that a type is embedded more than once at
+// the depth it is looking at.
+type selection struct {
+	typ       Type
+	obj       *Object
+	path      []Field
+	ambiguous bool
+	multiple  bool
+}
+
+// find finds a field or method of a value of type t, which may be
+// promoted from an embedded field at any depth, so long as there is
+// just one at the shallowest depth at which there are any, and the
+// type it is found in is embedded just once at that depth.
+func find(t Type, name string) selection {
+	level := []selection{{typ: t}}
+	seen := make(map[Type]bool)
+	for len(level) > 0 {
+		var found, next []selection
+		for _, s := range level {
+			base := s.typ
+			if p, ok := base.(*Pointer); ok {
+				base = p.Elem
+			}
+			if seen[base] {
+				// It was found at a shallower depth.
+				continue
+			}
+			seen[base] = true
+			if typ, m := lookupHere(s.typ, name); typ != nil {
+				found = append(found, selection{typ: typ, obj: m, path: s.path, ambiguous: s.multiple})
+				continue
+			}
+			if st, ok := Underlying(base).(*Struct); ok {
+				for _, f := range st.Fields {
+					if f.Embedded {
+						path := append(s.path[:len(s.path):len(s.path)], f)
+						next = append(next, selection{typ: f.Type, path: path, multiple: s.multiple})
+					}
+				}
+			}
+		}
+		switch {
+		case len(found) == 0:
+			level = consolidate(next)
+		case len(found) == 1 && !found[0].ambiguous:
+			return found[0]
+		default:
+			return selection{ambiguous: true}
+		}
+	}
+	return selection{}
+}
+
+// consolidate merges the selections of a level that are of the same
+// type, or pointers to it, marking them as multiple.
+func consolidate(level []selection) []selection {
+	var out []selection
+	at := make(map[Type]int)
+	for _, s := range level {
+		base := s.typ
+		if p, ok := base.(*Pointer); ok {
+			base = p.Elem
+		}
+		if i, ok := at[base]; ok {
+			out[i].multiple = true
+			continue
+		}
+		at[base] = len(out)
+		out = append(out, s)
+	}
+	return out
+}
+
+// lookupHere finds a field or method of a value of type t, which is
+// not promoted.
+func lookupHere(t Type, name string) (Type, *Object) {
+	if p, ok := t.(*Pointer); ok {
+		t = p.Elem
+	}
+	if p, ok := t.(*TypeParam); ok {
+		for _, m := range p.typeSet().Methods {
+			if m.Name == name {
+				return m.Type, m
+			}
+		}
+		return nil, nil
+	}
+	if n, ok := t.(*Named); ok {
+		for _, m := range n.Methods {
+			if m.Name == name {
+				return m.Type, m
+			}
+		}
+	}
+	switch u := Underlying(t).(type) {
+	case *Struct:
+		for _, f := range u.Fields {
+			if f.Name == name {
+				return f.Type, nil
+			}
+		}
+	case *Interface:
+		for _, m := range u.Methods {
+			if m.Name == name {
+				return m.Type, m
+			}
+		}
+	case *Pointer:
+		if _, ok := t.(*Named); ok {
+			return lookupHere(u, name)
+		}
+	case TypeType:
+		for _, m := range TypeMethods {
+			if m.Name == name {
+				return m.Type, m
+			}
+		}
+	}
+	return nil, nil
+}
+
+// EmbeddedPath gives the names of the embedded fields through which a
+// value of type t finds its field or method name.
+func EmbeddedPath(t Type, name string) []string {
+	var names []string
+	for _, f := range find(t, name).path {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// throughPointer tells whether any of the embedded fields path is a
+// pointer.
+func throughPointer(path []Field) bool {
+	for _, f := range path {
+		if IsPointer(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) selector(e *ast.SelectorExpr) *operand {
+	x := c.expr(e.X, nil)
+	if x.mode == typexpr {
+		// A method expression, T.m or (*T).m
+		_, m := lookup(x.typ, e.Sel.Name)
+		c.Objects[e.Sel] = m
+		sig := m.Type.(*Function)
+		f := &Function{Parameters: append([]Type{x.typ}, sig.Parameters...),
+			Results: sig.Results, Variadic: sig.Variadic}
+		return &operand{mode: value, typ: f}
+	}
+	s := find(x.typ, e.Sel.Name)
+	t, m := s.typ, s.obj
+	if s.ambiguous {
+		errorf(e.Sel.Pos(), "ambiguous selector %s", e.Sel.Name)
+	}
+	if t == nil {
+		panic(fmt.Sprintf("%v has no field or method %s", x.typ, e.Sel.Name))
+	}
+	if len(s.path) > 0 {
+		c.Promoted[e] = EmbeddedPath(x.typ, e.Sel.Name)
+	}
+	c.Types[e.Sel] = t
+	if m != nil {
+		c.Objects[e.Sel] = m
+		return &operand{mode: value, typ: t}
+	}
+	if x.mode == variable || IsPointer(x.typ) || throughPointer(s.path) {
+		return &operand{mode: variable, typ: t}
+	}
+	return &operand{mode: value, typ: t}
+}
+
+// ArrayOf gives the array type of t, which may be an array or a
+// pointer to an array, since indexing and slicing see through the
+// pointer.
+func ArrayOf(t Type) (*Array, bool) {
+	if p, ok := Underlying(t).(*Pointer); ok {
+		t = p.Elem
+	}
+	a, ok := Underlying(t).(*Array)
+	return a, ok
+}
+
+func isIntegral(v constant.Value) bool {
+	return constant.ToInt(v).Kind() == constant.Int
+}
+
+func IsPointer(t Type) bool {
+	_, ok := Underlying(t).(*Pointer)
+	return ok
+}
+
+func (c *checker) compositeLit(e *ast.CompositeLit, hint Type) *operand {
+	t := hint
+	if at, ok := e.Type.(*ast.ArrayType); ok && isEllipsis(at.Len) {
+		// The length of a [...]T is the number of elements.
+		t = &Array{c.elements(e.Elts), c.typExpr(at.Elt)}
+		c.recordType(e.Type, t)
+	} else if e.Type != nil {
+		t = c.typExpr(e.Type)
+	}
+	base := t
+	if p, ok := Underlying(t).(*Pointer); ok && e.Type == nil {
+		// An elided &T in a composite literal
+		base = p.Elem
+	}
+	switch u := Underlying(base).(type) {
+	case *Struct:
+		for i, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				ft, _ := lookup(u, kv.Key.(*ast.Ident).Name)
+				c.assign(c.expr(kv.Value, ft), ft)
+			} else {
+				ft := u.Fields[i].Type
+				c.assign(c.expr(elt, ft), ft)
+			}
+		}
+	case *Slice:
+		c.elements(e.Elts)
+		for _, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			c.assign(c.expr(elt, u.Elem), u.Elem)
+		}
+	case *Map:
+		for _, elt := range e.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				panic("missing key in map literal")
+			}
+			if id, isid := kv.Key.(*ast.Ident); isid {
+				// A key that is a name must look like an expression,
+				// not a field name, to the passes that rewrite it.
+				kv.Key = &ast.ParenExpr{Lparen: id.Pos(), X: id, Rparen: id.End()}
+			}
+			c.assign(c.expr(kv.Key, u.Key), u.Key)
+			c.assign(c.expr(kv.Value, u.Elem), u.Elem)
+		}
+	case *Array:
+		if n := c.elements(e.Elts); n > u.Len {
+			panic(fmt.Sprintf("index %d is out of bounds (>= %d)", n-1, u.Len))
+		}
+		for _, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			c.assign(c.expr(elt, u.Elem), u.Elem)
+		}
+	default:
+		panic(fmt.Sprintf("I can't handle composite literals of type %v", t))
+	}
+	return &operand{mode: value, typ: t}
+}
+
+func isEllipsis(e ast.Expr) bool {
+	_, ok := e.(*ast.Ellipsis)
+	return ok
+}
+
+// elements checks the keys of the elements of an array or slice
+// literal, and returns the length that they need.
+func (c *checker) elements(elts []ast.Expr) int64 {
+	n, i := int64(0), int64(0)
+	for _, elt := range elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if _, done := c.Types[kv.Key]; !done {
+				k := c.expr(kv.Key, nil)
+				if k.mode != constval {
+					panic("index must be non-negative integer constant")
+				}
+				c.index(k)
+			}
+			i, _ = constant.Int64Val(c.Values[kv.Key])
+		}
+		i++
+		if i > n {
+			n = i
+		}
+	}
+	return n
+}
+
+// index checks an index (or a size given to make), which may be of any
+// integer type, but must be an int if it is untyped.
+func (c *checker) index(x *operand) {
+	if !IsInteger(x.typ) && !(x.mode == constval && IsUntyped(x.typ)) {
+		panic(fmt.Sprintf("Index of non-integer type %v", x.typ))
+	}
+	if IsUntyped(x.typ) {
+		c.convertUntyped(x, Typ[Int])
+	}
+}
+
+func (c *checker) unary(e *ast.UnaryExpr) *operand {
+	x := c.expr(e.X, nil)
+	switch e.Op {
+	case token.AND:
+		if _, ok := StripParens(e.X).(*ast.CompositeLit); ok {
+			return &operand{mode: value, typ: &Pointer{x.typ}}
+		}
+		return &operand{mode: value, typ: &Pointer{x.typ}}
+	case token.ARROW:
+		ch, ok := Underlying(x.typ).(*Chan)
+		if !ok || ch.Dir == ast.SEND {
+			panic(fmt.Sprintf("invalid operation: cannot receive from %v", x.typ))
+		}
+		return &operand{mode: value, typ: ch.Elem}
+	}
+	if x.mode == constval {
+		prec := uint(0)
+		if IsUnsigned(x.typ) {
+			prec = uint(8 * x.typ.Size())
+			if isBasic(x.typ, Uint, Uintptr) {
+				prec = 64
+			}
+		}
+		return &operand{mode: constval, typ: x.typ, val: constant.UnaryOp(e.Op, x.val, prec)}
+	}
+	return &operand{mode: value, typ: x.typ}
+}
+
+func StripParens(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
+
+func isComparison(op token.Token) bool {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return true
+	}
+	return false
+}
+
+func (c *checker) binary(e *ast.BinaryExpr) *operand {
+	x := c.expr(e.X, nil)
+	y := c.expr(e.Y, nil)
+	if e.Op == token.SHL || e.Op == token.SHR {
+		return c.shift(x, y, e.Op)
+	}
+	if isComparison(e.Op) {
+		c.comparison(x, y)
+		if e.Op != token.EQL && e.Op != token.NEQ {
+			operator(e.Op, x.typ)
+		}
+		if x.mode == constval && y.mode == constval {
+			return &operand{mode: constval, typ: Typ[UntypedBool],
+				val: constant.MakeBool(constant.Compare(x.val, e.Op, y.val))}
+		}
+		return &operand{mode: value, typ: Typ[UntypedBool]}
+	}
+	c.matchTypes(x, y)
+	operator(e.Op, x.typ)
+	t := x.typ
+	if IsUntyped(x.typ) && IsUntyped(y.typ) && y.typ.(*Basic).Kind > x.typ.(*Basic).Kind {
+		t = y.typ
+	}
+	if x.mode == constval && y.mode == constval {
+		op := e.Op
+		if op == token.QUO && IsInteger(t) {
+			op = token.QUO_ASSIGN // for integer division
+		}
+		v := constant.BinaryOp(x.val, op, y.val)
+		return &operand{mode: constval, typ: t, val: representable(v, t)}
+	}
+	return &operand{mode: value, typ: t}
+}
+
+// matchTypes converts an untyped operand to the type of the other
+// operand of a binary operation.
+func (c *checker) matchTypes(x, y *operand) {
+	switch {
+	case IsUntyped(x.typ) && !IsUntyped(y.typ):
+		c.convertUntyped(x, y.typ)
+	case IsUntyped(y.typ) && !IsUntyped(x.typ):
+		c.convertUntyped(y, x.typ)
+	}
+}
+
+func (c *checker) comparison(x, y *operand) {
+	for _, o := range []*operand{x, y} {
+		if o.mode == typexpr {
+			c.assign(o, TypeType{})
+		}
+	}
+	if x.typ != Typ[UntypedNil] && y.typ != Typ[UntypedNil] {
+		for _, o := range []*operand{x, y} {
+			if !Comparable(o.typ) {
+				panic(fmt.Sprintf("invalid operation: %v cannot be compared", o.typ))
+			}
+		}
+	}
+	c.matchTypes(x, y)
+	switch {
+	case Identical(x.typ, y.typ):
+	case IsInterface(x.typ) && !IsInterface(y.typ):
+		c.assign(y, x.typ)
+	case IsInterface(y.typ) && !IsInterface(x.typ):
+		c.assign(x, y.typ)
+	case IsUntyped(x.typ) && IsUntyped(y.typ):
+		if x.mode != constval || y.mode != constval {
+			c.assign(x, nil)
+			c.assign(y, nil)
+		}
+	}
+}
+
+func (c *checker) shiftCount(y *operand) {
+	if IsUntyped(y.typ) {
+		c.convertUntyped(y, Typ[Uint])
+	}
+}
+
+func (c *checker) shift(x, y *operand, op token.Token) *operand {
+	c.shiftCount(y)
+	if x.mode == constval && y.mode == constval {
+		if IsUntyped(x.typ) {
+			x.val = constant.ToInt(x.val)
+			if x.typ != Typ[UntypedRune] {
+				x.typ = Typ[UntypedInt]
+			}
+		}
+		s, _ := constant.Uint64Val(y.val)
+		return &operand{mode: constval, typ: x.typ,
+			val: representable(constant.Shift(x.val, op, uint(s)), x.typ)}
+	}
+	// The type of a non-constant shift of an untyped constant is
+	// determined by its context, which we remember in c.untyped.
+	return &operand{mode: value, typ: x.typ}
+}
+
+// representable truncates the result of a constant operation to fit
+// its type, which is only needed for operations on unsigned
+// constants like ^uint8(0).
+func representable(v constant.Value, t Type) constant.Value {
+	if IsInteger(t) && !IsUntyped(t) && v.Kind() == constant.Int && IsUnsigned(t) {
+		bits := uint(8 * t.Size())
+		if isBasic(t, Uint, Uintptr) {
+			bits = 64
+		}
+		mask := constant.Shift(constant.MakeInt64(1), token.SHL, bits)
+		mask = constant.BinaryOp(mask, token.SUB, constant.MakeInt64(1))
+		return constant.BinaryOp(v, token.AND, mask)
+	}
+	return v
+}
+
+// fits reports, as gc does, the constant v given to an operand of
+// type t that t can't represent, at pos.
+func fits(pos token.Pos, v constant.Value, t Type) {
+	if v == nil || v.Kind() == constant.Unknown || IsUntyped(t) {
+		return
+	}
+	switch {
+	case IsInteger(t):
+		i := constant.ToInt(v)
+		if i.Kind() != constant.Int {
+			errorf(pos, "constant %s truncated to integer", v)
+		}
+		bits := uint(8 * t.Size())
+		if isBasic(t, Int, Uint, Uintptr) {
+			bits = 64
+		}
+		one := constant.MakeInt64(1)
+		min := constant.MakeInt64(0)
+		max := constant.BinaryOp(constant.Shift(one, token.SHL, bits), token.SUB, one)
+		if !IsUnsigned(t) {
+			min = constant.UnaryOp(token.SUB, constant.Shift(one, token.SHL, bits-1), 0)
+			max = constant.BinaryOp(constant.Shift(one, token.SHL, bits-1), token.SUB, one)
+		}
+		if constant.Compare(i, token.LSS, min) || constant.Compare(i, token.GTR, max) {
+			errorf(pos, "constant %s overflows %s", i, t)
+		}
+	case IsFloat(t):
+		f := constant.ToFloat(v)
+		if f.Kind() != constant.Float && f.Kind() != constant.Int {
+			errorf(pos, "constant %s truncated to real", v)
+		}
+		x, _ := constant.Float64Val(f)
+		if isBasic(t, Float32) {
+			x32, _ := constant.Float32Val(f)
+			x = float64(x32)
+		}
+		if math.IsInf(x, 0) {
+			errorf(pos, "constant %s overflows %s", v, t)
+		}
+	}
+}
+
+// convertValue converts a constant value to a representation
+// appropriate for type t.
+func convertValue(v constant.Value, t Type) constant.Value {
+	switch {
+	case v == nil:
+		return nil
+	case IsInteger(t) && !IsUntyped(t):
+		if v.Kind() == constant.Float {
+			return constant.ToInt(v)
+		}
+	case IsFloat(t) && !IsUntyped(t):
+		return constant.ToFloat(v)
+	case IsComplex(t) && !IsUntyped(t):
+		return constant.ToComplex(v)
+	}
+	return v
+}
+
+// convertUntyped gives an untyped operand the type t, which it gets
+// from its context.
+func (c *checker) convertUntyped(x *operand, t Type) {
+	if !IsUntyped(x.typ) {
+		return
+	}
+	if t == nil || IsInterface(t) && x.typ != Typ[UntypedNil] {
+		t = Default(x.typ)
+	}
+	if IsUntyped(t) {
+		return
+	}
+	if x.expr != nil {
+		fits(x.expr.Pos(), x.val, t)
+	}
+	orig := x.typ
+	x.typ = t
+	x.val = convertValue(x.val, t)
+	if c.settle(x.expr, t) {
+		c.Untyped[x.expr] = orig
+	}
+}
+
+// settle gives an untyped expression (and its untyped operands) its
+// final type.
+func (c *checker) settle(e ast.Expr, t Type) bool {
+	if _, ok := c.untyped[e]; !ok {
+		return false
+	}
+	delete(c.untyped, e)
+	c.Types[e] = t
+	if v, ok := c.Values[e]; ok {
+		c.Values[e] = convertValue(v, t)
+	}
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		c.settle(e.X, t)
+	case *ast.UnaryExpr:
+		c.settle(e.X, t)
+	case *ast.BinaryExpr:
+		switch {
+		case isComparison(e.Op):
+			// The operands of a comparison have their own types.
+		case e.Op == token.SHL || e.Op == token.SHR:
+			c.settle(e.X, t)
+		default:
+			c.settle(e.X, t)
+			c.settle(e.Y, t)
+		}
+	}
+	return true
+}
+
+// assign checks that the value x can flow into a place of type t,
+// converting untyped constants and noting any implicit conversion.
+// If t is nil, x will be given its default type.
+func (c *checker) assign(x *operand, t Type) {
+	if x.mode == typexpr {
+		// A type used as a value is a Type.
+		if t != nil && !Identical(t, TypeType{}) {
+			panic(fmt.Sprintf("%v (type) is not an expression", x.typ))
+		}
+		c.Implicit[x.expr] = TypeType{}
+		x.mode, x.typ = value, TypeType{}
+		return
+	}
+	if isGeneric(x) {
+		panic(fmt.Sprintf("cannot use generic function %v without instantiation", x.typ))
+	}
+	if IsUntyped(x.typ) {
+		c.convertUntyped(x, t)
+	}
+	if t != nil && !Identical(x.typ, t) {
+		c.implements(x, t)
+		c.Implicit[x.expr] = t
+	}
+}
+
+// implements checks that x may be converted to t, if t is an
+// interface type.
+func (c *checker) implements(x *operand, t Type) {
+	i, ok := Underlying(t).(*Interface)
+	if !ok || x.typ == Typ[UntypedNil] {
+		return
+	}
+	if i.Sealed && !Identical(Underlying(x.typ), i) && Variant(t, x.typ) < 0 {
+		panic(fmt.Sprintf("cannot use %v value as %v value: %v is not a variant of %v",
+			x.typ, t, x.typ, t))
+	}
+	if m := MissingMethod(x.typ, i); m != nil {
+		panic(fmt.Sprintf("cannot use %v value as %v value: %v does not implement %v (missing method %s)",
+			x.typ, t, x.typ, t, m.Name))
+	}
+}
+
+// assertion checks that x, which must be an interface, could hold a
+// value of type t.
+func (c *checker) assertion(x *operand, t Type) {
+	i, ok := Underlying(x.typ).(*Interface)
+	if !ok {
+		panic(fmt.Sprintf("invalid operation: %v is not an interface", x.typ))
+	}
+	if IsInterface(t) {
+		return
+	}
+	if i.Sealed && Variant(x.typ, t) < 0 {
+		panic(fmt.Sprintf("impossible type assertion: %v is not a variant of %v", t, x.typ))
+	}
+	if m := MissingMethod(t, i); m != nil {
+		panic(fmt.Sprintf("impossible type assertion: %v does not implement %v (missing method %s)",
+			t, x.typ, m.Name))
+	}
+}
+
+// values checks an expression that gives n values, which is either a
+// call or a comma-ok expression, and returns their types.  A comma-ok
+// expression is given a Tuple type.
+func (c *checker) values(e ast.Expr, n int) []Type {
+	x := c.expr(e, nil)
+	_, isassert := StripParens(e).(*ast.TypeAssertExpr)
+	u, isrecv := StripParens(e).(*ast.UnaryExpr)
+	ix, isindex := StripParens(e).(*ast.IndexExpr)
+	if (isassert || isrecv && u.Op == token.ARROW || isindex && IsMap(c.Types[ix.X])) && n == 2 {
+		t := &Tuple{[]Type{x.typ, Typ[Bool]}}
+		c.Types[e] = t
+		return t.Types
+	}
+	return x.typ.(*Tuple).Types
+}
+
+func (c *checker) call(e *ast.CallExpr) *operand {
+	if fun, x, explicit := c.partial(e.Fun); x != nil {
+		// A call of a generic function with some of its type
+		// arguments, such as Convert[float64](x)
+		return c.results(c.genericCall(e, fun, x, explicit))
+	}
+	f := c.expr(e.Fun, nil)
+	switch f.mode {
+	case typexpr:
+		return c.conversion(e, f.typ)
+	case builtin:
+		return c.builtin(e, f.id)
+	}
+	if isGeneric(f) {
+		return c.results(c.genericCall(e, e.Fun, f, nil))
+	}
+	sig := Underlying(f.typ).(*Function)
+	c.args(e, sig)
+	return c.results(sig)
+}
+
+// results gives the result of a call of a function of type sig.
+func (c *checker) results(sig *Function) *operand {
+	if len(sig.Results) == 0 {
+		return &operand{mode: novalue, typ: &Tuple{}}
+	}
+	return &operand{mode: value, typ: sig.ResultType()}
+}
+
+// args checks the arguments of a call against the parameters of a
+// function.
+func (c *checker) args(e *ast.CallExpr, sig *Function) {
+	if len(e.Args) == 1 && len(sig.Parameters) > 1 {
+		// A call of the form f(g()) where g has multiple results
+		c.expr(e.Args[0], nil)
+		return
+	}
+	for i, a := range e.Args {
+		var t Type
+		switch {
+		case sig.Variadic && i >= len(sig.Parameters)-1 && !e.Ellipsis.IsValid():
+			t = sig.Parameters[len(sig.Parameters)-1]
+			t = elem(t)
+		default:
+			t = sig.Parameters[i]
+		}
+		c.assign(c.expr(a, t), t)
+	}
+}
+
+// elem gives the element type of a variadic parameter.
+func elem(t Type) Type {
+	return Underlying(t).(*Slice).Elem
+}
+
+func (c *checker) conversion(e *ast.CallExpr, t Type) *operand {
+	x := c.expr(e.Args[0], nil)
+	if x.mode == typexpr {
+		c.assign(x, t)
+		delete(c.Implicit, x.expr)
+		return &operand{mode: value, typ: t}
+	}
+	if x.mode == constval && isBasic(t, Bool, Int, Int8, Int16, Int32, Int64,
+		Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Float32, Float64,
+		Complex64, Complex128, String) {
+		if IsString(t) && IsInteger(x.typ) {
+			// string(65) is "A"
+			i, _ := constant.Int64Val(x.val)
+			c.settle(x.expr, Default(x.typ))
+			return &operand{mode: constval, typ: t, val: constant.MakeString(string(rune(i)))}
+		}
+		fits(x.expr.Pos(), x.val, t)
+		v := convertValue(x.val, t)
+		if IsUntyped(x.typ) {
+			c.settle(x.expr, t)
+		}
+		return &operand{mode: constval, typ: t, val: v}
+	}
+	c.implements(x, t)
+	if IsUntyped(x.typ) {
+		if _, isbasic := Underlying(t).(*Basic); x.typ == Typ[UntypedNil] || isbasic {
+			c.settle(x.expr, t)
+		} else {
+			c.settle(x.expr, Default(x.typ))
+		}
+	}
+	return &operand{mode: value, typ: t}
+}
+
+// hasCall tells whether e calls a function (other than a conversion
+// or a builtin with a constant result) or receives from a channel.
+func (c *checker) hasCall(e ast.Expr) bool {
+	found := false
+	ast.Inspect(e, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			if _, isconst := c.Values[n]; !isconst && !c.IsType(n.Fun) {
+				found = true
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *checker) builtin(e *ast.CallExpr, id string) *operand {
+	switch id {
+	case "len", "cap":
+		x := c.expr(e.Args[0], nil)
+		if IsString(x.typ) && x.mode == constval {
+			return &operand{mode: constval, typ: Typ[Int],
+				val: constant.MakeInt64(int64(len(constant.StringVal(x.val))))}
+		}
+		if a, ok := ArrayOf(x.typ); ok && !c.hasCall(e.Args[0]) {
+			// The length of an array is constant, so long as finding
+			// it doesn't mean calling a function.
+			return &operand{mode: constval, typ: Typ[Int], val: constant.MakeInt64(a.Len)}
+		}
+		c.assign(x, nil)
+		return &operand{mode: value, typ: Typ[Int]}
+	case "new":
+		x := c.expr(e.Args[0], nil)
+		if x.mode == typexpr {
+			return &operand{mode: value, typ: &Pointer{x.typ}}
+		}
+		// new of a Type value gives a pointer to a zero value of
+		// that type, held in an interface.
+		c.assign(x, TypeType{})
+		return &operand{mode: value, typ: &Interface{}}
+	case "typeof":
+		c.assign(c.expr(e.Args[0], nil), nil)
+		return &operand{mode: value, typ: TypeType{}}
+	case "close":
+		x := c.expr(e.Args[0], nil)
+		if ch, ok := Underlying(x.typ).(*Chan); !ok || ch.Dir == ast.RECV {
+			panic(fmt.Sprintf("invalid operation: cannot close %v", x.typ))
+		}
+		return &operand{mode: novalue, typ: &Tuple{}}
+	case "delete":
+		x := c.expr(e.Args[0], nil)
+		m, ok := Underlying(x.typ).(*Map)
+		if !ok {
+			panic(fmt.Sprintf("invalid argument: %v is not a map", x.typ))
+		}
+		c.assign(c.expr(e.Args[1], m.Key), m.Key)
+		return &operand{mode: novalue, typ: &Tuple{}}
+	case "make":
+		t := c.typExpr(e.Args[0])
+		for _, a := range e.Args[1:] {
+			c.index(c.expr(a, nil))
+		}
+		return &operand{mode: value, typ: t}
+	case "append":
+		s := c.expr(e.Args[0], nil)
+		if s.typ == Typ[UntypedNil] {
+			panic("First argument to append must be a typed slice")
+		}
+		t := Underlying(s.typ).(*Slice).Elem
+		if e.Ellipsis.IsValid() {
+			x := c.expr(e.Args[1], nil)
+			if IsString(x.typ) && isBasic(t, Uint8) {
+				c.assign(x, nil)
+			} else {
+				c.assign(x, &Slice{t})
+			}
+			return &operand{mode: value, typ: s.typ}
+		}
+		for _, a := range e.Args[1:] {
+			c.assign(c.expr(a, t), t)
+		}
+		return &operand{mode: value, typ: s.typ}
+	case "copy":
+		dst, src := c.expr(e.Args[0], nil), c.expr(e.Args[1], nil)
+		c.assign(src, nil)
+		if !IsString(src.typ) {
+			c.assign(src, dst.typ)
+		}
+		return &operand{mode: value, typ: Typ[Int]}
+	case "panic":
+		c.assign(c.expr(e.Args[0], nil), &Interface{})
+		return &operand{mode: novalue, typ: &Tuple{}}
+	case "print", "println":
+		for _, a := range e.Args {
+			c.assign(c.expr(a, nil), nil)
+		}
+		return &operand{mode: novalue, typ: &Tuple{}}
+	case "recover":
+		return &operand{mode: value, typ: &Interface{}}
+	case "min", "max":
+		xs := make([]*operand, len(e.Args))
+		allconst := true
+		for i, a := range e.Args {
+			xs[i] = c.expr(a, nil)
+			allconst = allconst && xs[i].mode == constval
+		}
+		var t Type
+		for _, x := range xs {
+			if !IsUntyped(x.typ) {
+				t = x.typ
+			}
+		}
+		if allconst {
+			best := xs[0]
+			for _, x := range xs[1:] {
+				if id == "min" && constant.Compare(x.val, token.LSS, best.val) ||
+					id == "max" && constant.Compare(x.val, token.GTR, best.val) {
+					best = x
+				}
+				if t == nil && x.typ.(*Basic).Kind > best.typ.(*Basic).Kind {
+					best = &operand{typ: x.typ, val: best.val}
+				}
+			}
+			if t == nil {
+				t = best.typ
+			}
+			for _, x := range xs {
+				c.assign(x, t)
+			}
+			return &operand{mode: constval, typ: t, val: convertValue(best.val, t)}
+		}
+		for _, x := range xs {
+			c.assign(x, t)
+		}
+		return &operand{mode: value, typ: t}
+	case "complex":
+		x, y := c.expr(e.Args[0], nil), c.expr(e.Args[1], nil)
+		c.matchTypes(x, y)
+		t := Typ[Complex128]
+		if isBasic(x.typ, Float32) {
+			t = Typ[Complex64]
+		}
+		if x.mode == constval && y.mode == constval {
+			v := constant.BinaryOp(constant.ToComplex(x.val), token.ADD,
+				constant.MakeImag(y.val))
+			if IsUntyped(x.typ) && IsUntyped(y.typ) {
+				return &operand{mode: constval, typ: Typ[UntypedComplex], val: v}
+			}
+			return &operand{mode: constval, typ: t, val: v}
+		}
+		c.assign(x, nil)
+		c.assign(y, nil)
+		return &operand{mode: value, typ: t}
+	case "real", "imag":
+		x := c.expr(e.Args[0], nil)
+		if x.mode == constval {
+			v := constant.Real(x.val)
+			if id == "imag" {
+				v = constant.Imag(x.val)
+			}
+			t := Typ[UntypedFloat]
+			if !IsUntyped(x.typ) {
+				t = Typ[Float64]
+				if isBasic(x.typ, Complex64) {
+					t = Typ[Float32]
+				}
+			}
+			return &operand{mode: constval, typ: t, val: v}
+		}
+		c.assign(x, nil)
+		if isBasic(x.typ, Complex64) {
+			return &operand{mode: value, typ: Typ[Float32]}
+		}
+		return &operand{mode: value, typ: Typ[Float64]}
+	}
+	panic("I don't yet handle builtin " + id)
+}
@@ -0,0 +1,743 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"strings"
+)
+
+// TypeParam is a type parameter of a generic function or type, which
+// stands for any type that satisfies its constraint.
+type TypeParam struct {
+	Name       string
+	Index      int
+	Constraint Type
+}
+
+func (t *TypeParam) Size() int {
+	if u := Underlying(t); u != t {
+		return u.Size()
+	}
+	panic("A type parameter has no size: " + t.Name)
+}
+func (t *TypeParam) Expr() ast.Expr {
+	return ast.NewIdent(t.Name)
+}
+func (t *TypeParam) String() string {
+	return t.Name
+}
+
+// typeSet is the interface that the constraint of p is.
+func (p *TypeParam) typeSet() *Interface {
+	if p.Constraint == nil {
+		return &Interface{}
+	}
+	return Underlying(p.Constraint).(*Interface)
+}
+
+// constraintName is the constraint of p as gc writes it.
+func (p *TypeParam) constraintName() string {
+	if i, ok := p.Constraint.(*Interface); p.Constraint == nil || ok && len(i.Methods) == 0 && i.Terms == nil {
+		return "any"
+	}
+	return p.Constraint.String()
+}
+
+// core is the underlying type of every type in the type set of p, if
+// they all have the same one, or nil.
+func (p *TypeParam) core() Type {
+	terms := p.typeSet().Terms
+	if len(terms) == 0 {
+		return nil
+	}
+	u := Underlying(terms[0].Type)
+	for _, term := range terms[1:] {
+		if !Identical(Underlying(term.Type), u) {
+			return nil
+		}
+	}
+	return u
+}
+
+// A Term is one of the types in the union of a constraint, which
+// stands for every type with that underlying type if Tilde is set.
+type Term struct {
+	Tilde bool
+	Type  Type
+}
+
+func (t *Term) String() string {
+	if t.Tilde {
+		return "~" + t.Type.String()
+	}
+	return t.Type.String()
+}
+
+// includes tells whether the type t is in the type set of the term.
+func (t *Term) includes(x Type) bool {
+	if t.Tilde {
+		return Identical(Underlying(x), Underlying(t.Type))
+	}
+	return Identical(x, t.Type)
+}
+
+// An Instance is a generic function or type, along with the type
+// arguments of an instantiation of it.
+type Instance struct {
+	Obj      *Object
+	TypeArgs []Type
+}
+
+// instance gives the instance of the generic type n with the type
+// arguments args, which is n itself if they are its own type
+// parameters.  Each instance is made just once, so that identical
+// instances are the same type.
+func instance(n *Named, args []Type) *Named {
+	same := true
+	for i, p := range n.TypeParams {
+		same = same && args[i] == p
+	}
+	if same {
+		return n
+	}
+	for _, in := range n.instances {
+		if identicalLists(in.TypeArgs, args) {
+			return in
+		}
+	}
+	in := &Named{Name: n.Name, Orig: n, TypeArgs: args}
+	n.instances = append(n.instances, in)
+	if n.Underlying != nil {
+		in.Underlying = Subst(n.Underlying, in.substitution())
+	}
+	for _, m := range n.Methods {
+		in.Methods = append(in.Methods, in.method(m))
+	}
+	return in
+}
+
+// substitution maps the type parameters of the generic type that n
+// instantiates to its type arguments.
+func (n *Named) substitution() map[*TypeParam]Type {
+	m := make(map[*TypeParam]Type)
+	for i, p := range n.Orig.TypeParams {
+		m[p] = n.TypeArgs[i]
+	}
+	return m
+}
+
+// method is the method m of the generic type that n instantiates, as
+// a method of n.
+func (n *Named) method(m *Object) *Object {
+	s := n.substitution()
+	return &Object{Kind: Func, Name: m.Name, Type: Subst(m.Type, s), Decl: m.Decl,
+		Pos: m.Pos, Recv: Subst(m.Recv, s), state: resolved}
+}
+
+// Subst replaces the type parameters in t with the types that m maps
+// them to.
+func Subst(t Type, m map[*TypeParam]Type) Type {
+	switch t := t.(type) {
+	case *TypeParam:
+		if x, ok := m[t]; ok {
+			return x
+		}
+	case *Named:
+		switch {
+		case t.Orig != nil:
+			return instance(t.Orig, substList(t.TypeArgs, m))
+		case len(t.TypeParams) > 0:
+			// A generic type within its own declaration stands for
+			// its instance with its own type parameters.
+			args := make([]Type, len(t.TypeParams))
+			for i, p := range t.TypeParams {
+				args[i] = p
+			}
+			return instance(t, substList(args, m))
+		}
+	case *Pointer:
+		return &Pointer{Subst(t.Elem, m)}
+	case *Slice:
+		return &Slice{Subst(t.Elem, m)}
+	case *Array:
+		return &Array{t.Len, Subst(t.Elem, m)}
+	case *Chan:
+		return &Chan{t.Dir, Subst(t.Elem, m)}
+	case *Map:
+		return &Map{Subst(t.Key, m), Subst(t.Elem, m)}
+	case *Struct:
+		s := &Struct{Fields: make([]Field, len(t.Fields))}
+		for i, f := range t.Fields {
+			s.Fields[i] = Field{f.Name, Subst(f.Type, m), f.Embedded}
+		}
+		return s
+	case *Interface:
+		i := &Interface{Comparable: t.Comparable}
+		for _, x := range t.Methods {
+			i.Methods = append(i.Methods, &Object{Kind: Func, Name: x.Name,
+				Type: Subst(x.Type, m), Pos: x.Pos, Recv: i, state: resolved})
+		}
+		for _, term := range t.Terms {
+			i.Terms = append(i.Terms, &Term{term.Tilde, Subst(term.Type, m)})
+		}
+		return i
+	case *Function:
+		return &Function{Parameters: substList(t.Parameters, m),
+			Results: substList(t.Results, m), Variadic: t.Variadic}
+	case *Tuple:
+		return &Tuple{substList(t.Types, m)}
+	}
+	return t
+}
+
+func substList(ts []Type, m map[*TypeParam]Type) []Type {
+	out := make([]Type, len(ts))
+	for i, t := range ts {
+		out[i] = Subst(t, m)
+	}
+	return out
+}
+
+// typeParams declares the type parameters in the list fl in the
+// current scope, creating them the first time that we see them.
+func (c *checker) typeParams(fl *ast.FieldList) []*TypeParam {
+	if fl == nil {
+		return nil
+	}
+	var ps []*TypeParam
+	var fields []*ast.Field
+	for _, f := range fl.List {
+		for _, n := range f.Names {
+			o := c.objectFor(n, TypeName)
+			if o.Type == nil {
+				o.Type = &TypeParam{Name: n.Name, Index: len(ps)}
+				o.Decl = f
+				o.state = resolved
+			}
+			c.recordType(n, o.Type)
+			c.scope.Insert(o)
+			ps = append(ps, o.Type.(*TypeParam))
+			fields = append(fields, f)
+		}
+	}
+	// A constraint may refer to any of the type parameters.
+	for i, p := range ps {
+		if p.Constraint == nil {
+			p.Constraint = c.constraint(fields[i].Type)
+		}
+	}
+	return ps
+}
+
+// recvTypeParams declares the type parameters of the receiver of a
+// method of a generic type, which are the type parameters of that
+// type, whatever the method calls them.
+func (c *checker) recvTypeParams(recv *ast.FieldList) {
+	t := recv.List[0].Type
+	if s, ok := t.(*ast.StarExpr); ok {
+		t = s.X
+	}
+	var base ast.Expr
+	var names []ast.Expr
+	switch x := t.(type) {
+	case *ast.IndexExpr:
+		base, names = x.X, []ast.Expr{x.Index}
+	case *ast.IndexListExpr:
+		base, names = x.X, x.Indices
+	default:
+		return
+	}
+	o := c.global.Lookup(base.(*ast.Ident).Name)
+	if o == nil || o.Kind != TypeName {
+		panic("Undefined: " + base.(*ast.Ident).Name)
+	}
+	c.resolve(o)
+	n, ok := o.Type.(*Named)
+	if !ok || len(n.TypeParams) != len(names) {
+		panic(fmt.Sprintf("receiver of %s has the wrong number of type parameters", o.Name))
+	}
+	for i, e := range names {
+		p := c.objectFor(e.(*ast.Ident), TypeName)
+		p.Type, p.state = n.TypeParams[i], resolved
+		c.scope.Insert(p)
+	}
+}
+
+// constraint checks the constraint of a type parameter, which may be
+// an interface, or a union of types that stands for an interface
+// with just those types.
+func (c *checker) constraint(e ast.Expr) Type {
+	if isUnion(e) {
+		return &Interface{Terms: c.terms(e)}
+	}
+	t := c.typExpr(e)
+	if _, ok := Underlying(t).(*Interface); ok {
+		return t
+	}
+	return &Interface{Terms: []*Term{{Type: t}}}
+}
+
+func isUnion(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		return e.Op == token.OR
+	case *ast.UnaryExpr:
+		return e.Op == token.TILDE
+	}
+	return false
+}
+
+// terms checks a union of types, such as ~int | ~float64.
+func (c *checker) terms(e ast.Expr) []*Term {
+	switch e := e.(type) {
+	case *ast.BinaryExpr:
+		if e.Op == token.OR {
+			return append(c.terms(e.X), c.terms(e.Y)...)
+		}
+	case *ast.UnaryExpr:
+		if e.Op == token.TILDE {
+			t := c.typExpr(e.X)
+			if !Identical(Underlying(t), t) {
+				panic(fmt.Sprintf("invalid use of ~ (underlying type of %v is %v)", t, Underlying(t)))
+			}
+			return []*Term{{Tilde: true, Type: t}}
+		}
+	}
+	return []*Term{{Type: c.typExpr(e)}}
+}
+
+// intersect gives the terms in the type sets of both a and b, where
+// nil stands for every type.
+func intersect(a, b []*Term) []*Term {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	out := []*Term{}
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case x.Tilde && y.Tilde && Identical(x.Type, y.Type):
+				out = append(out, x)
+			case !x.Tilde && y.includes(x.Type):
+				out = append(out, x)
+			case !y.Tilde && x.includes(y.Type):
+				out = append(out, y)
+			}
+		}
+	}
+	return out
+}
+
+// instantiate checks the instantiation of the generic function or type
+// x with the type arguments in list.
+func (c *checker) instantiate(e ast.Expr, x *operand, list []ast.Expr) *operand {
+	o := c.Objects[StripParens(x.expr).(*ast.Ident)]
+	var params []*TypeParam
+	kind := "type"
+	if n, ok := x.typ.(*Named); ok {
+		params = n.TypeParams
+	} else {
+		params = x.typ.(*Function).TypeParams
+		kind = "func"
+	}
+	if len(list) < len(params) {
+		panic(fmt.Sprintf("not enough type arguments for %s %s: have %d, want %d",
+			kind, o.Name, len(list), len(params)))
+	}
+	if len(list) > len(params) {
+		panic(fmt.Sprintf("too many type arguments for %s %s: have %d, want %d",
+			kind, o.Name, len(list), len(params)))
+	}
+	args := make([]Type, len(list))
+	for i, a := range list {
+		args[i] = c.typExpr(a)
+	}
+	c.satisfies(e.Pos(), params, args)
+	c.Instances[e] = &Instance{Obj: o, TypeArgs: args}
+	if n, ok := x.typ.(*Named); ok {
+		return &operand{mode: typexpr, typ: instance(n, args)}
+	}
+	m := make(map[*TypeParam]Type)
+	for i, p := range params {
+		m[p] = args[i]
+	}
+	return &operand{mode: value, typ: Subst(x.typ, m)}
+}
+
+// satisfies checks that each type argument satisfies the constraint of
+// its type parameter, in an instantiation at pos.
+func (c *checker) satisfies(pos token.Pos, params []*TypeParam, args []Type) {
+	m := make(map[*TypeParam]Type)
+	for i, p := range params {
+		m[p] = args[i]
+	}
+	for i, p := range params {
+		t := args[i]
+		set := Underlying(Subst(p.typeSet(), m)).(*Interface)
+		name := p.constraintName()
+		if mm := MissingMethod(t, set); mm != nil {
+			errorf(pos, "%v does not satisfy %s (missing method %s)", t, name, mm.Name)
+		}
+		if set.Comparable && !Comparable(t) {
+			errorf(pos, "%v does not satisfy %s", t, name)
+		}
+		if set.Terms == nil {
+			continue
+		}
+		ts := []Type{t}
+		if tp, ok := t.(*TypeParam); ok {
+			ts = nil
+			for _, term := range tp.typeSet().Terms {
+				ts = append(ts, term.Type)
+			}
+			if tp.typeSet().Terms == nil {
+				errorf(pos, "%v does not satisfy %s", t, name)
+			}
+		}
+		for _, x := range ts {
+			in := false
+			for _, term := range set.Terms {
+				in = in || term.includes(x)
+			}
+			if !in {
+				errorf(pos, "%v does not satisfy %s (%v missing in %s)",
+					t, name, x, termsString(set.Terms))
+			}
+		}
+	}
+}
+
+func termsString(terms []*Term) string {
+	ss := make([]string, len(terms))
+	for i, t := range terms {
+		ss[i] = t.String()
+	}
+	return strings.Join(ss, " | ")
+}
+
+// allows tells whether every type in the type set of p has the
+// operator op.
+func (p *TypeParam) allows(op token.Token) bool {
+	terms := p.typeSet().Terms
+	if terms == nil {
+		return false
+	}
+	for _, term := range terms {
+		t := term.Type
+		ok := false
+		switch op {
+		case token.ADD:
+			ok = IsNumeric(t) || IsString(t)
+		case token.SUB, token.MUL, token.QUO:
+			ok = IsNumeric(t)
+		case token.REM, token.AND, token.OR, token.XOR, token.AND_NOT, token.SHL, token.SHR:
+			ok = IsInteger(t)
+		case token.LSS, token.LEQ, token.GTR, token.GEQ:
+			ok = IsInteger(t) || IsFloat(t) || IsString(t)
+		case token.LAND, token.LOR:
+			ok = IsBoolean(t)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// operator checks that the operator op applies to a value of type t,
+// if t is a type parameter.
+func operator(op token.Token, t Type) {
+	if p, ok := t.(*TypeParam); ok && !p.allows(op) {
+		panic(fmt.Sprintf("invalid operation: operator %s not defined on %s (constrained by %s)",
+			op, p, p.constraintName()))
+	}
+}
+
+// An Error is a type error at a position in the program, which the
+// type checker panics with.
+type Error struct {
+	Pos token.Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func errorf(pos token.Pos, format string, args ...interface{}) {
+	panic(&Error{pos, fmt.Sprintf(format, args...)})
+}
+
+// partial tells whether the function fun is a generic function given
+// fewer type arguments than it has type parameters, which is allowed
+// only when it is called, and if so gives the function and the type
+// arguments.
+func (c *checker) partial(fun ast.Expr) (ast.Expr, *operand, []ast.Expr) {
+	var x ast.Expr
+	var list []ast.Expr
+	switch e := StripParens(fun).(type) {
+	case *ast.IndexExpr:
+		x, list = e.X, []ast.Expr{e.Index}
+	case *ast.IndexListExpr:
+		x, list = e.X, e.Indices
+	}
+	if _, ok := x.(*ast.Ident); !ok {
+		return nil, nil, nil
+	}
+	f := c.expr(x, nil)
+	if t, ok := f.typ.(*Function); ok && isGeneric(f) && len(list) < len(t.TypeParams) {
+		return StripParens(fun), f, list
+	}
+	return nil, nil, nil
+}
+
+// genericCall checks a call of the generic function named by e.Fun,
+// which may give some of its type arguments explicitly, inferring the
+// rest from the arguments of the call, much as gc does: first from
+// those with types, then from untyped constants, which take their
+// default types, and then from the core types of the constraints.
+func (c *checker) genericCall(e *ast.CallExpr, fun ast.Expr, x *operand, explicit []ast.Expr) *Function {
+	o := c.Objects[StripParens(x.expr).(*ast.Ident)]
+	sig := x.typ.(*Function)
+	params := sig.TypeParams
+	if len(explicit) > len(params) {
+		errorf(e.Pos(), "too many type arguments for func %s: have %d, want %d",
+			o.Name, len(explicit), len(params))
+	}
+	u := &unifier{bound: make(map[*TypeParam]Type), params: make(map[*TypeParam]bool)}
+	for _, p := range params {
+		u.params[p] = true
+	}
+	for i, a := range explicit {
+		u.bound[params[i]] = c.typExpr(a)
+	}
+	var xs []*operand
+	var ts []Type
+	if len(e.Args) == 1 && len(sig.Parameters) > 1 {
+		// A call of the form f(g()) where g has multiple results
+		x := c.expr(e.Args[0], nil)
+		xs = []*operand{x}
+		ts = x.typ.(*Tuple).Types
+	} else {
+		for _, a := range e.Args {
+			x := c.expr(a, nil)
+			xs = append(xs, x)
+			ts = append(ts, x.typ)
+		}
+	}
+	param := func(i int) Type {
+		if sig.Variadic && i >= len(sig.Parameters)-1 && !e.Ellipsis.IsValid() {
+			return elem(sig.Parameters[len(sig.Parameters)-1])
+		}
+		if i >= len(sig.Parameters) {
+			errorf(e.Pos(), "too many arguments in call to %s", o.Name)
+		}
+		return sig.Parameters[i]
+	}
+	for i, t := range ts {
+		if IsUntyped(t) {
+			continue
+		}
+		pt := param(i)
+		want := Subst(pt, u.bound)
+		if !u.unify(pt, t) {
+			a := e.Args[i%len(e.Args)]
+			if p, ok := pt.(*TypeParam); ok {
+				errorf(a.Pos(), "type %v of %s does not match inferred type %v for %v",
+					t, exprString(a), want, p)
+			}
+			errorf(a.Pos(), "type %v of %s does not match %v", t, exprString(a), want)
+		}
+	}
+	// An untyped constant gives a type parameter the default type of
+	// the largest kind of constant passed to it.
+	untyped := make(map[*TypeParam]*Basic)
+	for i, t := range ts {
+		p, ok := param(i).(*TypeParam)
+		if !ok || !IsUntyped(t) || t == Typ[UntypedNil] || u.bound[p] != nil {
+			continue
+		}
+		if b := untyped[p]; b == nil || t.(*Basic).Kind > b.Kind {
+			untyped[p] = t.(*Basic)
+		}
+	}
+	for p, b := range untyped {
+		u.bound[p] = Default(b)
+	}
+	for progress := true; progress; {
+		progress = false
+		for _, p := range params {
+			terms := p.typeSet().Terms
+			if len(terms) != 1 {
+				continue
+			}
+			core := terms[0]
+			if b := u.bound[p]; b != nil {
+				n := len(u.bound)
+				if core.Tilde {
+					b = Underlying(b)
+				}
+				u.unify(core.Type, b)
+				progress = progress || len(u.bound) > n
+			} else if t := Subst(core.Type, u.bound); !u.mentions(t) {
+				u.bound[p] = t
+				progress = true
+			}
+		}
+	}
+	args := make([]Type, len(params))
+	for i, p := range params {
+		if u.bound[p] == nil {
+			errorf(e.Pos(), "in call to %s, cannot infer %s", o.Name, p)
+		}
+		args[i] = u.bound[p]
+	}
+	c.satisfies(e.Pos(), params, args)
+	inst := Subst(sig, u.bound).(*Function)
+	c.Instances[fun] = &Instance{Obj: o, TypeArgs: args}
+	c.Types[fun] = inst
+	if len(xs) == 1 && len(ts) > 1 {
+		return inst
+	}
+	// Now that the parameters have their type arguments, the untyped
+	// constants among the arguments can settle.
+	sig = inst
+	for i, x := range xs {
+		c.assign(x, param(i))
+	}
+	return inst
+}
+
+func exprString(e ast.Expr) string {
+	var b bytes.Buffer
+	printer.Fprint(&b, token.NewFileSet(), e)
+	return b.String()
+}
+
+// A unifier works out the type arguments of the type parameters
+// params, which it binds as it matches them up with other types.
+type unifier struct {
+	params map[*TypeParam]bool
+	bound  map[*TypeParam]Type
+}
+
+// unify tells whether x, whose type parameters we are inferring, can
+// be the type y, binding those that aren't yet bound.  Where x isn't
+// a named type, y may be a named type with the same structure.
+func (u *unifier) unify(x, y Type) bool {
+	if p, ok := x.(*TypeParam); ok && u.params[p] {
+		if b := u.bound[p]; b != nil {
+			return Identical(b, y)
+		}
+		u.bound[p] = y
+		return true
+	}
+	if _, named := x.(*Named); !named {
+		y = Underlying(y)
+	}
+	switch x := x.(type) {
+	case *Named:
+		if y, ok := y.(*Named); ok && x.Orig != nil && x.Orig == y.Orig {
+			return u.unifyLists(x.TypeArgs, y.TypeArgs)
+		}
+	case *Pointer:
+		if y, ok := y.(*Pointer); ok {
+			return u.unify(x.Elem, y.Elem)
+		}
+		return false
+	case *Slice:
+		if y, ok := y.(*Slice); ok {
+			return u.unify(x.Elem, y.Elem)
+		}
+		return false
+	case *Array:
+		if y, ok := y.(*Array); ok && x.Len == y.Len {
+			return u.unify(x.Elem, y.Elem)
+		}
+		return false
+	case *Chan:
+		if y, ok := y.(*Chan); ok && (x.Dir == y.Dir || y.Dir == ast.SEND|ast.RECV) {
+			return u.unify(x.Elem, y.Elem)
+		}
+		return false
+	case *Map:
+		if y, ok := y.(*Map); ok {
+			return u.unify(x.Key, y.Key) && u.unify(x.Elem, y.Elem)
+		}
+		return false
+	case *Function:
+		if y, ok := y.(*Function); ok && x.Variadic == y.Variadic {
+			return u.unifyLists(x.Parameters, y.Parameters) && u.unifyLists(x.Results, y.Results)
+		}
+		return false
+	case *Struct:
+		if y, ok := y.(*Struct); ok && len(x.Fields) == len(y.Fields) {
+			for i, f := range x.Fields {
+				g := y.Fields[i]
+				if f.Name != g.Name || f.Embedded != g.Embedded || !u.unify(f.Type, g.Type) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	}
+	return Identical(Subst(x, u.bound), y)
+}
+
+func (u *unifier) unifyLists(xs, ys []Type) bool {
+	if len(xs) != len(ys) {
+		return false
+	}
+	for i := range xs {
+		if !u.unify(xs[i], ys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// mentions tells whether t refers to any of the type parameters that
+// we are inferring.
+func (u *unifier) mentions(t Type) bool {
+	switch t := t.(type) {
+	case *TypeParam:
+		return u.params[t]
+	case *Named:
+		return u.mentionsAny(t.TypeArgs)
+	case *Pointer:
+		return u.mentions(t.Elem)
+	case *Slice:
+		return u.mentions(t.Elem)
+	case *Array:
+		return u.mentions(t.Elem)
+	case *Chan:
+		return u.mentions(t.Elem)
+	case *Map:
+		return u.mentions(t.Key) || u.mentions(t.Elem)
+	case *Function:
+		return u.mentionsAny(t.Parameters) || u.mentionsAny(t.Results)
+	case *Struct:
+		for _, f := range t.Fields {
+			if u.mentions(f.Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (u *unifier) mentionsAny(ts []Type) bool {
+	for _, t := range ts {
+		if u.mentions(t) {
+			return true
+		}
+	}
+	return false
+}
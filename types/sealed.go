@@ -0,0 +1,112 @@
+package types
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// A sealed interface, one that embeds the predeclared sealed, holds
+// nothing but the types of its union, its variants, so that
+//
+//	type Shape interface {
+//		sealed
+//		Circle | Square
+//		Area() float64
+//	}
+//
+// is a Circle, a Square or nil, and a type switch on a Shape must
+// handle both Circle and Square, unless it has a default.
+
+// IsSealed tells whether t is a sealed interface type.
+func IsSealed(t Type) bool {
+	i, ok := Underlying(t).(*Interface)
+	return ok && i.Sealed
+}
+
+// Variants gives the variants of the sealed interface type t, in the
+// order they are declared, or nil if t isn't sealed.
+func Variants(t Type) []Type {
+	if !IsSealed(t) {
+		return nil
+	}
+	var vs []Type
+	for _, term := range Underlying(t).(*Interface).Terms {
+		vs = append(vs, term.Type)
+	}
+	return vs
+}
+
+// Variant gives the index of the variant v of the sealed interface
+// type t, or -1 if v isn't one of them.
+func Variant(t, v Type) int {
+	for i, x := range Variants(t) {
+		if Identical(x, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sealedType checks the variants of the sealed interface t, which
+// must be distinct types that aren't interfaces.  Whether each has
+// the methods of t can only be checked once all methods are declared.
+func (c *checker) sealedType(e *ast.InterfaceType, t *Interface) {
+	if len(t.Terms) == 0 {
+		errorf(e.Pos(), "sealed interface %v has no variants", t)
+	}
+	for i, term := range t.Terms {
+		if term.Tilde {
+			errorf(e.Pos(), "invalid variant %v of sealed interface (a variant is exactly one type)", term)
+		}
+		if IsInterface(term.Type) {
+			errorf(e.Pos(), "invalid variant %v of sealed interface (%v is an interface)", term, term.Type)
+		}
+		for _, prev := range t.Terms[:i] {
+			if Identical(prev.Type, term.Type) {
+				errorf(e.Pos(), "duplicate variant %v of sealed interface", term)
+			}
+		}
+	}
+	c.sealed = append(c.sealed, sealedDecl{e.Pos(), t})
+}
+
+type sealedDecl struct {
+	pos token.Pos
+	t   *Interface
+}
+
+// checkVariants checks that each variant of each sealed interface
+// has its methods.
+func (c *checker) checkVariants() {
+	for _, s := range c.sealed {
+		for _, term := range s.t.Terms {
+			if m := MissingMethod(term.Type, s.t); m != nil {
+				errorf(s.pos, "%v is a variant of %v but does not implement it (missing method %s)",
+					term.Type, s.t, m.Name)
+			}
+		}
+	}
+}
+
+// exhaustive checks that a type switch on x, a value of a sealed
+// interface type, handles each of its variants, given the types of
+// its cases.  A case of an interface type handles each variant that
+// implements it.
+func (c *checker) exhaustive(s *ast.TypeSwitchStmt, x *operand, cases []Type) {
+	var missing []string
+	for _, v := range Variants(x.typ) {
+		handled := false
+		for _, t := range cases {
+			if Identical(t, v) || IsInterface(t) && MissingMethod(v, Underlying(t).(*Interface)) == nil {
+				handled = true
+			}
+		}
+		if !handled {
+			missing = append(missing, v.String())
+		}
+	}
+	if missing != nil {
+		errorf(s.Pos(), "missing cases in type switch on %v: %s", x.typ, strings.Join(missing, ", "))
+	}
+}
@@ -3,7 +3,10 @@ package types
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
+	"sort"
+	"strings"
 )
 
 const (
@@ -21,49 +24,450 @@ func AlignSize(sz, al int) int {
 type Type interface {
 	Size() int
 	Expr() ast.Expr
+	String() string
 }
 
+// TypeType is the builtin Type, whose values are types.  In C a Type
+// is a pointer to a descriptor of the type.
 type TypeType struct {
 }
 
 func (t TypeType) Size() int {
 	return PointerSize
 }
+func (t TypeType) Expr() ast.Expr {
+	return ast.NewIdent("Type")
+}
+func (t TypeType) String() string {
+	return "Type"
+}
+
+// TypeMethods are the methods of the builtin Type, which describe the
+// type that is its value.
+var TypeMethods []*Object
+
+func init() {
+	sigs := []struct {
+		name   string
+		params []Type
+		result Type
+	}{
+		{"Align", nil, Typ[Int]},
+		{"Elem", nil, TypeType{}},
+		{"FieldName", []Type{Typ[Int]}, Typ[String]},
+		{"FieldType", []Type{Typ[Int]}, TypeType{}},
+		{"Implements", []Type{TypeType{}}, Typ[Bool]},
+		{"Kind", nil, Typ[String]},
+		{"Len", nil, Typ[Int]},
+		{"Method", []Type{Typ[Int]}, Typ[String]},
+		{"Name", nil, Typ[String]},
+		{"NumField", nil, Typ[Int]},
+		{"NumMethod", nil, Typ[Int]},
+		{"Size", nil, Typ[Int]},
+	}
+	for _, sig := range sigs {
+		TypeMethods = append(TypeMethods, &Object{Kind: Func, Name: sig.name,
+			Type: &Function{Parameters: sig.params, Results: []Type{sig.result}},
+			Recv: TypeType{}, state: resolved})
+	}
+}
+
+type BasicKind int
+
+const (
+	Invalid BasicKind = iota
+	Bool
+	Int
+	Int8
+	Int16
+	Int32
+	Int64
+	Uint
+	Uint8
+	Uint16
+	Uint32
+	Uint64
+	Uintptr
+	Float32
+	Float64
+	Complex64
+	Complex128
+	String
+	UnsafePointer
+
+	UntypedBool
+	UntypedInt
+	UntypedRune
+	UntypedFloat
+	UntypedComplex
+	UntypedString
+	UntypedNil
+)
+
+// Basic is one of the predeclared types, or the type of an untyped
+// constant (or nil).
+type Basic struct {
+	Kind BasicKind
+	Name string
+}
+
+var Typ = [...]*Basic{
+	Invalid:        {Invalid, "invalid type"},
+	Bool:           {Bool, "bool"},
+	Int:            {Int, "int"},
+	Int8:           {Int8, "int8"},
+	Int16:          {Int16, "int16"},
+	Int32:          {Int32, "int32"},
+	Int64:          {Int64, "int64"},
+	Uint:           {Uint, "uint"},
+	Uint8:          {Uint8, "uint8"},
+	Uint16:         {Uint16, "uint16"},
+	Uint32:         {Uint32, "uint32"},
+	Uint64:         {Uint64, "uint64"},
+	Uintptr:        {Uintptr, "uintptr"},
+	Float32:        {Float32, "float32"},
+	Float64:        {Float64, "float64"},
+	Complex64:      {Complex64, "complex64"},
+	Complex128:     {Complex128, "complex128"},
+	String:         {String, "string"},
+	UnsafePointer:  {UnsafePointer, "unsafe.Pointer"},
+	UntypedBool:    {UntypedBool, "untyped bool"},
+	UntypedInt:     {UntypedInt, "untyped int"},
+	UntypedRune:    {UntypedRune, "untyped rune"},
+	UntypedFloat:   {UntypedFloat, "untyped float"},
+	UntypedComplex: {UntypedComplex, "untyped complex"},
+	UntypedString:  {UntypedString, "untyped string"},
+	UntypedNil:     {UntypedNil, "untyped nil"},
+}
+
+func (t *Basic) Size() int {
+	switch t.Kind {
+	case Bool, Int8, Uint8:
+		return 1
+	case Int16, Uint16:
+		return 2
+	case Int32, Uint32, Float32:
+		return 4
+	case Int64, Uint64, Float64, Complex64:
+		return 8
+	case Complex128:
+		return 16
+	case Int, Uint:
+		return IntSize
+	case Uintptr, UnsafePointer:
+		return PointerSize
+	case String:
+		return AlignSize(IntSize+PointerSize, PointerSize)
+	}
+	panic("Untyped constants have no size: " + t.Name)
+}
+func (t *Basic) Expr() ast.Expr {
+	if t.Kind == UnsafePointer {
+		return &ast.SelectorExpr{X: ast.NewIdent("unsafe"), Sel: ast.NewIdent("Pointer")}
+	}
+	return ast.NewIdent(t.Name)
+}
+func (t *Basic) String() string {
+	return t.Name
+}
+
+// Named is a type declared with a type declaration.  Named types are
+// identical only to themselves, so they are always handled by
+// pointer.  A generic type has TypeParams, and an instance of it
+// with type arguments TypeArgs has it as its Orig.
+type Named struct {
+	Name       string
+	Underlying Type
+	Methods    []*Object
+	TypeParams []*TypeParam
+	Orig       *Named
+	TypeArgs   []Type
+
+	instances []*Named
+}
+
+func (t *Named) Size() int {
+	return t.Underlying.Size()
+}
+func (t *Named) Expr() ast.Expr {
+	if t.Orig == nil {
+		return ast.NewIdent(t.Name)
+	}
+	args := make([]ast.Expr, len(t.TypeArgs))
+	for i, a := range t.TypeArgs {
+		args[i] = a.Expr()
+	}
+	if len(args) == 1 {
+		return &ast.IndexExpr{X: ast.NewIdent(t.Name), Index: args[0]}
+	}
+	return &ast.IndexListExpr{X: ast.NewIdent(t.Name), Indices: args}
+}
+func (t *Named) String() string {
+	if t.Orig == nil {
+		return t.Name
+	}
+	args := make([]string, len(t.TypeArgs))
+	for i, a := range t.TypeArgs {
+		args[i] = a.String()
+	}
+	return t.Name + "[" + strings.Join(args, ",") + "]"
+}
+
+type Pointer struct {
+	Elem Type
+}
+
+func (t *Pointer) Size() int {
+	return PointerSize
+}
+func (t *Pointer) Expr() ast.Expr {
+	return &ast.StarExpr{X: t.Elem.Expr()}
+}
+func (t *Pointer) String() string {
+	return "*" + t.Elem.String()
+}
+
+// Slice is the type of a slice, which is represented in C as a
+// pointer to its elements along with its length and capacity.
+type Slice struct {
+	Elem Type
+}
+
+func (t *Slice) Size() int {
+	return AlignSize(PointerSize+2*IntSize, PointerSize)
+}
+func (t *Slice) Expr() ast.Expr {
+	return &ast.ArrayType{Elt: t.Elem.Expr()}
+}
+func (t *Slice) String() string {
+	return "[]" + t.Elem.String()
+}
+
+// Chan is the type of a channel, which may only send or receive if Dir
+// says so.  A channel is a pointer to the runtime's channel.
+type Chan struct {
+	Dir  ast.ChanDir
+	Elem Type
+}
+
+func (t *Chan) Size() int {
+	return PointerSize
+}
+func (t *Chan) Expr() ast.Expr {
+	return &ast.ChanType{Dir: t.Dir, Value: t.Elem.Expr()}
+}
+func (t *Chan) String() string {
+	switch t.Dir {
+	case ast.SEND:
+		return "chan<- " + t.Elem.String()
+	case ast.RECV:
+		return "<-chan " + t.Elem.String()
+	}
+	return "chan " + t.Elem.String()
+}
+
+// Map is the type of a map, which is a pointer to the runtime's hash
+// table.
+type Map struct {
+	Key, Elem Type
+}
+
+func (t *Map) Size() int {
+	return PointerSize
+}
+func (t *Map) Expr() ast.Expr {
+	return &ast.MapType{Key: t.Key.Expr(), Value: t.Elem.Expr()}
+}
+func (t *Map) String() string {
+	return "map[" + t.Key.String() + "]" + t.Elem.String()
+}
+
+// Array is the type of a fixed-size array, which is a value: it is
+// copied whole on assignment, just like a struct.
+type Array struct {
+	Len  int64
+	Elem Type
+}
+
+func (t *Array) Size() int {
+	return int(t.Len) * t.Elem.Size()
+}
+func (t *Array) Expr() ast.Expr {
+	return &ast.ArrayType{
+		Len: &ast.BasicLit{Kind: token.INT, Value: fmt.Sprint(t.Len)},
+		Elt: t.Elem.Expr()}
+}
+func (t *Array) String() string {
+	return fmt.Sprint("[", t.Len, "]", t.Elem)
+}
+
+type Field struct {
+	Name     string
+	Type     Type
+	Embedded bool
+}
+
+type Struct struct {
+	Fields []Field
+}
+
+func (t *Struct) Size() int {
+	sz := 0
+	for _, f := range t.Fields {
+		sz += AlignSize(f.Type.Size(), PointerSize)
+	}
+	return sz
+}
+func (t *Struct) Expr() ast.Expr {
+	fs := make([]*ast.Field, len(t.Fields))
+	for i, f := range t.Fields {
+		fs[i] = &ast.Field{Type: f.Type.Expr()}
+		if !f.Embedded {
+			fs[i].Names = []*ast.Ident{ast.NewIdent(f.Name)}
+		}
+	}
+	return &ast.StructType{Fields: &ast.FieldList{List: fs}}
+}
+func (t *Struct) String() string {
+	fs := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		if f.Embedded {
+			fs[i] = f.Type.String()
+		} else {
+			fs[i] = f.Name + " " + f.Type.String()
+		}
+	}
+	return "struct{" + strings.Join(fs, "; ") + "}"
+}
 
-type Int struct {
+// Interface holds the full (flattened) method set of an interface
+// type, sorted by name.  An interface that is only a constraint may
+// also limit its types to the union of Terms, or to those that are
+// Comparable.
+type Interface struct {
+	Methods    []*Object
+	Terms      []*Term
+	Comparable bool
+	// A sealed interface holds only the types of its Terms (see
+	// sealed.go).
+	Sealed bool
 }
 
-func (t Int) Size() int {
-	return IntSize
+func (t *Interface) Size() int {
+	if t.Sealed {
+		// A tag, and then the largest of the variants.
+		size := 0
+		for _, term := range t.Terms {
+			if s := term.Type.Size(); s > size {
+				size = s
+			}
+		}
+		return PointerSize + (size+PointerSize-1)/PointerSize*PointerSize
+	}
+	return 2 * PointerSize
+}
+func (t *Interface) Expr() ast.Expr {
+	if len(t.Methods) == 0 && t.Terms == nil && !t.Comparable && !t.Sealed {
+		return ast.NewIdent("any")
+	}
+	ms := make([]*ast.Field, len(t.Methods))
+	for i, m := range t.Methods {
+		ms[i] = &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(m.Name)},
+			Type:  m.Type.Expr()}
+	}
+	if t.Comparable {
+		ms = append(ms, &ast.Field{Type: ast.NewIdent("comparable")})
+	}
+	if t.Sealed {
+		ms = append(ms, &ast.Field{Type: ast.NewIdent("sealed")})
+	}
+	if len(t.Terms) > 0 {
+		var union ast.Expr
+		for _, term := range t.Terms {
+			x := term.Type.Expr()
+			if term.Tilde {
+				x = &ast.UnaryExpr{Op: token.TILDE, X: x}
+			}
+			if union == nil {
+				union = x
+			} else {
+				union = &ast.BinaryExpr{X: union, Op: token.OR, Y: x}
+			}
+		}
+		ms = append(ms, &ast.Field{Type: union})
+	}
+	return &ast.InterfaceType{Methods: &ast.FieldList{List: ms}}
 }
-func (t Int) Expr() ast.Expr {
-	return ast.NewIdent("int")
+func (t *Interface) String() string {
+	ms := make([]string, len(t.Methods))
+	for i, m := range t.Methods {
+		ms[i] = m.Name + strings.TrimPrefix(m.Type.String(), "func")
+	}
+	if t.Comparable {
+		ms = append(ms, "comparable")
+	}
+	if t.Sealed {
+		ms = append(ms, "sealed")
+	}
+	if len(t.Terms) > 0 {
+		ms = append(ms, termsString(t.Terms))
+	}
+	return "interface{" + strings.Join(ms, "; ") + "}"
 }
 
-type String struct {
+// Tuple is the type of a call to a function with other than one
+// result.
+type Tuple struct {
+	Types []Type
 }
 
-func (t String) Size() int {
-	return AlignSize(IntSize+PointerSize, PointerSize)
+func (t *Tuple) Size() int {
+	sz := 0
+	for _, x := range t.Types {
+		sz += AlignSize(x.Size(), PointerSize)
+	}
+	return sz
 }
-func (t String) Expr() ast.Expr {
-	return ast.NewIdent("string")
+func (t *Tuple) Expr() ast.Expr {
+	panic("A tuple has no go syntax")
 }
-func (t String) String() string {
-	return "string"
+func (t *Tuple) String() string {
+	ts := make([]string, len(t.Types))
+	for i, x := range t.Types {
+		ts[i] = x.String()
+	}
+	return "(" + strings.Join(ts, ", ") + ")"
 }
 
+// Function is the type of a function, which is generic if it has
+// TypeParams.
 type Function struct {
 	Parameters, Results []Type
+	Variadic            bool
+	TypeParams          []*TypeParam
 }
 
 func (t Function) Size() int {
-	// I plan to pass a bunch of arguments along with a pointer when
-	// creating a closure.
-	return AlignSize(IntSize+PointerSize+PointerSize, PointerSize)
+	// A func value points to its closure.
+	return PointerSize
 }
 func (t Function) String() string {
-	return fmt.Sprint("func(", t.Parameters, ") ", t.Results)
+	ps := make([]string, len(t.Parameters))
+	for i, p := range t.Parameters {
+		ps[i] = p.String()
+		if t.Variadic && i == len(ps)-1 {
+			ps[i] = "..." + strings.TrimPrefix(ps[i], "[]")
+		}
+	}
+	s := "func(" + strings.Join(ps, ", ") + ")"
+	switch len(t.Results) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + t.Results[0].String()
+	}
+	return s + " " + (&Tuple{t.Results}).String()
 }
 func (t Function) Expr() ast.Expr {
 	p := make([]*ast.Field, len(t.Parameters))
@@ -73,6 +477,9 @@ func (t Function) Expr() ast.Expr {
 		p[i] = &ast.Field{
 			Names: []*ast.Ident{ast.NewIdent(fmt.Sprint("param", n))},
 			Type:  t.Parameters[i].Expr()}
+		if t.Variadic && i == len(p)-1 {
+			p[i].Type = &ast.Ellipsis{Elt: p[i].Type.(*ast.ArrayType).Elt}
+		}
 		n++
 	}
 	n = 0
@@ -87,6 +494,14 @@ func (t Function) Expr() ast.Expr {
 		Results: &ast.FieldList{List: r}}
 }
 
+// ResultType is the type of a call to a function of type t.
+func (t Function) ResultType() Type {
+	if len(t.Results) == 1 {
+		return t.Results[0]
+	}
+	return &Tuple{t.Results}
+}
+
 type Method struct {
 	Receiver            Type
 	Parameters, Results []Type
@@ -96,106 +511,387 @@ func (t Method) Size() int {
 	return PointerSize
 }
 
-// Here we go...
+// Underlying returns the type that a named type was declared with.
+// The operations on a value of a type parameter are those of its core
+// type, if it has one, which we therefore treat as its underlying
+// type.
+func Underlying(t Type) Type {
+	switch t := t.(type) {
+	case *Named:
+		return t.Underlying
+	case *TypeParam:
+		if u := t.core(); u != nil {
+			return u
+		}
+	}
+	return t
+}
+
+func IsUntyped(t Type) bool {
+	b, ok := t.(*Basic)
+	return ok && b.Kind >= UntypedBool
+}
 
-type Scope struct {
-	types map[string]Type
-	outer *Scope
+func isBasic(t Type, kinds ...BasicKind) bool {
+	if b, ok := Underlying(t).(*Basic); ok {
+		for _, k := range kinds {
+			if b.Kind == k {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func TypeCheck(bigfile *ast.File) map[string]Type {
-	ts := make(map[string]Type)
-	typeCheck(ts, bigfile.Decls)
-	return ts
+func IsInteger(t Type) bool {
+	return isBasic(t, Int, Int8, Int16, Int32, Int64,
+		Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, UntypedInt, UntypedRune)
+}
+func IsUnsigned(t Type) bool {
+	return isBasic(t, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr)
+}
+func IsFloat(t Type) bool {
+	return isBasic(t, Float32, Float64, UntypedFloat)
+}
+func IsComplex(t Type) bool {
+	return isBasic(t, Complex64, Complex128, UntypedComplex)
+}
+func IsNumeric(t Type) bool {
+	return IsInteger(t) || IsFloat(t) || IsComplex(t)
+}
+func IsString(t Type) bool {
+	return isBasic(t, String, UntypedString)
+}
+func IsBoolean(t Type) bool {
+	return isBasic(t, Bool, UntypedBool)
+}
+func IsSlice(t Type) bool {
+	_, ok := Underlying(t).(*Slice)
+	return ok
+}
+func IsChan(t Type) bool {
+	_, ok := Underlying(t).(*Chan)
+	return ok
+}
+func IsMap(t Type) bool {
+	_, ok := Underlying(t).(*Map)
+	return ok
+}
+func IsArray(t Type) bool {
+	_, ok := Underlying(t).(*Array)
+	return ok
 }
 
-func typeCheck(t map[string]Type, ds []ast.Decl) {
-	// First check types of all global variables and functions
-	for _, d := range ds {
-		typeDecl(t, d, ds)
+// Comparable tells whether values of type t may be compared with ==.
+func Comparable(t Type) bool {
+	switch t := Underlying(t).(type) {
+	case *Slice, *Map, *Function:
+		return false
+	case *Array:
+		return Comparable(t.Elem)
+	case *Struct:
+		for _, f := range t.Fields {
+			if !Comparable(f.Type) {
+				return false
+			}
+		}
+	case *Interface:
+		if t.Sealed {
+			for _, term := range t.Terms {
+				if !Comparable(term.Type) {
+					return false
+				}
+			}
+		}
+	case *TypeParam:
+		set := t.typeSet()
+		if set.Comparable {
+			return true
+		}
+		for _, term := range set.Terms {
+			if !Comparable(term.Type) {
+				return false
+			}
+		}
+		return set.Terms != nil
 	}
-	// Finally, go into functions and check types inside
-	//global := Scope{t, nil}
+	return true
 }
 
-func typeDecl(t map[string]Type, d ast.Decl, ds []ast.Decl) {
-	switch d := d.(type) {
-	case *ast.FuncDecl:
-		if _, ok := t[d.Name.Name]; ok {
-			// No problem here, we've already done this!
-		} else {
-			if d.Recv == nil {
-				args := []Type{}
-				results := []Type{}
-				for _, a := range d.Type.Params.List {
-					for i := 0; i < len(a.Names); i++ {
-						args = append(args, evalTypeExpr(a.Type, t, ds))
-					}
+// HasPointers tells whether a value of type t may hold pointers, which
+// the garbage collector must then look through.
+func HasPointers(t Type) bool {
+	switch t := Underlying(t).(type) {
+	case *Basic:
+		return t.Kind == String || t.Kind == UnsafePointer
+	case *Array:
+		return HasPointers(t.Elem)
+	case *Struct:
+		for _, f := range t.Fields {
+			if HasPointers(f.Type) {
+				return true
+			}
+		}
+		return false
+	case *Interface:
+		if t.Sealed {
+			for _, term := range t.Terms {
+				if HasPointers(term.Type) {
+					return true
 				}
-				if d.Type.Results != nil {
-					for _, r := range d.Type.Results.List {
-						for i := 0; i < len(r.Names); i++ {
-							results = append(results, evalTypeExpr(r.Type, t, ds))
-						}
-					}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func IsInterface(t Type) bool {
+	_, ok := Underlying(t).(*Interface)
+	return ok
+}
+
+// MethodSet gives the methods that may be called on a value of type
+// t, sorted by name.  The method set of a named type holds only the
+// methods with value receivers, while that of a pointer to it holds
+// them all.  Either holds the methods promoted from its embedded
+// fields, those with pointer receivers only if there is a pointer to
+// go through.
+func MethodSet(t Type) []*Object {
+	if p, ok := t.(*TypeParam); ok {
+		return p.typeSet().Methods
+	}
+	if i, ok := Underlying(t).(*Interface); ok {
+		return i.Methods
+	}
+	ptr := false
+	if p, ok := t.(*Pointer); ok {
+		t, ptr = p.Elem, true
+	}
+	n, ok := t.(*Named)
+	if !ok {
+		return nil
+	}
+	var ms []*Object
+	own := make(map[string]bool)
+	for _, m := range n.Methods {
+		own[m.Name] = true
+		if _, isptr := m.Recv.(*Pointer); ptr || !isptr {
+			ms = append(ms, m)
+		}
+	}
+	promoted := false
+	for _, name := range embeddedMethods(n, make(map[Type]bool)) {
+		if own[name] {
+			continue
+		}
+		own[name] = true
+		s := find(n, name)
+		if s.obj == nil || s.ambiguous {
+			continue
+		}
+		if _, isptr := s.obj.Recv.(*Pointer); ptr || !isptr || throughPointer(s.path) {
+			ms = append(ms, s.obj)
+			promoted = true
+		}
+	}
+	if promoted {
+		sort.Sort(byName(ms))
+	}
+	return ms
+}
+
+// embeddedMethods gives the names of the methods of the fields that
+// are embedded in t, or in them in turn.
+func embeddedMethods(t Type, seen map[Type]bool) []string {
+	st, ok := Underlying(t).(*Struct)
+	if !ok || seen[t] {
+		return nil
+	}
+	seen[t] = true
+	var names []string
+	for _, f := range st.Fields {
+		if !f.Embedded {
+			continue
+		}
+		ft := f.Type
+		if p, ok := ft.(*Pointer); ok {
+			ft = p.Elem
+		}
+		if n, ok := ft.(*Named); ok {
+			for _, m := range n.Methods {
+				names = append(names, m.Name)
+			}
+		}
+		if i, ok := Underlying(ft).(*Interface); ok {
+			for _, m := range i.Methods {
+				names = append(names, m.Name)
+			}
+		}
+		names = append(names, embeddedMethods(ft, seen)...)
+	}
+	return names
+}
+
+// MissingMethod gives a method of the interface i which a value of
+// type t lacks, or nil if t implements i.
+func MissingMethod(t Type, i *Interface) *Object {
+	ms := MethodSet(t)
+	for _, m := range i.Methods {
+		found := false
+		for _, tm := range ms {
+			if tm.Name == m.Name && Identical(tm.Type, m.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return m
+		}
+	}
+	return nil
+}
+
+// Default gives the type an untyped constant takes when there is
+// nothing in its context to tell it otherwise.
+func Default(t Type) Type {
+	if b, ok := t.(*Basic); ok {
+		switch b.Kind {
+		case UntypedBool:
+			return Typ[Bool]
+		case UntypedInt:
+			return Typ[Int]
+		case UntypedRune:
+			return Typ[Int32]
+		case UntypedFloat:
+			return Typ[Float64]
+		case UntypedComplex:
+			return Typ[Complex128]
+		case UntypedString:
+			return Typ[String]
+		}
+	}
+	return t
+}
+
+func Identical(a, b Type) bool {
+	if a == b {
+		return true
+	}
+	switch a := a.(type) {
+	case *Basic:
+		if b, ok := b.(*Basic); ok {
+			return a.Kind == b.Kind
+		}
+	case *Pointer:
+		if b, ok := b.(*Pointer); ok {
+			return Identical(a.Elem, b.Elem)
+		}
+	case *Slice:
+		if b, ok := b.(*Slice); ok {
+			return Identical(a.Elem, b.Elem)
+		}
+	case *Array:
+		if b, ok := b.(*Array); ok {
+			return a.Len == b.Len && Identical(a.Elem, b.Elem)
+		}
+	case *Chan:
+		if b, ok := b.(*Chan); ok {
+			return a.Dir == b.Dir && Identical(a.Elem, b.Elem)
+		}
+	case *Map:
+		if b, ok := b.(*Map); ok {
+			return Identical(a.Key, b.Key) && Identical(a.Elem, b.Elem)
+		}
+	case *Struct:
+		if b, ok := b.(*Struct); ok && len(a.Fields) == len(b.Fields) {
+			for i := range a.Fields {
+				fa, fb := a.Fields[i], b.Fields[i]
+				if fa.Name != fb.Name || fa.Embedded != fb.Embedded ||
+					!Identical(fa.Type, fb.Type) {
+					return false
 				}
-				t[d.Name.Name] = Function{args, results}
-				fmt.Println("Type of", d.Name.Name, "is", t[d.Name.Name])
-			} else {
-				panic("I don't yet handle methods!")
 			}
+			return true
 		}
-	case *ast.GenDecl:
-		switch d.Tok {
-		case token.IMPORT:
-			// Nothing to do!
-		case token.CONST:
-			// TODO
-		case token.TYPE:
-			// TODO
-		case token.VAR:
-			for _, s := range d.Specs {
-				s := s.(*ast.ValueSpec)
-				var thist Type
-				if s.Type != nil {
-					thist = evalTypeExpr(s.Type, t, ds)
-				} else {
-					thist = findTypeOf(s.Values[0], t, ds)
-					s.Type = thist.Expr()
+	case *Interface:
+		if b, ok := b.(*Interface); ok && len(a.Methods) == len(b.Methods) && a.Sealed == b.Sealed {
+			if a.Sealed {
+				// The variants are in order, since a variant's tag
+				// is its place among them.
+				if len(a.Terms) != len(b.Terms) {
+					return false
+				}
+				for i := range a.Terms {
+					if !Identical(a.Terms[i].Type, b.Terms[i].Type) {
+						return false
+					}
 				}
-				for _, n := range s.Names {
-					t[n.Name] = thist
+			}
+			for i := range a.Methods {
+				if a.Methods[i].Name != b.Methods[i].Name ||
+					!Identical(a.Methods[i].Type, b.Methods[i].Type) {
+					return false
 				}
 			}
-		default:
-			panic("Invalid token in GenDecl.Tok!")
+			return true
+		}
+	case *Function:
+		if b, ok := b.(*Function); ok {
+			return a.Variadic == b.Variadic &&
+				identicalLists(a.Parameters, b.Parameters) &&
+				identicalLists(a.Results, b.Results)
+		}
+	case *Tuple:
+		if b, ok := b.(*Tuple); ok {
+			return identicalLists(a.Types, b.Types)
 		}
-	default:
-		panic(fmt.Sprintf("Unhandled case %T in typeDecl!", d))
 	}
+	return false
 }
 
-func evalTypeExpr(t ast.Expr, ts map[string]Type, ds []ast.Decl) Type {
-	switch t.(type) {
-	default:
-		panic(fmt.Sprintf("Unknown type in evalTypeExpr: %T", t))
+func identicalLists(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Identical(a[i], b[i]) {
+			return false
+		}
 	}
-	return Int{}
+	return true
 }
 
-func findTypeOf(t ast.Expr, ts map[string]Type, ds []ast.Decl) Type {
-	switch t := t.(type) {
-	case *ast.BasicLit:
-		switch t.Kind {
-		case token.STRING:
-			return String{}
-		case token.INT:
-			return Int{}
-		default:
-			panic(fmt.Sprint("BasicLit not understood yet: ", t.Kind, " which is ", t.Value))
-		}
-	default:
-		panic(fmt.Sprintf("findTypeOf not implemented for %T", t))
-	}
-	panic("findTypeOf not implemented")
+// ObjKind tells what sort of thing an identifier refers to.
+type ObjKind int
+
+const (
+	Bad ObjKind = iota
+	Var
+	Const
+	TypeName
+	Func
+	Builtin
+	Nil
+)
+
+// An Object is anything that can be named by an identifier.
+type Object struct {
+	Kind  ObjKind
+	Name  string
+	Type  Type
+	Value constant.Value // for Const objects
+	Decl  ast.Node       // where the object was declared, if anywhere
+	Pos   token.Pos
+
+	// Global is true for package-level objects.
+	Global bool
+	// Recv is the receiver type of a method.
+	Recv Type
+
+	state int // for lazily checking global declarations
+}
+
+func (o *Object) String() string {
+	return fmt.Sprint(o.Name, " ", o.Type)
 }
@@ -0,0 +1,34 @@
+// Package sum gives sealed interfaces a syntax of their own: with
+// ogo -x=sum,
+//
+//	type Shape sealed interface {
+//		Circle | Square
+//	}
+//
+// means the same as
+//
+//	type Shape interface {
+//		sealed
+//		Circle | Square
+//	}
+//
+// which is a sum type, held in C as a tagged union.
+package sum
+
+import (
+	"github.com/droundy/ogo/transform"
+	"regexp"
+)
+
+// The sealed goes on the same line as the brace, so that positions in
+// the rest of the file don't move.
+var sealed = regexp.MustCompile(`\bsealed\s+interface\s*\{`)
+
+func init() {
+	transform.Register(&transform.Extension{
+		Name: "sum",
+		Preprocess: func(filename string, src []byte) []byte {
+			return sealed.ReplaceAll(src, []byte("interface { sealed;"))
+		},
+	})
+}
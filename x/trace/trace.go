@@ -0,0 +1,52 @@
+// Package trace is an example of an extension with a g2g pass of its
+// own: with ogo -x=trace, each function prints its name as it is
+// called.
+package trace
+
+import (
+	"github.com/droundy/ogo/transform"
+	"github.com/droundy/ogo/types"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+func init() {
+	transform.Register(&transform.Extension{
+		Name: "trace",
+		Passes: []*transform.Pass{{
+			Name: "Trace",
+			Run:  Trace,
+			// Each instance of a generic function should say which
+			// it is, and each call should be traced, even of a
+			// function that could otherwise be inlined.
+			After:  []string{"Monomorphize"},
+			Before: []string{"Inline"},
+		}},
+	})
+}
+
+// Trace makes each function declared in f print its name as it is
+// called, other than main itself, which only calls main_main after
+// running the init functions.
+func Trace(f *ast.File, info *types.Info) {
+	for _, d := range f.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || fn.Name.Name == "main" {
+			continue
+		}
+		name := fn.Name.Name
+		if fn.Recv != nil {
+			t := fn.Recv.List[0].Type
+			if s, ok := t.(*ast.StarExpr); ok {
+				t = s.X
+			}
+			name = t.(*ast.Ident).Name + "." + name
+		}
+		trace := &ast.ExprStmt{X: &ast.CallExpr{
+			Fun:  ast.NewIdent("println"),
+			Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("enter " + name)}},
+		}}
+		fn.Body.List = append([]ast.Stmt{trace}, fn.Body.List...)
+	}
+}
@@ -0,0 +1,29 @@
+// Package unless is an example of an extension with syntax of its
+// own: with ogo -x=unless,
+//
+//	unless x > 0 {
+//		...
+//	}
+//
+// means the same as
+//
+//	if !(x > 0) {
+//		...
+//	}
+package unless
+
+import (
+	"github.com/droundy/ogo/transform"
+	"regexp"
+)
+
+var statement = regexp.MustCompile(`(?m)^(\s*)unless\s+(.*?)\s*\{\s*$`)
+
+func init() {
+	transform.Register(&transform.Extension{
+		Name: "unless",
+		Preprocess: func(filename string, src []byte) []byte {
+			return statement.ReplaceAll(src, []byte("${1}if !(${2}) {"))
+		},
+	})
+}